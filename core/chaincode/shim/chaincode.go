@@ -156,7 +156,11 @@ func getPeerAddress() string {
 func newPeerClientConnection() (*grpc.ClientConn, error) {
 	var peerAddress = getPeerAddress()
 	if comm.TLSEnabled() {
-		return comm.NewClientConnectionWithAddress(peerAddress, true, true, comm.InitTLSForPeer())
+		creds, err := comm.NewTLSForPeer()
+		if err != nil {
+			return nil, err
+		}
+		return comm.NewClientConnectionWithAddress(peerAddress, true, true, creds)
 	}
 	return comm.NewClientConnectionWithAddress(peerAddress, true, false, nil)
 }
@@ -1,11 +1,14 @@
 package comm
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
 	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/grpclog"
 
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
@@ -16,7 +19,8 @@ const defaultTimeout = time.Second * 3
 var commLogger = logging.MustGetLogger("comm")
 
 // NewClientConnectionWithAddress Returns a new grpc.ClientConn to the given address.
-func NewClientConnectionWithAddress(peerAddress string, block bool, tslEnabled bool, creds credentials.TransportAuthenticator) (*grpc.ClientConn, error) {
+// Any dialOpts are applied after the options derived from the other arguments.
+func NewClientConnectionWithAddress(peerAddress string, block bool, tslEnabled bool, creds credentials.TransportAuthenticator, dialOpts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	var opts []grpc.DialOption
 	if tslEnabled {
 		opts = append(opts, grpc.WithTransportCredentials(creds))
@@ -27,6 +31,7 @@ func NewClientConnectionWithAddress(peerAddress string, block bool, tslEnabled b
 	if block {
 		opts = append(opts, grpc.WithBlock())
 	}
+	opts = append(opts, dialOpts...)
 	conn, err := grpc.Dial(peerAddress, opts...)
 	if err != nil {
 		return nil, err
@@ -34,21 +39,35 @@ func NewClientConnectionWithAddress(peerAddress string, block bool, tslEnabled b
 	return conn, err
 }
 
-// InitTLSForPeer returns TLS credentials for peer
-func InitTLSForPeer() credentials.TransportAuthenticator {
-	var sn string
-	if viper.GetString("peer.tls.serverhostoverride") != "" {
-		sn = viper.GetString("peer.tls.serverhostoverride")
+// InitTLSForPeer returns TLS credentials for peer like NewTLSForPeer. When the
+// configured CA file cannot be used the error is returned, unless
+// peer.tls.fallbacktosystemcas is set: the error is then logged and the
+// credentials verify the peer against the system roots instead
+func InitTLSForPeer() (credentials.TransportAuthenticator, error) {
+	creds, err := NewTLSForPeer()
+	if err != nil {
+		if !viper.GetBool("peer.tls.fallbacktosystemcas") {
+			return nil, err
+		}
+		commLogger.Errorf("Failed to create TLS credentials, using the system roots: %s", err)
+		return credentials.NewClientTLSFromCert(nil, viper.GetString("peer.tls.serverhostoverride")), nil
 	}
-	var creds credentials.TransportAuthenticator
-	if viper.GetString("peer.tls.cert.file") != "" {
-		var err error
-		creds, err = credentials.NewClientTLSFromFile(viper.GetString("peer.tls.cert.file"), sn)
+	return creds, nil
+}
+
+// NewTLSForPeer returns TLS credentials for peer, or an error if the
+// configured CA file cannot be used
+func NewTLSForPeer() (credentials.TransportAuthenticator, error) {
+	config := &tls.Config{ServerName: viper.GetString("peer.tls.serverhostoverride")}
+	if certFile := viper.GetString("peer.tls.cert.file"); certFile != "" {
+		b, err := ioutil.ReadFile(certFile)
 		if err != nil {
-			grpclog.Fatalf("Failed to create TLS credentials %v", err)
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("credentials: failed to append certificates")
 		}
-	} else {
-		creds = credentials.NewClientTLSFromCert(nil, sn)
 	}
-	return creds
+	return credentials.NewTLS(config), nil
 }
@@ -16,7 +16,11 @@ func TestConnection_Correct(t *testing.T) {
 	var tmpConn *grpc.ClientConn
 	var err error
 	if TLSEnabled() {
-		tmpConn, err = NewClientConnectionWithAddress(viper.GetString("peer.address"), true, true, InitTLSForPeer())
+		creds, cerr := InitTLSForPeer()
+		if cerr != nil {
+			t.Fatalf("could not create TLS credentials: %s", cerr)
+		}
+		tmpConn, err = NewClientConnectionWithAddress(viper.GetString("peer.address"), true, true, creds)
 	}
 	tmpConn, err = NewClientConnectionWithAddress(viper.GetString("peer.address"), true, false, nil)
 	if err != nil {
@@ -33,7 +37,11 @@ func TestConnection_WrongAddress(t *testing.T) {
 	var tmpConn *grpc.ClientConn
 	var err error
 	if TLSEnabled() {
-		tmpConn, err = NewClientConnectionWithAddress(viper.GetString("peer.address"), true, true, InitTLSForPeer())
+		creds, cerr := InitTLSForPeer()
+		if cerr != nil {
+			t.Fatalf("could not create TLS credentials: %s", cerr)
+		}
+		tmpConn, err = NewClientConnectionWithAddress(viper.GetString("peer.address"), true, true, creds)
 	}
 	tmpConn, err = NewClientConnectionWithAddress(viper.GetString("peer.address"), true, false, nil)
 	if err == nil {
@@ -42,3 +50,29 @@ func TestConnection_WrongAddress(t *testing.T) {
 		tmpConn.Close()
 	}
 }
+
+func TestNewTLSForPeerMissingCAFile(t *testing.T) {
+	viper.Set("peer.tls.cert.file", "/nonexistent/ca.pem")
+	defer viper.Set("peer.tls.cert.file", "")
+	if _, err := NewTLSForPeer(); err == nil {
+		t.Fatal("expected an error for a missing CA file")
+	}
+}
+
+func TestInitTLSForPeerMissingCAFile(t *testing.T) {
+	viper.Set("peer.tls.cert.file", "/nonexistent/ca.pem")
+	defer viper.Set("peer.tls.cert.file", "")
+	if _, err := InitTLSForPeer(); err == nil {
+		t.Fatal("expected an error for a missing CA file without the fallback")
+	}
+
+	viper.Set("peer.tls.fallbacktosystemcas", true)
+	defer viper.Set("peer.tls.fallbacktosystemcas", false)
+	creds, err := InitTLSForPeer()
+	if err != nil {
+		t.Fatalf("expected the fallback to the system roots, got %s", err)
+	}
+	if creds == nil {
+		t.Fatal("expected credentials using the system roots")
+	}
+}
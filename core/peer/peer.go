@@ -161,7 +161,11 @@ func GetLocalIP() string {
 // NewPeerClientConnectionWithAddress Returns a new grpc.ClientConn to the configured local PEER.
 func NewPeerClientConnectionWithAddress(peerAddress string) (*grpc.ClientConn, error) {
 	if comm.TLSEnabled() {
-		return comm.NewClientConnectionWithAddress(peerAddress, true, true, comm.InitTLSForPeer())
+		creds, err := comm.NewTLSForPeer()
+		if err != nil {
+			return nil, err
+		}
+		return comm.NewClientConnectionWithAddress(peerAddress, true, true, creds)
 	}
 	return comm.NewClientConnectionWithAddress(peerAddress, true, false, nil)
 }
@@ -0,0 +1,83 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/op/go-logging"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//WithRequireAllAccepted makes a registration fail when its ack does not list
+//every interested event of the Register message, the ack of the event hub
+//holding the interests it accepted. The error names the missing interests,
+//so that subscribing to events the peer does not support fails Start, or
+//the reconnection or Reregister, rather than silently delivering nothing.
+//Such a failure is not retried. It is off by default, for the event hubs
+//acknowledging with an empty Register
+func WithRequireAllAccepted() Option {
+	return func(ec *EventsClient) {
+		ec.requireAccepted = true
+	}
+}
+
+//checkAccepted returns an error naming the interests of sent missing from
+//ack when WithRequireAllAccepted is set
+func (ec *EventsClient) checkAccepted(sent, ack *ehpb.Register) error {
+	if !ec.requireAccepted {
+		return nil
+	}
+	missing := unacceptedInterests(sent.GetEvents(), ack.GetEvents())
+	if len(missing) == 0 {
+		return nil
+	}
+	names := make([]string, len(missing))
+	for i, ie := range missing {
+		names[i] = interestName(ie)
+	}
+	ec.logf(logging.ERROR, "event hub did not accept %d interested events", len(missing))
+	return fmt.Errorf("interested events not accepted by the event hub: %s", strings.Join(names, ", "))
+}
+
+//unacceptedInterests returns the interests of requested missing from
+//accepted
+func unacceptedInterests(requested, accepted []*ehpb.Interest) []*ehpb.Interest {
+	acked := make(map[interestKey]bool, len(accepted))
+	for _, ie := range accepted {
+		acked[keyOf(ie)] = true
+	}
+	var missing []*ehpb.Interest
+	for _, ie := range requested {
+		if !acked[keyOf(ie)] {
+			missing = append(missing, ie)
+		}
+	}
+	return missing
+}
+
+//interestName describes ie: its event type, followed by the chaincode ID and
+//event name for a chaincode interest
+func interestName(ie *ehpb.Interest) string {
+	key := keyOf(ie)
+	if key.chaincodeID == "" && key.eventName == "" {
+		return key.eventType.String()
+	}
+	return fmt.Sprintf("%s %s/%s", key.eventType, key.chaincodeID, key.eventName)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"strings"
+	"testing"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//ackBlocksOnly acknowledges the registrations with their block interests
+//only, like a peer not supporting the other event types
+func ackBlocksOnly(n int, stream ehpb.Events_ChatServer) error {
+	in, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	accepted := &ehpb.Register{}
+	for _, ie := range in.GetRegister().Events {
+		if ie.EventType == ehpb.EventType_BLOCK {
+			accepted.Events = append(accepted.Events, ie)
+		}
+	}
+	if err := stream.Send(&ehpb.Event{Event: &ehpb.Event_Register{Register: accepted}}); err != nil {
+		return err
+	}
+	return waitForEOF(stream)
+}
+
+func TestRequireAllAccepted(t *testing.T) {
+	addr, _, stop := startFakeServer(t, ackBlocksOnly)
+	defer stop()
+	interests := []*ehpb.Interest{
+		{EventType: ehpb.EventType_BLOCK},
+		{EventType: ehpb.EventType_REJECTION},
+		ChaincodeInterest("mycc", "transfer"),
+	}
+
+	// lenient by default
+	client := NewEventsClient(addr, &interestsAdapter{newRecordingAdapter(), interests})
+	if err := client.Start(); err != nil {
+		t.Fatalf("expected the partial ack to be accepted, got %s", err)
+	}
+	client.Stop()
+
+	client = NewEventsClient(addr, &interestsAdapter{newRecordingAdapter(), interests}, WithRequireAllAccepted(), WithRegisterRetries(2))
+	err := client.Start()
+	if err == nil {
+		client.Stop()
+		t.Fatalf("expected the partial ack to fail the registration")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "REJECTION, CHAINCODE mycc/transfer") || strings.Contains(msg, "BLOCK") {
+		t.Fatalf("expected the error to name the rejected interests, got %s", msg)
+	}
+	if stats := client.Stats(); stats.RegistrationAttempts != 1 {
+		t.Fatalf("expected the failure not to be retried, got %d attempts", stats.RegistrationAttempts)
+	}
+
+	// a full ack passes
+	addr, _, stop = startFakeServer(t, sendBlocks(1))
+	defer stop()
+	adapter := &interestsAdapter{newRecordingAdapter(), interests}
+	client = NewEventsClient(addr, adapter, WithRequireAllAccepted())
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+}
@@ -17,13 +17,182 @@ limitations under the License.
 package consumer
 
 import (
+	"time"
+
+	"golang.org/x/net/context"
+
 	ehpb "github.com/hyperledger/fabric/protos"
 )
 
 //EventAdapter is the interface by which a openchain event client registers interested events and
-//receives messages from the openchain event Server
+//receives messages from the openchain event Server. Disconnected is called
+//exactly once, when a started client terminates for good, with the error that
+//terminated it or nil after a stop
 type EventAdapter interface {
 	GetInterestedEvents() ([]*ehpb.Interest, error)
 	Recv(msg *ehpb.Event) (bool, error)
 	Disconnected(err error)
 }
+
+//CloseReason tells why an events client terminated
+type CloseReason int
+
+//A client is stopped by its caller with Stop, or by its adapter with
+//ErrStopConsuming. It terminates with the server EOF when the event hub ends
+//the stream and reconnecting is not enabled, with a fatal error when the
+//stream fails, and after exhausting its reconnect attempts when it could not
+//re-establish a lost stream. It stops on its own once it has delivered the
+//number of events set with WithMaxEvents. With WithTrustOnFirstUse it
+//terminates when a peer it reconnects to changes of certificate, and with
+//WithRequireEventWithin when no event follows a registration
+const (
+	CloseStoppedByCaller CloseReason = iota
+	CloseServerEOF
+	CloseFatalError
+	CloseReconnectExhausted
+	CloseMaxEvents
+	CloseIdentityChanged
+	CloseNoEvents
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseStoppedByCaller:
+		return "stopped by caller"
+	case CloseServerEOF:
+		return "server EOF"
+	case CloseFatalError:
+		return "fatal error"
+	case CloseReconnectExhausted:
+		return "reconnect exhausted"
+	case CloseMaxEvents:
+		return "max events delivered"
+	case CloseIdentityChanged:
+		return "peer identity changed"
+	case CloseNoEvents:
+		return "no events after registering"
+	default:
+		return "unknown"
+	}
+}
+
+//CloseNotifier can be implemented by an EventAdapter to learn why the client
+//terminated. OnClosed is called once, right after Disconnected, with the
+//same error
+type CloseNotifier interface {
+	OnClosed(reason CloseReason, err error)
+}
+
+//RejectionHandler can be implemented by an EventAdapter to receive the
+//rejection events already decoded. OnRejection is called for each rejection
+//event, after Recv, with the Uuid of the rejected transaction and the
+//errorMsg of the event; txID is empty when the event carries no transaction
+type RejectionHandler interface {
+	OnRejection(txID string, reason string)
+}
+
+//EventMetadata is what the client knows about a delivered event besides its
+//content
+type EventMetadata struct {
+	//Sequence numbers the events delivered by the client from 1, in the
+	//order they were received from the event hub. It keeps increasing
+	//across reconnections, so a new stream does not restart it. Filtered
+	//and dropped events are not delivered, hence not numbered
+	Sequence uint64
+	//ConnectionID is the ID of the connection whose stream delivered the
+	//event, the one sent to the event hub in the ConnectionIDMetadataKey
+	//metadata, see CurrentConnectionID. It changes with each reconnection,
+	//so it tells apart the events of successive streams when looking for
+	//gaps or duplicates. It is empty for a client created with NewFromStream
+	ConnectionID string
+}
+
+//MetadataAdapter can be implemented by an EventAdapter to receive each event
+//with its metadata. The client then calls RecvWithMetadata instead of Recv.
+//The client delivers the events one at a time in the order of the stream,
+//with or without a buffer, so the sequence numbers an adapter sees are
+//strictly increasing; they let an adapter dispatching events concurrently,
+//like an AsyncEventAdapter with several workers, detect and undo
+//reordering. Replayed events are delivered with Recv
+type MetadataAdapter interface {
+	EventAdapter
+	RecvWithMetadata(msg *ehpb.Event, md EventMetadata) (bool, error)
+}
+
+//ContextAdapter can be implemented by an EventAdapter to receive each event
+//with a context. The client then calls RecvContext instead of Recv and
+//RecvWithMetadata. The context is cancelled when the client terminates, like
+//the one returned by Context, and carries the values of the context given to
+//WithBaseContext besides those of the context given to StartContext
+type ContextAdapter interface {
+	EventAdapter
+	RecvContext(ctx context.Context, msg *ehpb.Event) (bool, error)
+}
+
+//ShutdownAdapter can be implemented by an EventAdapter to be given a budget
+//for its cleanup, e.g. flushing what it buffered downstream. The client then
+//calls DisconnectedContext instead of Disconnected, with a context whose
+//deadline is the shutdown timeout set with WithShutdownTimeout from now, and
+//which carries the values of the context given to WithBaseContext. The
+//client does not interrupt the adapter at the deadline: it is up to the
+//adapter to give up when the context is done
+type ShutdownAdapter interface {
+	EventAdapter
+	DisconnectedContext(ctx context.Context, err error)
+}
+
+//FlushingAdapter can be implemented by an EventAdapter accumulating events,
+//e.g. in batches, to emit what it holds before the client terminates. Flush
+//is called once the last event has been delivered and right before
+//Disconnected, when the client terminates gracefully: stopped with Stop, its
+//context or ErrStopConsuming, at the end of the stream, or after the
+//events set with WithMaxEvents. With a buffer,
+//the client first waits for the buffered events to be delivered. An error of
+//Flush is logged. A reconnection does not flush the adapter, whose partial
+//batch is carried over to the next stream, unless WithFlushOnReconnect is
+//set
+type FlushingAdapter interface {
+	EventAdapter
+	Flush() error
+}
+
+//ClosingAdapter can be implemented by an EventAdapter holding resources, like
+//a FileSink its file, for the client to release them when it terminates:
+//Done is called right after Disconnected, whatever the termination. An
+//error of Done is logged
+type ClosingAdapter interface {
+	EventAdapter
+	Done() error
+}
+
+//StreamStartNotifier can be implemented by an EventAdapter to mark the
+//boundaries between streams in what it receives. OnStreamStart is called
+//right before the first event of each stream is delivered, the one opened by
+//Start as well as those opened by each reconnection; a stream that ends
+//before delivering an event does not call it
+type StreamStartNotifier interface {
+	OnStreamStart()
+}
+
+//WatermarkAdapter can be implemented by an EventAdapter to adapt the pace
+//of its downstream to the buffer of the client, set with WithBufferWatermarks.
+//OnBufferHighWatermark is called when the buffer reaches its high watermark,
+//e.g. to have the downstream speed up, and OnBufferLowWatermark when it falls
+//back to its low watermark, the downstream having caught up. The calls
+//alternate, starting with OnBufferHighWatermark. They are made by the
+//receive loop or the dispatcher, which they hold up, so they should return
+//quickly
+type WatermarkAdapter interface {
+	OnBufferHighWatermark()
+	OnBufferLowWatermark()
+}
+
+//TickAdapter can be implemented by an EventAdapter doing periodic work, e.g.
+//flushing or checkpointing, even when no events arrive. With
+//WithTickInterval, OnTick is called with the current time every interval
+//while the client is connected, never concurrently with the delivery of an
+//event. No tick is made while the client is reconnecting or its delivery is
+//paused, and the ticks stop when it is stopped or terminates
+type TickAdapter interface {
+	OnTick(now time.Time)
+}
@@ -0,0 +1,76 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dnsScheme  = "dns:///"
+	unixScheme = "unix://"
+)
+
+//normalizePeerAddress validates a peer address and returns it in the form
+//used for dialing. Accepted forms are host:port (surrounding whitespace is
+//ignored), dns:///host:port, which is dialed as host:port, and
+//unix:///path/to/socket
+func normalizePeerAddress(address string) (string, error) {
+	addr := strings.TrimSpace(address)
+	if addr == "" {
+		return "", fmt.Errorf("peer address must not be empty")
+	}
+	if strings.HasPrefix(addr, unixScheme) {
+		if strings.TrimPrefix(addr, unixScheme) == "" {
+			return "", fmt.Errorf("invalid peer address %q: missing socket path", address)
+		}
+		return addr, nil
+	}
+	addr = strings.TrimPrefix(addr, dnsScheme)
+	if i := strings.Index(addr, "://"); i >= 0 {
+		return "", fmt.Errorf("invalid peer address %q: unsupported scheme %s", address, addr[:i])
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid peer address %q: expected host:port", address)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid peer address %q: invalid port %s", address, port)
+	}
+	return addr, nil
+}
+
+//dialTCP is the default dialer of gRPC
+func dialTCP(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, timeout)
+}
+
+func dialUnix(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("unix", strings.TrimPrefix(addr, unixScheme), timeout)
+}
+
+//localDialer returns a dialer of TCP connections from addr
+func localDialer(addr *net.TCPAddr) func(string, time.Duration) (net.Conn, error) {
+	return func(target string, timeout time.Duration) (net.Conn, error) {
+		dialer := net.Dialer{LocalAddr: addr, Timeout: timeout}
+		return dialer.Dial("tcp", target)
+	}
+}
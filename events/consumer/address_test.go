@@ -0,0 +1,104 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+	"google.golang.org/grpc"
+)
+
+func TestNormalizePeerAddress(t *testing.T) {
+	valid := map[string]string{
+		"127.0.0.1:7053":             "127.0.0.1:7053",
+		" peer0:7053\n":              "peer0:7053",
+		"[::1]:7053":                 "[::1]:7053",
+		"dns:///peer0:7053":          "peer0:7053",
+		"unix:///var/run/peer.sock":  "unix:///var/run/peer.sock",
+		"  unix:///tmp/events.sock ": "unix:///tmp/events.sock",
+	}
+	for in, expected := range valid {
+		got, err := normalizePeerAddress(in)
+		if err != nil || got != expected {
+			t.Errorf("%q: expected %q, got %q, %v", in, expected, got, err)
+		}
+	}
+
+	malformed := []string{"", "   ", "peer0", "peer0:", "peer0:events", "peer0:70000", "http://peer0:7053", "unix://", "dns:///peer0"}
+	for _, in := range malformed {
+		if got, err := normalizePeerAddress(in); err == nil {
+			t.Errorf("%q: expected an error, got %q", in, got)
+		}
+	}
+}
+
+func TestStartRejectsMalformedAddress(t *testing.T) {
+	client := NewEventsClient("peer0", newRecordingAdapter())
+	if err := client.Start(); err == nil {
+		t.Fatalf("expected Start to reject an address without port")
+	}
+}
+
+func TestUnixSocketAddress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consumer")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.sock")
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("could not listen on %s: %s", path, err)
+	}
+	grpcServer := grpc.NewServer()
+	ehpb.RegisterEventsServer(grpcServer, &fakeEventsServer{handle: sendBlocks(1)})
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(" unix://"+path+" ", adapter)
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+}
+
+func TestRemoteAddr(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter)
+	if remote := client.RemoteAddr(); remote != "" {
+		t.Fatalf("expected no remote address before Start, got %s", remote)
+	}
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	adapter.waitEvent(t)
+	if remote := client.RemoteAddr(); remote != addr {
+		t.Fatalf("expected the remote address %s, got %s", addr, remote)
+	}
+	client.Stop()
+	waitFor(t, "the client to terminate", func() bool { return client.RemoteAddr() == "" })
+}
@@ -0,0 +1,174 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//ErrAsyncQueueFull is reported to AsyncEventAdapter.OnError for each event
+//discarded because the adapter's queue was full
+var ErrAsyncQueueFull = errors.New("async adapter queue full")
+
+//AsyncEventAdapter hands every received event to a pool of workers calling
+//the wrapped adapter, so that slow processing never holds up the client's
+//receive loop. See AsyncAdapter
+type AsyncEventAdapter struct {
+	next  EventAdapter
+	queue chan asyncEvent
+	wg    sync.WaitGroup
+	once  sync.Once
+
+	mutex   sync.RWMutex
+	closed  bool
+	stopErr error
+	stopped bool
+
+	semOnce  sync.Once
+	sem      chan struct{}
+	inFlight int32
+
+	//OnError, if set, is called with the errors of the wrapped adapter's Recv
+	//and with ErrAsyncQueueFull for events dropped because the queue was full.
+	//It may be called concurrently from several workers
+	OnError func(msg *ehpb.Event, err error)
+
+	//MaxInFlight, if positive, caps the number of concurrent calls to the
+	//wrapped adapter's Recv independently of the number of workers, e.g. to
+	//bound the connections to a downstream system. Workers wait for a free
+	//slot. Like OnError it must be set before the first event is received
+	MaxInFlight int
+}
+
+//asyncEvent is a queued event with its metadata, if the client provided it
+type asyncEvent struct {
+	msg *ehpb.Event
+	md  *EventMetadata
+}
+
+//AsyncAdapter wraps next so that events are queued (up to queueSize) and
+//delivered to next.Recv by workers goroutines. As Recv returns before next
+//has seen the event, errors are surfaced through OnError; once next asks to
+//stop, the following Recv stops the client. Disconnected waits for the
+//queued events to be processed before calling next.Disconnected. With more
+//than one worker events may reach next out of order; when next implements
+//MetadataAdapter it receives the metadata of each event, whose sequence
+//number reveals the original order
+func AsyncAdapter(next EventAdapter, queueSize int, workers int) *AsyncEventAdapter {
+	if workers < 1 {
+		workers = 1
+	}
+	a := &AsyncEventAdapter{next: next, queue: make(chan asyncEvent, queueSize)}
+	a.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go a.work()
+	}
+	return a
+}
+
+func (a *AsyncEventAdapter) work() {
+	defer a.wg.Done()
+	for e := range a.queue {
+		cont, err := a.deliver(e)
+		if err != nil && a.OnError != nil {
+			a.OnError(e.msg, err)
+		}
+		if !cont {
+			a.mutex.Lock()
+			if !a.stopped {
+				a.stopped, a.stopErr = true, err
+			}
+			a.mutex.Unlock()
+		}
+	}
+}
+
+func (a *AsyncEventAdapter) deliver(e asyncEvent) (bool, error) {
+	a.semOnce.Do(func() {
+		if a.MaxInFlight > 0 {
+			a.sem = make(chan struct{}, a.MaxInFlight)
+		}
+	})
+	if a.sem != nil {
+		a.sem <- struct{}{}
+		defer func() { <-a.sem }()
+	}
+	atomic.AddInt32(&a.inFlight, 1)
+	defer atomic.AddInt32(&a.inFlight, -1)
+	if ma, ok := a.next.(MetadataAdapter); ok && e.md != nil {
+		return ma.RecvWithMetadata(e.msg, *e.md)
+	}
+	return a.next.Recv(e.msg)
+}
+
+//InFlight returns the number of calls to the wrapped adapter's Recv in
+//progress
+func (a *AsyncEventAdapter) InFlight() int {
+	return int(atomic.LoadInt32(&a.inFlight))
+}
+
+//GetInterestedEvents implements EventAdapter
+func (a *AsyncEventAdapter) GetInterestedEvents() ([]*ehpb.Interest, error) {
+	return a.next.GetInterestedEvents()
+}
+
+//Recv implements EventAdapter by queueing msg for the workers
+func (a *AsyncEventAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	return a.enqueue(asyncEvent{msg: msg})
+}
+
+//RecvWithMetadata implements MetadataAdapter by queueing msg with md for the
+//workers
+func (a *AsyncEventAdapter) RecvWithMetadata(msg *ehpb.Event, md EventMetadata) (bool, error) {
+	return a.enqueue(asyncEvent{msg: msg, md: &md})
+}
+
+func (a *AsyncEventAdapter) enqueue(e asyncEvent) (bool, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.stopped {
+		return false, a.stopErr
+	}
+	if a.closed {
+		return false, nil
+	}
+	select {
+	case a.queue <- e:
+	default:
+		if a.OnError != nil {
+			a.OnError(e.msg, ErrAsyncQueueFull)
+		}
+	}
+	return true, nil
+}
+
+//Disconnected implements EventAdapter. It processes the queued events before
+//passing err on to the wrapped adapter
+func (a *AsyncEventAdapter) Disconnected(err error) {
+	a.once.Do(func() {
+		a.mutex.Lock()
+		a.closed = true
+		close(a.queue)
+		a.mutex.Unlock()
+		a.wg.Wait()
+		a.next.Disconnected(err)
+	})
+}
@@ -0,0 +1,152 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//slowAdapter takes delay to process each event
+type slowAdapter struct {
+	*recordingAdapter
+	delay time.Duration
+	err   error
+}
+
+func (a *slowAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	time.Sleep(a.delay)
+	a.recordingAdapter.Recv(msg)
+	return a.err == nil, a.err
+}
+
+func TestAsyncAdapterDoesNotBlock(t *testing.T) {
+	next := &slowAdapter{recordingAdapter: newRecordingAdapter(), delay: 50 * time.Millisecond}
+	adapter := AsyncAdapter(next, 10, 2)
+
+	begin := time.Now()
+	for i := 0; i < 10; i++ {
+		if cont, err := adapter.Recv(blockEvent()); !cont || err != nil {
+			t.Fatalf("unexpected Recv result %t, %v", cont, err)
+		}
+	}
+	if elapsed := time.Since(begin); elapsed > 40*time.Millisecond {
+		t.Fatalf("Recv blocked for %s", elapsed)
+	}
+
+	// Disconnected flushes the queue before reaching the wrapped adapter
+	adapter.Disconnected(nil)
+	if n := len(next.events); n != 10 {
+		t.Fatalf("expected 10 events processed before Disconnected, got %d", n)
+	}
+	if err := next.waitDisconnected(t); err != nil {
+		t.Fatalf("unexpected disconnect error %v", err)
+	}
+}
+
+func TestAsyncAdapterErrors(t *testing.T) {
+	failure := errors.New("downstream failure")
+	next := &slowAdapter{recordingAdapter: newRecordingAdapter(), delay: 20 * time.Millisecond, err: failure}
+	adapter := AsyncAdapter(next, 1, 1)
+
+	var mutex sync.Mutex
+	var errs []error
+	adapter.OnError = func(msg *ehpb.Event, err error) {
+		mutex.Lock()
+		errs = append(errs, err)
+		mutex.Unlock()
+	}
+
+	adapter.Recv(blockEvent())
+	// the worker may or may not have picked the first event up yet: send
+	// enough events to overflow the single queue slot
+	adapter.Recv(blockEvent())
+	adapter.Recv(blockEvent())
+	waitFor(t, "downstream error", func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, err := range errs {
+			if err == failure {
+				return true
+			}
+		}
+		return false
+	})
+
+	if cont, err := adapter.Recv(blockEvent()); cont || err != failure {
+		t.Fatalf("expected Recv to stop the client after a downstream stop, got %t, %v", cont, err)
+	}
+	adapter.Disconnected(nil)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	var full bool
+	for _, err := range errs {
+		full = full || err == ErrAsyncQueueFull
+	}
+	if !full {
+		t.Fatalf("expected an ErrAsyncQueueFull report, got %v", errs)
+	}
+}
+
+//concurrencyAdapter records the highest number of concurrent Recv calls
+type concurrencyAdapter struct {
+	*slowAdapter
+	mutex        sync.Mutex
+	current, max int
+}
+
+func (a *concurrencyAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	a.mutex.Lock()
+	a.current++
+	if a.current > a.max {
+		a.max = a.current
+	}
+	a.mutex.Unlock()
+	defer func() {
+		a.mutex.Lock()
+		a.current--
+		a.mutex.Unlock()
+	}()
+	return a.slowAdapter.Recv(msg)
+}
+
+func TestAsyncAdapterMaxInFlight(t *testing.T) {
+	next := &concurrencyAdapter{slowAdapter: &slowAdapter{recordingAdapter: newRecordingAdapter(), delay: 10 * time.Millisecond}}
+	adapter := AsyncAdapter(next, 50, 8)
+	adapter.MaxInFlight = 3
+
+	for i := 0; i < 50; i++ {
+		adapter.Recv(blockEvent())
+	}
+	waitFor(t, "in-flight calls", func() bool { return adapter.InFlight() == 3 })
+	adapter.Disconnected(nil)
+
+	if n := len(next.events); n != 50 {
+		t.Fatalf("expected 50 events processed, got %d", n)
+	}
+	if next.max != 3 {
+		t.Fatalf("expected at most 3 concurrent calls, got %d", next.max)
+	}
+	if n := adapter.InFlight(); n != 0 {
+		t.Fatalf("expected no call in flight, got %d", n)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//Backoff paces the retries of a client configured with WithBackoff: the
+//reconnect attempts and the Register messages resent after an invalid ack.
+//Implementations must be safe for concurrent use
+type Backoff interface {
+	//NextDelay returns how long to wait before the attempt-th retry,
+	//counted from 1, or a negative duration to give up retrying
+	NextDelay(attempt int) time.Duration
+	//Reset is called once a retried operation has succeeded
+	Reset()
+}
+
+//ExponentialBackoff is the default Backoff: the delay starts at Initial and
+//is multiplied by Multiplier with every attempt up to Max, then randomized by
+//up to Jitter times itself in either direction so that clients failing
+//together do not retry in lockstep
+type ExponentialBackoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	//Jitter is a fraction of the delay between 0 and 1
+	Jitter float64
+	//MaxAttempts, if positive, is the number of retries after which the
+	//backoff gives up
+	MaxAttempts int
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+//DefaultBackoff returns the ExponentialBackoff used by WithBackoff(nil): from
+//100ms doubling up to 30s, with a 20% jitter and no limit of attempts
+func DefaultBackoff() *ExponentialBackoff {
+	return &ExponentialBackoff{Initial: 100 * time.Millisecond, Max: 30 * time.Second, Multiplier: 2, Jitter: 0.2}
+}
+
+//NextDelay implements Backoff
+func (b *ExponentialBackoff) NextDelay(attempt int) time.Duration {
+	if b.MaxAttempts > 0 && attempt > b.MaxAttempts {
+		return -1
+	}
+	delay := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt-1))
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		b.mutex.Lock()
+		if b.rand == nil {
+			b.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		delay += delay * b.Jitter * (2*b.rand.Float64() - 1)
+		b.mutex.Unlock()
+	}
+	return time.Duration(delay)
+}
+
+//Reset implements Backoff. The delays only depend on the attempt number, so
+//there is nothing to reset
+func (b *ExponentialBackoff) Reset() {
+}
+
+//backoffWait waits for the delay of the attempt-th retry unless the client
+//is stopped or done is closed first. It returns the delay, negative when the
+//backoff gives up, and false if the wait was interrupted
+func (ec *EventsClient) backoffWait(done <-chan struct{}, attempt int) (time.Duration, bool) {
+	delay := ec.backoff.NextDelay(attempt)
+	if delay < 0 {
+		return delay, true
+	}
+	select {
+	case <-ec.clock.After(delay):
+		return delay, true
+	case <-ec.stopChan:
+		return delay, false
+	case <-done:
+		return delay, false
+	}
+}
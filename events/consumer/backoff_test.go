@@ -0,0 +1,276 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//scriptedBackoff returns its delays in turn, then gives up
+type scriptedBackoff struct {
+	mutex    sync.Mutex
+	delays   []time.Duration
+	attempts []int
+	resets   int
+}
+
+func (b *scriptedBackoff) NextDelay(attempt int) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.attempts = append(b.attempts, attempt)
+	if attempt > len(b.delays) {
+		return -1
+	}
+	return b.delays[attempt-1]
+}
+
+func (b *scriptedBackoff) Reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.resets++
+}
+
+func (b *scriptedBackoff) calls() ([]int, int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]int(nil), b.attempts...), b.resets
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := &ExponentialBackoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2, MaxAttempts: 6}
+	for i, expected := range []time.Duration{100, 200, 400, 800, 1000, 1000, -1} {
+		if expected > 0 {
+			expected *= time.Millisecond
+		}
+		if d := b.NextDelay(i + 1); d != expected {
+			t.Fatalf("attempt %d: expected %s, got %s", i+1, expected, d)
+		}
+	}
+
+	b = DefaultBackoff()
+	for attempt := 1; attempt < 20; attempt++ {
+		base := 100 * time.Millisecond << uint(attempt-1)
+		if base > 30*time.Second {
+			base = 30 * time.Second
+		}
+		if d := b.NextDelay(attempt); d < base*8/10 || d > base*12/10 {
+			t.Fatalf("attempt %d: delay %s outside of the jitter around %s", attempt, d, base)
+		}
+	}
+}
+
+func TestBackoffReconnect(t *testing.T) {
+	addr, srv, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if n == 1 {
+			_, err := ackRegister(stream)
+			return err
+		}
+		stream.Recv()
+		return errors.New("overloaded")
+	})
+	defer stop()
+
+	backoff := &scriptedBackoff{delays: []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}}
+	infos := make(chan ReconnectInfo, 10)
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithReconnectOnServerClose(), WithBackoff(backoff),
+		WithReconnectHook(func(info ReconnectInfo) { infos <- info }))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	if err := adapter.waitDisconnected(t); err == nil {
+		t.Fatalf("expected the client to give up reconnecting")
+	}
+	if attempts, resets := backoff.calls(); len(attempts) != 3 || attempts[2] != 3 || resets != 0 {
+		t.Fatalf("expected the backoff to be asked for 3 delays, got %v and %d resets", attempts, resets)
+	}
+	if n := srv.chatCount(); n != 3 {
+		t.Fatalf("expected 2 reconnect attempts, got %d streams", n)
+	}
+	for _, delay := range backoff.delays {
+		if info := <-infos; info.Delay != delay {
+			t.Fatalf("expected attempt %d to wait %s, waited %s", info.Attempt, delay, info.Delay)
+		}
+	}
+}
+
+func TestBackoffRegisterRetries(t *testing.T) {
+	addr, _, stop := startFakeServer(t, nackRegister(2))
+	defer stop()
+
+	backoff := &scriptedBackoff{delays: []time.Duration{time.Millisecond, time.Millisecond}}
+	client := NewEventsClient(addr, newRecordingAdapter(), WithRegisterRetries(5), WithBackoff(backoff))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	if attempts, resets := backoff.calls(); len(attempts) != 2 || attempts[1] != 2 || resets != 1 {
+		t.Fatalf("expected delays for 2 retries and a reset, got %v and %d resets", attempts, resets)
+	}
+
+	// the backoff gives up before the retries are exhausted
+	addr, _, stop2 := startFakeServer(t, nackRegister(2))
+	defer stop2()
+	backoff = &scriptedBackoff{delays: []time.Duration{time.Millisecond}}
+	client = NewEventsClient(addr, newRecordingAdapter(), WithRegisterRetries(5), WithBackoff(backoff))
+	if err := client.Start(); err == nil {
+		t.Fatalf("expected the registration to fail once the backoff gives up")
+	}
+}
+
+func TestReconnectError(t *testing.T) {
+	failing := func(n int, stream ehpb.Events_ChatServer) error {
+		switch {
+		case n == 1:
+			_, err := ackRegister(stream)
+			return err
+		case n%2 == 0:
+			stream.Recv()
+			return errors.New("overloaded")
+		default:
+			stream.Recv()
+			stream.Send(&ehpb.Event{})
+			return waitForEOF(stream)
+		}
+	}
+	for _, keep := range []int{10, 2} {
+		addr, _, stop := startFakeServer(t, failing)
+		defer stop()
+		backoff := &scriptedBackoff{delays: []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}}
+		client := NewEventsClient(addr, newRecordingAdapter(), WithReconnectOnServerClose(), WithBackoff(backoff), WithReconnectErrorHistory(keep))
+		if err := client.Start(); err != nil {
+			t.Fatalf("could not start client: %s", err)
+		}
+		err := client.Wait()
+		reconnectErr, ok := err.(*ReconnectError)
+		if !ok {
+			t.Fatalf("expected a ReconnectError, got %v", err)
+		}
+		if reconnectErr.Attempts != 3 {
+			t.Fatalf("expected 3 failed attempts, got %d", reconnectErr.Attempts)
+		}
+		if n := len(reconnectErr.Errors); n != 3 && keep == 10 || n != 2 && keep == 2 {
+			t.Fatalf("expected %d kept errors, got %v", keep, reconnectErr.Errors)
+		}
+		invalidAck := false
+		for _, e := range reconnectErr.Errors {
+			if _, ok := e.(invalidAckError); ok {
+				invalidAck = true
+			}
+		}
+		if !invalidAck {
+			t.Fatalf("expected the invalid ack to be found in %s", err)
+		}
+		if !strings.Contains(err.Error(), "overloaded") {
+			t.Fatalf("expected the stream error in %s", err)
+		}
+	}
+}
+
+func TestReconnectOnStreamError(t *testing.T) {
+	addr, srv, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		if err := stream.Send(blockEvent()); err != nil {
+			return err
+		}
+		if n == 1 {
+			return errors.New("peer restarting")
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithReconnectPolicy(3, 10*time.Millisecond, 50*time.Millisecond))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	adapter.waitEvent(t)
+	if n := srv.chatCount(); n != 2 {
+		t.Fatalf("expected the failed stream to be re-established, got %d streams", n)
+	}
+	select {
+	case err := <-adapter.disconnected:
+		t.Fatalf("expected the client to survive the stream failure, got %v", err)
+	default:
+	}
+	if stats := client.Stats(); stats.Reconnects != 1 {
+		t.Fatalf("expected a reconnection, got %d", stats.Reconnects)
+	}
+}
+
+func TestReconnectPolicyExhausted(t *testing.T) {
+	addr, srv, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if n == 1 {
+			if _, err := ackRegister(stream); err != nil {
+				return err
+			}
+		}
+		return errors.New("peer down")
+	})
+	defer stop()
+
+	adapter := &closeAdapter{newRecordingAdapter(), make(chan CloseReason, 1)}
+	client := NewEventsClient(addr, adapter, WithReconnectPolicy(2, 10*time.Millisecond, 50*time.Millisecond))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	if err := adapter.waitDisconnected(t); err == nil {
+		t.Fatalf("expected the adapter to be disconnected with the last failure")
+	}
+	if reason := <-adapter.reasons; reason != CloseReconnectExhausted {
+		t.Fatalf("expected the reconnections to be exhausted, got %s", reason)
+	}
+	if n := srv.chatCount(); n != 3 {
+		t.Fatalf("expected the initial stream and 2 attempts, got %d streams", n)
+	}
+}
+
+func TestReconnectPolicyStopDuringBackoff(t *testing.T) {
+	addr, srv, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		return errors.New("peer restarting")
+	})
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithReconnectPolicy(0, time.Hour, time.Hour))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	waitFor(t, "reconnecting", func() bool { return client.State() == StateReconnecting })
+	client.Stop()
+	if err := adapter.waitDisconnected(t); err != nil {
+		t.Fatalf("expected a stop during the backoff to disconnect with nil, got %s", err)
+	}
+	if n := srv.chatCount(); n != 1 {
+		t.Fatalf("expected no redial after Stop, got %d streams", n)
+	}
+}
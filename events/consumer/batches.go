@@ -0,0 +1,233 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//WithRegisterBatches splits the interested events into several Register
+//messages of at most maxInterests interests and maxBytes serialized bytes
+//each, for subscriptions too large for one message, a limit being ignored
+//when not positive. An interest larger than maxBytes on its own is sent
+//alone. The messages are sent one after the other over the stream, each
+//waiting for its ack, and the registration succeeds once all are
+//acknowledged, within the registration timeout as a whole. When one of them
+//is rejected, i.e. gets an invalid ack once the register retries are spent
+//or none at all, the registration fails like that of a single message: the
+//stream is abandoned, along with the interests of the messages acknowledged
+//before, and the client reconnects as configured
+func WithRegisterBatches(maxInterests, maxBytes int) Option {
+	return func(ec *EventsClient) {
+		ec.registerBatchCount = maxInterests
+		ec.registerBatchBytes = maxBytes
+	}
+}
+
+//WithRegisterBatchWait makes the registration best-effort rather than
+//strict: instead of waiting for the ack of each Register message before
+//sending the next, it sends them all, then waits at most wait for their
+//acks and goes on with those received so far. The messages left without an
+//ack are logged and reported by ClientStats.UnconfirmedBatches, and their
+//late acks are discarded; the event hub may still apply them. The
+//registration fails when no message is acknowledged by then, as well as on
+//an invalid ack, which is not resent, or when the registration timeout
+//expires first. This trades the completeness of the confirmation for a
+//faster start with large subscriptions split by WithRegisterBatches
+func WithRegisterBatchWait(wait time.Duration) Option {
+	return func(ec *EventsClient) {
+		ec.registerBatchWait = wait
+	}
+}
+
+//registerBatches splits reg into the Register messages sent for it, as set
+//with WithRegisterBatches
+func (ec *EventsClient) registerBatches(reg *ehpb.Register) []*ehpb.Event {
+	if ec.registerBatchCount <= 0 && ec.registerBatchBytes <= 0 {
+		return []*ehpb.Event{{Event: &ehpb.Event_Register{Register: reg}}}
+	}
+	var batches []*ehpb.Event
+	var batch []*ehpb.Interest
+	size := 0
+	flush := func() {
+		batches = append(batches, &ehpb.Event{Event: &ehpb.Event_Register{Register: &ehpb.Register{Events: batch}}})
+		batch, size = nil, 0
+	}
+	for _, ie := range reg.Events {
+		n := proto.Size(ie)
+		n += 1 + varintSize(n)
+		full := ec.registerBatchCount > 0 && len(batch) >= ec.registerBatchCount
+		if ec.registerBatchBytes > 0 && registerSize(size+n) > ec.registerBatchBytes {
+			full = true
+		}
+		if full && len(batch) > 0 {
+			flush()
+		}
+		batch = append(batch, ie)
+		size += n
+	}
+	if len(batch) > 0 || len(batches) == 0 {
+		flush()
+	}
+	return batches
+}
+
+//registerSize returns the serialized size of the Event carrying a Register
+//whose interests take n bytes
+func registerSize(n int) int {
+	return 1 + varintSize(n) + n
+}
+
+//varintSize returns the size of the varint encoding of n
+func varintSize(n int) int {
+	return len(proto.EncodeVarint(uint64(n)))
+}
+
+//recvOutcome is the result of a stream receive
+type recvOutcome struct {
+	in  *ehpb.Event
+	err error
+}
+
+//registerBestEffort sends batches over stream, then collects their acks for
+//the wait set with WithRegisterBatchWait, and returns the interests of the
+//acknowledged ones. A receive still pending then is left to the receive loop
+func (ec *EventsClient) registerBestEffort(ctx context.Context, stream ehpb.Events_ChatClient, batches []*ehpb.Event) ([]*ehpb.Interest, error) {
+	begin := ec.clock.Now()
+	sent := make(chan error, 1)
+	ec.routines.spawn(func() {
+		for _, batch := range batches {
+			if err := ec.send(stream, batch); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- nil
+	})
+	select {
+	case err := <-sent:
+		if err != nil {
+			ec.logf(logging.ERROR, "error on Register send %s", err)
+			return nil, err
+		}
+	case <-ctx.Done():
+		return nil, registrationAborted(ctx, "sending")
+	}
+
+	waitCtx, cancel := withTimeout(ec.clock, ctx, ec.registerBatchWait)
+	defer cancel()
+	recv := stream.Recv
+	handoff, ok := stream.(*onceClosedStream)
+	if ok {
+		// the pending receive is handed to the receive loop through it
+		recv = handoff.Events_ChatClient.Recv
+	}
+	confirmed := make([]bool, len(batches))
+	for acked := 0; acked < len(batches); {
+		received := make(chan recvOutcome, 1)
+		ec.routines.spawn(func() {
+			in, err := recv()
+			received <- recvOutcome{in, err}
+		})
+		var r recvOutcome
+		select {
+		case r = <-received:
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return nil, registrationAborted(ctx, "waiting for")
+			}
+			if handoff == nil {
+				return nil, registrationAborted(waitCtx, "waiting for")
+			}
+			handoff.pending = received
+			return ec.unconfirmedBatches(batches, confirmed)
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		ec.countTraffic(MetricBytesReceived, proto.Size(r.in))
+		ack, ok := r.in.Event.(*ehpb.Event_Register)
+		if !ok {
+			if ec.lenientRegister {
+				ec.logf(logging.DEBUG, "ignoring a %T message while waiting for the registration ack", r.in.Event)
+				continue
+			}
+			if r.in.Event == nil {
+				return nil, invalidAckError("invalid nil object for register")
+			}
+			return nil, invalidAckError("invalid registration object")
+		}
+		i := ackedBatch(batches, confirmed, ack.Register)
+		if err := ec.checkAccepted(batches[i].GetRegister(), ack.Register); err != nil {
+			return nil, err
+		}
+		confirmed[i] = true
+		acked++
+		ec.registered(ec.clock.Now().Sub(begin))
+	}
+	return ec.unconfirmedBatches(batches, confirmed)
+}
+
+//ackedBatch returns the index of the batch not yet confirmed that ack
+//acknowledges: the first holding all the interests of ack, or the first
+//when ack does not tell, e.g. is empty
+func ackedBatch(batches []*ehpb.Event, confirmed []bool, ack *ehpb.Register) int {
+	first := -1
+	for i, batch := range batches {
+		if confirmed[i] {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		if len(ack.GetEvents()) > 0 && len(unacceptedInterests(ack.GetEvents(), batch.GetRegister().GetEvents())) == 0 {
+			return i
+		}
+	}
+	return first
+}
+
+//unconfirmedBatches records and logs the batches not confirmed, and returns
+//the interests of the confirmed ones, or an error when there are none
+func (ec *EventsClient) unconfirmedBatches(batches []*ehpb.Event, confirmed []bool) ([]*ehpb.Interest, error) {
+	var missing []int
+	var ies []*ehpb.Interest
+	for i, batch := range batches {
+		if confirmed[i] {
+			ies = append(ies, batch.GetRegister().GetEvents()...)
+		} else {
+			missing = append(missing, i+1)
+		}
+	}
+	ec.mutex.Lock()
+	ec.stats.unconfirmedBatches = missing
+	ec.mutex.Unlock()
+	if len(missing) == len(batches) {
+		return nil, fmt.Errorf("no Register message acknowledged within %s", ec.registerBatchWait)
+	}
+	if len(missing) > 0 {
+		ec.logf(logging.WARNING, "Register messages %v of %d not acknowledged within %s, going on with %d interested events confirmed", missing, len(batches), ec.registerBatchWait, len(ies))
+	}
+	return ies, nil
+}
@@ -0,0 +1,200 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func manyInterests(n int) []*ehpb.Interest {
+	interests := make([]*ehpb.Interest, n)
+	for i := range interests {
+		interests[i] = ChaincodeInterest(fmt.Sprintf("chaincode%03d", i), "")
+	}
+	return interests
+}
+
+func TestRegisterBatches(t *testing.T) {
+	interests := manyInterests(25)
+	reg := &ehpb.Register{Events: interests}
+	limit := proto.Size(&ehpb.Event{Event: &ehpb.Event_Register{Register: &ehpb.Register{Events: interests[:4]}}})
+	tests := []struct {
+		count, bytes int
+		sizes        []int
+	}{
+		{0, 0, []int{25}},
+		{10, 0, []int{10, 10, 5}},
+		{0, limit, []int{4, 4, 4, 4, 4, 4, 1}},
+		{3, limit, []int{3, 3, 3, 3, 3, 3, 3, 3, 1}},
+		// an interest over the limit goes alone
+		{0, 1, []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+	}
+	for i, test := range tests {
+		ec := NewEventsClient("", nil, WithRegisterBatches(test.count, test.bytes))
+		var sizes []int
+		var all []*ehpb.Interest
+		for _, batch := range ec.registerBatches(reg) {
+			sizes = append(sizes, len(batch.GetRegister().Events))
+			all = append(all, batch.GetRegister().Events...)
+			if test.bytes > 1 && proto.Size(batch) > test.bytes {
+				t.Errorf("test %d: batch of %d bytes over the %d bytes limit", i, proto.Size(batch), test.bytes)
+			}
+		}
+		if !reflect.DeepEqual(sizes, test.sizes) {
+			t.Errorf("test %d: expected batches of %v interests, got %v", i, test.sizes, sizes)
+		}
+		if !reflect.DeepEqual(all, interests) {
+			t.Errorf("test %d: the batches do not add up to the interests", i)
+		}
+	}
+}
+
+func TestRegisterInBatches(t *testing.T) {
+	interests := manyInterests(25)
+	registers := make(chan *ehpb.Register, 10)
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		for registered := 0; registered < len(interests); {
+			reg, err := ackRegister(stream)
+			if err != nil {
+				return err
+			}
+			registers <- reg
+			registered += len(reg.Events)
+		}
+		if err := stream.Send(chaincodeEvent("chaincode007", "transfer")); err != nil {
+			return err
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	adapter := &interestsAdapter{newRecordingAdapter(), interests}
+	client := NewEventsClient(addr, adapter, WithRegisterBatches(10, 0))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	var registered []*ehpb.Interest
+	for _, expected := range []int{10, 10, 5} {
+		reg := <-registers
+		if len(reg.Events) != expected {
+			t.Fatalf("expected a Register of %d interests, got %d", expected, len(reg.Events))
+		}
+		registered = append(registered, reg.Events...)
+	}
+	if !reflect.DeepEqual(registered, interests) {
+		t.Fatalf("expected all the interests to be registered, got %v", registered)
+	}
+	if types := client.SubscribedTypes(); !reflect.DeepEqual(types, []ehpb.EventType{ehpb.EventType_CHAINCODE}) {
+		t.Fatalf("expected the chaincode events to be subscribed, got %v", types)
+	}
+}
+
+//delayedAckServer acknowledges the three Register messages as they come,
+//but the second one only once release is closed, then sends an event
+func delayedAckServer(release chan struct{}) func(int, ehpb.Events_ChatServer) error {
+	return func(_ int, stream ehpb.Events_ChatServer) error {
+		var sendMutex sync.Mutex
+		acks := 0
+		ack := func(in *ehpb.Event) error {
+			sendMutex.Lock()
+			defer sendMutex.Unlock()
+			if err := stream.Send(in); err != nil {
+				return err
+			}
+			if acks++; acks < 3 {
+				return nil
+			}
+			return stream.Send(chaincodeEvent("chaincode007", "transfer"))
+		}
+		for n := 1; ; n++ {
+			in, err := stream.Recv()
+			if err != nil {
+				return nil
+			}
+			if n != 2 {
+				if err := ack(in); err != nil {
+					return err
+				}
+				continue
+			}
+			go func() {
+				<-release
+				ack(in)
+			}()
+		}
+	}
+}
+
+func TestRegisterBatchWait(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		unconfirmed []int
+	}{
+		{"strict", nil, nil},
+		{"best effort", []Option{WithRegisterBatchWait(100 * time.Millisecond)}, []int{2}},
+	}
+	for _, test := range tests {
+		release := make(chan struct{})
+		addr, _, stop := startFakeServer(t, delayedAckServer(release))
+		adapter := &interestsAdapter{newRecordingAdapter(), manyInterests(25)}
+		opts := append([]Option{WithRegisterBatches(10, 0)}, test.opts...)
+		client := NewEventsClient(addr, adapter, opts...)
+		if test.unconfirmed == nil {
+			// the strict registration waits for the delayed ack
+			time.AfterFunc(200*time.Millisecond, func() { close(release) })
+		}
+		if err := client.Start(); err != nil {
+			t.Fatalf("%s: could not start client: %s", test.name, err)
+		}
+		if got := client.Stats().UnconfirmedBatches; !reflect.DeepEqual(got, test.unconfirmed) {
+			t.Fatalf("%s: expected unconfirmed batches %v, got %v", test.name, test.unconfirmed, got)
+		}
+		if test.unconfirmed != nil {
+			// the late ack is discarded and the events are delivered
+			close(release)
+		}
+		if e := adapter.waitEvent(t); e.GetChaincodeEvent() == nil {
+			t.Fatalf("%s: expected the chaincode event, got %v", test.name, e)
+		}
+		client.Stop()
+		stop()
+	}
+}
+
+func TestRegisterBatchWaitNoAck(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(_ int, stream ehpb.Events_ChatServer) error {
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter(), WithRegisterBatchWait(50*time.Millisecond))
+	if err := client.Start(); err == nil || !strings.Contains(err.Error(), "no Register message acknowledged") {
+		t.Fatalf("expected the registration to fail without any ack, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"sync"
+	"time"
+)
+
+//BreakerState is the state of the circuit breaker guarding reconnection
+type BreakerState int
+
+//The circuit breaker is closed while reconnect attempts are allowed, open
+//while they are suspended for the cooldown period, and half-open while a
+//single trial attempt decides whether it closes again
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+//CircuitBreakerConfig configures the circuit breaker set with
+//WithCircuitBreaker
+type CircuitBreakerConfig struct {
+	//Failures is the number of failed reconnect attempts within Window that
+	//opens the breaker
+	Failures int
+	Window   time.Duration
+	//Cooldown is how long the breaker stays open before allowing a trial
+	//attempt
+	Cooldown time.Duration
+	//OnStateChange, if set, is called on every transition of the breaker
+	OnStateChange func(from, to BreakerState)
+}
+
+type circuitBreaker struct {
+	mutex    sync.Mutex
+	config   CircuitBreakerConfig
+	state    BreakerState
+	failures []time.Time
+	openedAt time.Time
+	now      func() time.Time
+}
+
+func newCircuitBreaker(config CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{config: config, now: time.Now}
+}
+
+func (b *circuitBreaker) getState() BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state
+}
+
+//wait returns how long to wait before the next attempt is allowed, moving an
+//open breaker whose cooldown has elapsed to half-open
+func (b *circuitBreaker) wait() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state != BreakerOpen {
+		return 0
+	}
+	if remaining := b.openedAt.Add(b.config.Cooldown).Sub(b.now()); remaining > 0 {
+		return remaining
+	}
+	b.transition(BreakerHalfOpen)
+	return 0
+}
+
+func (b *circuitBreaker) success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures = nil
+	if b.state != BreakerClosed {
+		b.transition(BreakerClosed)
+	}
+}
+
+func (b *circuitBreaker) failure() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := b.now()
+	if b.state == BreakerHalfOpen {
+		b.open(now)
+		return
+	}
+	// only keep the failures within the window
+	recent := b.failures[:0]
+	for _, f := range b.failures {
+		if now.Sub(f) < b.config.Window {
+			recent = append(recent, f)
+		}
+	}
+	b.failures = append(recent, now)
+	if len(b.failures) >= b.config.Failures {
+		b.open(now)
+	}
+}
+
+func (b *circuitBreaker) open(now time.Time) {
+	b.failures = nil
+	b.openedAt = now
+	b.transition(BreakerOpen)
+}
+
+func (b *circuitBreaker) transition(to BreakerState) {
+	from := b.state
+	b.state = to
+	if b.config.OnStateChange != nil {
+		b.config.OnStateChange(from, to)
+	}
+}
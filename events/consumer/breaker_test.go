@@ -0,0 +1,126 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	var transitions []BreakerState
+	b := newCircuitBreaker(CircuitBreakerConfig{
+		Failures:      3,
+		Window:        time.Minute,
+		Cooldown:      10 * time.Second,
+		OnStateChange: func(from, to BreakerState) { transitions = append(transitions, to) },
+	})
+	now := time.Unix(0, 0)
+	b.now = func() time.Time { return now }
+
+	// failures outside the window do not count
+	b.failure()
+	now = now.Add(2 * time.Minute)
+	b.failure()
+	b.failure()
+	if b.getState() != BreakerClosed {
+		t.Fatalf("expected breaker to stay closed, got %s", b.getState())
+	}
+	b.failure()
+	if b.getState() != BreakerOpen {
+		t.Fatalf("expected breaker to open, got %s", b.getState())
+	}
+	if wait := b.wait(); wait != 10*time.Second {
+		t.Fatalf("expected a 10s wait, got %s", wait)
+	}
+
+	now = now.Add(10 * time.Second)
+	if wait := b.wait(); wait != 0 || b.getState() != BreakerHalfOpen {
+		t.Fatalf("expected a half-open breaker after the cooldown, got %s (wait %s)", b.getState(), wait)
+	}
+	// a failed trial reopens the breaker
+	b.failure()
+	if b.getState() != BreakerOpen {
+		t.Fatalf("expected breaker to reopen, got %s", b.getState())
+	}
+	now = now.Add(10 * time.Second)
+	b.wait()
+	b.success()
+	if b.getState() != BreakerClosed {
+		t.Fatalf("expected breaker to close, got %s", b.getState())
+	}
+
+	expected := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if !reflect.DeepEqual(transitions, expected) {
+		t.Fatalf("expected transitions %v, got %v", expected, transitions)
+	}
+}
+
+func TestCircuitBreakerReconnect(t *testing.T) {
+	addr, srv, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		switch {
+		case n == 1:
+			// clean close of the first stream
+			_, err := ackRegister(stream)
+			return err
+		case n <= 3:
+			// refuse the registration
+			stream.Recv()
+			return errors.New("overloaded")
+		}
+		return sendBlocks(1)(n, stream)
+	})
+	defer stop()
+
+	var mutex sync.Mutex
+	var transitions []BreakerState
+	config := CircuitBreakerConfig{
+		Failures: 2,
+		Window:   time.Minute,
+		Cooldown: 200 * time.Millisecond,
+		OnStateChange: func(from, to BreakerState) {
+			mutex.Lock()
+			transitions = append(transitions, to)
+			mutex.Unlock()
+		},
+	}
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithReconnectOnServerClose(), WithCircuitBreaker(config))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+
+	adapter.waitEvent(t)
+	if n := srv.chatCount(); n != 4 {
+		t.Fatalf("expected 4 chat streams, got %d", n)
+	}
+	if client.BreakerState() != BreakerClosed {
+		t.Fatalf("expected closed breaker, got %s", client.BreakerState())
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	expected := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if !reflect.DeepEqual(transitions, expected) {
+		t.Fatalf("expected transitions %v, got %v", expected, transitions)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//eventQueue is the bounded FIFO sitting between the stream receive loop and
+//the adapter when the client is configured with a buffer. It is full when it
+//holds capacity events or, with a positive maxBytes, when the next event
+//would take the serialized size of the queued events over maxBytes; an event
+//larger than maxBytes is still queued when the queue is empty
+type eventQueue struct {
+	mutex    sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	idle     *sync.Cond
+	items    []*ehpb.Event
+	capacity int
+	maxBytes int
+	bytes    int
+	drop     bool
+	closed   bool
+	onChange func(depth int)
+
+	//busy is set while the popped event is delivered, halted once the
+	//dispatcher gave up on the queue, drained once its events were removed
+	//by drain
+	busy    bool
+	halted  bool
+	drained bool
+}
+
+func newEventQueue(capacity int, maxBytes int, drop bool, onChange func(depth int)) *eventQueue {
+	q := &eventQueue{capacity: capacity, maxBytes: maxBytes, drop: drop, onChange: onChange}
+	q.notEmpty = sync.NewCond(&q.mutex)
+	q.notFull = sync.NewCond(&q.mutex)
+	q.idle = sync.NewCond(&q.mutex)
+	return q
+}
+
+//push appends e to the queue, blocking while the queue is full unless the
+//queue drops on overflow. It returns false if e was not queued
+func (q *eventQueue) push(e *ehpb.Event) bool {
+	size := proto.Size(e)
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for q.full(size) && !q.closed {
+		if q.drop {
+			return false
+		}
+		q.notFull.Wait()
+	}
+	if q.closed {
+		return false
+	}
+	q.items = append(q.items, e)
+	q.bytes += size
+	q.changed()
+	q.notEmpty.Signal()
+	return true
+}
+
+//full tells whether an event of size bytes does not fit in the queue
+func (q *eventQueue) full(size int) bool {
+	if len(q.items) >= q.capacity {
+		return true
+	}
+	return q.maxBytes > 0 && len(q.items) > 0 && q.bytes+size > q.maxBytes
+}
+
+//pop removes the oldest event, blocking while the queue is empty. It returns
+//false once the queue is closed and drained
+func (q *eventQueue) pop() (*ehpb.Event, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for len(q.items) == 0 {
+		if q.closed {
+			return nil, false
+		}
+		q.notEmpty.Wait()
+	}
+	e := q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+	q.bytes -= proto.Size(e)
+	q.busy = true
+	q.changed()
+	q.notFull.Signal()
+	return e, true
+}
+
+//delivered tells the queue the event last popped has been delivered
+func (q *eventQueue) delivered() {
+	q.mutex.Lock()
+	q.busy = false
+	q.idle.Broadcast()
+	q.mutex.Unlock()
+}
+
+//halt tells the queue no more events will be popped
+func (q *eventQueue) halt() {
+	q.mutex.Lock()
+	q.busy = false
+	q.halted = true
+	q.idle.Broadcast()
+	q.mutex.Unlock()
+}
+
+//waitIdle blocks until the queue is empty and the event last popped has
+//been delivered, or ctx is done
+func (q *eventQueue) waitIdle(ctx context.Context) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			q.mutex.Lock()
+			q.idle.Broadcast()
+			q.mutex.Unlock()
+		case <-stop:
+		}
+	}()
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for len(q.items) > 0 || q.busy {
+		if q.halted {
+			return fmt.Errorf("events client stopped before the buffer was flushed")
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		q.idle.Wait()
+	}
+	return nil
+}
+
+//close stops accepting events; queued events can still be popped
+func (q *eventQueue) close() {
+	q.mutex.Lock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+	q.mutex.Unlock()
+}
+
+//drain closes the queue and removes the events it still holds
+func (q *eventQueue) drain() []*ehpb.Event {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	items := q.items
+	q.items = nil
+	q.bytes = 0
+	q.closed = true
+	q.drained = true
+	q.changed()
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+	q.idle.Broadcast()
+	return items
+}
+
+func (q *eventQueue) isDrained() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.drained
+}
+
+func (q *eventQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.items)
+}
+
+//byteSize returns the serialized size of the queued events
+func (q *eventQueue) byteSize() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.bytes
+}
+
+func (q *eventQueue) changed() {
+	if q.onChange != nil {
+		q.onChange(len(q.items))
+	}
+}
@@ -0,0 +1,470 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//gatedAdapter blocks in Recv until the gate is opened
+type gatedAdapter struct {
+	*recordingAdapter
+	gate chan struct{}
+}
+
+func (a *gatedAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	<-a.gate
+	return a.recordingAdapter.Recv(msg)
+}
+
+func sendBlocks(n int) func(int, ehpb.Events_ChatServer) error {
+	return func(_ int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := stream.Send(blockEvent()); err != nil {
+				return err
+			}
+		}
+		return waitForEOF(stream)
+	}
+}
+
+func TestQueueDepth(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(4))
+	defer stop()
+
+	metrics := newFakeMetrics()
+	adapter := &gatedAdapter{newRecordingAdapter(), make(chan struct{})}
+	client := NewEventsClient(addr, adapter, WithBufferSize(10), WithMetrics(metrics))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+
+	// one event is held by the adapter, the rest wait in the buffer
+	waitFor(t, "buffered events", func() bool { return client.QueueDepth() == 3 })
+
+	close(adapter.gate)
+	for i := 0; i < 4; i++ {
+		adapter.waitEvent(t)
+	}
+	waitFor(t, "empty buffer", func() bool { return client.QueueDepth() == 0 })
+
+	depths := metrics.gauge(MetricQueueDepth)
+	if len(depths) != 8 {
+		t.Fatalf("expected a gauge update per enqueue and dequeue, got %v", depths)
+	}
+	if last := depths[len(depths)-1]; last != 0 {
+		t.Fatalf("expected final gauge value 0, got %v", last)
+	}
+}
+
+func TestDropWhenFull(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(5))
+	defer stop()
+
+	metrics := newFakeMetrics()
+	adapter := &gatedAdapter{newRecordingAdapter(), make(chan struct{})}
+	client := NewEventsClient(addr, adapter, WithBufferSize(2), WithDropWhenFull(), WithMetrics(metrics))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+
+	// with the adapter blocked at most three events fit in the pipeline
+	waitFor(t, "dropped events", func() bool { return client.DroppedEvents() >= 2 })
+	close(adapter.gate)
+	waitFor(t, "all events accounted for", func() bool {
+		return uint64(len(adapter.events))+client.DroppedEvents() == 5
+	})
+	if n := metrics.counter(MetricDroppedEvents); uint64(n) != client.DroppedEvents() {
+		t.Fatalf("expected dropped counter %d, got %v", client.DroppedEvents(), n)
+	}
+}
+
+func TestStopAndDrain(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(5))
+	defer stop()
+
+	adapter := &gatedAdapter{newRecordingAdapter(), make(chan struct{})}
+	client := NewEventsClient(addr, adapter, WithBufferSize(10))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+
+	// one event is held by the adapter, the rest wait in the buffer
+	waitFor(t, "buffered events", func() bool { return client.QueueDepth() == 4 })
+	pending, err := client.StopAndDrain()
+	if err != nil {
+		t.Fatalf("StopAndDrain failed: %s", err)
+	}
+	if len(pending) != 4 {
+		t.Fatalf("expected the 4 buffered events, got %d", len(pending))
+	}
+
+	close(adapter.gate)
+	adapter.waitEvent(t)
+	if err := adapter.waitDisconnected(t); err != nil {
+		t.Fatalf("expected a clean disconnect, got %s", err)
+	}
+	select {
+	case <-adapter.events:
+		t.Fatalf("a drained event was delivered")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if n := client.DroppedEvents(); n != 0 {
+		t.Fatalf("drained events were counted as dropped: %d", n)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(4))
+	defer stop()
+
+	adapter := &gatedAdapter{newRecordingAdapter(), make(chan struct{})}
+	client := NewEventsClient(addr, adapter, WithBufferSize(10))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	waitFor(t, "buffered events", func() bool { return client.QueueDepth() == 3 })
+
+	// the deadline expires while the adapter is blocked
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := client.Flush(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the deadline to expire, got %v", err)
+	}
+
+	flushed := make(chan error)
+	go func() { flushed <- client.Flush(context.Background()) }()
+	select {
+	case err := <-flushed:
+		t.Fatalf("Flush returned before the buffer was delivered: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(adapter.gate)
+	select {
+	case err := <-flushed:
+		if err != nil {
+			t.Fatalf("Flush failed: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Flush did not return")
+	}
+	if n := len(adapter.events); n != 4 {
+		t.Fatalf("expected the 4 events delivered when Flush returns, got %d", n)
+	}
+	if n := client.QueueDepth(); n != 0 {
+		t.Fatalf("expected an empty buffer, got %d", n)
+	}
+}
+
+func TestBufferBytes(t *testing.T) {
+	large := &ehpb.Event{Event: &ehpb.Event_Block{Block: &ehpb.Block{ConsensusMetadata: make([]byte, 1000)}}}
+	size := proto.Size(large)
+	held := make(chan struct{})
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for i := 0; i < 5; i++ {
+			if i == 1 {
+				<-held
+			}
+			if err := stream.Send(large); err != nil {
+				return err
+			}
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	adapter := &gatedAdapter{newRecordingAdapter(), make(chan struct{})}
+	// the count limit leaves room for all events, the byte limit for two
+	client := NewEventsClient(addr, adapter, WithBufferSize(100), WithBufferBytes(2*size+size/2), WithDropWhenFull())
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+
+	// the first event is held by the adapter, two are buffered, two dropped
+	waitFor(t, "held event", func() bool {
+		client.queue.mutex.Lock()
+		defer client.queue.mutex.Unlock()
+		return client.queue.busy
+	})
+	close(held)
+	waitFor(t, "dropped events", func() bool { return client.DroppedEvents() == 2 })
+	if stats := client.Stats(); stats.QueueDepth != 2 || stats.BufferedBytes != 2*size {
+		t.Fatalf("expected 2 buffered events of %d bytes, got %d events of %d bytes", 2*size, stats.QueueDepth, stats.BufferedBytes)
+	}
+	close(adapter.gate)
+	for i := 0; i < 3; i++ {
+		adapter.waitEvent(t)
+	}
+	waitFor(t, "empty buffer", func() bool { return client.Stats().BufferedBytes == 0 })
+}
+
+//batchingAdapter accumulates the events it receives behind its gate and
+//logs its batches when flushed, then its disconnection
+type batchingAdapter struct {
+	gate  chan struct{}
+	mutex sync.Mutex
+	batch int
+	log   []string
+}
+
+func (a *batchingAdapter) GetInterestedEvents() ([]*ehpb.Interest, error) {
+	return []*ehpb.Interest{BlockInterest()}, nil
+}
+
+func (a *batchingAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	<-a.gate
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.batch++
+	return true, nil
+}
+
+func (a *batchingAdapter) Flush() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.log = append(a.log, fmt.Sprintf("flush %d", a.batch))
+	a.batch = 0
+	return nil
+}
+
+func (a *batchingAdapter) Disconnected(err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.log = append(a.log, "disconnected")
+}
+
+//delivered counts the events received, flushed or not
+func (a *batchingAdapter) delivered() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	n := a.batch
+	for _, entry := range a.log {
+		var flushed int
+		if _, err := fmt.Sscanf(entry, "flush %d", &flushed); err == nil {
+			n += flushed
+		}
+	}
+	return n
+}
+
+func (a *batchingAdapter) entries() []string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]string(nil), a.log...)
+}
+
+func TestFlushBeforeDisconnected(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithBufferSize(10)}} {
+		addr, _, stop := startFakeServer(t, sendBlocks(3))
+		adapter := &batchingAdapter{gate: make(chan struct{})}
+		client := NewEventsClient(addr, adapter, opts...)
+		if err := client.Start(); err != nil {
+			t.Fatalf("could not start client: %s", err)
+		}
+		if opts == nil {
+			// let the events through before stopping
+			close(adapter.gate)
+			waitFor(t, "the events to be delivered", func() bool {
+				adapter.mutex.Lock()
+				defer adapter.mutex.Unlock()
+				return adapter.batch == 3
+			})
+		} else {
+			waitFor(t, "the events to be buffered", func() bool { return client.Stats().QueueDepth == 2 })
+		}
+		client.Stop()
+		if opts != nil {
+			close(adapter.gate)
+		}
+		client.Wait()
+		expected := []string{"flush 3", "disconnected"}
+		if got := adapter.entries(); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+		stop()
+	}
+}
+
+func TestDeliverBufferedOnEOF(t *testing.T) {
+	// the stream ends right after the events, while they are buffered
+	endAfterBlocks := func(_ int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for i := 0; i < 3; i++ {
+			if err := stream.Send(blockEvent()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	addr, _, stop := startFakeServer(t, endAfterBlocks)
+	defer stop()
+	adapter := &batchingAdapter{gate: make(chan struct{})}
+	client := NewEventsClient(addr, adapter, WithBufferSize(10))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	waitFor(t, "the events to be buffered", func() bool { return client.Stats().QueueDepth == 2 })
+	close(adapter.gate)
+	if err := client.Wait(); err != ErrServerClosed {
+		t.Fatalf("expected the client to terminate with ErrServerClosed, got %v", err)
+	}
+	expected := []string{"flush 3", "disconnected"}
+	if got := adapter.entries(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	// the delivery of the buffered events is bounded by the shutdown timeout
+	adapter = &batchingAdapter{gate: make(chan struct{})}
+	defer close(adapter.gate)
+	client = NewEventsClient(addr, adapter, WithBufferSize(10), WithShutdownTimeout(100*time.Millisecond))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	client.Wait()
+	expected = []string{"disconnected"}
+	if got := adapter.entries(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v once the shutdown timeout expired, got %v", expected, got)
+	}
+}
+
+func TestFlushOnReconnect(t *testing.T) {
+	// the first stream ends in the middle of the batch
+	handle := func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for i := 0; i < 5-2*n; i++ {
+			if err := stream.Send(blockEvent()); err != nil {
+				return err
+			}
+		}
+		if n == 1 {
+			return nil
+		}
+		return waitForEOF(stream)
+	}
+	for _, test := range []struct {
+		opts     []Option
+		expected []string
+	}{
+		{[]Option{WithReconnectOnServerClose()}, []string{"flush 4", "disconnected"}},
+		{[]Option{WithReconnectOnServerClose(), WithFlushOnReconnect()}, []string{"flush 3", "flush 1", "disconnected"}},
+		{[]Option{WithReconnectOnServerClose(), WithFlushOnReconnect(), WithBufferSize(10)}, []string{"flush 3", "flush 1", "disconnected"}},
+	} {
+		addr, srv, stop := startFakeServer(t, handle)
+		adapter := &batchingAdapter{gate: make(chan struct{})}
+		close(adapter.gate)
+		client := NewEventsClient(addr, adapter, test.opts...)
+		if err := client.Start(); err != nil {
+			t.Fatalf("could not start client: %s", err)
+		}
+		waitFor(t, "the events of both streams", func() bool { return srv.chatCount() == 2 && adapter.delivered() == 4 })
+		client.Stop()
+		client.Wait()
+		if got := adapter.entries(); !reflect.DeepEqual(got, test.expected) {
+			t.Fatalf("%d options: expected %v, got %v", len(test.opts), test.expected, got)
+		}
+		stop()
+	}
+}
+
+//watermarkAdapter records the watermark notifications
+type watermarkAdapter struct {
+	*gatedAdapter
+	marks chan string
+}
+
+func (a *watermarkAdapter) OnBufferHighWatermark() { a.marks <- "high" }
+func (a *watermarkAdapter) OnBufferLowWatermark()  { a.marks <- "low" }
+
+func (a *watermarkAdapter) waitMark(t *testing.T) string {
+	select {
+	case mark := <-a.marks:
+		return mark
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for a watermark notification")
+	}
+	return ""
+}
+
+func (a *watermarkAdapter) noMark(t *testing.T) {
+	select {
+	case mark := <-a.marks:
+		t.Fatalf("unexpected %s watermark notification", mark)
+	default:
+	}
+}
+
+func TestBufferWatermarks(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(8))
+	defer stop()
+
+	adapter := &watermarkAdapter{&gatedAdapter{newRecordingAdapter(), make(chan struct{})}, make(chan string, 10)}
+	client := NewEventsClient(addr, adapter, WithBufferSize(10), WithBufferWatermarks(6, 2))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+
+	// one event is held by the adapter, the rest wait in the buffer
+	waitFor(t, "buffered events", func() bool { return client.QueueDepth() == 7 })
+	if mark := adapter.waitMark(t); mark != "high" || !client.AboveBufferWatermark() {
+		t.Fatalf("expected the high watermark to be reached, got %s", mark)
+	}
+	adapter.noMark(t)
+
+	// between the marks the buffer stays above its high watermark
+	for i := 0; i < 4; i++ {
+		adapter.gate <- struct{}{}
+	}
+	waitFor(t, "buffer between the marks", func() bool { return client.QueueDepth() == 3 })
+	adapter.noMark(t)
+	if !client.AboveBufferWatermark() || !client.Stats().AboveBufferWatermark {
+		t.Fatalf("expected the buffer to stay above its high watermark")
+	}
+
+	adapter.gate <- struct{}{}
+	if mark := adapter.waitMark(t); mark != "low" || client.AboveBufferWatermark() {
+		t.Fatalf("expected the low watermark to be reached, got %s", mark)
+	}
+	close(adapter.gate)
+	for i := 0; i < 8; i++ {
+		adapter.waitEvent(t)
+	}
+	adapter.noMark(t)
+}
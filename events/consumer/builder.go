@@ -0,0 +1,80 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"github.com/op/go-logging"
+)
+
+//Builder configures an events client step by step, as an alternative to
+//passing the options to NewEventsClient when there are many of them. Each
+//method returns the builder so that calls can be chained, and Build creates
+//the client. The methods not shadowing an option take the options with With
+type Builder struct {
+	peerAddress string
+	adapter     EventAdapter
+	opts        []Option
+}
+
+//NewBuilder returns a builder of a client of the event hub at peerAddress
+func NewBuilder(peerAddress string) *Builder {
+	return &Builder{peerAddress: peerAddress}
+}
+
+//WithAdapter sets the adapter the client delivers the events to
+func (b *Builder) WithAdapter(adapter EventAdapter) *Builder {
+	b.adapter = adapter
+	return b
+}
+
+//WithConnectionConfig sets the connection configuration, including TLS, as
+//WithConnectionConfig does
+func (b *Builder) WithConnectionConfig(config ConnectionConfig) *Builder {
+	return b.With(WithConnectionConfig(config))
+}
+
+//WithReconnect has the client reconnect when the event hub closes the
+//stream, waiting between the attempts as told by backoff unless it is nil
+func (b *Builder) WithReconnect(backoff Backoff) *Builder {
+	b.With(WithReconnectOnServerClose())
+	if backoff != nil {
+		b.With(WithBackoff(backoff))
+	}
+	return b
+}
+
+//WithLogger sets the logger of the client, as WithLogger does
+func (b *Builder) WithLogger(logger *logging.Logger) *Builder {
+	return b.With(WithLogger(logger))
+}
+
+//With adds opts to the options of the client. Options are applied in the
+//order they were added, whichever method added them
+func (b *Builder) With(opts ...Option) *Builder {
+	b.opts = append(b.opts, opts...)
+	return b
+}
+
+//Build creates the client. It fails with every problem of the configuration
+//that Start would report, a missing adapter included
+func (b *Builder) Build() (*EventsClient, error) {
+	ec := NewEventsClient(b.peerAddress, b.adapter, b.opts...)
+	if err := ec.validate(); err != nil {
+		return nil, err
+	}
+	return ec, nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+func TestBuilder(t *testing.T) {
+	adapter := newRecordingAdapter()
+	config := ConnectionConfig{DialTimeout: time.Second}
+	backoff := &scriptedBackoff{}
+	logger := logging.MustGetLogger("builder_test")
+
+	built, err := NewBuilder("localhost:7053").
+		WithAdapter(adapter).
+		WithConnectionConfig(config).
+		WithReconnect(backoff).
+		WithLogger(logger).
+		With(WithName("built"), WithBufferSize(4)).
+		Build()
+	if err != nil {
+		t.Fatalf("could not build the client: %s", err)
+	}
+	expected := NewEventsClient("localhost:7053", adapter, WithConnectionConfig(config), WithReconnectOnServerClose(), WithBackoff(backoff), WithLogger(logger), WithName("built"), WithBufferSize(4))
+	for _, field := range []struct {
+		name          string
+		got, expected interface{}
+	}{
+		{"peer address", built.PeerAddress(), expected.PeerAddress()},
+		{"adapter", built.adapter, expected.adapter},
+		{"connection config", built.connConfig, expected.connConfig},
+		{"reconnect", built.reconnectOnServerClose, expected.reconnectOnServerClose},
+		{"backoff", built.backoff, expected.backoff},
+		{"logger", built.logger, expected.logger},
+		{"name", built.Name(), expected.Name()},
+		{"buffer size", built.bufferSize, expected.bufferSize},
+	} {
+		if !reflect.DeepEqual(field.got, field.expected) {
+			t.Errorf("expected %s %v, got %v", field.name, field.expected, field.got)
+		}
+	}
+
+	if _, err := NewBuilder("localhost:7053").Build(); err == nil || !strings.Contains(err.Error(), "no adapter") {
+		t.Fatalf("expected a missing adapter to fail, got %v", err)
+	}
+	_, err = NewBuilder("localhost:7053").WithAdapter(adapter).With(WithBufferSize(-1), WithDropWhenFull()).Build()
+	if err == nil || !strings.Contains(err.Error(), "negative buffer size") || !strings.Contains(err.Error(), "dropping when full requires a buffer") {
+		t.Fatalf("expected the configuration problems to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+)
+
+//ErrTLSFilesChanged is the cause of the reconnections triggered by
+//WithTLSFileWatch
+var ErrTLSFilesChanged = errors.New("TLS files changed")
+
+//WithTLSFileWatch has the client watch the TLS files of its connection and
+//replace its stream once one of them has changed, so that new credentials
+//take effect without waiting for the event hub to end the stream. The CA
+//file of the connection configuration is watched, along with files, e.g.
+//the client certificate and key of the DialOptions. The files are polled
+//every interval while the client runs, from Start to Stop.
+//
+//A file replaced atomically, by renaming a new one over it, is picked up as
+//a whole. A file modified in place may be caught half written, so a change
+//only triggers the reconnection once the file has stayed the same for one
+//interval: writing it must take less than that. A missing file is a change
+//too, only reported if the file is still missing at the next poll. The new
+//connection fails like any other when the new files are invalid, and the
+//reconnection then goes through the backoff and the attempts configured
+func WithTLSFileWatch(interval time.Duration, files ...string) Option {
+	return func(ec *EventsClient) {
+		ec.watchInterval = interval
+		ec.watchFiles = files
+	}
+}
+
+//fileState is what a poll of a watched file sees
+type fileState struct {
+	info os.FileInfo
+}
+
+func statFile(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{info: info}
+}
+
+//same tells whether s and other are the same version of the file: the same
+//file, i.e. not replaced, with the same size and modification time
+func (s fileState) same(other fileState) bool {
+	if s.info == nil || other.info == nil {
+		return s.info == nil && other.info == nil
+	}
+	return os.SameFile(s.info, other.info) && s.info.Size() == other.info.Size() && s.info.ModTime().Equal(other.info.ModTime())
+}
+
+//connectingWith records the CA file about to be read by a connection with
+//config, to watch it along with the other files
+func (ec *EventsClient) connectingWith(config ConnectionConfig) {
+	if ec.watchInterval <= 0 {
+		return
+	}
+	var path string
+	var state fileState
+	if config.TLSEnabled && len(config.CACert) == 0 && config.CACertFile != "" {
+		path = config.CACertFile
+		state = statFile(path)
+	}
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.caFile = path
+	ec.caFileState = state
+}
+
+//watchedFiles returns the files watched for the current connection, with
+//the state of its CA file when the connection read it
+func (ec *EventsClient) watchedFiles() ([]string, fileState) {
+	files := append([]string(nil), ec.watchFiles...)
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if ec.caFile != "" {
+		files = append(files, ec.caFile)
+	}
+	return files, ec.caFileState
+}
+
+//statTLSFiles returns the state of the files given to WithTLSFileWatch
+func (ec *EventsClient) statTLSFiles() map[string]fileState {
+	states := make(map[string]fileState)
+	for _, path := range ec.watchFiles {
+		states[path] = statFile(path)
+	}
+	return states
+}
+
+//watchTLSFiles polls the watched files until ctx is done and requests a new
+//stream once a change from current has settled
+func (ec *EventsClient) watchTLSFiles(ctx context.Context, current map[string]fileState) {
+	pending := make(map[string]fileState)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ec.clock.After(ec.watchInterval):
+		}
+		changed := false
+		files, caState := ec.watchedFiles()
+		for _, path := range files {
+			state := statFile(path)
+			last, known := current[path]
+			if !known {
+				// the CA file of a new connection
+				last = caState
+				current[path] = last
+			}
+			if state.same(last) {
+				delete(pending, path)
+				continue
+			}
+			if p, ok := pending[path]; ok && state.same(p) {
+				ec.logf(logging.INFO, "TLS file %s changed", path)
+				current[path] = state
+				delete(pending, path)
+				changed = true
+				continue
+			}
+			pending[path] = state
+		}
+		if changed {
+			ec.requestReconnect(ErrTLSFilesChanged)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTLSFileWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certwatch")
+	if err != nil {
+		t.Fatalf("could not create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "client.pem")
+	if err := ioutil.WriteFile(path, []byte("first"), 0600); err != nil {
+		t.Fatalf("could not write the watched file: %s", err)
+	}
+
+	addr, srv, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+	adapter := newRecordingAdapter()
+	reconnects := make(chan ReconnectInfo, 10)
+	client := NewEventsClient(addr, adapter, WithTLSFileWatch(10*time.Millisecond, path), WithReconnectHook(func(info ReconnectInfo) { reconnects <- info }))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+
+	expectReconnect := func(what string) {
+		select {
+		case info := <-reconnects:
+			if info.Cause != ErrTLSFilesChanged {
+				t.Fatalf("expected ErrTLSFilesChanged as the cause, got %v", info.Cause)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the reconnect after %s", what)
+		}
+		adapter.waitEvent(t)
+	}
+
+	// modified in place
+	if err := ioutil.WriteFile(path, []byte("second version"), 0600); err != nil {
+		t.Fatalf("could not modify the watched file: %s", err)
+	}
+	expectReconnect("modifying the file")
+
+	// replaced atomically
+	tmp := filepath.Join(dir, "client.pem.tmp")
+	if err := ioutil.WriteFile(tmp, []byte("third"), 0600); err != nil {
+		t.Fatalf("could not write the new file: %s", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("could not replace the watched file: %s", err)
+	}
+	expectReconnect("replacing the file")
+
+	select {
+	case info := <-reconnects:
+		t.Fatalf("unexpected reconnect %v", info)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := srv.chatCount(); n != 3 {
+		t.Fatalf("expected 3 chat streams, got %d", n)
+	}
+}
@@ -0,0 +1,52 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//ChaincodeEvent is a chaincode event decoded from an event hub message
+type ChaincodeEvent struct {
+	ChaincodeID string
+	TxID        string
+	EventName   string
+	Payload     []byte
+}
+
+//DecodeChaincodeEvent extracts the chaincode event carried by msg. It fails
+//for messages that are not chaincode events
+func DecodeChaincodeEvent(msg *ehpb.Event) (*ChaincodeEvent, error) {
+	cc := msg.GetChaincodeEvent()
+	if cc == nil {
+		return nil, fmt.Errorf("not a chaincode event: %T", msg.GetEvent())
+	}
+	return &ChaincodeEvent{ChaincodeID: cc.ChaincodeID, TxID: cc.TxID, EventName: cc.EventName, Payload: cc.Payload}, nil
+}
+
+//UnmarshalPayload decodes the protobuf-encoded payload of the event into
+//target
+func (e *ChaincodeEvent) UnmarshalPayload(target proto.Message) error {
+	if err := proto.Unmarshal(e.Payload, target); err != nil {
+		return fmt.Errorf("error unmarshaling payload of chaincode event %s from %s: %s", e.EventName, e.ChaincodeID, err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestDecodeChaincodeEvent(t *testing.T) {
+	payload, err := proto.Marshal(&ehpb.ChaincodeReg{ChaincodeID: "inner", EventName: "payload"})
+	if err != nil {
+		t.Fatalf("could not marshal payload: %s", err)
+	}
+	msg := &ehpb.Event{Event: &ehpb.Event_ChaincodeEvent{ChaincodeEvent: &ehpb.ChaincodeEvent{
+		ChaincodeID: "mycc", TxID: "tx1", EventName: "evt", Payload: payload,
+	}}}
+
+	cc, err := DecodeChaincodeEvent(msg)
+	if err != nil {
+		t.Fatalf("could not decode chaincode event: %s", err)
+	}
+	if cc.ChaincodeID != "mycc" || cc.TxID != "tx1" || cc.EventName != "evt" {
+		t.Fatalf("unexpected decoded event %+v", cc)
+	}
+	var target ehpb.ChaincodeReg
+	if err = cc.UnmarshalPayload(&target); err != nil {
+		t.Fatalf("could not unmarshal payload: %s", err)
+	}
+	if target.ChaincodeID != "inner" || target.EventName != "payload" {
+		t.Fatalf("unexpected payload %v", target)
+	}
+
+	cc.Payload = []byte{0xff}
+	if err = cc.UnmarshalPayload(&target); err == nil {
+		t.Fatalf("expected an error for a corrupt payload")
+	}
+}
+
+func TestDecodeChaincodeEventWrongType(t *testing.T) {
+	if _, err := DecodeChaincodeEvent(blockEvent()); err == nil {
+		t.Fatalf("expected an error for a block event")
+	}
+}
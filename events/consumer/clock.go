@@ -0,0 +1,105 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+//clock is the source of time of a client: the registration timeout, the
+//backoff and throttle waits, the circuit breaker, the rate meter and the
+//staleness of Healthy all go through it, so that tests can drive them
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+//realClock is the clock of the time package, the default
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+//withClock replaces the clock of the client, for tests
+func withClock(c clock) Option {
+	return func(ec *EventsClient) {
+		ec.clock = c
+	}
+}
+
+//clockKey is the context key of the clock of a client, set on the context
+//passed to RecvContext
+type clockKey struct{}
+
+//clockFrom returns the clock of the client that passed ctx to RecvContext,
+//the real clock for any other context
+func clockFrom(ctx context.Context) clock {
+	if c, ok := ctx.Value(clockKey{}).(clock); ok {
+		return c
+	}
+	return realClock{}
+}
+
+//withTimeout is context.WithTimeout measured by c
+func withTimeout(c clock, parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := c.(realClock); ok {
+		return context.WithTimeout(parent, timeout)
+	}
+	ctx, cancel := context.WithCancel(parent)
+	tc := &timeoutContext{Context: ctx, deadline: c.Now().Add(timeout)}
+	go func() {
+		select {
+		case <-c.After(timeout):
+			tc.mutex.Lock()
+			tc.expired = true
+			tc.mutex.Unlock()
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return tc, cancel
+}
+
+//timeoutContext reports context.DeadlineExceeded once the timeout of
+//withTimeout has expired
+type timeoutContext struct {
+	context.Context
+	deadline time.Time
+	mutex    sync.Mutex
+	expired  bool
+}
+
+func (c *timeoutContext) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *timeoutContext) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.expired {
+		return context.DeadlineExceeded
+	}
+	return c.Context.Err()
+}
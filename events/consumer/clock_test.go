@@ -0,0 +1,164 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//fakeClock only moves forward with advance
+type fakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	w := fakeWaiter{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, w)
+	return w.c
+}
+
+func (c *fakeClock) pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.waiters)
+}
+
+//advance moves the clock forward by d and fires the waiters due
+func (c *fakeClock) advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+	var waiting []fakeWaiter
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.c <- c.now
+	}
+	c.waiters = waiting
+}
+
+func TestFakeClockRegistrationTimeout(t *testing.T) {
+	addr, _, stop := startFakeServer(t, neverAck)
+	defer stop()
+
+	clock := newFakeClock()
+	client := NewEventsClient(addr, newRecordingAdapter(), WithRegistrationTimeout(time.Hour), withClock(clock))
+	result := make(chan error, 1)
+	go func() { result <- client.Start() }()
+	waitFor(t, "the registration timeout to be set", func() bool { return clock.pending() == 1 })
+	clock.advance(time.Hour)
+	select {
+	case err := <-result:
+		if err == nil || !strings.Contains(err.Error(), "timeout") {
+			t.Fatalf("expected the registration to time out, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the registration did not time out with the clock")
+	}
+}
+
+func TestFakeClockStaleness(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(0))
+	defer stop()
+
+	clock := newFakeClock()
+	client := NewEventsClient(addr, newRecordingAdapter(), WithStalenessThreshold(time.Minute), withClock(clock))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	if err := client.Healthy(); err != nil {
+		t.Fatalf("expected a healthy client, got %s", err)
+	}
+	clock.advance(2 * time.Minute)
+	if err := client.Healthy(); err == nil || !strings.Contains(err.Error(), "registered 2m0s ago") {
+		t.Fatalf("expected the client to be stale, got %v", err)
+	}
+}
+
+//clockedAdapter takes a second of the fake clock to receive an event
+type clockedAdapter struct {
+	*recordingAdapter
+	clock *fakeClock
+}
+
+func (a *clockedAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	a.clock.advance(time.Second)
+	return a.recordingAdapter.Recv(msg)
+}
+
+func TestFakeClockTimings(t *testing.T) {
+	clock := newFakeClock()
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		in, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		// the event hub takes 3 seconds to acknowledge
+		clock.advance(3 * time.Second)
+		if err := stream.Send(in); err != nil {
+			return err
+		}
+		if err := stream.Send(blockEvent()); err != nil {
+			return err
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	adapter := &clockedAdapter{newRecordingAdapter(), clock}
+	client := NewEventsClient(addr, adapter, withClock(clock))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	waitFor(t, "the adapter to return", func() bool { return client.Stats().AdapterCalls == 1 })
+	stats := client.Stats()
+	if stats.RegistrationTime != 3*time.Second {
+		t.Fatalf("expected the registration to take 3s of the clock, got %s", stats.RegistrationTime)
+	}
+	if stats.AdapterTime != time.Second {
+		t.Fatalf("expected the adapter to take 1s of the clock, got %s", stats.AdapterTime)
+	}
+}
@@ -0,0 +1,297 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/hyperledger/fabric/core/comm"
+)
+
+//ConnectionConfig holds everything needed to open the connection to the
+//event hub. Unless WithConnectionConfig is used, the client reads it from the
+//peer configuration with ConnectionConfigFromViper on every connection
+type ConnectionConfig struct {
+	TLSEnabled bool
+	//CACert holds the PEM encoded certificates of the CAs verifying the
+	//event hub. When it is empty they are read from CACertFile, and when both
+	//are empty the system pool is used
+	CACert     []byte
+	CACertFile string
+	//FallbackToSystemCAs verifies the event hub with the system pool when
+	//CACertFile cannot be read, instead of failing the connection
+	FallbackToSystemCAs bool
+	//ServerNameOverride replaces the host name of the peer address when
+	//verifying the event hub certificate
+	ServerNameOverride string
+	//CipherSuites restricts the cipher suites of TLS 1.2 and below to these
+	//crypto/tls IDs, e.g. to comply with a crypto policy, while the suites
+	//of TLS 1.3 are not configurable. An ID unknown to crypto/tls is an
+	//error, and the insecure suites are accepted with a warning. The
+	//defaults of crypto/tls apply when it is empty
+	CipherSuites []uint16
+	//DialTimeout bounds the connection establishment, 3 seconds when zero
+	DialTimeout time.Duration
+	//DialOptions are applied after the options derived from the other fields
+	DialOptions []grpc.DialOption
+}
+
+//ConnectionConfigFromViper returns the connection configuration from the
+//peer.tls settings
+func ConnectionConfigFromViper() ConnectionConfig {
+	return ConnectionConfig{
+		TLSEnabled:         comm.TLSEnabled(),
+		CACertFile:         viper.GetString("peer.tls.cert.file"),
+		ServerNameOverride: viper.GetString("peer.tls.serverhostoverride"),
+	}
+}
+
+func (c ConnectionConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{ServerName: c.ServerNameOverride}
+	caCert := c.CACert
+	if len(caCert) == 0 && c.CACertFile != "" {
+		var err error
+		if caCert, err = ioutil.ReadFile(c.CACertFile); err != nil {
+			if !c.FallbackToSystemCAs {
+				return nil, err
+			}
+			consumerLogger.Warningf("could not read CA file, using the system pool: %s", err)
+		}
+	}
+	if len(caCert) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("credentials: failed to append certificates")
+		}
+	}
+	if len(c.CipherSuites) > 0 {
+		if err := checkCipherSuites(c.CipherSuites); err != nil {
+			return nil, err
+		}
+		config.CipherSuites = append([]uint16(nil), c.CipherSuites...)
+	}
+	return config, nil
+}
+
+//cipherSuite describes a cipher suite implemented by crypto/tls
+type cipherSuite struct {
+	id       uint16
+	name     string
+	insecure bool
+	tls13    bool
+}
+
+//knownCipherSuites lists the cipher suites of crypto/tls, the insecure ones
+//included. It is kept here rather than taken from tls.CipherSuites, which
+//the Go versions the tree builds with do not all have
+var knownCipherSuites = []cipherSuite{
+	{0x1301, "TLS_AES_128_GCM_SHA256", false, true},
+	{0x1302, "TLS_AES_256_GCM_SHA384", false, true},
+	{0x1303, "TLS_CHACHA20_POLY1305_SHA256", false, true},
+	{0xc009, "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA", false, false},
+	{0xc00a, "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA", false, false},
+	{0xc013, "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA", false, false},
+	{0xc014, "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA", false, false},
+	{0xc02b, "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", false, false},
+	{0xc02c, "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", false, false},
+	{0xc02f, "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", false, false},
+	{0xc030, "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", false, false},
+	{0xcca8, "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256", false, false},
+	{0xcca9, "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256", false, false},
+	{0x0005, "TLS_RSA_WITH_RC4_128_SHA", true, false},
+	{0x000a, "TLS_RSA_WITH_3DES_EDE_CBC_SHA", true, false},
+	{0x002f, "TLS_RSA_WITH_AES_128_CBC_SHA", true, false},
+	{0x0035, "TLS_RSA_WITH_AES_256_CBC_SHA", true, false},
+	{0x003c, "TLS_RSA_WITH_AES_128_CBC_SHA256", true, false},
+	{0x009c, "TLS_RSA_WITH_AES_128_GCM_SHA256", true, false},
+	{0x009d, "TLS_RSA_WITH_AES_256_GCM_SHA384", true, false},
+	{0xc007, "TLS_ECDHE_ECDSA_WITH_RC4_128_SHA", true, false},
+	{0xc011, "TLS_ECDHE_RSA_WITH_RC4_128_SHA", true, false},
+	{0xc012, "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA", true, false},
+	{0xc023, "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256", true, false},
+	{0xc027, "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256", true, false},
+}
+
+//checkCipherSuites fails on the suites unknown to crypto/tls and warns about
+//the insecure ones and those of TLS 1.3, which are ignored
+func checkCipherSuites(suites []uint16) error {
+	known := make(map[uint16]cipherSuite, len(knownCipherSuites))
+	for _, suite := range knownCipherSuites {
+		known[suite.id] = suite
+	}
+	for _, id := range suites {
+		suite, ok := known[id]
+		if !ok {
+			return fmt.Errorf("unknown cipher suite 0x%04x", id)
+		}
+		if suite.insecure {
+			consumerLogger.Warningf("cipher suite %s is insecure", suite.name)
+		}
+		if suite.tls13 {
+			consumerLogger.Warningf("cipher suite %s of TLS 1.3 is not configurable, ignoring it", suite.name)
+		}
+	}
+	return nil
+}
+
+//ValidateTLS builds the TLS configuration of every peer the client is
+//configured for, the peer address and those given to
+//WithPeerConnectionConfig, and returns an error listing those that cannot
+//be built, e.g. because a CA file is missing or holds no valid certificate.
+//Calling it right after creating the client surfaces such errors at startup
+//instead of when connecting; Start does not call it, so that the files can
+//be provided later
+func (ec *EventsClient) ValidateTLS() error {
+	if ec.ownStream != nil {
+		return nil
+	}
+	addresses := []string{ec.peerAddress}
+	for addr := range ec.peerConfigs {
+		addresses = append(addresses, addr)
+	}
+	sort.Strings(addresses[1:])
+	checked := make(map[string]bool)
+	var problems []string
+	for _, addr := range addresses {
+		if normalized, err := normalizePeerAddress(addr); err == nil {
+			addr = normalized
+		}
+		if checked[addr] {
+			continue
+		}
+		checked[addr] = true
+		config := ec.connectionConfig(addr)
+		if !config.TLSEnabled {
+			continue
+		}
+		if _, err := config.tlsConfig(); err != nil {
+			problems = append(problems, fmt.Sprintf("peer %s: %s", addr, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid TLS configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+//Connect dials the event hub ahead of Start, e.g. during the initialization
+//of a service, so that Start only has to open the chat stream and register.
+//The connection is kept for the next Start, and closed by Stop if the client
+//is stopped first. It returns ctx.Err() if ctx is done before the
+//connection is established, and does nothing when the client is already
+//connected. With a peer selector the peer is selected by Connect. A client
+//created with NewFromStream does not dial, so Connect fails
+func (ec *EventsClient) Connect(ctx context.Context) error {
+	if err := ec.validate(); err != nil {
+		return err
+	}
+	if ec.ownStream != nil {
+		return fmt.Errorf("a client created from a stream does not connect")
+	}
+	ec.mutex.Lock()
+	if ec.stopped {
+		ec.mutex.Unlock()
+		return errClientStopped
+	}
+	if ec.starting || ec.started {
+		ec.mutex.Unlock()
+		return ErrAlreadyStarted
+	}
+	connected := ec.warmConn != nil
+	ec.mutex.Unlock()
+	if connected {
+		return nil
+	}
+	if ec.selector != nil {
+		if err := ec.selectPeer(); err != nil {
+			return err
+		}
+	} else {
+		addr, err := normalizePeerAddress(ec.PeerAddress())
+		if err != nil {
+			return err
+		}
+		ec.mutex.Lock()
+		ec.peerAddress = addr
+		ec.mutex.Unlock()
+	}
+
+	conn, err := ec.dialContext(ctx, ec.PeerAddress())
+	if err != nil {
+		if ctx.Err() == nil {
+			ec.recordError(err)
+		}
+		return err
+	}
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if ec.stopped || ec.warmConn != nil || ec.starting || ec.started {
+		conn.Close()
+		if ec.stopped {
+			return errClientStopped
+		}
+		return nil
+	}
+	ec.warmConn = conn
+	return nil
+}
+
+//takeWarmConn returns and forgets the connection established by Connect, if
+//any
+func (ec *EventsClient) takeWarmConn() *grpc.ClientConn {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	conn := ec.warmConn
+	ec.warmConn = nil
+	return conn
+}
+
+//newEventsClientConnectionWithAddress Returns a new grpc.ClientConn to the configured local PEER.
+func newEventsClientConnectionWithAddress(peerAddress string, config ConnectionConfig, verifier tlsVerifier, block bool, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	var dialOpts []grpc.DialOption
+	if config.DialTimeout > 0 {
+		dialOpts = append(dialOpts, grpc.WithTimeout(config.DialTimeout))
+	}
+	dialOpts = append(append(dialOpts, config.DialOptions...), opts...)
+	if !config.TLSEnabled {
+		if verifier != nil {
+			return nil, fmt.Errorf("certificate pinning requires TLS to be enabled")
+		}
+		return comm.NewClientConnectionWithAddress(peerAddress, block, false, nil, dialOpts...)
+	}
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if verifier == nil {
+		return comm.NewClientConnectionWithAddress(peerAddress, block, true, credentials.NewTLS(tlsConfig), dialOpts...)
+	}
+	verifier.apply(tlsConfig)
+	creds := verifyingCreds{TransportAuthenticator: credentials.NewTLS(tlsConfig), verifier: verifier}
+	return comm.NewClientConnectionWithAddress(peerAddress, block, true, creds, dialOpts...)
+}
@@ -0,0 +1,342 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/hyperledger/fabric/core/comm"
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestConnectionConfigFromViper(t *testing.T) {
+	viper.Set("peer.tls.enabled", true)
+	viper.Set("peer.tls.cert.file", "/etc/hyperledger/ca.pem")
+	viper.Set("peer.tls.serverhostoverride", "eventhub")
+	comm.CacheConfiguration()
+	defer func() {
+		viper.Set("peer.tls.enabled", false)
+		viper.Set("peer.tls.cert.file", "")
+		viper.Set("peer.tls.serverhostoverride", "")
+		comm.CacheConfiguration()
+	}()
+
+	config := ConnectionConfigFromViper()
+	if !config.TLSEnabled || config.CACertFile != "/etc/hyperledger/ca.pem" || config.ServerNameOverride != "eventhub" {
+		t.Fatalf("unexpected configuration %+v", config)
+	}
+}
+
+func TestConnectionConfigTLS(t *testing.T) {
+	tlsCert, _, certPEM := selfSignedCert(t)
+	addr, stop := startTLSFakeServer(t, tlsCert, sendBlocks(1))
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithConnectionConfig(ConnectionConfig{TLSEnabled: true, CACert: certPEM}))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+
+	client = NewEventsClient(addr, adapter, WithConnectionConfig(ConnectionConfig{TLSEnabled: true, CACertFile: "/nonexistent/ca.pem"}))
+	if err := client.Start(); err == nil {
+		client.Stop()
+		t.Fatalf("expected an unreadable CA file to fail Start")
+	}
+}
+
+func TestConnectionConfigCAFallback(t *testing.T) {
+	config := ConnectionConfig{TLSEnabled: true, CACertFile: "/nonexistent/ca.pem"}
+	if _, err := config.tlsConfig(); err == nil {
+		t.Fatalf("expected a missing CA file to be an error")
+	}
+	config.FallbackToSystemCAs = true
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		t.Fatalf("expected the system pool to be used, got %s", err)
+	}
+	if tlsConfig.RootCAs != nil {
+		t.Fatalf("expected the system pool, got a custom pool")
+	}
+}
+
+func TestCipherSuites(t *testing.T) {
+	// the event hub speaks TLS 1.2 with a single suite
+	tlsCert, _, certPEM := selfSignedCert(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start listener: %s", err)
+	}
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverConfig)))
+	ehpb.RegisterEventsServer(grpcServer, &fakeEventsServer{handle: sendBlocks(1)})
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	config := ConnectionConfig{TLSEnabled: true, CACert: certPEM, DialTimeout: time.Second, CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}}
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		t.Fatalf("could not build the TLS configuration: %s", err)
+	}
+	if !reflect.DeepEqual(tlsConfig.CipherSuites, config.CipherSuites) {
+		t.Fatalf("expected the cipher suites %v, got %v", config.CipherSuites, tlsConfig.CipherSuites)
+	}
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(lis.Addr().String(), adapter, WithConnectionConfig(config))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client with the suite of the event hub: %s", err)
+	}
+	adapter.waitEvent(t)
+	client.Stop()
+
+	config.CipherSuites = []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}
+	client = NewEventsClient(lis.Addr().String(), newRecordingAdapter(), WithConnectionConfig(config))
+	if err := client.Start(); err == nil {
+		client.Stop()
+		t.Fatalf("expected the handshake to fail without a common suite")
+	}
+
+	// insecure suites are accepted, unknown ones are not
+	config.CipherSuites = []uint16{tls.TLS_RSA_WITH_RC4_128_SHA}
+	if _, err := config.tlsConfig(); err != nil {
+		t.Fatalf("expected an insecure suite to be accepted, got %s", err)
+	}
+	config.CipherSuites = []uint16{0x1234}
+	if _, err := config.tlsConfig(); err == nil || !strings.Contains(err.Error(), "unknown cipher suite 0x1234") {
+		t.Fatalf("expected an unknown suite to be refused, got %v", err)
+	}
+	client = NewEventsClient("127.0.0.1:7053", nil, WithConnectionConfig(config))
+	if err := client.ValidateTLS(); err == nil || !strings.Contains(err.Error(), "unknown cipher suite") {
+		t.Fatalf("expected ValidateTLS to report the unknown suite, got %v", err)
+	}
+}
+
+func TestCredentialFailureReturnsError(t *testing.T) {
+	client := NewEventsClient("127.0.0.1:7053", newRecordingAdapter(), WithConnectionConfig(ConnectionConfig{TLSEnabled: true, CACert: []byte("not a certificate")}))
+	if err := client.Start(); err == nil || !strings.Contains(err.Error(), "failed to append certificates") {
+		t.Fatalf("expected the invalid CA to fail Start, got %v", err)
+	}
+
+	// the peer configuration is used without WithConnectionConfig
+	viper.Set("peer.tls.enabled", true)
+	viper.Set("peer.tls.cert.file", "/nonexistent/ca.pem")
+	comm.CacheConfiguration()
+	defer func() {
+		viper.Set("peer.tls.enabled", false)
+		viper.Set("peer.tls.cert.file", "")
+		comm.CacheConfiguration()
+	}()
+	client = NewEventsClient("127.0.0.1:7053", newRecordingAdapter())
+	if err := client.Start(); err == nil || !strings.Contains(err.Error(), "/nonexistent/ca.pem") {
+		t.Fatalf("expected the missing CA file to fail Start, got %v", err)
+	}
+}
+
+func TestBlockingDial(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	for _, block := range []bool{true, false} {
+		adapter := newRecordingAdapter()
+		client := NewEventsClient(addr, adapter, WithBlockingDial(block))
+		if err := client.Start(); err != nil {
+			t.Fatalf("block=%t: could not start client: %s", block, err)
+		}
+		adapter.waitEvent(t)
+		client.Stop()
+	}
+
+	// without blocking, the dial succeeds and opening the stream fails
+	client := NewEventsClient("127.0.0.1:1", newRecordingAdapter(), WithBlockingDial(false))
+	if err := client.Start(); err == nil || !strings.Contains(err.Error(), "Could not open event stream") {
+		t.Fatalf("expected the stream to fail, got %v", err)
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	f, err := ioutil.TempFile("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("-----BEGIN CERTIFICATE-----\nnot base64\n-----END CERTIFICATE-----\n")
+	f.Close()
+
+	_, _, certPEM := selfSignedCert(t)
+	client := NewEventsClient("127.0.0.1:7053", newRecordingAdapter(),
+		WithConnectionConfig(ConnectionConfig{TLSEnabled: true, CACert: certPEM}),
+		WithPeerConnectionConfig("127.0.0.1:7054", ConnectionConfig{TLSEnabled: true, CACertFile: f.Name()}),
+		WithPeerConnectionConfig("127.0.0.1:7055", ConnectionConfig{CACertFile: "/nonexistent/ca.pem"}))
+	err = client.ValidateTLS()
+	if err == nil || !strings.Contains(err.Error(), "peer 127.0.0.1:7054: credentials: failed to append certificates") {
+		t.Fatalf("expected the malformed CA file to be reported, got %v", err)
+	}
+	if strings.Contains(err.Error(), "7053") || strings.Contains(err.Error(), "7055") {
+		t.Fatalf("expected only the malformed CA file to be reported, got %s", err)
+	}
+
+	client = NewEventsClient("127.0.0.1:7053", newRecordingAdapter(), WithConnectionConfig(ConnectionConfig{TLSEnabled: true, CACert: certPEM}))
+	if err := client.ValidateTLS(); err != nil {
+		t.Fatalf("expected a valid configuration, got %s", err)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	serverAddr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	var dials int32
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return net.Dial("tcp", addr)
+	}
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(serverAddr, adapter, WithContextDialer(dialer))
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatalf("could not connect: %s", err)
+	}
+	warm := client.warmConn
+	if warm == nil || atomic.LoadInt32(&dials) != 1 {
+		t.Fatalf("expected Connect to dial once, got %d dials", atomic.LoadInt32(&dials))
+	}
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatalf("expected a second Connect to do nothing, got %s", err)
+	}
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	client.mutex.Lock()
+	conn := client.conn
+	client.mutex.Unlock()
+	if conn != warm {
+		t.Fatalf("expected Start to reuse the connection of Connect")
+	}
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatalf("expected no dial by Start, got %d dials", n)
+	}
+	if err := client.Connect(context.Background()); err == nil {
+		t.Fatalf("expected Connect to fail once started")
+	}
+}
+
+func TestConnectThenStop(t *testing.T) {
+	serverAddr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	client := NewEventsClient(serverAddr, newRecordingAdapter())
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatalf("could not connect: %s", err)
+	}
+	warm := client.warmConn
+	client.Stop()
+	if client.warmConn != nil || warm.State() != grpc.Shutdown {
+		t.Fatalf("expected Stop to close the connection of Connect, got %s", warm.State())
+	}
+}
+
+func TestDialOptions(t *testing.T) {
+	// a plain and a TLS event hub, each with its own dial options in the
+	// same process and without viper
+	viper.Set("peer.tls.enabled", true)
+	defer viper.Set("peer.tls.enabled", false)
+	plainAddr, _, stopPlain := startFakeServer(t, sendBlocks(1))
+	defer stopPlain()
+	tlsCert, cert, _ := selfSignedCert(t)
+	tlsAddr, stopTLS := startTLSFakeServer(t, tlsCert, sendBlocks(1))
+	defer stopTLS()
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	tests := []struct {
+		addr string
+		opts []grpc.DialOption
+	}{
+		{plainAddr, []grpc.DialOption{grpc.WithInsecure()}},
+		{tlsAddr, []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool}))}},
+	}
+	for _, test := range tests {
+		adapter := newRecordingAdapter()
+		client := NewEventsClient(test.addr, adapter, WithDialOptions(test.opts...))
+		if err := client.Start(); err != nil {
+			t.Fatalf("could not start client of %s: %s", test.addr, err)
+		}
+		adapter.waitEvent(t)
+		client.Stop()
+	}
+
+	client := NewEventsClient(plainAddr, newRecordingAdapter(), WithDialOptions(grpc.WithBlock(), grpc.WithTimeout(time.Second)))
+	if err := client.Start(); err == nil || !strings.Contains(err.Error(), grpc.ErrNoTransportSecurity.Error()) {
+		t.Fatalf("expected dial options without transport security to fail, got %v", err)
+	}
+	client = NewEventsClient(plainAddr, newRecordingAdapter(), WithDialOptions(grpc.WithInsecure()), WithConnectionConfig(ConnectionConfig{}))
+	if err := client.Start(); err == nil || !strings.Contains(err.Error(), "dial options exclude") {
+		t.Fatalf("expected dial options and a connection config to be exclusive, got %v", err)
+	}
+
+	// without options the client dials as usual
+	adapter := newRecordingAdapter()
+	client = NewEventsClient(plainAddr, adapter, WithDialOptions(), WithConnectionConfig(ConnectionConfig{}))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client without dial options: %s", err)
+	}
+	adapter.waitEvent(t)
+	client.Stop()
+}
+
+func TestStartContextCancelsDial(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		<-release
+		return nil, context.Canceled
+	}
+	client := NewEventsClient("127.0.0.1:7053", newRecordingAdapter(), WithContextDialer(dialer), WithDialOptions(grpc.WithInsecure(), grpc.WithBlock(), grpc.WithTimeout(time.Hour)))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	if err := client.StartContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the dial to be abandoned with the context, got %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Fatalf("expected the dial to be abandoned at once, took %s", elapsed)
+	}
+	if state := client.State(); state != StateIdle {
+		t.Fatalf("expected the client to be idle after the failed start, got %s", state)
+	}
+}
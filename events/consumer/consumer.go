@@ -17,131 +17,1965 @@ limitations under the License.
 package consumer
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 
-	"github.com/hyperledger/fabric/core/comm"
+	"github.com/hyperledger/fabric/core/util"
 	ehpb "github.com/hyperledger/fabric/protos"
 )
 
-//EventsClient holds the stream and adapter for consumer to work with
+var consumerLogger = logging.MustGetLogger("eventhub_consumer")
+
+//ErrServerClosed is passed to EventAdapter.Disconnected when the event hub
+//ends the stream cleanly (io.EOF) without the client having been stopped
+var ErrServerClosed = errors.New("event stream closed by server")
+
+//ErrStreamCancelled is the cause of the reconnection following CancelStream
+var ErrStreamCancelled = errors.New("event stream cancelled")
+
+//ErrStopConsuming can be returned by EventAdapter.Recv when the adapter does
+//not need any more events. The client then stops as if Stop had been called:
+//it does not reconnect, Disconnected is called with a nil error and Wait
+//returns nil
+var ErrStopConsuming = errors.New("stop consuming events")
+
+//ErrAlreadyStarted is returned by Start, StartContext and Connect when the
+//client is starting or started. A client runs once: it cannot be started
+//again after Stop, so a new client is needed to consume the events again
+var ErrAlreadyStarted = errors.New("events client already started")
+
+var errClientStopped = errors.New("events client stopped")
+
+const defaultRegistrationTimeout = 5 * time.Second
+
+//EventsClient holds the stream and adapter for consumer to work with.
+//Its methods can be called concurrently; a client can be started only once
 type EventsClient struct {
+	mutex       sync.Mutex
+	name        string
+	labels      map[string]string
 	peerAddress string
+	conn        *grpc.ClientConn
 	stream      ehpb.Events_ChatClient
 	adapter     EventAdapter
+	stopped     bool
+	starting    bool
+	started     bool
+	// stopChan is closed when the client is stopped
+	stopChan chan struct{}
+	// ctx is the parent of every chat stream the client opens
+	ctx          context.Context
+	cancelStream context.CancelFunc
+	// lifeCtx is returned by Context and cancelled by lifeCancel
+	lifeCtx    context.Context
+	lifeCancel context.CancelFunc
+	// done is closed with err set once the receive loop has terminated
+	done chan struct{}
+	err  error
+	// set when the adapter asked to stop from the buffered dispatch path,
+	// with the error it failed with, if any
+	adapterQuit bool
+	adapterErr  error
+	queue       *eventQueue
+	stats       clientCounters
+
+	reconnectOnServerClose bool
+	reconnectOnStreamError bool
+	bufferSize             int
+	bufferBytes            int
+	highWatermark          int
+	lowWatermark           int
+	dropWhenFull           bool
+	metrics                Metrics
+	registerHook           func(*ehpb.Register)
+	sendHook               func(*ehpb.Event)
+	filter                 Filter
+	contextDialer          func(context.Context, string) (net.Conn, error)
+	breaker                *circuitBreaker
+	registerRetries        int
+	pins                   *certificatePins
+	tofu                   *firstUsePins
+	connConfig             *ConnectionConfig
+	peerConfigs            map[string]ConnectionConfig
+	throttle               *throttleBackoff
+	recorder               *rawRecorder
+	backoff                Backoff
+	reconnectOn            func(error) bool
+	staleness              time.Duration
+	baseCtx                context.Context
+	coalesce               bool
+	passEmpty              bool
+	clock                  clock
+	errorHistory           int
+	latencyWindow          int
+	shutdownTimeout        time.Duration
+	lenientRegister        bool
+	localAddr              *net.TCPAddr
+	warmConn               *grpc.ClientConn
+	dumpEvents             bool
+	maxEvents              uint64
+	downstreamCheck        func() bool
+	downstreamInterval     time.Duration
+	watchInterval          time.Duration
+	watchFiles             []string
+	selector               PeerSelector
+	rate                   *rateMeter
+	blockingDial           bool
+	dialOpts               []grpc.DialOption
+	reconnectHook          func(ReconnectInfo)
+	codec                  grpc.Codec
+	rejectDuplicates       bool
+	interestOrder          func(a, b *ehpb.Interest) bool
+	transform              Transform
+	transformPolicy        TransformErrorPolicy
+	recvTimeout            time.Duration
+	eventWindow            time.Duration
+	tickInterval           time.Duration
+	reregisterAck          chan *ehpb.Register
+	reconnectDisabled      bool
+	interestProvider       InterestProvider
+	structured             structuredLogger
+	registerBatchCount     int
+	registerBatchBytes     int
+	registerBatchWait      time.Duration
+	reconnectAllowed       func(now time.Time) bool
+	schedulePoll           time.Duration
+	eventWindowReconnect   bool
+	requireAccepted        bool
+	flushOnReconnect       bool
+	sampleRate             float64
+	sampleRateSet          bool
+	sampleEvery            int
+	recvTimeoutPolicy      RecvTimeoutPolicy
+	deadLetter             func(msg *ehpb.Event)
+	registerDelay          time.Duration
+	registerDelayInitial   bool
+	registrationTimeout    time.Duration
+	disconnectOnce         sync.Once
+	// watermarkMutex serializes the buffer watermark notifications
+	watermarkMutex sync.Mutex
+	// deliverMutex serializes the deliveries to adapters and protects replay
+	deliverMutex sync.Mutex
+	replay       *replayRing
+	replaySize   int
+	// sequence numbers the delivered events, guarded by deliverMutex
+	sequence uint64
+	logLevel logging.Level
+	logger   *logging.Logger
+	// deliveredConnectionID is the connection ID of the stream of the
+	// delivered events, guarded by deliverMutex
+	deliveredConnectionID string
+	// muted holds the event types muted with Mute, guarded by mutex
+	muted map[ehpb.EventType]bool
+	// subscribed holds the event types of the last acknowledged
+	// registration, guarded by mutex
+	subscribed map[ehpb.EventType]bool
+	// taps are the pending WaitForEvent calls, guarded by mutex
+	taps []*eventTap
+	// ownStream is the stream given to NewFromStream, in place of dialing
+	ownStream ehpb.Events_ChatClient
+	// outbound serializes the messages sent to the event hub
+	outbound outboundQueue
+	// previous is the encoding of the last event passed on for delivery,
+	// owned by the receive loop
+	previous []byte
+	// sampleRand draws the events sampled with a rate and sampleCount counts
+	// those sampled every n, owned by the receive loop
+	sampleRand  *rand.Rand
+	sampleCount uint64
+	// reconnectCause is set by the dispatcher to have the receive loop
+	// replace the stream, guarded by mutex
+	reconnectCause error
+	// state is the lifecycle state of the client, guarded by mutex
+	state ClientState
+	// leftStream is closed by the receive loop once it has left the stream
+	// MigrateTo replaced, guarded by mutex
+	leftStream chan struct{}
+	// startedStream is the stream whose first event was passed on for
+	// delivery, owned by the receive loop
+	startedStream ehpb.Events_ChatClient
+	// streamStarts holds the queued events that are the first of their
+	// stream with the connection ID of their stream, guarded by mutex
+	streamStarts map[*ehpb.Event]string
+	// caFile is the CA file read by the last connection and caFileState
+	// its state then, for WithTLSFileWatch, guarded by mutex
+	caFile      string
+	caFileState fileState
+	// pool holds the received events for reuse, see WithMessagePool
+	pool *sync.Pool
+	// tap is the adapter set with SetTap, and tapClosed tells whether the
+	// client has terminated, both guarded by mutex
+	tap       *tapAdapter
+	tapClosed bool
+	// connectionID is the ID of the last connection attempt, an atomic
+	// value since the log messages read it under mutex
+	connectionID atomic.Value
+	// dialedPeer is the address of the peer last dialed, an atomic value
+	// for the same reason
+	dialedPeer atomic.Value
+	// routines tracks the goroutines of the client
+	routines goroutineGroup
+	// remote is the network connection of the current transport, guarded by
+	// remoteMutex since it is set by the gRPC dialer
+	remote      *remoteConn
+	remoteMutex sync.Mutex
 }
 
 //NewEventsClient Returns a new grpc.ClientConn to the configured local PEER.
-func NewEventsClient(peerAddress string, adapter EventAdapter) *EventsClient {
-	return &EventsClient{peerAddress, nil, adapter}
+func NewEventsClient(peerAddress string, adapter EventAdapter, opts ...Option) *EventsClient {
+	ec := &EventsClient{peerAddress: peerAddress, adapter: adapter, stats: newClientCounters(), stopChan: make(chan struct{}), registrationTimeout: defaultRegistrationTimeout, clock: realClock{}, errorHistory: defaultErrorHistory, latencyWindow: defaultLatencyWindow, shutdownTimeout: defaultShutdownTimeout, logLevel: logging.INFO, logger: consumerLogger, rate: newRateMeter(defaultRateWindow), blockingDial: true}
+	for _, opt := range opts {
+		opt(ec)
+	}
+	ec.rate.now = ec.clock.Now
+	if ec.breaker != nil {
+		ec.breaker.now = ec.clock.Now
+	}
+	if ec.name == "" {
+		ec.name = peerAddress
+	}
+	ec.labels = map[string]string{MetricLabelClient: ec.name}
+	ec.outbound.routines = &ec.routines
+	return ec
+}
+
+//NewFromStream returns a client consuming the events of stream, an already
+//established chat stream, e.g. one opened on an in-process event hub. The
+//client skips dialing: Start registers the interested events of adapter on
+//stream and then receives the events like any other client. The lifecycle of
+//stream is shared with the caller: Stop closes its send side, and cancelling
+//the context it was opened with ends the client. It cannot be re-established,
+//so reconnecting options are rejected on Start. The client is named "stream"
+//unless WithName is given
+func NewFromStream(stream ehpb.Events_ChatClient, adapter EventAdapter, opts ...Option) *EventsClient {
+	ec := NewEventsClient("", adapter, append([]Option{WithName("stream")}, opts...)...)
+	ec.ownStream = stream
+	return ec
+}
+
+//Name returns the name identifying the client in logs and metrics
+func (ec *EventsClient) Name() string {
+	return ec.name
 }
 
-//newEventsClientConnectionWithAddress Returns a new grpc.ClientConn to the configured local PEER.
-func newEventsClientConnectionWithAddress(peerAddress string) (*grpc.ClientConn, error) {
-	if comm.TLSEnabled() {
-		return comm.NewClientConnectionWithAddress(peerAddress, true, true, comm.InitTLSForPeer())
+//PeerAddress returns the address of the event hub the client connects to
+func (ec *EventsClient) PeerAddress() string {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.peerAddress
+}
+
+//dialOptions returns the dial options configured on the client
+func (ec *EventsClient) dialOptions() []grpc.DialOption {
+	dial := dialTCP
+	if strings.HasPrefix(ec.PeerAddress(), unixScheme) {
+		dial = dialUnix
 	}
-	return comm.NewClientConnectionWithAddress(peerAddress, true, false, nil)
+	if ec.localAddr != nil && ec.contextDialer == nil && !strings.HasPrefix(ec.PeerAddress(), unixScheme) {
+		dial = localDialer(ec.localAddr)
+	}
+	if ec.contextDialer != nil {
+		dialer := ec.contextDialer
+		dial = func(addr string, timeout time.Duration) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			return dialer(ctx, addr)
+		}
+	}
+	opts := []grpc.DialOption{grpc.WithDialer(ec.trackRemoteAddr(dial))}
+	if ec.recorder != nil {
+		opts = append(opts, grpc.WithCodec(&recordingCodec{next: ec.codec, recorder: ec.recorder}))
+	} else if ec.codec != nil {
+		opts = append(opts, grpc.WithCodec(ec.codec))
+	}
+	return opts
 }
 
-func (ec *EventsClient) register(ies []*ehpb.Interest) error {
-	emsg := &ehpb.Event{Event: &ehpb.Event_Register{Register: &ehpb.Register{Events: ies}}}
-	var err error
-	if err = ec.stream.Send(emsg); err != nil {
-		fmt.Printf("error on Register send %s\n", err)
+//register is registerContext bounded by the registration timeout
+func (ec *EventsClient) register(ctx context.Context, stream ehpb.Events_ChatClient, ies []*ehpb.Interest) error {
+	ctx, cancel := withTimeout(ec.clock, ctx, ec.registrationTimeout)
+	defer cancel()
+	return ec.registerContext(ctx, stream, ies)
+}
+
+//registerContext sends the Register messages for ies over stream, one
+//unless WithRegisterBatches splits them, and waits for the event hub to
+//acknowledge each, resending it on invalid acks. The registration is
+//abandoned when ctx is done; the caller must then cancel the stream to
+//release the pending send or receive
+func (ec *EventsClient) registerContext(ctx context.Context, stream ehpb.Events_ChatClient, ies []*ehpb.Interest) error {
+	emsg, err := ec.registerMessage(ies)
+	if err != nil {
 		return err
 	}
+	reg := emsg.GetRegister()
+	batches := ec.registerBatches(reg)
+	if len(batches) > 1 {
+		ec.logf(logging.DEBUG, "registering %d interested events in %d messages", len(reg.Events), len(batches))
+	} else {
+		ec.logf(logging.DEBUG, "registering %d interested events", len(reg.Events))
+	}
+	if ec.registerBatchWait > 0 {
+		confirmed, err := ec.registerBestEffort(ctx, stream, batches)
+		if err != nil {
+			return err
+		}
+		ec.setRegistered(confirmed)
+		return nil
+	}
+	for _, batch := range batches {
+		if err := ec.registerBatch(ctx, stream, batch); err != nil {
+			return err
+		}
+	}
+	ec.setRegistered(reg.Events)
+	return nil
+}
 
-	regChan := make(chan struct{})
-	go func() {
-		defer close(regChan)
-		in, inerr := ec.stream.Recv()
-		if inerr != nil {
-			err = inerr
-			return
+//registerBatch sends the Register message emsg and waits for its ack,
+//resending it on invalid acks
+func (ec *EventsClient) registerBatch(ctx context.Context, stream ehpb.Events_ChatClient, emsg *ehpb.Event) error {
+	for attempt := 0; ; attempt++ {
+		err := ec.sendRegister(ctx, stream, emsg)
+		if err == nil && attempt > 0 && ec.backoff != nil {
+			ec.backoff.Reset()
 		}
-		switch in.Event.(type) {
-		case *ehpb.Event_Register:
-		case nil:
-			err = fmt.Errorf("invalid nil object for register")
-		default:
-			err = fmt.Errorf("invalid registration object")
+		if _, invalid := err.(invalidAckError); !invalid || attempt >= ec.registerRetries {
+			return err
 		}
-	}()
+		if ec.backoff != nil {
+			delay, waited := ec.backoffWait(ctx.Done(), attempt+1)
+			if delay < 0 {
+				return err
+			}
+			if !waited {
+				return registrationAborted(ctx, "retrying")
+			}
+		}
+		ec.logf(logging.WARNING, "%s, resending Register", err)
+	}
+}
+
+//registerMessage returns the Register message for ies, sorted, passed to the
+//register hook and deduplicated as configured
+func (ec *EventsClient) registerMessage(ies []*ehpb.Interest) (*ehpb.Event, error) {
+	reg := &ehpb.Register{Events: ies}
+	if ec.interestOrder != nil {
+		reg.Events = sortInterests(reg.Events, ec.interestOrder)
+	}
+	if ec.registerHook != nil {
+		ec.registerHook(reg)
+	}
+	unique, dups := dedupInterests(reg.Events)
+	if dups > 0 {
+		if ec.rejectDuplicates {
+			return nil, fmt.Errorf("%d duplicate interested events", dups)
+		}
+		ec.logf(logging.WARNING, "ignoring %d duplicate interested events", dups)
+		reg.Events = unique
+	}
+	return &ehpb.Event{Event: &ehpb.Event_Register{Register: reg}}, nil
+}
+
+//registrationGrace waits for the delay set with WithRegistrationDelay
+//between opening a stream and registering on it
+func (ec *EventsClient) registrationGrace(ctx context.Context) error {
+	if ec.registerDelay <= 0 {
+		return nil
+	}
+	ec.mutex.Lock()
+	initial := !ec.started
+	ec.mutex.Unlock()
+	if initial && !ec.registerDelayInitial {
+		return nil
+	}
 	select {
-	case <-regChan:
-	case <-time.After(5 * time.Second):
-		err = fmt.Errorf("timeout waiting for registration")
+	case <-ec.clock.After(ec.registerDelay):
+		return nil
+	case <-ec.stopChan:
+		return errClientStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//invalidAckError reports a registration ack that was received but is not a
+//Register message. Unlike a timeout or a stream error it leaves the stream
+//usable, so the Register can be resent on it
+type invalidAckError string
+
+func (e invalidAckError) Error() string {
+	return string(e)
+}
+
+//registrationAborted returns the error of a registration step abandoned
+//because ctx is done
+func registrationAborted(ctx context.Context, step string) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timeout %s registration", step)
+	}
+	return ctx.Err()
+}
+
+//send sends msg over stream through the outbound queue, all the messages of
+//the client to the event hub go through it
+func (ec *EventsClient) send(stream ehpb.Events_ChatClient, msg *ehpb.Event) error {
+	err := ec.outbound.send(stream, msg, ec.sendHook)
+	if err == nil {
+		ec.countTraffic(MetricBytesSent, proto.Size(msg))
 	}
 	return err
 }
 
+//sendRegister sends emsg and waits for its ack, both bounded by ctx so that
+//a send stuck on a congested stream fails the registration as well
+func (ec *EventsClient) sendRegister(ctx context.Context, stream ehpb.Events_ChatClient, emsg *ehpb.Event) error {
+	begin := ec.clock.Now()
+	sent := make(chan error)
+	ec.routines.spawn(func() {
+		err := ec.send(stream, emsg)
+		select {
+		case sent <- err:
+		case <-ctx.Done():
+		}
+	})
+	select {
+	case err := <-sent:
+		if err != nil {
+			ec.logf(logging.ERROR, "error on Register send %s", err)
+			return err
+		}
+	case <-ctx.Done():
+		return registrationAborted(ctx, "sending")
+	}
+
+	regChan := make(chan error)
+	ec.routines.spawn(func() {
+		var err error
+		for ctx.Err() == nil {
+			var in *ehpb.Event
+			if in, err = stream.Recv(); err != nil {
+				break
+			}
+			ec.countTraffic(MetricBytesReceived, proto.Size(in))
+			if ack, ok := in.Event.(*ehpb.Event_Register); ok {
+				err = ec.checkAccepted(emsg.GetRegister(), ack.Register)
+				break
+			}
+			if ec.lenientRegister {
+				ec.logf(logging.DEBUG, "ignoring a %T message while waiting for the registration ack", in.Event)
+				continue
+			}
+			if in.Event == nil {
+				err = invalidAckError("invalid nil object for register")
+			} else {
+				err = invalidAckError("invalid registration object")
+			}
+			break
+		}
+		select {
+		case regChan <- err:
+		case <-ctx.Done():
+		}
+	})
+	select {
+	case err := <-regChan:
+		if err == nil {
+			ec.registered(ec.clock.Now().Sub(begin))
+		}
+		return err
+	case <-ctx.Done():
+		return registrationAborted(ctx, "waiting for")
+	}
+}
+
+//onceClosedStream is a stream whose send side is closed once, whichever of
+//Stop and the terminating receive loop or dispatcher gets to it first; the
+//later calls return the result of the first. id is the connection ID the
+//stream was opened with, empty for the stream given to NewFromStream
+type onceClosedStream struct {
+	ehpb.Events_ChatClient
+	id   string
+	once sync.Once
+	err  error
+	// pending is the receive a best-effort registration stopped waiting
+	// for, taken by the next Recv or RecvMsg
+	pending chan recvOutcome
+}
+
+func closeSendOnce(stream ehpb.Events_ChatClient, id string) *onceClosedStream {
+	return &onceClosedStream{Events_ChatClient: stream, id: id}
+}
+
+func (s *onceClosedStream) Recv() (*ehpb.Event, error) {
+	if s.pending != nil {
+		r := <-s.pending
+		s.pending = nil
+		return r.in, r.err
+	}
+	return s.Events_ChatClient.Recv()
+}
+
+func (s *onceClosedStream) RecvMsg(m interface{}) error {
+	if s.pending != nil {
+		r := <-s.pending
+		s.pending = nil
+		if r.err != nil {
+			return r.err
+		}
+		proto.Merge(m.(proto.Message), r.in)
+		return nil
+	}
+	return s.Events_ChatClient.RecvMsg(m)
+}
+
+//streamConnectionID returns the connection ID stream was opened with
+func streamConnectionID(stream ehpb.Events_ChatClient) string {
+	if s, ok := stream.(*onceClosedStream); ok {
+		return s.id
+	}
+	return ""
+}
+
+func (s *onceClosedStream) CloseSend() error {
+	s.once.Do(func() { s.err = s.Events_ChatClient.CloseSend() })
+	return s.err
+}
+
+func (ec *EventsClient) getStream() ehpb.Events_ChatClient {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.stream
+}
+
+func (ec *EventsClient) isStopped() bool {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.stopped
+}
+
+//markStopped must be called with the mutex held
+func (ec *EventsClient) markStopped() {
+	if !ec.stopped {
+		ec.stopped = true
+		close(ec.stopChan)
+	}
+	if ec.lifeCancel != nil {
+		ec.lifeCancel()
+	}
+}
+
+//contextDone tells whether the context the client was started with is done
+func (ec *EventsClient) contextDone() bool {
+	ec.mutex.Lock()
+	ctx := ec.ctx
+	ec.mutex.Unlock()
+	return ctx != nil && ctx.Err() != nil
+}
+
+//stopOnContext stops the client once the context it was started with is
+//done; its connection is closed as the receive loop terminates
+func (ec *EventsClient) stopOnContext() {
+	ec.mutex.Lock()
+	ec.markStopped()
+	ec.mutex.Unlock()
+}
+
+//closeConnWait bounds the wait of closeConn for gRPC to be done redialing
+const closeConnWait = 10 * time.Second
+
+//closeConn closes conn once gRPC is no longer redialing it. The vendored gRPC
+//revives a connection closed in the middle of a redial, and closes it a
+//second time when its dial timeout expires, which panics. A connection being
+//redialed is thus left to reconnect, or to be closed by gRPC itself once its
+//dial timeout expires; it is closed anyway after closeConnWait
+func closeConn(conn *grpc.ClientConn) error {
+	deadline := time.Now().Add(closeConnWait)
+	for {
+		state := conn.State()
+		switch state {
+		case grpc.Shutdown:
+			return nil
+		case grpc.Idle, grpc.Connecting, grpc.TransientFailure:
+			if wait := deadline.Sub(time.Now()); wait > 0 {
+				conn.WaitForStateChange(wait, state)
+				continue
+			}
+		}
+		return conn.Close()
+	}
+}
+
+//hasQuit reports whether the adapter stopped the client, and the error it
+//failed with
+func (ec *EventsClient) hasQuit() (bool, error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.adapterQuit, ec.adapterErr
+}
+
+func (ec *EventsClient) getAdapter() EventAdapter {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.adapter
+}
+
+//disconnected runs the shutdown sequence of the client, the first call only
+//whichever exit path makes it. The sequence is bounded by the shutdown
+//timeout: once it is over, the deliveries and flushes are no longer waited
+//for, while the sinks are still disconnected and closed. In order:
+//  1. on a graceful termination, the buffered events are delivered to the
+//     adapter, then the adapter is flushed if it is a FlushingAdapter
+//  2. the writer of WithRawRecorder is flushed and synced if it can be
+//  3. the adapter is disconnected, notified with OnClosed if it is a
+//     CloseNotifier, and closed with Done if it is a ClosingAdapter
+//  4. the tap receives its pending events, then is flushed, disconnected
+//     and closed in the same way, and the client waits for it
+func (ec *EventsClient) disconnected(reason CloseReason, err error) {
+	ec.disconnectOnce.Do(func() {
+		ec.transition(StateDraining)
+		defer ec.transition(StateClosed)
+		ctx, cancel := ec.shutdownContext()
+		defer cancel()
+		graceful := reason == CloseStoppedByCaller || reason == CloseServerEOF || reason == CloseMaxEvents
+		adapter := ec.getAdapter()
+		if graceful {
+			if err := ec.waitDispatched(ctx); err != nil {
+				ec.logf(logging.WARNING, "buffered events not delivered on shutdown: %s", err)
+			}
+			if fa, ok := adapter.(FlushingAdapter); ok {
+				ec.flushAdapter(ctx, "adapter", fa)
+			}
+		}
+		if ec.recorder != nil {
+			if err := ec.recorder.flush(); err != nil {
+				ec.logf(logging.WARNING, "raw recorder failed to flush: %s", err)
+			}
+		}
+		if adapter != nil {
+			if sa, ok := adapter.(ShutdownAdapter); ok {
+				sa.DisconnectedContext(ctx, err)
+			} else {
+				adapter.Disconnected(err)
+			}
+			if notifier, ok := adapter.(CloseNotifier); ok {
+				notifier.OnClosed(reason, err)
+			}
+			ec.closeAdapter("adapter", adapter)
+		}
+		ec.closeTap(ctx, graceful, err)
+	})
+}
+
+//flushAdapter flushes the adapter named what, unless the shutdown deadline
+//of ctx is over
+func (ec *EventsClient) flushAdapter(ctx context.Context, what string, fa FlushingAdapter) {
+	if ctx.Err() != nil {
+		ec.logf(logging.WARNING, "shutdown deadline exceeded, %s not flushed", what)
+		return
+	}
+	if err := fa.Flush(); err != nil {
+		ec.logf(logging.WARNING, "%s failed to flush: %s", what, err)
+	}
+}
+
+//flushBeforeReconnect flushes a FlushingAdapter once the buffered events of
+//the lost stream are delivered, with WithFlushOnReconnect
+func (ec *EventsClient) flushBeforeReconnect() {
+	if !ec.flushOnReconnect {
+		return
+	}
+	fa, ok := ec.getAdapter().(FlushingAdapter)
+	if !ok {
+		return
+	}
+	if err := ec.waitDispatched(ec.Context()); err != nil {
+		// stopped meanwhile, the shutdown sequence flushes
+		return
+	}
+	ec.deliverMutex.Lock()
+	defer ec.deliverMutex.Unlock()
+	if err := fa.Flush(); err != nil {
+		ec.logf(logging.WARNING, "adapter failed to flush before reconnecting: %s", err)
+	}
+}
+
+//closeAdapter calls Done on the adapter named what if it is a
+//ClosingAdapter
+func (ec *EventsClient) closeAdapter(what string, adapter EventAdapter) {
+	if ca, ok := adapter.(ClosingAdapter); ok {
+		if err := ca.Done(); err != nil {
+			ec.logf(logging.WARNING, "%s failed to close: %s", what, err)
+		}
+	}
+}
+
+//shutdownContext returns the context passed to DisconnectedContext
+func (ec *EventsClient) shutdownContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := withTimeout(ec.clock, context.Background(), ec.shutdownTimeout)
+	if ec.baseCtx == nil {
+		return ctx, cancel
+	}
+	return valuesContext{Context: ctx, values: ec.baseCtx}, cancel
+}
+
+//deliver passes in to the adapter; first tells whether in is the first event
+//delivered from its stream, then opened with the connection ID connID
+func (ec *EventsClient) deliver(in *ehpb.Event, first bool, connID string) (bool, error) {
+	ec.deliverMutex.Lock()
+	defer ec.deliverMutex.Unlock()
+	adapter := ec.getAdapter()
+	if adapter == nil {
+		// Start and SetAdapter refuse a nil adapter, so this is a bug: make
+		// the drop visible instead of losing the event silently
+		ec.logf(logging.ERROR, "no adapter set, dropping event")
+		ec.eventDropped()
+		return true, nil
+	}
+	if first {
+		ec.deliveredConnectionID = connID
+		if notifier, ok := adapter.(StreamStartNotifier); ok {
+			notifier.OnStreamStart()
+		}
+	}
+	ec.sequence++
+	md := EventMetadata{Sequence: ec.sequence, ConnectionID: ec.deliveredConnectionID}
+	var cont bool
+	var err error
+	begin := ec.clock.Now()
+	if ec.recvTimeout > 0 {
+		cont, err = ec.recvWithTimeout(adapter, in, md)
+	} else {
+		cont, err = ec.callAdapter(ec.recvContext(), adapter, in, md)
+	}
+	ec.adapterReturned(ec.clock.Now().Sub(begin))
+	if rejection := in.GetRejection(); rejection != nil {
+		if handler, ok := adapter.(RejectionHandler); ok {
+			var txID string
+			if tx := rejection.GetTx(); tx != nil {
+				txID = tx.Uuid
+			}
+			handler.OnRejection(txID, rejection.ErrorMsg)
+		}
+	}
+	ec.offerTap(in)
+	if ec.replay != nil {
+		ec.replay.add(in)
+	}
+	if cont && ec.maxEvents > 0 && ec.sequence >= ec.maxEvents {
+		return false, errMaxEvents
+	}
+	return cont, err
+}
+
 func (ec *EventsClient) processEvents() error {
-	defer ec.stream.CloseSend()
+	defer func() { ec.getStream().CloseSend() }()
+	defer func() {
+		if ec.contextDone() {
+			ec.stopOnContext()
+		}
+	}()
+	if ec.queue != nil {
+		defer ec.queue.close()
+	}
 	for {
-		in, err := ec.stream.Recv()
+		stream := ec.getStream()
+		in, err := ec.recvEvent(stream)
+		if quit, qerr := ec.hasQuit(); err != nil && quit {
+			// the dispatcher stopped the stream on behalf of the adapter
+			return qerr
+		}
+		if err != nil && ec.leftReplacedStream(stream) {
+			// the old peer ended its stream after MigrateTo
+			continue
+		}
 		if err == io.EOF {
 			// read done.
-			if ec.adapter != nil {
-				ec.adapter.Disconnected(nil)
+			if ec.isStopped() {
+				ec.disconnected(CloseStoppedByCaller, nil)
+				return nil
+			}
+			if !ec.reconnectOnServerClose || !ec.reconnectEnabled() {
+				ec.disconnected(CloseServerEOF, ErrServerClosed)
+				return ErrServerClosed
 			}
+			ec.logf(logging.INFO, "event stream closed by server %s, reconnecting", ec.PeerAddress())
+			stream.CloseSend()
+			if err = ec.reconnect(ErrServerClosed); err != nil {
+				return ec.reconnectFailed(err)
+			}
+			continue
+		}
+		if err != nil && ec.isStopped() {
+			// the stream was torn down by Stop
+			ec.disconnected(CloseStoppedByCaller, nil)
+			return nil
+		}
+		if err != nil && ec.contextDone() {
+			// the context of StartContext was cancelled
+			ec.disconnected(CloseStoppedByCaller, nil)
 			return nil
 		}
+		if err != nil && ec.reconnectRequested() {
+			// the stream was cancelled to have it replaced
+			cause := ec.takeReconnectRequest()
+			if ec.noEventsFatal(cause) {
+				ec.recordError(cause)
+				ec.disconnected(CloseNoEvents, cause)
+				return cause
+			}
+			if err = ec.replaceStream(stream, cause); err != nil {
+				return ec.reconnectFailed(err)
+			}
+			continue
+		}
+		if ec.throttle != nil && grpc.Code(err) == codes.ResourceExhausted && ec.reconnectEnabled() {
+			ec.recordError(err)
+			if err = ec.throttled(stream, err); err != nil {
+				return ec.reconnectFailed(err)
+			}
+			continue
+		}
 		if err != nil {
-			if ec.adapter != nil {
-				ec.adapter.Disconnected(err)
+			ierr := ec.identityChanged(ec.PeerAddress())
+			if ierr != nil {
+				// the connection was re-established with another peer
+				err = ierr
 			}
+			ec.recordError(err)
+			if ierr == nil && ec.reconnectOnStreamError && ec.reconnectEnabled() {
+				ec.logf(logging.WARNING, "event stream to %s failed with %s, reconnecting", ec.PeerAddress(), err)
+				if err = ec.replaceStream(stream, err); err != nil {
+					return ec.reconnectFailed(err)
+				}
+				continue
+			}
+			ec.disconnected(CloseFatalError, err)
 			return err
 		}
-		if ec.adapter != nil {
-			cont, err := ec.adapter.Recv(in)
-			if !cont {
+		if ec.throttle != nil {
+			ec.throttle.reset()
+		}
+		ec.countTraffic(MetricBytesReceived, proto.Size(in))
+		if ack, ok := in.Event.(*ehpb.Event_Register); ok {
+			if !ec.reregisterAcked(ack.Register) {
+				// the ack of a Register resent after an invalid ack, or of
+				// one the registration stopped waiting for
+				ec.logf(logging.DEBUG, "discarding a late registration ack")
+			}
+			ec.releaseEvent(in)
+			continue
+		}
+		ec.eventReceived(in)
+		if in.Event == nil && !ec.passEmpty {
+			ec.logf(logging.WARNING, "dropping an event without payload")
+			ec.eventEmpty()
+			ec.releaseEvent(in)
+			continue
+		}
+		if ec.transform != nil {
+			out, err := ec.transformEvent(in)
+			if err != nil {
+				ec.releaseEvent(in)
+				ec.recordError(err)
+				ec.disconnected(CloseFatalError, err)
 				return err
 			}
+			if out == nil {
+				ec.releaseEvent(in)
+				continue
+			}
+			in = out
+		}
+		if et, ok := getEventType(in); ok {
+			ec.logf(logging.DEBUG, "received %s event", et)
+		}
+		ec.dumpEvent(in)
+		ec.tapEvent(in)
+		if ec.filter != nil && !ec.filter(in) {
+			ec.releaseEvent(in)
+			continue
+		}
+		if ec.isMuted(in) {
+			ec.releaseEvent(in)
+			continue
+		}
+		if ec.coalesce && ec.isDuplicate(in) {
+			ec.eventCoalesced()
+			ec.releaseEvent(in)
+			continue
+		}
+		if ec.sampling() && !ec.sampledIn(in) {
+			ec.releaseEvent(in)
+			continue
+		}
+		first := ec.startedStream != stream
+		ec.startedStream = stream
+		if ec.queue != nil {
+			if first {
+				ec.markStreamStart(in, streamConnectionID(stream))
+			}
+			if !ec.queue.push(in) {
+				if first {
+					// the next event queued starts the stream instead
+					ec.takeStreamStart(in)
+					ec.startedStream = nil
+				}
+				if !ec.isStopped() {
+					ec.eventDropped()
+				}
+				ec.releaseEvent(in)
+				continue
+			}
+			ec.checkWatermarks()
+			continue
+		}
+		if !ec.waitDownstream() {
+			// stopped while paused, the event is not delivered
+			ec.releaseEvent(in)
+			continue
+		}
+		cont, err := ec.deliver(in, first, streamConnectionID(stream))
+		ec.releaseEvent(in)
+		if err == ErrStopConsuming || err == errMaxEvents {
+			ec.stopConsuming(nil)
+			ec.disconnected(stopReason(err), nil)
+			return nil
+		}
+		if !cont && ec.reconnectsOn(err) {
+			ec.logf(logging.WARNING, "adapter failed with %s, reconnecting", err)
+			if err = ec.replaceStream(stream, err); err != nil {
+				return ec.reconnectFailed(err)
+			}
+			continue
+		}
+		if !cont {
+			ec.stopConsuming(err)
+			return ec.adapterStopped(err)
 		}
 	}
 }
 
-//Start establishes connection with Event hub and registers interested events with it
-func (ec *EventsClient) Start() error {
-	conn, err := newEventsClientConnectionWithAddress(ec.peerAddress)
+//adapterStopped terminates the client once its adapter returned false with
+//err, and returns the terminal error of the receive loop: nil when the
+//adapter merely asked to stop
+func (ec *EventsClient) adapterStopped(err error) error {
+	if err == nil {
+		ec.disconnected(CloseStoppedByCaller, nil)
+		return nil
+	}
+	ec.recordError(err)
+	ec.disconnected(CloseFatalError, err)
+	return err
+}
+
+//errMaxEvents is returned by deliver once the events set with WithMaxEvents
+//have been delivered
+var errMaxEvents = errors.New("max events delivered")
+
+//stopReason returns the reason of the termination of a client whose delivery
+//returned err, ErrStopConsuming or errMaxEvents
+func stopReason(err error) CloseReason {
+	if err == errMaxEvents {
+		return CloseMaxEvents
+	}
+	return CloseStoppedByCaller
+}
+
+//reconnectFailed terminates the client after a failed attempt to replace
+//its stream and returns the terminal error of the receive loop
+func (ec *EventsClient) reconnectFailed(err error) error {
+	if err == errClientStopped {
+		ec.disconnected(CloseStoppedByCaller, nil)
+		return nil
+	}
+	if _, ok := err.(*PeerIdentityError); ok {
+		ec.disconnected(CloseIdentityChanged, err)
+		return err
+	}
+	ec.disconnected(CloseReconnectExhausted, err)
+	return err
+}
+
+//reconnectsOn reports whether the adapter error err calls for a new stream
+//rather than stopping the client
+func (ec *EventsClient) reconnectsOn(err error) bool {
+	return err != nil && ec.reconnectOn != nil && ec.reconnectOn(err)
+}
+
+//replaceStream closes stream and reconnects because of cause
+func (ec *EventsClient) replaceStream(stream ehpb.Events_ChatClient, cause error) error {
+	stream.CloseSend()
+	return ec.reconnect(cause)
+}
+
+//requestReconnect has the receive loop replace its stream because of cause,
+//by cancelling the current one
+func (ec *EventsClient) requestReconnect(cause error) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.reconnectCause = cause
+	if ec.cancelStream != nil {
+		ec.cancelStream()
+	}
+}
+
+//reconnectRequested reports whether requestReconnect was called since the
+//last takeReconnectRequest
+func (ec *EventsClient) reconnectRequested() bool {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.reconnectCause != nil
+}
+
+//takeReconnectRequest returns and clears the cause set by requestReconnect
+func (ec *EventsClient) takeReconnectRequest() error {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	cause := ec.reconnectCause
+	ec.reconnectCause = nil
+	return cause
+}
+
+//markStreamStart records that the event in, about to be queued, is the first
+//of its stream, opened with the connection ID connID
+func (ec *EventsClient) markStreamStart(in *ehpb.Event, connID string) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if ec.streamStarts == nil {
+		ec.streamStarts = make(map[*ehpb.Event]string)
+	}
+	ec.streamStarts[in] = connID
+}
+
+//takeStreamStart reports whether the dequeued event in is the first of its
+//stream, and the connection ID of that stream, forgetting it
+func (ec *EventsClient) takeStreamStart(in *ehpb.Event) (bool, string) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	connID, first := ec.streamStarts[in]
+	delete(ec.streamStarts, in)
+	return first, connID
+}
+
+//stopConsuming stops the client on behalf of the adapter, which failed with
+//err if it is not nil: with a buffer, it becomes the terminal error of the
+//receive loop
+func (ec *EventsClient) stopConsuming(err error) ehpb.Events_ChatClient {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.markStopped()
+	ec.adapterQuit = true
+	ec.adapterErr = err
+	return ec.stream
+}
+
+//dispatchEvents delivers buffered events to the adapter until the queue is
+//closed and drained, or the adapter asks to stop
+func (ec *EventsClient) dispatchEvents() {
+	defer ec.queue.halt()
+	for {
+		// the events stay queued while the downstream is unhealthy
+		ec.waitDownstream()
+		in, ok := ec.queue.pop()
+		if !ok {
+			return
+		}
+		ec.checkWatermarks()
+		first, connID := ec.takeStreamStart(in)
+		cont, err := ec.deliver(in, first, connID)
+		ec.releaseEvent(in)
+		ec.queue.delivered()
+		if err == ErrStopConsuming || err == errMaxEvents {
+			stream := ec.stopConsuming(nil)
+			ec.queue.close()
+			// no more events are delivered
+			ec.queue.halt()
+			// disconnected before the receive loop sees the stream end
+			ec.disconnected(stopReason(err), nil)
+			stream.CloseSend()
+			return
+		}
+		if !cont && ec.reconnectsOn(err) {
+			ec.logf(logging.WARNING, "adapter failed with %s, reconnecting", err)
+			ec.requestReconnect(err)
+			continue
+		}
+		if !cont {
+			if err != nil {
+				ec.logf(logging.ERROR, "adapter stopped the events client: %s", err)
+			}
+			stream := ec.stopConsuming(err)
+			ec.queue.close()
+			ec.queue.halt()
+			ec.adapterStopped(err)
+			stream.CloseSend()
+			return
+		}
+	}
+}
+
+func (ec *EventsClient) eventReceived(in *ehpb.Event) {
+	et, ok := getEventType(in)
+	ec.mutex.Lock()
+	if ok {
+		ec.stats.received[et]++
+	}
+	ec.stats.lastEvent = ec.clock.Now()
+	ec.mutex.Unlock()
+	rate := ec.rate.add()
+	if ec.metrics != nil {
+		ec.metrics.SetGauge(MetricEventsPerSecond, ec.labels, rate)
+		label := MetricLabelUnknownEventType
+		if ok {
+			label = et.String()
+		}
+		ec.metrics.AddCounter(MetricReceivedEvents, map[string]string{MetricLabelClient: ec.name, MetricLabelEventType: label}, 1)
+	}
+}
+
+//ReceivedEvents returns the number of events received from the event hub so
+//far, by event type
+func (ec *EventsClient) ReceivedEvents() map[ehpb.EventType]uint64 {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.stats.receivedCopy()
+}
+
+func (ec *EventsClient) eventDropped() {
+	ec.mutex.Lock()
+	ec.stats.dropped++
+	ec.mutex.Unlock()
+	if ec.metrics != nil {
+		ec.metrics.AddCounter(MetricDroppedEvents, ec.labels, 1)
+	}
+}
+
+//isDuplicate reports whether in has the encoding of the previous event passed
+//on for delivery, and makes it the previous one otherwise
+func (ec *EventsClient) isDuplicate(in *ehpb.Event) bool {
+	data, err := proto.Marshal(in)
 	if err != nil {
-		return fmt.Errorf("Could not create client conn to %s", ec.peerAddress)
+		ec.previous = nil
+		return false
+	}
+	if ec.previous != nil && bytes.Equal(data, ec.previous) {
+		return true
+	}
+	ec.previous = data
+	return false
+}
+
+func (ec *EventsClient) eventCoalesced() {
+	ec.mutex.Lock()
+	ec.stats.coalesced++
+	ec.mutex.Unlock()
+	if ec.metrics != nil {
+		ec.metrics.AddCounter(MetricCoalescedEvents, ec.labels, 1)
+	}
+}
+
+func (ec *EventsClient) eventEmpty() {
+	ec.mutex.Lock()
+	ec.stats.empty++
+	ec.mutex.Unlock()
+	if ec.metrics != nil {
+		ec.metrics.AddCounter(MetricEmptyEvents, ec.labels, 1)
+	}
+}
+
+//adapterReturned records the time spent delivering an event to the adapter
+func (ec *EventsClient) adapterReturned(elapsed time.Duration) {
+	if h, ok := ec.metrics.(HistogramMetrics); ok {
+		h.Observe(MetricAdapterRecvSeconds, ec.labels, elapsed.Seconds())
+	}
+	ec.mutex.Lock()
+	ec.stats.adapterTime += elapsed
+	ec.stats.adapterCalls++
+	ec.mutex.Unlock()
+}
+
+//registered records the round-trip time of an acknowledged registration
+func (ec *EventsClient) registered(elapsed time.Duration) {
+	ec.mutex.Lock()
+	ec.stats.lastRegistration = elapsed
+	ec.stats.registeredAt = ec.clock.Now()
+	registeredAt := ec.stats.registeredAt
+	ec.mutex.Unlock()
+	ec.latencyObserved(elapsed)
+	ec.watchEventWindow(registeredAt)
+	ec.logf(logging.DEBUG, "registered in %s", elapsed)
+	if ec.metrics != nil {
+		ec.metrics.SetGauge(MetricRegistrationSeconds, ec.labels, elapsed.Seconds())
+	}
+}
+
+func (ec *EventsClient) queueDepthChanged(depth int) {
+	if ec.metrics != nil {
+		ec.metrics.SetGauge(MetricQueueDepth, ec.labels, float64(depth))
+	}
+}
+
+//QueueDepth returns the number of received events buffered but not yet
+//delivered to the adapter. It is always 0 for an unbuffered client
+func (ec *EventsClient) QueueDepth() int {
+	ec.mutex.Lock()
+	queue := ec.queue
+	ec.mutex.Unlock()
+	if queue == nil {
+		return 0
+	}
+	return queue.len()
+}
+
+//Flush blocks until the events buffered when it is called, and those
+//buffered meanwhile, have been delivered to the adapter, including the event
+//the adapter is processing. It returns ctx.Err() if ctx is done first, and an
+//error if the client stops delivering before the buffer is empty. Without a
+//buffer events are delivered as they are received and Flush returns at once
+func (ec *EventsClient) Flush(ctx context.Context) error {
+	ec.mutex.Lock()
+	queue := ec.queue
+	ec.mutex.Unlock()
+	if queue == nil {
+		return nil
+	}
+	return queue.waitIdle(ctx)
+}
+
+//DroppedEvents returns the number of events discarded because the buffer
+//was full
+func (ec *EventsClient) DroppedEvents() uint64 {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.stats.dropped
+}
+
+//connect dials the event hub, opens the chat stream and registers the
+//adapter's interested events on it, replacing any previous connection
+func (ec *EventsClient) connect(ctx context.Context) error {
+	err := ec.establish(ctx)
+	if err != nil && err != errClientStopped {
+		ec.recordError(err)
+	}
+	return err
+}
+
+func (ec *EventsClient) establish(ctx context.Context) error {
+	if ec.ownStream != nil {
+		return ec.establishOwnStream(ctx)
+	}
+	id := util.GenerateUUID()
+	ec.connectionID.Store(id)
+	conn := ec.takeWarmConn()
+	if conn == nil && ec.selector != nil {
+		if err := ec.selectPeer(); err != nil {
+			return err
+		}
+	}
+	peerAddress := ec.PeerAddress()
+	if conn == nil {
+		var err error
+		if conn, err = ec.dialContext(ctx, peerAddress); err != nil {
+			return err
+		}
 	}
 
-	ies, err := ec.adapter.GetInterestedEvents()
+	ies, err := ec.interestedEvents()
 	if err != nil {
+		conn.Close()
 		return fmt.Errorf("error getting interested events:%s", err)
 	}
 
 	if len(ies) == 0 {
+		conn.Close()
 		return fmt.Errorf("must supply interested events")
 	}
 
 	serverClient := ehpb.NewEventsClient(conn)
-	ec.stream, err = serverClient.Chat(context.Background())
+	streamCtx, cancel := context.WithCancel(withConnectionID(ctx, id))
+	chat, err := serverClient.Chat(streamCtx)
 	if err != nil {
-		return fmt.Errorf("Could not create client conn to %s", ec.peerAddress)
+		cancel()
+		conn.Close()
+		return fmt.Errorf("Could not open event stream to %s: %s", peerAddress, err)
 	}
+	stream := closeSendOnce(chat, id)
 
-	if err = ec.register(ies); err != nil {
+	ec.mutex.Lock()
+	if ec.stopped {
+		ec.mutex.Unlock()
+		cancel()
+		conn.Close()
+		return errClientStopped
+	}
+	oldConn, oldCancel := ec.conn, ec.cancelStream
+	ec.conn, ec.stream, ec.cancelStream = conn, stream, cancel
+	ec.mutex.Unlock()
+	if oldCancel != nil {
+		oldCancel()
+	}
+	if oldConn != nil {
+		// the connection of a lost stream is likely redialing
+		ec.routines.spawn(func() { closeConn(oldConn) })
+	}
+
+	err = ec.registrationGrace(streamCtx)
+	if err == nil {
+		ec.registrationAttempted(peerAddress)
+		err = ec.register(streamCtx, stream, ies)
+		ec.registrationEnded(peerAddress, err)
+	}
+	if err != nil {
+		// unblocks the goroutine still waiting for the registration reply
+		cancel()
+		ec.mutex.Lock()
+		if ec.conn == conn {
+			ec.conn = nil
+		}
+		ec.mutex.Unlock()
+		conn.Close()
 		return err
 	}
+	return nil
+}
 
-	go ec.processEvents()
+//ConnectionIDMetadataKey is the gRPC metadata key of the chat streams
+//carrying the connection ID, see CurrentConnectionID
+const ConnectionIDMetadataKey = "eventhub-connection-id"
 
+//withConnectionID returns ctx with the connection ID id added to its
+//outgoing metadata
+func withConnectionID(ctx context.Context, id string) context.Context {
+	md, ok := metadata.FromContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	md[ConnectionIDMetadataKey] = []string{id}
+	return metadata.NewContext(ctx, md)
+}
+
+//CurrentConnectionID returns the ID of the current connection attempt, or
+//of the last one once the client has terminated, and the empty string
+//before Start or for a client created with NewFromStream. A new ID is
+//generated for every attempt, initial connection and reconnections, and
+//sent to the event hub in the ConnectionIDMetadataKey metadata of the chat
+//stream so that its logs can be correlated with those of the client: the
+//messages the client logs carry the ID, and so does the ReconnectInfo of
+//each attempt. The metrics do not, to keep their number of series bounded
+func (ec *EventsClient) CurrentConnectionID() string {
+	id, _ := ec.connectionID.Load().(string)
+	return id
+}
+
+//selectPeer makes the next peer of the selector the one to connect to
+func (ec *EventsClient) selectPeer() error {
+	addr, err := normalizePeerAddress(ec.selector.Next())
+	if err != nil {
+		return err
+	}
+	ec.mutex.Lock()
+	ec.peerAddress = addr
+	ec.mutex.Unlock()
 	return nil
 }
 
-//Stop terminates connection with event hub
+//dialContext opens a connection to peerAddress like dial, but returns
+//ctx.Err() when ctx is done first; the connection is then closed once dialed
+func (ec *EventsClient) dialContext(ctx context.Context, peerAddress string) (*grpc.ClientConn, error) {
+	type dialed struct {
+		conn *grpc.ClientConn
+		err  error
+	}
+	result := make(chan dialed, 1)
+	ec.routines.spawn(func() {
+		conn, err := ec.dial(peerAddress)
+		result <- dialed{conn, err}
+	})
+	select {
+	case d := <-result:
+		return d.conn, d.err
+	case <-ctx.Done():
+		ec.routines.spawn(func() {
+			if d := <-result; d.conn != nil {
+				d.conn.Close()
+			}
+		})
+		return nil, ctx.Err()
+	}
+}
+
+//dial opens a connection to peerAddress
+func (ec *EventsClient) dial(peerAddress string) (*grpc.ClientConn, error) {
+	ec.dialedPeer.Store(peerAddress)
+	if len(ec.dialOpts) > 0 {
+		conn, err := grpc.Dial(peerAddress, append(ec.dialOptions(), ec.dialOpts...)...)
+		if err != nil {
+			return nil, fmt.Errorf("Could not create client conn to %s: %s", peerAddress, err)
+		}
+		return conn, nil
+	}
+	config := ec.connectionConfig(peerAddress)
+	ec.connectingWith(config)
+	conn, err := newEventsClientConnectionWithAddress(peerAddress, config, ec.verifier(peerAddress), ec.blockingDial, ec.dialOptions()...)
+	if ierr := ec.identityChanged(peerAddress); ierr != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, ierr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not create client conn to %s: %s", peerAddress, err)
+	}
+	return conn, nil
+}
+
+//connectionConfig returns the configuration of the connection to
+//peerAddress: the one set for that peer with WithPeerConnectionConfig, else
+//the one set with WithConnectionConfig, else the peer configuration
+func (ec *EventsClient) connectionConfig(peerAddress string) ConnectionConfig {
+	for addr, config := range ec.peerConfigs {
+		if normalized, err := normalizePeerAddress(addr); err == nil && normalized == peerAddress {
+			return config
+		}
+	}
+	if ec.connConfig != nil {
+		return *ec.connConfig
+	}
+	return ConnectionConfigFromViper()
+}
+
+//establishOwnStream registers the interested events over the stream given to
+//NewFromStream
+func (ec *EventsClient) establishOwnStream(ctx context.Context) error {
+	stream := closeSendOnce(ec.ownStream, "")
+	ec.mutex.Lock()
+	used := ec.stream != nil
+	ec.mutex.Unlock()
+	if used {
+		return fmt.Errorf("the stream of the events client cannot be re-established")
+	}
+
+	ies, err := ec.interestedEvents()
+	if err != nil {
+		return fmt.Errorf("error getting interested events:%s", err)
+	}
+	if len(ies) == 0 {
+		return fmt.Errorf("must supply interested events")
+	}
+
+	registerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ec.mutex.Lock()
+	if ec.stopped {
+		ec.mutex.Unlock()
+		return errClientStopped
+	}
+	ec.stream, ec.cancelStream = stream, cancel
+	ec.mutex.Unlock()
+	ec.registrationAttempted(ec.peerAddress)
+	err = ec.register(registerCtx, stream, ies)
+	ec.registrationEnded(ec.peerAddress, err)
+	return err
+}
+
+//defaultErrorHistory is the number of failed attempts a ReconnectError
+//keeps by default
+const defaultErrorHistory = 10
+
+//defaultShutdownTimeout is the time given to a ShutdownAdapter to clean up
+//by default
+const defaultShutdownTimeout = 5 * time.Second
+
+//ReconnectError is the terminal error of a client that could not
+//re-establish its stream. It keeps the errors of the last failed attempts,
+//oldest first, so that a flapping peer failing differently from attempt to
+//attempt can be diagnosed; errors.Is and errors.As see through it
+type ReconnectError struct {
+	//Errors are the errors of the last attempts, at most the number set
+	//with WithReconnectErrorHistory
+	Errors []error
+	//Attempts is the number of failed attempts, including those whose error
+	//was not kept
+	Attempts int
+}
+
+func (e *ReconnectError) add(err error, keep int) {
+	e.Attempts++
+	e.Errors = append(e.Errors, err)
+	if keep > 0 && len(e.Errors) > keep {
+		e.Errors = e.Errors[len(e.Errors)-keep:]
+	}
+}
+
+func (e *ReconnectError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	if len(e.Errors) < e.Attempts {
+		return fmt.Sprintf("could not reconnect after %d attempts, last %d: %s", e.Attempts, len(e.Errors), strings.Join(msgs, "; "))
+	}
+	return fmt.Sprintf("could not reconnect after %d attempts: %s", e.Attempts, strings.Join(msgs, "; "))
+}
+
+//Unwrap returns the kept errors
+func (e *ReconnectError) Unwrap() []error {
+	return e.Errors
+}
+
+//ReconnectInfo describes a reconnect attempt, see WithReconnectHook
+type ReconnectInfo struct {
+	//Attempt numbers the attempts made since the stream was lost, from 1
+	Attempt int
+	//Cause is the error that triggered the attempt: the one that ended the
+	//stream for the first attempt, the failure of the previous attempt next
+	Cause error
+	//Delay is how long the client waited before the attempt
+	Delay time.Duration
+	//Err is the outcome of the attempt, nil if it succeeded
+	Err error
+	//ConnectionID identifies the attempt, see CurrentConnectionID
+	ConnectionID string
+}
+
+//reconnect replaces the current stream, lost because of cause, with a new
+//one. Without a circuit breaker or a backoff a single attempt is made; with
+//one, attempts continue until one succeeds or the client is stopped, pausing
+//while the breaker is open, and for the delays of the backoff until it gives
+//up
+func (ec *EventsClient) reconnect(cause error) error {
+	info := ReconnectInfo{Cause: cause}
+	failures := &ReconnectError{}
+	ec.transition(StateReconnecting)
+	ec.flushBeforeReconnect()
+	for {
+		waited, ok := ec.waitSchedule()
+		if !ok {
+			return errClientStopped
+		}
+		info.Delay += waited
+		if ec.breaker != nil {
+			if wait := ec.breaker.wait(); wait > 0 {
+				ec.logf(logging.WARNING, "circuit breaker open, next reconnect attempt in %s", wait)
+				select {
+				case <-ec.clock.After(wait):
+				case <-ec.stopChan:
+					return errClientStopped
+				case <-ec.ctx.Done():
+					return errClientStopped
+				}
+				info.Delay += wait
+				continue
+			}
+		}
+		if ec.backoff != nil {
+			delay, waited := ec.backoffWait(ec.ctx.Done(), info.Attempt+1)
+			if delay < 0 {
+				if failures.Attempts == 0 {
+					return info.Cause
+				}
+				return failures
+			}
+			if !waited {
+				return errClientStopped
+			}
+			info.Delay += delay
+		}
+		info.Attempt++
+		err := ec.connect(ec.ctx)
+		if err == errClientStopped || ec.ctx.Err() != nil {
+			return errClientStopped
+		}
+		info.Err = err
+		info.ConnectionID = ec.CurrentConnectionID()
+		ec.reconnectAttempted(info)
+		if err == nil {
+			ec.mutex.Lock()
+			ec.stats.reconnects++
+			ec.mutex.Unlock()
+			if ec.breaker != nil {
+				ec.breaker.success()
+			}
+			if ec.backoff != nil {
+				ec.backoff.Reset()
+			}
+			ec.transition(StateConnected)
+			return nil
+		}
+		if _, ok := err.(*PeerIdentityError); ok {
+			// retrying cannot restore the identity of the peer
+			return err
+		}
+		failures.add(err, ec.errorHistory)
+		if ec.breaker == nil && ec.backoff == nil {
+			return failures
+		}
+		if ec.breaker != nil {
+			ec.breaker.failure()
+		}
+		info = ReconnectInfo{Attempt: info.Attempt, Cause: err}
+	}
+}
+
+//reconnectAttempted reports the outcome of a reconnect attempt
+func (ec *EventsClient) reconnectAttempted(info ReconnectInfo) {
+	outcome := "success"
+	if info.Err != nil {
+		outcome = info.Err.Error()
+	}
+	ec.logf(logging.INFO, "reconnect attempt=%d cause=%q delay=%s outcome=%q", info.Attempt, info.Cause, info.Delay, outcome)
+	if ec.reconnectHook != nil {
+		ec.reconnectHook(info)
+	}
+}
+
+//BreakerState returns the state of the client's circuit breaker. It is
+//always BreakerClosed when no breaker is configured
+func (ec *EventsClient) BreakerState() BreakerState {
+	if ec.breaker == nil {
+		return BreakerClosed
+	}
+	return ec.breaker.getState()
+}
+
+//Start establishes connection with Event hub and registers interested events with it.
+//Calling it again while the client is started, or after Stop, returns
+//ErrAlreadyStarted without touching the running stream, even when Stop was
+//called before the client ever started; a Start that failed can be retried
+func (ec *EventsClient) Start() error {
+	return ec.StartContext(context.Background())
+}
+
+//StartContext is like Start, but the dial and the chat stream are bound to
+//ctx: cancelling ctx while the client is connecting or registering aborts
+//StartContext with the context's error. Calling Stop during StartContext aborts it as well. Cancelling ctx
+//once the client has started stops it like Stop, reconnect attempts
+//included, and closes its connection: the adapter is disconnected with nil
+//and the cancellation is not mistaken for a stream failure. The options are
+//checked first: inconsistent ones make it fail before connecting, with an
+//error listing all the problems
+func (ec *EventsClient) StartContext(ctx context.Context) error {
+	if err := ec.validate(); err != nil {
+		return err
+	}
+	addr := ec.PeerAddress()
+	if ec.selector == nil && ec.ownStream == nil {
+		var err error
+		if addr, err = normalizePeerAddress(addr); err != nil {
+			return err
+		}
+	}
+	ec.mutex.Lock()
+	if ec.starting || ec.started {
+		ec.mutex.Unlock()
+		return ErrAlreadyStarted
+	}
+	if err := ec.transitionLocked(StateConnecting); err != nil {
+		// stopped before starting, a client runs once
+		ec.mutex.Unlock()
+		return ErrAlreadyStarted
+	}
+	ec.starting = true
+	ec.peerAddress = addr
+	ec.ctx = ctx
+	ec.lifeCtx, ec.lifeCancel = context.WithCancel(ctx)
+	lifeCancel := ec.lifeCancel
+	ec.mutex.Unlock()
+
+	// the files are seen before the connection reads them, so that no
+	// change is missed
+	var tlsFiles map[string]fileState
+	if ec.watchInterval > 0 {
+		tlsFiles = ec.statTLSFiles()
+	}
+	if err := ec.connect(ctx); err != nil {
+		ec.mutex.Lock()
+		ec.starting = false
+		if ec.stopped {
+			ec.transitionLocked(StateClosed)
+		} else {
+			ec.transitionLocked(StateIdle)
+		}
+		ec.mutex.Unlock()
+		lifeCancel()
+		return err
+	}
+
+	done := make(chan struct{})
+	ec.mutex.Lock()
+	ec.starting = false
+	ec.started = true
+	ec.done = done
+	ec.transitionLocked(StateConnected)
+	if ec.bufferSize > 0 {
+		ec.queue = newEventQueue(ec.bufferSize, ec.bufferBytes, ec.dropWhenFull, ec.queueDepthChanged)
+		ec.routines.spawn(ec.dispatchEvents)
+	}
+	ec.mutex.Unlock()
+	if ec.watchInterval > 0 {
+		lifeCtx := ec.Context()
+		ec.routines.spawn(func() { ec.watchTLSFiles(lifeCtx, tlsFiles) })
+	}
+	ec.tickAdapter()
+
+	ec.routines.spawn(func() {
+		err := ec.processEvents()
+		ec.mutex.Lock()
+		ec.err = err
+		conn := ec.conn
+		ec.mutex.Unlock()
+		if conn != nil {
+			// the connection does not outlive the receive loop, whichever
+			// way it terminated; a peer gone away can hold up its closing,
+			// see WaitGoroutines
+			ec.routines.spawn(func() { closeConn(conn) })
+		}
+		lifeCancel()
+		close(done)
+	})
+
+	return nil
+}
+
+//Context returns a context cancelled when the client is stopped, its receive
+//loop terminates or its start fails, for the work tied to the client's
+//lifetime. It is created by Start: before that, context.Background is
+//returned
+func (ec *EventsClient) Context() context.Context {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if ec.lifeCtx == nil {
+		return context.Background()
+	}
+	return ec.lifeCtx
+}
+
+//recvContext returns the context passed to RecvContext, cancelled with the
+//client and carrying the values of the base context first, then the clock
+//of the client
+func (ec *EventsClient) recvContext() context.Context {
+	ctx := context.WithValue(ec.Context(), clockKey{}, ec.clock)
+	if ec.baseCtx == nil {
+		return ctx
+	}
+	return valuesContext{Context: ctx, values: ec.baseCtx}
+}
+
+//valuesContext is a context whose values are looked up in values first
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c valuesContext) Value(key interface{}) interface{} {
+	if v := c.values.Value(key); v != nil {
+		return v
+	}
+	return c.Context.Value(key)
+}
+
+//StreamContext returns the context of the current stream to the event hub,
+//done once the stream ends. Before Start, context.Background is returned
+func (ec *EventsClient) StreamContext() context.Context {
+	stream := ec.getStream()
+	if stream == nil {
+		return context.Background()
+	}
+	return stream.Context()
+}
+
+//CancelStream ends the current stream without stopping the client, e.g. to
+//get a fresh stream after detecting a staleness the client cannot see. With
+//WithReconnectOnServerClose or WithReconnectOnStreamError the client then
+//reconnects, following the reconnect settings with ErrStreamCancelled as the
+//cause; otherwise the client terminates with the cancellation as a stream
+//failure
+func (ec *EventsClient) CancelStream() error {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if ec.stopped {
+		return errClientStopped
+	}
+	if !ec.started || ec.cancelStream == nil {
+		return fmt.Errorf("events client not started")
+	}
+	ec.logf(logging.INFO, "cancelling the event stream")
+	if (ec.reconnectOnServerClose || ec.reconnectOnStreamError) && !ec.reconnectDisabled {
+		ec.reconnectCause = ErrStreamCancelled
+	}
+	ec.cancelStream()
+	return nil
+}
+
+//SetReconnectEnabled turns the reconnections after a stream loss off and
+//back on while the client runs, e.g. during a planned shutdown of the peer
+//handled by the caller. While they are off, a stream closed by the event hub
+//terminates the client with ErrServerClosed, and the failed streams, the
+//throttled ones and those cancelled with CancelStream terminate it with their
+//failure, as without WithReconnectOnServerClose, WithReconnectOnStreamError
+//and WithThrottleBackoff. Turning them
+//back on restores the reconnections configured with these options; it does
+//not enable those that were not
+func (ec *EventsClient) SetReconnectEnabled(enabled bool) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.reconnectDisabled = !enabled
+}
+
+//reconnectEnabled tells whether SetReconnectEnabled left the reconnections on
+func (ec *EventsClient) reconnectEnabled() bool {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return !ec.reconnectDisabled
+}
+
+//Wait blocks until the receive loop of a started client terminates and
+//returns its terminal error, which is nil after Stop
+func (ec *EventsClient) Wait() error {
+	ec.mutex.Lock()
+	done := ec.done
+	ec.mutex.Unlock()
+	if done == nil {
+		return fmt.Errorf("events client not started")
+	}
+	<-done
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.err
+}
+
+//Run starts the client and receives events until stop is closed, in which
+//case the client is stopped and Run returns nil, or until the receive loop
+//terminates on its own, in which case Run returns its terminal error
+func (ec *EventsClient) Run(stop <-chan struct{}) error {
+	if err := ec.Start(); err != nil {
+		return err
+	}
+	ec.mutex.Lock()
+	done := ec.done
+	ec.mutex.Unlock()
+	select {
+	case <-stop:
+		ec.Stop()
+	case <-done:
+	}
+	return ec.Wait()
+}
+
+//RunFor starts the client and receives events for d, after which the client
+//is stopped and RunFor returns nil, e.g. for a bounded collection job. When
+//the receive loop terminates on its own first, RunFor returns its terminal
+//error. The duration is counted from the call, reconnections included
+func (ec *EventsClient) RunFor(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	if err := ec.Start(); err != nil {
+		return err
+	}
+	ec.mutex.Lock()
+	done := ec.done
+	ec.mutex.Unlock()
+	select {
+	case <-timer.C:
+		ec.Stop()
+	case <-done:
+	}
+	return ec.Wait()
+}
+
+//Stop terminates connection with event hub. The connection is closed once
+//the receive loop has terminated, see WaitGoroutines
 func (ec *EventsClient) Stop() error {
-	if ec.stream == nil {
+	ec.mutex.Lock()
+	ec.markStopped()
+	if ec.state == StateIdle {
+		ec.transitionLocked(StateClosed)
+	} else {
+		ec.transitionLocked(StateDraining)
+	}
+	stream := ec.stream
+	if !ec.started && ec.cancelStream != nil {
+		// abort a registration in progress
+		ec.cancelStream()
+	}
+	warmConn := ec.warmConn
+	ec.warmConn = nil
+	ec.mutex.Unlock()
+	if warmConn != nil {
+		warmConn.Close()
+	}
+	if stream == nil {
 		// in case the steam/chat server has not been established earlier, we assume that it's closed, successfully
 		return nil
 	}
-	return ec.stream.CloseSend()
+	return stream.CloseSend()
+}
+
+//StopAndDrain stops the client like Stop, but the events still buffered are
+//returned instead of being delivered to the adapter, e.g. to persist them
+//elsewhere. An event the adapter is processing is not returned, nor waited
+//for before the adapter is disconnected. It waits for the receive loop to
+//terminate, which has the connection to the event hub closed.
+//Without a buffer no event is returned
+func (ec *EventsClient) StopAndDrain() ([]*ehpb.Event, error) {
+	ec.mutex.Lock()
+	queue := ec.queue
+	done := ec.done
+	ec.mutex.Unlock()
+	var pending []*ehpb.Event
+	if queue != nil {
+		pending = queue.drain()
+	}
+	if err := ec.Stop(); err != nil {
+		return pending, err
+	}
+	if done != nil {
+		// the receive loop has the connection closed as it terminates
+		<-done
+		return pending, nil
+	}
+	ec.mutex.Lock()
+	conn := ec.conn
+	ec.mutex.Unlock()
+	if conn != nil {
+		return pending, closeConn(conn)
+	}
+	return pending, nil
+}
+
+//SetAdapter replaces the adapter receiving the events without reconnecting.
+//An event being delivered to the previous adapter completes first; the
+//following events go to adapter, and it is the one asked for the interested
+//events when the client re-registers. The previous adapter's Disconnected is
+//not called. adapter cannot be nil: together with Start refusing a client
+//without adapter, this guarantees that every event has an adapter to go to
+func (ec *EventsClient) SetAdapter(adapter EventAdapter) error {
+	if adapter == nil {
+		return fmt.Errorf("adapter must not be nil")
+	}
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if ec.stopped {
+		return errClientStopped
+	}
+	ec.adapter = adapter
+	return nil
 }
@@ -0,0 +1,2152 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	ehpb "github.com/hyperledger/fabric/protos"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+//fakeEventsServer is an in-process event hub whose behavior for the n-th
+//chat stream (starting at 1) is scripted by the test
+type fakeEventsServer struct {
+	sync.Mutex
+	chats  int
+	handle func(n int, stream ehpb.Events_ChatServer) error
+}
+
+func (s *fakeEventsServer) Chat(stream ehpb.Events_ChatServer) error {
+	s.Lock()
+	s.chats++
+	n := s.chats
+	s.Unlock()
+	return s.handle(n, stream)
+}
+
+func (s *fakeEventsServer) chatCount() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.chats
+}
+
+func startFakeServer(t *testing.T, handle func(n int, stream ehpb.Events_ChatServer) error) (string, *fakeEventsServer, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start listener: %s", err)
+	}
+	srv := &fakeEventsServer{handle: handle}
+	grpcServer := grpc.NewServer()
+	ehpb.RegisterEventsServer(grpcServer, srv)
+	go grpcServer.Serve(lis)
+	return lis.Addr().String(), srv, grpcServer.Stop
+}
+
+//ackRegister reads the register message from the stream and echoes it back
+//the way the event hub does
+func ackRegister(stream ehpb.Events_ChatServer) (*ehpb.Register, error) {
+	in, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if err = stream.Send(in); err != nil {
+		return nil, err
+	}
+	return in.GetRegister(), nil
+}
+
+//waitForEOF blocks until the client closes its side of the stream
+func waitForEOF(stream ehpb.Events_ChatServer) error {
+	for {
+		if _, err := stream.Recv(); err != nil {
+			return nil
+		}
+	}
+}
+
+func blockEvent() *ehpb.Event {
+	return &ehpb.Event{Event: &ehpb.Event_Block{Block: &ehpb.Block{}}}
+}
+
+type recordingAdapter struct {
+	events       chan *ehpb.Event
+	disconnected chan error
+}
+
+func newRecordingAdapter() *recordingAdapter {
+	return &recordingAdapter{events: make(chan *ehpb.Event, 100), disconnected: make(chan error, 10)}
+}
+
+func (a *recordingAdapter) GetInterestedEvents() ([]*ehpb.Interest, error) {
+	return []*ehpb.Interest{{EventType: ehpb.EventType_BLOCK}}, nil
+}
+
+func (a *recordingAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	a.events <- msg
+	return true, nil
+}
+
+func (a *recordingAdapter) Disconnected(err error) {
+	a.disconnected <- err
+}
+
+func (a *recordingAdapter) waitEvent(t *testing.T) *ehpb.Event {
+	select {
+	case e := <-a.events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+	return nil
+}
+
+func (a *recordingAdapter) waitDisconnected(t *testing.T) error {
+	select {
+	case err := <-a.disconnected:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for disconnect")
+	}
+	return nil
+}
+
+func TestServerCloseDisconnects(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		_, err := ackRegister(stream)
+		return err
+	})
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter)
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	if err := adapter.waitDisconnected(t); err != ErrServerClosed {
+		t.Fatalf("expected ErrServerClosed, got %v", err)
+	}
+}
+
+func TestServerCloseReconnects(t *testing.T) {
+	addr, srv, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		if n == 1 {
+			// clean close of the first stream
+			return nil
+		}
+		if err := stream.Send(blockEvent()); err != nil {
+			return err
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithReconnectOnServerClose())
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	if e := adapter.waitEvent(t); e.GetBlock() == nil {
+		t.Fatalf("expected block event, got %v", e)
+	}
+	if n := srv.chatCount(); n != 2 {
+		t.Fatalf("expected 2 chat streams, got %d", n)
+	}
+
+	client.Stop()
+	if err := adapter.waitDisconnected(t); err != nil {
+		t.Fatalf("expected clean disconnect after Stop, got %v", err)
+	}
+}
+
+type fakeMetrics struct {
+	sync.Mutex
+	gauges   map[string][]float64
+	counters map[string]float64
+	labels   []map[string]string
+	// counters broken down by event type label
+	byType map[string]map[string]float64
+}
+
+func newFakeMetrics() *fakeMetrics {
+	return &fakeMetrics{gauges: make(map[string][]float64), counters: make(map[string]float64), byType: make(map[string]map[string]float64)}
+}
+
+func (m *fakeMetrics) SetGauge(name string, labels map[string]string, value float64) {
+	m.Lock()
+	m.labels = append(m.labels, labels)
+	m.gauges[name] = append(m.gauges[name], value)
+	m.Unlock()
+}
+
+func (m *fakeMetrics) AddCounter(name string, labels map[string]string, delta float64) {
+	m.Lock()
+	m.labels = append(m.labels, labels)
+	m.counters[name] += delta
+	if et, ok := labels[MetricLabelEventType]; ok {
+		if m.byType[name] == nil {
+			m.byType[name] = make(map[string]float64)
+		}
+		m.byType[name][et] += delta
+	}
+	m.Unlock()
+}
+
+func (m *fakeMetrics) gauge(name string) []float64 {
+	m.Lock()
+	defer m.Unlock()
+	return append([]float64(nil), m.gauges[name]...)
+}
+
+func (m *fakeMetrics) counter(name string) float64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.counters[name]
+}
+
+//waitFor polls cond until it holds or the deadline passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRegisterHook(t *testing.T) {
+	registered := make(chan *ehpb.Register, 1)
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		reg, err := ackRegister(stream)
+		if err != nil {
+			return err
+		}
+		registered <- reg
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	hook := func(reg *ehpb.Register) {
+		reg.Events = append(reg.Events, &ehpb.Interest{EventType: ehpb.EventType_REJECTION})
+	}
+	client := NewEventsClient(addr, newRecordingAdapter(), WithRegisterHook(hook))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+
+	reg := <-registered
+	if len(reg.Events) != 2 || reg.Events[1].EventType != ehpb.EventType_REJECTION {
+		t.Fatalf("hook modification did not reach the server: %v", reg)
+	}
+}
+
+func TestSendHook(t *testing.T) {
+	addr, _, stop := startFakeServer(t, nackRegister(1))
+	defer stop()
+
+	var mutex sync.Mutex
+	var sent []*ehpb.Event
+	hook := func(msg *ehpb.Event) {
+		mutex.Lock()
+		sent = append(sent, msg)
+		mutex.Unlock()
+	}
+	client := NewEventsClient(addr, newRecordingAdapter(), WithSendHook(hook), WithRegisterRetries(1))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	// the Register and its resend after the invalid ack
+	if len(sent) != 2 {
+		t.Fatalf("expected 2 sent messages, got %v", sent)
+	}
+	for _, msg := range sent {
+		if reg := msg.GetRegister(); reg == nil || len(reg.Events) != 1 || reg.Events[0].EventType != ehpb.EventType_BLOCK {
+			t.Fatalf("expected a Register message, got %v", msg)
+		}
+	}
+}
+
+func TestConcurrentSend(t *testing.T) {
+	const senders, messages = 8, 25
+	received := make(chan *ehpb.Event, senders*messages)
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for {
+			in, err := stream.Recv()
+			if err != nil {
+				return nil
+			}
+			received <- in
+		}
+	})
+	defer stop()
+
+	var inFlight, overlaps int32
+	hook := func(msg *ehpb.Event) {
+		if atomic.AddInt32(&inFlight, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		time.Sleep(time.Microsecond)
+		atomic.AddInt32(&inFlight, -1)
+	}
+	client := NewEventsClient(addr, newRecordingAdapter(), WithSendHook(hook))
+	if err := client.Send(blockEvent()); err == nil {
+		t.Fatalf("expected Send to fail before Start")
+	}
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				if err := client.Send(chaincodeEvent(fmt.Sprintf("cc%d", i), fmt.Sprintf("%d", j))); err != nil {
+					t.Errorf("could not send: %s", err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// the messages of each sender arrive whole and in order
+	next := make(map[string]int)
+	for k := 0; k < senders*messages; k++ {
+		select {
+		case in := <-received:
+			e := in.GetChaincodeEvent()
+			if e == nil || e.EventName != fmt.Sprintf("%d", next[e.ChaincodeID]) {
+				t.Fatalf("unexpected message %v", in)
+			}
+			next[e.ChaincodeID]++
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for message %d", k)
+		}
+	}
+	if n := atomic.LoadInt32(&overlaps); n != 0 {
+		t.Fatalf("expected the sends to be serialized, %d overlapped", n)
+	}
+}
+
+func TestClientName(t *testing.T) {
+	if name := NewEventsClient("127.0.0.1:7053", nil).Name(); name != "127.0.0.1:7053" {
+		t.Fatalf("expected name to default to the peer address, got %s", name)
+	}
+	if addr := NewEventsClient("127.0.0.1:7053", nil, WithName("auditor")).PeerAddress(); addr != "127.0.0.1:7053" {
+		t.Fatalf("unexpected peer address %s", addr)
+	}
+
+	addr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	metrics := newFakeMetrics()
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithName("auditor"), WithBufferSize(1), WithMetrics(metrics))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+
+	metrics.Lock()
+	defer metrics.Unlock()
+	if len(metrics.labels) == 0 {
+		t.Fatalf("expected metrics to be reported")
+	}
+	for _, l := range metrics.labels {
+		if l[MetricLabelClient] != "auditor" {
+			t.Fatalf("expected client label auditor, got %v", l)
+		}
+	}
+}
+
+//neverAck reads the register message but never acknowledges it
+func neverAck(n int, stream ehpb.Events_ChatServer) error {
+	if _, err := stream.Recv(); err != nil {
+		return err
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+func TestStartContextCancelDuringRegistration(t *testing.T) {
+	addr, _, stop := startFakeServer(t, neverAck)
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	client := NewEventsClient(addr, newRecordingAdapter())
+	begin := time.Now()
+	err := client.StartContext(ctx)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed > 2*time.Second {
+		t.Fatalf("cancellation took %s", elapsed)
+	}
+}
+
+func TestStopDuringRegistration(t *testing.T) {
+	addr, _, stop := startFakeServer(t, neverAck)
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter())
+	time.AfterFunc(200*time.Millisecond, func() { client.Stop() })
+	begin := time.Now()
+	if err := client.Start(); err == nil {
+		t.Fatalf("expected Start to fail when stopped during registration")
+	}
+	if elapsed := time.Since(begin); elapsed > 2*time.Second {
+		t.Fatalf("Stop took %s to abort registration", elapsed)
+	}
+}
+
+//nackRegister answers the first nacks Register messages of the stream with
+//an empty event before acknowledging the next one
+func nackRegister(nacks int) func(int, ehpb.Events_ChatServer) error {
+	return func(n int, stream ehpb.Events_ChatServer) error {
+		for i := 0; i < nacks; i++ {
+			if _, err := stream.Recv(); err != nil {
+				return err
+			}
+			if err := stream.Send(&ehpb.Event{}); err != nil {
+				return err
+			}
+		}
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		return waitForEOF(stream)
+	}
+}
+
+func TestRegisterRetries(t *testing.T) {
+	addr, srv, stop := startFakeServer(t, nackRegister(2))
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter(), WithRegisterRetries(2))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	if n := srv.chatCount(); n != 1 {
+		t.Fatalf("expected the Register to be resent on a single stream, got %d streams", n)
+	}
+}
+
+func TestRegisterRetriesExhausted(t *testing.T) {
+	addr, srv, stop := startFakeServer(t, nackRegister(2))
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter(), WithRegisterRetries(1))
+	err := client.Start()
+	if err == nil || err.Error() != "invalid nil object for register" {
+		t.Fatalf("expected the invalid ack to fail Start, got %v", err)
+	}
+	if n := srv.chatCount(); n != 1 {
+		t.Fatalf("expected a single stream, got %d", n)
+	}
+}
+
+func TestLenientRegistration(t *testing.T) {
+	// the hub sends an event before acknowledging the Register
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		reg, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(rejectionEvent()); err != nil {
+			return err
+		}
+		if err := stream.Send(reg); err != nil {
+			return err
+		}
+		if err := stream.Send(blockEvent()); err != nil {
+			return err
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	strict := NewEventsClient(addr, newRecordingAdapter())
+	if err := strict.Start(); err == nil || err.Error() != "invalid registration object" {
+		t.Fatalf("expected the interleaved event to fail a strict registration, got %v", err)
+	}
+
+	adapter := newRecordingAdapter()
+	lenient := NewEventsClient(addr, adapter, WithLenientRegistration())
+	if err := lenient.Start(); err != nil {
+		t.Fatalf("expected a lenient registration to succeed, got %s", err)
+	}
+	defer lenient.Stop()
+	if e := adapter.waitEvent(t); e.GetBlock() == nil {
+		t.Fatalf("expected the block following the ack, got %v", e)
+	}
+}
+
+func TestLenientRegistrationTimeout(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := stream.Recv(); err != nil {
+			return err
+		}
+		if err := stream.Send(blockEvent()); err != nil {
+			return err
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter(), WithLenientRegistration(), WithRegistrationTimeout(100*time.Millisecond))
+	if err := client.Start(); err == nil || err.Error() != "timeout waiting for registration" {
+		t.Fatalf("expected the registration to time out, got %v", err)
+	}
+}
+
+func TestReceivedEventsByType(t *testing.T) {
+	events := []*ehpb.Event{blockEvent(), chaincodeEvent("mycc", "evt"), rejectionEvent(), rejectionEvent(), {}}
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for _, e := range events {
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	metrics := newFakeMetrics()
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithMetrics(metrics), WithPassEmptyEvents())
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	for range events {
+		adapter.waitEvent(t)
+	}
+
+	expected := map[ehpb.EventType]uint64{ehpb.EventType_BLOCK: 1, ehpb.EventType_CHAINCODE: 1, ehpb.EventType_REJECTION: 2}
+	if got := client.ReceivedEvents(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected counts %v, got %v", expected, got)
+	}
+	metrics.Lock()
+	defer metrics.Unlock()
+	expectedLabels := map[string]float64{"BLOCK": 1, "CHAINCODE": 1, "REJECTION": 2, MetricLabelUnknownEventType: 1}
+	if got := metrics.byType[MetricReceivedEvents]; !reflect.DeepEqual(got, expectedLabels) {
+		t.Fatalf("expected metric counts %v, got %v", expectedLabels, got)
+	}
+}
+
+//recordingListener records the remote addresses of the connections it
+//accepts
+type recordingListener struct {
+	net.Listener
+	mutex   sync.Mutex
+	remotes []net.Addr
+}
+
+func (l *recordingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mutex.Lock()
+		l.remotes = append(l.remotes, conn.RemoteAddr())
+		l.mutex.Unlock()
+	}
+	return conn, err
+}
+
+func TestLocalAddr(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start listener: %s", err)
+	}
+	recording := &recordingListener{Listener: lis}
+	grpcServer := grpc.NewServer()
+	ehpb.RegisterEventsServer(grpcServer, &fakeEventsServer{handle: sendBlocks(1)})
+	go grpcServer.Serve(recording)
+	defer grpcServer.Stop()
+
+	adapter := newRecordingAdapter()
+	local := &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}
+	client := NewEventsClient(lis.Addr().String(), adapter, WithLocalAddr(local))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	recording.mutex.Lock()
+	defer recording.mutex.Unlock()
+	if len(recording.remotes) != 1 || !recording.remotes[0].(*net.TCPAddr).IP.Equal(local.IP) {
+		t.Fatalf("expected a connection from %s, got %v", local.IP, recording.remotes)
+	}
+
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) { return nil, errors.New("unused") }
+	if err := NewEventsClient(lis.Addr().String(), adapter, WithLocalAddr(local), WithContextDialer(dialer)).Start(); err == nil {
+		t.Fatalf("expected a local address with a context dialer to be rejected")
+	}
+}
+
+func TestContextDialer(t *testing.T) {
+	serverAddr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	// the peer address is not resolvable: only the custom dialer knows
+	// where the event hub listens
+	var dialed []string
+	var mutex sync.Mutex
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		mutex.Lock()
+		dialed = append(dialed, addr)
+		mutex.Unlock()
+		deadline, _ := ctx.Deadline()
+		return net.DialTimeout("tcp", serverAddr, deadline.Sub(time.Now()))
+	}
+	adapter := newRecordingAdapter()
+	client := NewEventsClient("inmemory:7053", adapter, WithContextDialer(dialer))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	if e := adapter.waitEvent(t); e.GetBlock() == nil {
+		t.Fatalf("expected a block event, got %v", e)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(dialed) == 0 || dialed[0] != "inmemory:7053" {
+		t.Fatalf("expected the custom dialer to be called with the peer address, got %v", dialed)
+	}
+}
+
+func TestRunUntilStopped(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter)
+	stopChan := make(chan struct{})
+	result := make(chan error, 1)
+	go func() { result <- client.Run(stopChan) }()
+
+	adapter.waitEvent(t)
+	close(stopChan)
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("expected Run to return nil after stop, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run did not return after stop was closed")
+	}
+}
+
+func TestRunReturnsTerminalError(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		_, err := ackRegister(stream)
+		return err
+	})
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter())
+	if err := client.Run(make(chan struct{})); err != ErrServerClosed {
+		t.Fatalf("expected ErrServerClosed, got %v", err)
+	}
+}
+
+func TestRunFor(t *testing.T) {
+	addr, srv, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		if n == 1 {
+			// the first stream ends half-way
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter(), WithReconnectOnServerClose())
+	start := time.Now()
+	if err := client.RunFor(100 * time.Millisecond); err != nil {
+		t.Fatalf("expected RunFor to return nil, got %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("expected the client to run for 100ms, ran for %s", elapsed)
+	}
+	if n := srv.chatCount(); n != 2 {
+		t.Fatalf("expected the client to reconnect once, got %d chat streams", n)
+	}
+}
+
+//trackingDialer dials addr over TCP and records the connections it opens
+type trackingDialer struct {
+	sync.Mutex
+	addr  string
+	conns []*trackedConn
+}
+
+type trackedConn struct {
+	net.Conn
+	sync.Mutex
+	closed bool
+}
+
+func (c *trackedConn) Close() error {
+	c.Lock()
+	c.closed = true
+	c.Unlock()
+	return c.Conn.Close()
+}
+
+func (d *trackingDialer) dial(ctx context.Context, addr string) (net.Conn, error) {
+	deadline, _ := ctx.Deadline()
+	conn, err := net.DialTimeout("tcp", d.addr, deadline.Sub(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	tc := &trackedConn{Conn: conn}
+	d.Lock()
+	d.conns = append(d.conns, tc)
+	d.Unlock()
+	return tc, nil
+}
+
+func (d *trackingDialer) allClosed() bool {
+	d.Lock()
+	defer d.Unlock()
+	for _, c := range d.conns {
+		c.Lock()
+		closed := c.closed
+		c.Unlock()
+		if !closed {
+			return false
+		}
+	}
+	return len(d.conns) > 0
+}
+
+type failingInterestsAdapter struct {
+	*recordingAdapter
+}
+
+func (a *failingInterestsAdapter) GetInterestedEvents() ([]*ehpb.Interest, error) {
+	return nil, errors.New("no configuration")
+}
+
+func TestInterestsFailureAbortsStart(t *testing.T) {
+	addr, srv, stop := startFakeServer(t, sendBlocks(0))
+	defer stop()
+
+	dialer := &trackingDialer{addr: addr}
+	client := NewEventsClient(addr, &failingInterestsAdapter{newRecordingAdapter()}, WithContextDialer(dialer.dial))
+	err := client.Start()
+	if err == nil || !strings.Contains(err.Error(), "no configuration") {
+		t.Fatalf("expected the interests error, got %v", err)
+	}
+	waitFor(t, "connection to be closed", dialer.allClosed)
+	if n := srv.chatCount(); n != 0 {
+		t.Fatalf("expected no chat stream to be opened, got %d", n)
+	}
+}
+
+//oneShotAdapter stops consuming after the first event
+type oneShotAdapter struct {
+	*recordingAdapter
+}
+
+func (a oneShotAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	a.recordingAdapter.Recv(msg)
+	return false, ErrStopConsuming
+}
+
+func TestStopConsuming(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithBufferSize(4)}} {
+		addr, srv, stop := startFakeServer(t, sendBlocks(3))
+
+		adapter := oneShotAdapter{newRecordingAdapter()}
+		client := NewEventsClient(addr, adapter, append(opts, WithReconnectOnServerClose())...)
+		if err := client.Start(); err != nil {
+			t.Fatalf("could not start client: %s", err)
+		}
+		if err := client.Wait(); err != nil {
+			t.Errorf("expected a clean stop, got %s", err)
+		}
+		if err := adapter.waitDisconnected(t); err != nil {
+			t.Errorf("expected Disconnected(nil), got %s", err)
+		}
+		if n := len(adapter.events); n != 1 {
+			t.Errorf("expected a single event to be delivered, got %d", n)
+		}
+		if n := srv.chatCount(); n != 1 {
+			t.Errorf("expected no reconnection, got %d streams", n)
+		}
+		stop()
+	}
+}
+
+func TestReconnectHook(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		switch n {
+		case 1:
+			if _, err := ackRegister(stream); err != nil {
+				return err
+			}
+			return nil
+		case 2:
+			stream.Recv()
+			return errors.New("overloaded")
+		}
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	attempts := make(chan ReconnectInfo, 10)
+	config := CircuitBreakerConfig{Failures: 1, Window: time.Minute, Cooldown: 100 * time.Millisecond}
+	client := NewEventsClient(addr, newRecordingAdapter(), WithReconnectOnServerClose(), WithCircuitBreaker(config),
+		WithReconnectHook(func(info ReconnectInfo) { attempts <- info }))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+
+	var infos []ReconnectInfo
+	for len(infos) < 2 {
+		select {
+		case info := <-attempts:
+			infos = append(infos, info)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for reconnect attempts, got %v", infos)
+		}
+	}
+	first, second := infos[0], infos[1]
+	if first.Attempt != 1 || first.Cause != ErrServerClosed || first.Delay != 0 || first.Err == nil {
+		t.Errorf("unexpected first attempt %+v", first)
+	}
+	if second.Attempt != 2 || second.Cause != first.Err || second.Delay <= 0 || second.Delay > config.Cooldown || second.Err != nil {
+		t.Errorf("unexpected second attempt %+v", second)
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(20))
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter(), WithBufferSize(5), WithReconnectOnServerClose())
+	var wg sync.WaitGroup
+	starts := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			starts <- client.Start()
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				client.Stats()
+				client.ReceivedEvents()
+				client.QueueDepth()
+				client.DroppedEvents()
+				client.BreakerState()
+				client.PeerAddress()
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		time.Sleep(50 * time.Millisecond)
+		client.Stop()
+	}()
+	wg.Wait()
+	close(starts)
+
+	succeeded := 0
+	for err := range starts {
+		if err == nil {
+			succeeded++
+		}
+	}
+	if succeeded > 1 {
+		t.Fatalf("the client was started %d times", succeeded)
+	}
+	if succeeded == 1 {
+		if err := client.Wait(); err != nil {
+			t.Fatalf("expected a clean stop, got %s", err)
+		}
+	}
+}
+
+//countingCodec is the protobuf codec counting the messages it handles
+type countingCodec struct {
+	mutex                sync.Mutex
+	marshals, unmarshals int
+}
+
+func (c *countingCodec) Marshal(v interface{}) ([]byte, error) {
+	c.mutex.Lock()
+	c.marshals++
+	c.mutex.Unlock()
+	return proto.Marshal(v.(proto.Message))
+}
+
+func (c *countingCodec) Unmarshal(data []byte, v interface{}) error {
+	c.mutex.Lock()
+	c.unmarshals++
+	c.mutex.Unlock()
+	return proto.Unmarshal(data, v.(proto.Message))
+}
+
+func (c *countingCodec) String() string {
+	return "counting"
+}
+
+func TestCodec(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	codec := &countingCodec{}
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithCodec(codec))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+
+	codec.mutex.Lock()
+	defer codec.mutex.Unlock()
+	// the Register message, then its ack and the block
+	if codec.marshals != 1 || codec.unmarshals != 2 {
+		t.Fatalf("expected the codec to handle the chat messages, got %d marshals and %d unmarshals", codec.marshals, codec.unmarshals)
+	}
+}
+
+//blockingSendStream is a chat stream whose Send never completes
+type blockingSendStream struct {
+	ehpb.Events_ChatClient
+	unblock chan struct{}
+}
+
+func (s *blockingSendStream) Send(*ehpb.Event) error {
+	<-s.unblock
+	return errors.New("stream canceled")
+}
+
+func TestRegisterSendTimeout(t *testing.T) {
+	stream := &blockingSendStream{unblock: make(chan struct{})}
+	defer close(stream.unblock)
+
+	client := NewEventsClient("127.0.0.1:7053", newRecordingAdapter(), WithRegistrationTimeout(100*time.Millisecond))
+	begin := time.Now()
+	err := client.register(context.Background(), stream, []*ehpb.Interest{BlockInterest()})
+	if err == nil || err.Error() != "timeout sending registration" {
+		t.Fatalf("expected the send to time out, got %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Fatalf("the send timeout took %s", elapsed)
+	}
+}
+
+func TestSetAdapter(t *testing.T) {
+	release := make(chan struct{})
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for i := 0; i < 6; i++ {
+			if i == 3 {
+				<-release
+			}
+			if err := stream.Send(blockEvent()); err != nil {
+				return err
+			}
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	first, second := newRecordingAdapter(), newRecordingAdapter()
+	client := NewEventsClient(addr, first)
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	for i := 0; i < 3; i++ {
+		first.waitEvent(t)
+	}
+	if err := client.SetAdapter(second); err != nil {
+		t.Fatalf("could not set the adapter: %s", err)
+	}
+	close(release)
+	for i := 0; i < 3; i++ {
+		second.waitEvent(t)
+	}
+	if n := len(first.events); n != 0 {
+		t.Fatalf("%d events went to the previous adapter", n)
+	}
+	if err := client.SetAdapter(nil); err == nil {
+		t.Fatalf("expected a nil adapter to be rejected")
+	}
+}
+
+//countingAdapter counts the calls to Disconnected
+type countingAdapter struct {
+	*recordingAdapter
+	mutex         sync.Mutex
+	disconnects   int
+	stopConsuming bool
+}
+
+func (a *countingAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	a.recordingAdapter.Recv(msg)
+	if a.stopConsuming {
+		return false, ErrStopConsuming
+	}
+	return true, nil
+}
+
+func (a *countingAdapter) Disconnected(err error) {
+	a.mutex.Lock()
+	a.disconnects++
+	a.mutex.Unlock()
+	a.recordingAdapter.Disconnected(err)
+}
+
+func TestDisconnectedOnce(t *testing.T) {
+	// the server ends the stream while the adapter stops consuming and the
+	// client is stopped
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		return stream.Send(blockEvent())
+	})
+	defer stop()
+
+	adapter := &countingAdapter{recordingAdapter: newRecordingAdapter(), stopConsuming: true}
+	client := NewEventsClient(addr, adapter, WithBufferSize(1))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	adapter.waitEvent(t)
+	client.Stop()
+	client.Wait()
+	client.disconnected(CloseFatalError, errors.New("late"))
+
+	adapter.mutex.Lock()
+	defer adapter.mutex.Unlock()
+	if adapter.disconnects != 1 {
+		t.Fatalf("expected a single Disconnected call, got %d", adapter.disconnects)
+	}
+}
+
+func TestContext(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(0))
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter())
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	ctx := client.Context()
+	if ctx.Err() != nil {
+		t.Fatalf("context cancelled while the client runs")
+	}
+	client.Stop()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("context not cancelled by Stop")
+	}
+
+	// the context also ends with the receive loop
+	client = NewEventsClient(addr, newRecordingAdapter())
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	ctx = client.Context()
+	stop()
+	client.Wait()
+	if ctx.Err() == nil {
+		t.Fatalf("context not cancelled at the end of the receive loop")
+	}
+}
+
+//closeAdapter records the reason passed to OnClosed
+type closeAdapter struct {
+	*recordingAdapter
+	reasons chan CloseReason
+}
+
+func (a *closeAdapter) OnClosed(reason CloseReason, err error) {
+	a.reasons <- reason
+}
+
+func TestCloseReason(t *testing.T) {
+	endStream := func(n int, stream ehpb.Events_ChatServer) error {
+		_, err := ackRegister(stream)
+		return err
+	}
+	failStream := func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		return errors.New("internal failure")
+	}
+	reconnectFails := func(n int, stream ehpb.Events_ChatServer) error {
+		if n == 1 {
+			return endStream(n, stream)
+		}
+		stream.Recv()
+		return errors.New("overloaded")
+	}
+	tests := []struct {
+		handle   func(int, ehpb.Events_ChatServer) error
+		opts     []Option
+		stop     bool
+		expected CloseReason
+	}{
+		{sendBlocks(0), nil, true, CloseStoppedByCaller},
+		{endStream, nil, false, CloseServerEOF},
+		{failStream, nil, false, CloseFatalError},
+		{reconnectFails, []Option{WithReconnectOnServerClose()}, false, CloseReconnectExhausted},
+	}
+	for _, test := range tests {
+		addr, _, stop := startFakeServer(t, test.handle)
+		adapter := &closeAdapter{newRecordingAdapter(), make(chan CloseReason, 2)}
+		client := NewEventsClient(addr, adapter, test.opts...)
+		if err := client.Start(); err != nil {
+			t.Fatalf("could not start client: %s", err)
+		}
+		if test.stop {
+			client.Stop()
+		}
+		client.Wait()
+		select {
+		case reason := <-adapter.reasons:
+			if reason != test.expected {
+				t.Errorf("expected reason %s, got %s", test.expected, reason)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("OnClosed not called, expected %s", test.expected)
+		}
+		if n := len(adapter.reasons); n != 0 {
+			t.Errorf("OnClosed called more than once")
+		}
+		stop()
+	}
+}
+
+func TestMaxEvents(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithBufferSize(10)}} {
+		addr, _, stop := startFakeServer(t, sendBlocks(5))
+		adapter := &closeAdapter{newRecordingAdapter(), make(chan CloseReason, 2)}
+		client := NewEventsClient(addr, adapter, append([]Option{WithMaxEvents(3)}, opts...)...)
+		if err := client.Start(); err != nil {
+			t.Fatalf("could not start client: %s", err)
+		}
+		if err := client.Wait(); err != nil {
+			t.Fatalf("expected the client to stop without error, got %s", err)
+		}
+		if err := adapter.waitDisconnected(t); err != nil {
+			t.Fatalf("expected the adapter to be disconnected with nil, got %v", err)
+		}
+		if reason := <-adapter.reasons; reason != CloseMaxEvents {
+			t.Fatalf("expected reason %s, got %s", CloseMaxEvents, reason)
+		}
+		if n := len(adapter.events); n != 3 {
+			t.Fatalf("expected exactly 3 events delivered, got %d", n)
+		}
+		stop()
+	}
+}
+
+func TestConnectionID(t *testing.T) {
+	ids := make(chan string, 10)
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		md, _ := metadata.FromContext(stream.Context())
+		ids <- strings.Join(md[ConnectionIDMetadataKey], ",")
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		if n == 1 {
+			// ends the first stream
+			return nil
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	reconnects := make(chan ReconnectInfo, 10)
+	client := NewEventsClient(addr, newRecordingAdapter(), WithReconnectOnServerClose(), WithReconnectHook(func(info ReconnectInfo) { reconnects <- info }))
+	if id := client.CurrentConnectionID(); id != "" {
+		t.Fatalf("expected no connection ID before Start, got %s", id)
+	}
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	first := <-ids
+	var info ReconnectInfo
+	select {
+	case info = <-reconnects:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the reconnect")
+	}
+	second := <-ids
+	if first == "" || second == "" || first == second {
+		t.Fatalf("expected a distinct connection ID per stream, got %q and %q", first, second)
+	}
+	if id := client.CurrentConnectionID(); id != second || info.ConnectionID != second {
+		t.Fatalf("expected the current connection ID %q, got %q and %q in the reconnect info", second, id, info.ConnectionID)
+	}
+}
+
+func TestRegisterContext(t *testing.T) {
+	addr, _, stop := startFakeServer(t, neverAck)
+	defer stop()
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not dial: %s", err)
+	}
+	defer conn.Close()
+	client := NewEventsClient(addr, newRecordingAdapter(), WithRegistrationTimeout(100*time.Millisecond))
+	interests := []*ehpb.Interest{BlockInterest()}
+
+	// the registration timeout
+	streamCtx, cancelStream := context.WithCancel(context.Background())
+	stream, err := ehpb.NewEventsClient(conn).Chat(streamCtx)
+	if err != nil {
+		t.Fatalf("could not open stream: %s", err)
+	}
+	if err = client.register(context.Background(), stream, interests); err == nil || err.Error() != "timeout waiting for registration" {
+		t.Fatalf("expected the ack wait to time out, got %v", err)
+	}
+	cancelStream()
+
+	// the cancellation of the context
+	streamCtx, cancelStream = context.WithCancel(context.Background())
+	defer cancelStream()
+	if stream, err = ehpb.NewEventsClient(conn).Chat(streamCtx); err != nil {
+		t.Fatalf("could not open stream: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err = client.registerContext(ctx, stream, interests); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+type rejection struct {
+	txID   string
+	reason string
+}
+
+//rejectionAdapter records the decoded rejections passed to OnRejection
+type rejectionAdapter struct {
+	*recordingAdapter
+	rejections chan rejection
+}
+
+func (a *rejectionAdapter) OnRejection(txID string, reason string) {
+	a.rejections <- rejection{txID, reason}
+}
+
+func TestOnRejection(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		rejected := &ehpb.Event{Event: &ehpb.Event_Rejection{Rejection: &ehpb.Rejection{Tx: &ehpb.Transaction{Uuid: "tx1"}, ErrorMsg: "invalid signature"}}}
+		if err := stream.Send(rejected); err != nil {
+			return err
+		}
+		if err := stream.Send(blockEvent()); err != nil {
+			return err
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	adapter := &rejectionAdapter{newRecordingAdapter(), make(chan rejection, 10)}
+	client := NewEventsClient(addr, adapter)
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	if adapter.waitEvent(t).GetRejection() == nil {
+		t.Fatalf("the rejection event was not passed to Recv")
+	}
+	adapter.waitEvent(t)
+	select {
+	case r := <-adapter.rejections:
+		if r.txID != "tx1" || r.reason != "invalid signature" {
+			t.Fatalf("unexpected rejection %+v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("OnRejection not called")
+	}
+	if n := len(adapter.rejections); n != 0 {
+		t.Fatalf("OnRejection called for %d other events", n)
+	}
+}
+
+func TestNewFromStream(t *testing.T) {
+	addr, srv, stop := startFakeServer(t, sendBlocks(2))
+	defer stop()
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not dial: %s", err)
+	}
+	defer conn.Close()
+	stream, err := ehpb.NewEventsClient(conn).Chat(context.Background())
+	if err != nil {
+		t.Fatalf("could not open stream: %s", err)
+	}
+
+	adapter := newRecordingAdapter()
+	client := NewFromStream(stream, adapter)
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	adapter.waitEvent(t)
+	adapter.waitEvent(t)
+	if n := srv.chatCount(); n != 1 {
+		t.Fatalf("expected the given stream only, got %d chats", n)
+	}
+	if stats := client.Stats(); stats.RegistrationAttempts != 1 {
+		t.Fatalf("expected a registration over the stream, got %d", stats.RegistrationAttempts)
+	}
+	client.Stop()
+	if err := adapter.waitDisconnected(t); err != nil {
+		t.Fatalf("expected a clean disconnect, got %s", err)
+	}
+
+	client = NewFromStream(stream, newRecordingAdapter(), WithReconnectOnServerClose())
+	if err := client.Start(); err == nil || !strings.Contains(err.Error(), "cannot reconnect") {
+		t.Fatalf("expected reconnecting to be rejected, got %v", err)
+	}
+}
+
+//sequenceAdapter records the sequence numbers of the delivered events
+type sequenceAdapter struct {
+	*recordingAdapter
+	sequences chan uint64
+}
+
+func (a *sequenceAdapter) RecvWithMetadata(msg *ehpb.Event, md EventMetadata) (bool, error) {
+	a.sequences <- md.Sequence
+	return a.recordingAdapter.Recv(msg)
+}
+
+func TestEventSequence(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithReconnectOnServerClose()},
+		{WithReconnectOnServerClose(), WithBufferSize(10)},
+	} {
+		addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+			if _, err := ackRegister(stream); err != nil {
+				return err
+			}
+			for i := 0; i < 3; i++ {
+				if err := stream.Send(blockEvent()); err != nil {
+					return err
+				}
+			}
+			if n > 1 {
+				return waitForEOF(stream)
+			}
+			return nil
+		})
+		adapter := &sequenceAdapter{newRecordingAdapter(), make(chan uint64, 10)}
+		client := NewEventsClient(addr, AsyncAdapter(adapter, 10, 1), opts...)
+		if err := client.Start(); err != nil {
+			t.Fatalf("could not start client: %s", err)
+		}
+		// the sequence continues over the reconnection
+		for want := uint64(1); want <= 6; want++ {
+			select {
+			case got := <-adapter.sequences:
+				if got != want {
+					t.Fatalf("expected sequence %d, got %d", want, got)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for sequence %d", want)
+			}
+		}
+		client.Stop()
+		stop()
+	}
+}
+
+//connIDAdapter records the connection IDs of the delivered events
+type connIDAdapter struct {
+	*recordingAdapter
+	ids chan string
+}
+
+func (a *connIDAdapter) RecvWithMetadata(msg *ehpb.Event, md EventMetadata) (bool, error) {
+	a.ids <- md.ConnectionID
+	return a.recordingAdapter.Recv(msg)
+}
+
+func TestEventConnectionID(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithReconnectOnServerClose()},
+		{WithReconnectOnServerClose(), WithBufferSize(10)},
+	} {
+		sent := make(chan string, 10)
+		addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+			md, _ := metadata.FromContext(stream.Context())
+			sent <- strings.Join(md[ConnectionIDMetadataKey], ",")
+			if _, err := ackRegister(stream); err != nil {
+				return err
+			}
+			for i := 0; i < 2; i++ {
+				if err := stream.Send(blockEvent()); err != nil {
+					return err
+				}
+			}
+			if n > 1 {
+				return waitForEOF(stream)
+			}
+			return nil
+		})
+		adapter := &connIDAdapter{newRecordingAdapter(), make(chan string, 10)}
+		client := NewEventsClient(addr, adapter, opts...)
+		if err := client.Start(); err != nil {
+			t.Fatalf("could not start client: %s", err)
+		}
+		var got []string
+		for len(got) < 4 {
+			select {
+			case id := <-adapter.ids:
+				got = append(got, id)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for events, got %v", got)
+			}
+		}
+		first, second := <-sent, <-sent
+		if want := []string{first, first, second, second}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected the events of each stream to carry its connection ID %v, got %v", want, got)
+		}
+		if first == second {
+			t.Fatalf("expected a new connection ID after reconnecting, got %s twice", first)
+		}
+		client.Stop()
+		stop()
+	}
+}
+
+func TestStartContextCancelled(t *testing.T) {
+	failReconnects := func(n int, stream ehpb.Events_ChatServer) error {
+		if n == 1 {
+			if _, err := ackRegister(stream); err != nil {
+				return err
+			}
+			return stream.Send(blockEvent())
+		}
+		stream.Recv()
+		return errors.New("overloaded")
+	}
+	tests := []struct {
+		name   string
+		handle func(int, ehpb.Events_ChatServer) error
+	}{
+		{"active stream", sendBlocks(1)},
+		{"reconnect loop", failReconnects},
+	}
+	for _, test := range tests {
+		addr, srv, stop := startFakeServer(t, test.handle)
+		ctx, cancel := context.WithCancel(context.Background())
+		adapter := &closeAdapter{newRecordingAdapter(), make(chan CloseReason, 2)}
+		backoff := &ExponentialBackoff{Initial: 50 * time.Millisecond, Max: 50 * time.Millisecond}
+		client := NewEventsClient(addr, adapter, WithReconnectOnServerClose(), WithBackoff(backoff))
+		if err := client.StartContext(ctx); err != nil {
+			t.Fatalf("%s: could not start client: %s", test.name, err)
+		}
+		adapter.waitEvent(t)
+		if test.name == "reconnect loop" {
+			waitFor(t, "failed reconnects", func() bool { return srv.chatCount() >= 3 })
+		}
+		cancel()
+		if err := adapter.waitDisconnected(t); err != nil {
+			t.Fatalf("%s: expected a clean disconnect, got %s", test.name, err)
+		}
+		if reason := <-adapter.reasons; reason != CloseStoppedByCaller {
+			t.Fatalf("%s: expected the client to be stopped, got %s", test.name, reason)
+		}
+		if err := client.Wait(); err != nil {
+			t.Fatalf("%s: expected no terminal error, got %s", test.name, err)
+		}
+		// a stream opened right before the cancellation may reach the server
+		// after the client terminated
+		time.Sleep(20 * time.Millisecond)
+		chats := srv.chatCount()
+		time.Sleep(200 * time.Millisecond)
+		if n := srv.chatCount(); n != chats {
+			t.Fatalf("%s: expected no reconnect after the cancellation, got %d more streams", test.name, n-chats)
+		}
+		if client.Context().Err() == nil {
+			t.Fatalf("%s: expected the client context to be done", test.name)
+		}
+		stop()
+	}
+}
+
+var errUnavailable = errors.New("store unavailable")
+
+//unavailableAdapter fails the first delivery with errUnavailable
+type unavailableAdapter struct {
+	*recordingAdapter
+	mutex  sync.Mutex
+	failed bool
+}
+
+func (a *unavailableAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	a.mutex.Lock()
+	failed := a.failed
+	a.failed = true
+	a.mutex.Unlock()
+	if !failed {
+		return false, errUnavailable
+	}
+	return a.recordingAdapter.Recv(msg)
+}
+
+func TestReconnectOnAdapterError(t *testing.T) {
+	classify := func(err error) bool { return err == errUnavailable }
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"unbuffered", nil},
+		{"buffered", []Option{WithBufferSize(10)}},
+	} {
+		func() {
+			addr, srv, stop := startFakeServer(t, sendBlocks(1))
+			defer stop()
+
+			adapter := &unavailableAdapter{recordingAdapter: newRecordingAdapter()}
+			client := NewEventsClient(addr, adapter, append(tc.opts, WithReconnectOnAdapterError(classify))...)
+			if err := client.Start(); err != nil {
+				t.Fatalf("%s: could not start client: %s", tc.name, err)
+			}
+			if e := adapter.waitEvent(t); e.GetBlock() == nil {
+				t.Fatalf("%s: expected block event, got %v", tc.name, e)
+			}
+			if n := srv.chatCount(); n != 2 {
+				t.Fatalf("%s: expected 2 chat streams, got %d", tc.name, n)
+			}
+			client.Stop()
+			if err := adapter.waitDisconnected(t); err != nil {
+				t.Fatalf("%s: expected clean disconnect after Stop, got %v", tc.name, err)
+			}
+		}()
+	}
+
+	addr, srv, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+	adapter := &unavailableAdapter{recordingAdapter: newRecordingAdapter()}
+	client := NewEventsClient(addr, adapter, WithReconnectOnAdapterError(func(error) bool { return false }))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	if err := client.Wait(); err != errUnavailable {
+		t.Fatalf("expected the adapter error to stop the client, got %v", err)
+	}
+	if n := srv.chatCount(); n != 1 {
+		t.Fatalf("expected 1 chat stream, got %d", n)
+	}
+}
+
+//failingAdapter fails the first delivery with errUnavailable and records
+//how the client terminated
+type failingAdapter struct {
+	*unavailableAdapter
+	reasons chan CloseReason
+	closed  chan struct{}
+}
+
+func (a *failingAdapter) OnClosed(reason CloseReason, err error) {
+	a.reasons <- reason
+}
+
+func (a *failingAdapter) Done() error {
+	close(a.closed)
+	return nil
+}
+
+func TestAdapterErrorTerminates(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"unbuffered", nil},
+		{"buffered", []Option{WithBufferSize(10)}},
+	} {
+		func() {
+			addr, _, stop := startFakeServer(t, sendBlocks(1))
+			defer stop()
+
+			adapter := &failingAdapter{&unavailableAdapter{recordingAdapter: newRecordingAdapter()}, make(chan CloseReason, 2), make(chan struct{})}
+			client := NewEventsClient(addr, adapter, tc.opts...)
+			if err := client.Start(); err != nil {
+				t.Fatalf("%s: could not start client: %s", tc.name, err)
+			}
+			if err := client.Wait(); err != errUnavailable {
+				t.Fatalf("%s: expected the adapter error as the terminal error, got %v", tc.name, err)
+			}
+			if err := adapter.waitDisconnected(t); err != errUnavailable {
+				t.Fatalf("%s: expected the adapter to be disconnected with its error, got %v", tc.name, err)
+			}
+			select {
+			case <-adapter.closed:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: Done not called after the adapter error", tc.name)
+			}
+			if reason := <-adapter.reasons; reason != CloseFatalError {
+				t.Fatalf("%s: expected reason %s, got %s", tc.name, CloseFatalError, reason)
+			}
+			if state := client.State(); state != StateClosed {
+				t.Fatalf("%s: expected the client to be closed, got %s", tc.name, state)
+			}
+			client.WaitGoroutines()
+			client.mutex.Lock()
+			conn := client.conn
+			client.mutex.Unlock()
+			if state := conn.State(); state != grpc.Shutdown {
+				t.Fatalf("%s: expected the connection to be closed, got %s", tc.name, state)
+			}
+		}()
+	}
+}
+
+type contextKey string
+
+type contextAdapter struct {
+	*recordingAdapter
+	contexts chan context.Context
+}
+
+func (a *contextAdapter) RecvContext(ctx context.Context, msg *ehpb.Event) (bool, error) {
+	a.contexts <- ctx
+	return a.recordingAdapter.Recv(msg)
+}
+
+func TestRecvContext(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	adapter := &contextAdapter{recordingAdapter: newRecordingAdapter(), contexts: make(chan context.Context, 1)}
+	base, cancelBase := context.WithCancel(context.WithValue(context.Background(), contextKey("tenant"), "acme"))
+	cancelBase()
+	client := NewEventsClient(addr, adapter, WithBaseContext(base))
+	parent := context.WithValue(context.Background(), contextKey("job"), "import")
+	if err := client.StartContext(parent); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	adapter.waitEvent(t)
+	ctx := <-adapter.contexts
+	if v := ctx.Value(contextKey("tenant")); v != "acme" {
+		t.Fatalf("expected the value of the base context, got %v", v)
+	}
+	if v := ctx.Value(contextKey("job")); v != "import" {
+		t.Fatalf("expected the value of the start context, got %v", v)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("the cancellation of the base context should not apply")
+	}
+	client.Stop()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the context to be cancelled once the client stopped")
+	}
+}
+
+//shutdownAdapter records the context and the error it is disconnected with
+type shutdownAdapter struct {
+	*recordingAdapter
+	contexts chan context.Context
+}
+
+func (a *shutdownAdapter) DisconnectedContext(ctx context.Context, err error) {
+	a.contexts <- ctx
+	a.Disconnected(err)
+}
+
+func TestDisconnectedContext(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	clock := newFakeClock()
+	adapter := &shutdownAdapter{recordingAdapter: newRecordingAdapter(), contexts: make(chan context.Context, 1)}
+	base := context.WithValue(context.Background(), contextKey("tenant"), "acme")
+	client := NewEventsClient(addr, adapter, WithShutdownTimeout(3*time.Second), WithBaseContext(base), withClock(clock))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	adapter.waitEvent(t)
+	client.Stop()
+	if err := adapter.waitDisconnected(t); err != nil {
+		t.Fatalf("expected the adapter to be disconnected with nil, got %v", err)
+	}
+	ctx := <-adapter.contexts
+	deadline, ok := ctx.Deadline()
+	if expected := clock.Now().Add(3 * time.Second); !ok || !deadline.Equal(expected) {
+		t.Fatalf("expected the deadline %s, got %s (%v)", expected, deadline, ok)
+	}
+	if v := ctx.Value(contextKey("tenant")); v != "acme" {
+		t.Fatalf("expected the value of the base context, got %v", v)
+	}
+	if ctx.Err() == nil {
+		t.Fatalf("expected the context to be released once the adapter returned")
+	}
+}
+
+func TestCancelStream(t *testing.T) {
+	for _, reconnect := range []Option{WithReconnectOnServerClose(), WithReconnectOnStreamError()} {
+		func() {
+			addr, srv, stop := startFakeServer(t, sendBlocks(1))
+			defer stop()
+
+			adapter := newRecordingAdapter()
+			reconnects := make(chan ReconnectInfo, 10)
+			client := NewEventsClient(addr, adapter, reconnect, WithReconnectHook(func(info ReconnectInfo) { reconnects <- info }))
+			if err := client.CancelStream(); err == nil {
+				t.Fatalf("expected CancelStream to fail before Start")
+			}
+			if err := client.Start(); err != nil {
+				t.Fatalf("could not start client: %s", err)
+			}
+			defer client.Stop()
+			adapter.waitEvent(t)
+			ctx := client.StreamContext()
+			if err := client.CancelStream(); err != nil {
+				t.Fatalf("could not cancel the stream: %s", err)
+			}
+			adapter.waitEvent(t)
+			select {
+			case <-ctx.Done():
+			default:
+				t.Fatalf("expected the context of the cancelled stream to be done")
+			}
+			if client.StreamContext().Err() != nil {
+				t.Fatalf("expected the new stream to be active")
+			}
+			select {
+			case info := <-reconnects:
+				if info.Cause != ErrStreamCancelled {
+					t.Fatalf("expected ErrStreamCancelled as the cause, got %v", info.Cause)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for the reconnect")
+			}
+			if n := srv.chatCount(); n != 2 {
+				t.Fatalf("expected exactly one reconnect, got %d chat streams", n)
+			}
+			if n := client.Stats().Reconnects; n != 1 {
+				t.Fatalf("expected 1 reconnect in the stats, got %d", n)
+			}
+		}()
+	}
+}
+
+func TestCancelStreamWithoutReconnect(t *testing.T) {
+	addr, srv, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter)
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	adapter.waitEvent(t)
+	client.CancelStream()
+	if err := client.Wait(); err == nil {
+		t.Fatalf("expected the cancelled stream to terminate the client")
+	}
+	if n := srv.chatCount(); n != 1 {
+		t.Fatalf("expected no reconnect, got %d chat streams", n)
+	}
+}
+
+//streamStartAdapter records the stream starts and the events it receives in
+//the order they are notified
+type streamStartAdapter struct {
+	*recordingAdapter
+	mutex sync.Mutex
+	log   []string
+}
+
+func (a *streamStartAdapter) OnStreamStart() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.log = append(a.log, "start")
+}
+
+func (a *streamStartAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	a.mutex.Lock()
+	a.log = append(a.log, "event")
+	a.mutex.Unlock()
+	return a.recordingAdapter.Recv(msg)
+}
+
+func (a *streamStartAdapter) entries() []string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]string(nil), a.log...)
+}
+
+func TestOnStreamStart(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"unbuffered", nil},
+		{"buffered", []Option{WithBufferSize(10)}},
+	} {
+		func() {
+			addr, _, stop := startFakeServer(t, sendBlocks(2))
+			defer stop()
+
+			adapter := &streamStartAdapter{recordingAdapter: newRecordingAdapter()}
+			opts := append([]Option{WithReconnectOnServerClose()}, tc.opts...)
+			client := NewEventsClient(addr, adapter, opts...)
+			if err := client.Start(); err != nil {
+				t.Fatalf("%s: could not start client: %s", tc.name, err)
+			}
+			defer client.Stop()
+			adapter.waitEvent(t)
+			adapter.waitEvent(t)
+			if err := client.CancelStream(); err != nil {
+				t.Fatalf("%s: could not cancel the stream: %s", tc.name, err)
+			}
+			adapter.waitEvent(t)
+			adapter.waitEvent(t)
+			want := []string{"start", "event", "event", "start", "event", "event"}
+			if got := adapter.entries(); !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: expected %v, got %v", tc.name, want, got)
+			}
+		}()
+	}
+}
+
+//aftersClock is a fakeClock reporting the durations it is asked to wait for
+type aftersClock struct {
+	*fakeClock
+	afters chan time.Duration
+}
+
+func (c *aftersClock) After(d time.Duration) <-chan time.Time {
+	ch := c.fakeClock.After(d)
+	select {
+	case c.afters <- d:
+	default:
+	}
+	return ch
+}
+
+//waitAfter waits for the clock to be asked to wait for d
+func (c *aftersClock) waitAfter(t *testing.T, d time.Duration) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case got := <-c.afters:
+			if got == d {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("the clock was not asked to wait for %s", d)
+		}
+	}
+}
+
+func TestRegistrationDelay(t *testing.T) {
+	const delay = 200 * time.Millisecond
+	for _, initial := range []bool{false, true} {
+		registered := make(chan int, 2)
+		addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+			if _, err := ackRegister(stream); err != nil {
+				return err
+			}
+			registered <- n
+			if n == 1 {
+				// the client reconnects
+				return nil
+			}
+			return waitForEOF(stream)
+		})
+		clock := &aftersClock{fakeClock: newFakeClock(), afters: make(chan time.Duration, 100)}
+		// the delay is measured by the clock of the client: the Register
+		// is only sent once the whole delay has elapsed on it
+		delayed := func(what string) {
+			clock.waitAfter(t, delay)
+			clock.advance(delay - time.Millisecond)
+			select {
+			case <-registered:
+				t.Fatalf("initial %t: the %s came before the delay", initial, what)
+			case <-time.After(50 * time.Millisecond):
+			}
+			clock.advance(time.Millisecond)
+			select {
+			case <-registered:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("initial %t: no %s after the delay", initial, what)
+			}
+		}
+		adapter := newRecordingAdapter()
+		client := NewEventsClient(addr, adapter, WithReconnectOnServerClose(), WithRegistrationDelay(delay, initial), withClock(clock))
+		started := make(chan error, 1)
+		go func() { started <- client.Start() }()
+		if initial {
+			delayed("first registration")
+		} else {
+			select {
+			case <-registered:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("initial %t: the first registration was delayed", initial)
+			}
+		}
+		if err := <-started; err != nil {
+			t.Fatalf("could not start client: %s", err)
+		}
+		delayed("registration after the reconnection")
+		client.Stop()
+		stop()
+	}
+}
+
+func TestLateRegistrationAck(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		first, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		// an event before the ack makes the client resend its Register
+		if err := stream.Send(blockEvent()); err != nil {
+			return err
+		}
+		if _, err := stream.Recv(); err != nil {
+			return err
+		}
+		// both registrations are acknowledged, the second ack comes late
+		for _, msg := range []*ehpb.Event{first, first, blockEvent()} {
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithRegisterRetries(1))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	if e := adapter.waitEvent(t); e.GetBlock() == nil {
+		t.Fatalf("expected the late ack to be discarded, got %v", e)
+	}
+	if n := client.ReceivedEvents()[ehpb.EventType_REGISTER]; n != 0 {
+		t.Fatalf("expected the late ack not to be counted, got %d", n)
+	}
+}
+
+func TestSetReconnectEnabled(t *testing.T) {
+	release := make(chan struct{})
+	addr, srv, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		if err := stream.Send(blockEvent()); err != nil {
+			return err
+		}
+		if n > 1 {
+			<-release
+		}
+		return nil
+	})
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithReconnectOnServerClose())
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	// the first stream loss is followed by a reconnection
+	adapter.waitEvent(t)
+	client.SetReconnectEnabled(false)
+	close(release)
+	if err := client.Wait(); err != ErrServerClosed {
+		t.Fatalf("expected the client to terminate with ErrServerClosed, got %v", err)
+	}
+	if n := srv.chatCount(); n != 2 {
+		t.Fatalf("expected no reconnection once disabled, got %d chat streams", n)
+	}
+}
+
+//countingStream counts the calls to CloseSend of the stream it wraps
+type countingStream struct {
+	ehpb.Events_ChatClient
+	closes int32
+}
+
+func (s *countingStream) CloseSend() error {
+	atomic.AddInt32(&s.closes, 1)
+	return s.Events_ChatClient.CloseSend()
+}
+
+func TestStopIsClean(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not dial: %s", err)
+	}
+	defer conn.Close()
+	chat, err := ehpb.NewEventsClient(conn).Chat(context.Background())
+	if err != nil {
+		t.Fatalf("could not open stream: %s", err)
+	}
+	stream := &countingStream{Events_ChatClient: chat}
+
+	adapter := &closeAdapter{newRecordingAdapter(), make(chan CloseReason, 1)}
+	client := NewFromStream(stream, adapter)
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	adapter.waitEvent(t)
+	if err := client.Stop(); err != nil {
+		t.Fatalf("expected Stop to succeed, got %s", err)
+	}
+	if err := adapter.waitDisconnected(t); err != nil {
+		t.Fatalf("expected a clean disconnect, got %s", err)
+	}
+	if reason := <-adapter.reasons; reason != CloseStoppedByCaller {
+		t.Fatalf("expected the %s close reason, got %s", CloseStoppedByCaller, reason)
+	}
+	if err := client.Wait(); err != nil {
+		t.Fatalf("expected no terminal error, got %s", err)
+	}
+	if err := client.Stop(); err != nil {
+		t.Fatalf("expected a second Stop to succeed, got %s", err)
+	}
+	if n := atomic.LoadInt32(&stream.closes); n != 1 {
+		t.Fatalf("expected the send side to be closed once, got %d", n)
+	}
+}
+
+func TestStartTwice(t *testing.T) {
+	addr, srv, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter)
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	adapter.waitEvent(t)
+	if err := client.Start(); err != ErrAlreadyStarted {
+		t.Fatalf("expected ErrAlreadyStarted, got %v", err)
+	}
+	if err := client.Connect(context.Background()); err != ErrAlreadyStarted {
+		t.Fatalf("expected Connect to return ErrAlreadyStarted, got %v", err)
+	}
+	if n := srv.chatCount(); n != 1 {
+		t.Fatalf("expected a single stream, got %d", n)
+	}
+	if stats := client.Stats(); stats.RegistrationAttempts != 1 {
+		t.Fatalf("expected a single registration, got %d", stats.RegistrationAttempts)
+	}
+	select {
+	case e := <-adapter.events:
+		t.Fatalf("unexpected event %v from a second receive loop", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+	client.Stop()
+	if err := client.Start(); err != ErrAlreadyStarted {
+		t.Fatalf("expected a stopped client not to start again, got %v", err)
+	}
+
+	// stopped before it ever started
+	client = NewEventsClient(addr, newRecordingAdapter())
+	client.Stop()
+	if err := client.Start(); err != ErrAlreadyStarted {
+		t.Fatalf("expected a client stopped before starting not to start, got %v", err)
+	}
+	if n := srv.chatCount(); n != 1 {
+		t.Fatalf("expected no stream from a client stopped before starting, got %d", n)
+	}
+
+	// concurrent starts, one of them wins
+	client = NewEventsClient(addr, newRecordingAdapter())
+	defer client.Stop()
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { results <- client.Start() }()
+	}
+	first, second := <-results, <-results
+	if (first == nil) == (second == nil) || (first != ErrAlreadyStarted && second != ErrAlreadyStarted) {
+		t.Fatalf("expected one start to fail with ErrAlreadyStarted, got %v and %v", first, second)
+	}
+}
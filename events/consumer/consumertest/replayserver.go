@@ -0,0 +1,135 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumertest
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+
+	"github.com/hyperledger/fabric/events/consumer"
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//ReplayServer is an event hub serving the events of a recording written by
+//a consumer.FileSink over gRPC, so that several services can run their
+//integration tests against the same captured sequence. Each chat stream is
+//served the whole recording from the start: the Register message is
+//acknowledged, then the recorded events matching its interests are sent in
+//order
+type ReplayServer struct {
+	events []*ehpb.Event
+
+	//CloseAtEnd ends each stream once the recording is sent. Otherwise the
+	//stream is kept open until the client closes it, like the event hub
+	//waiting for new events
+	CloseAtEnd bool
+
+	mutex  sync.Mutex
+	server *grpc.Server
+}
+
+//NewReplayServer returns a ReplayServer of the recording read from r, which
+//is read entirely first
+func NewReplayServer(r io.Reader) (*ReplayServer, error) {
+	events := consumer.NewEventFileReader(r)
+	s := &ReplayServer{}
+	for {
+		msg, err := events.Next()
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.events = append(s.events, msg)
+	}
+}
+
+//Len returns the number of recorded events
+func (s *ReplayServer) Len() int {
+	return len(s.events)
+}
+
+//Serve serves the recording on lis until Stop is called
+func (s *ReplayServer) Serve(lis net.Listener) error {
+	server := grpc.NewServer()
+	ehpb.RegisterEventsServer(server, s)
+	s.mutex.Lock()
+	s.server = server
+	s.mutex.Unlock()
+	return server.Serve(lis)
+}
+
+//Stop stops serving, closing the open streams
+func (s *ReplayServer) Stop() {
+	s.mutex.Lock()
+	server := s.server
+	s.mutex.Unlock()
+	if server != nil {
+		server.Stop()
+	}
+}
+
+//Chat implements ehpb.EventsServer
+func (s *ReplayServer) Chat(stream ehpb.Events_ChatServer) error {
+	in, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	interests := in.GetRegister().GetEvents()
+	if err := stream.Send(in); err != nil {
+		return err
+	}
+	for _, msg := range s.events {
+		if !matches(interests, msg) {
+			continue
+		}
+		if err := stream.Send(msg); err != nil {
+			return err
+		}
+	}
+	if s.CloseAtEnd {
+		return nil
+	}
+	for {
+		if _, err := stream.Recv(); err != nil {
+			return nil
+		}
+	}
+}
+
+//matches tells whether the event hub sends msg to a consumer registered
+//with interests
+func matches(interests []*ehpb.Interest, msg *ehpb.Event) bool {
+	for _, interest := range interests {
+		switch {
+		case interest.EventType == ehpb.EventType_BLOCK && msg.GetBlock() != nil:
+			return true
+		case interest.EventType == ehpb.EventType_REJECTION && msg.GetRejection() != nil:
+			return true
+		case interest.EventType == ehpb.EventType_CHAINCODE && msg.GetChaincodeEvent() != nil:
+			reg, event := interest.GetChaincodeRegInfo(), msg.GetChaincodeEvent()
+			if reg != nil && reg.ChaincodeID == event.ChaincodeID && (reg.EventName == "" || reg.EventName == event.EventName) {
+				return true
+			}
+		}
+	}
+	return false
+}
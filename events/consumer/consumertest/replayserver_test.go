@@ -0,0 +1,124 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumertest_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/events/consumer"
+	"github.com/hyperledger/fabric/events/consumer/consumertest"
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//record writes events to a recording in dir with a FileSink
+func record(t *testing.T, dir string, events []*ehpb.Event) string {
+	path := filepath.Join(dir, "events.rec")
+	sink, err := consumer.FileSinkAdapter(consumer.FileSinkConfig{Path: path}, nil)
+	if err != nil {
+		t.Fatalf("could not create the recording: %s", err)
+	}
+	for _, msg := range events {
+		if _, err := sink.Recv(msg); err != nil {
+			t.Fatalf("could not record an event: %s", err)
+		}
+	}
+	if err := sink.Done(); err != nil {
+		t.Fatalf("could not close the recording: %s", err)
+	}
+	return path
+}
+
+//orderedAdapter records the blocks it receives, interested in the blocks
+//only
+type orderedAdapter struct {
+	blocks chan *ehpb.Block
+}
+
+func (a *orderedAdapter) GetInterestedEvents() ([]*ehpb.Interest, error) {
+	return []*ehpb.Interest{{EventType: ehpb.EventType_BLOCK}}, nil
+}
+
+func (a *orderedAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	a.blocks <- msg.GetBlock()
+	return true, nil
+}
+
+func (a *orderedAdapter) Disconnected(err error) {}
+
+func TestReplayServer(t *testing.T) {
+	var events []*ehpb.Event
+	for i := 0; i < 5; i++ {
+		events = append(events, &ehpb.Event{Event: &ehpb.Event_Block{Block: &ehpb.Block{StateHash: []byte{byte(i)}}}})
+		if i == 2 {
+			// not delivered to the adapter, interested in the blocks only
+			events = append(events, &ehpb.Event{Event: &ehpb.Event_Rejection{Rejection: &ehpb.Rejection{ErrorMsg: "rejected"}}})
+		}
+	}
+	dir, err := ioutil.TempDir("", "replayserver")
+	if err != nil {
+		t.Fatalf("could not create a directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := os.Open(record(t, dir, events))
+	if err != nil {
+		t.Fatalf("could not open the recording: %s", err)
+	}
+	server, err := consumertest.NewReplayServer(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("could not read the recording: %s", err)
+	}
+	if server.Len() != len(events) {
+		t.Fatalf("expected %d recorded events, got %d", len(events), server.Len())
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start listener: %s", err)
+	}
+	go server.Serve(lis)
+	defer server.Stop()
+
+	// each client gets the whole recording
+	for c := 0; c < 2; c++ {
+		adapter := &orderedAdapter{make(chan *ehpb.Block, 10)}
+		client := consumer.NewEventsClient(lis.Addr().String(), adapter)
+		if err := client.Start(); err != nil {
+			t.Fatalf("could not start client: %s", err)
+		}
+		for i := 0; i < 5; i++ {
+			select {
+			case block := <-adapter.blocks:
+				if block == nil || len(block.StateHash) != 1 || block.StateHash[0] != byte(i) {
+					t.Fatalf("client %d: expected block %d, got %v", c, i, block)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("client %d: timed out waiting for block %d", c, i)
+			}
+		}
+		select {
+		case block := <-adapter.blocks:
+			t.Fatalf("client %d: unexpected event %v", c, block)
+		case <-time.After(50 * time.Millisecond):
+		}
+		client.Stop()
+	}
+}
@@ -0,0 +1,240 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//Package consumertest provides a fake chat stream of the event hub, for
+//testing an events client built with consumer.NewFromStream without a
+//network, and ReplayServer, an event hub serving a recording over gRPC
+package consumertest
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//step is what a call of Recv returns
+type step struct {
+	event *ehpb.Event
+	err   error
+}
+
+//Stream is a scripted ehpb.Events_ChatClient. Recv returns, in order, the
+//events and errors queued with Queue, QueueAck, QueueError and End, and
+//blocks while none is queued. Once the send side is closed, Recv returns
+//io.EOF after the queued steps, like an event hub ending the stream when its
+//client goes away; after Cancel it returns the error of the context. Send
+//records the messages, except those failed with FailSend. A Stream is safe
+//for concurrent use
+type Stream struct {
+	mutex    sync.Mutex
+	queue    []step
+	wake     chan struct{}
+	sent     []*ehpb.Event
+	sends    int
+	sendErrs map[int]error
+	closed   bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+//NewStream returns a stream with nothing queued
+func NewStream() *Stream {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Stream{
+		wake:     make(chan struct{}),
+		sendErrs: make(map[int]error),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+func (s *Stream) push(steps ...step) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.queue = append(s.queue, steps...)
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+//Queue queues events for Recv to return
+func (s *Stream) Queue(events ...*ehpb.Event) {
+	steps := make([]step, len(events))
+	for i, e := range events {
+		steps[i] = step{event: e}
+	}
+	s.push(steps...)
+}
+
+//QueueAck queues the ack of a registration. The client does not match the
+//acks with its Register messages, so one ack is to be queued per Register,
+//before the events that follow it
+func (s *Stream) QueueAck() {
+	s.Queue(&ehpb.Event{Event: &ehpb.Event_Register{Register: &ehpb.Register{}}})
+}
+
+//QueueError queues an error for Recv to return, e.g. a transport error in the
+//middle of the stream. The steps queued after it are returned by the next
+//calls of Recv
+func (s *Stream) QueueError(err error) {
+	s.push(step{err: err})
+}
+
+//End queues the end of the stream by the event hub: Recv returns io.EOF
+func (s *Stream) End() {
+	s.QueueError(io.EOF)
+}
+
+//FailSend makes the nth call of Send, counting from 1, return err instead of
+//sending its message
+func (s *Stream) FailSend(n int, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sendErrs[n] = err
+}
+
+//Sent returns the messages sent so far, in order
+func (s *Stream) Sent() []*ehpb.Event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*ehpb.Event(nil), s.sent...)
+}
+
+//WaitSent waits up to timeout for n messages to have been sent and returns
+//them, or an error with those sent so far
+func (s *Stream) WaitSent(n int, timeout time.Duration) ([]*ehpb.Event, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		sent := s.Sent()
+		if len(sent) >= n {
+			return sent, nil
+		}
+		if time.Now().After(deadline) {
+			return sent, fmt.Errorf("%d messages sent after %s, expected %d", len(sent), timeout, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+//Closed tells whether the send side of the stream was closed
+func (s *Stream) Closed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+//Cancel cancels the context of the stream, as if the context it was opened
+//with was cancelled
+func (s *Stream) Cancel() {
+	s.cancel()
+}
+
+//Send records msg, or returns the error set with FailSend for this call.
+//Sending on a closed stream is an error
+func (s *Stream) Send(msg *ehpb.Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sends++
+	if err, ok := s.sendErrs[s.sends]; ok {
+		return err
+	}
+	if s.closed {
+		return fmt.Errorf("send on a closed stream")
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	s.sent = append(s.sent, proto.Clone(msg).(*ehpb.Event))
+	return nil
+}
+
+//Recv returns the next queued step
+func (s *Stream) Recv() (*ehpb.Event, error) {
+	for {
+		s.mutex.Lock()
+		if len(s.queue) > 0 {
+			next := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mutex.Unlock()
+			return next.event, next.err
+		}
+		closed, wake := s.closed, s.wake
+		s.mutex.Unlock()
+		if closed {
+			return nil, io.EOF
+		}
+		select {
+		case <-wake:
+		case <-s.ctx.Done():
+			return nil, s.ctx.Err()
+		}
+	}
+}
+
+//CloseSend closes the send side of the stream and makes Recv return io.EOF
+//once the queued steps are returned
+func (s *Stream) CloseSend() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	close(s.wake)
+	s.wake = make(chan struct{})
+	return nil
+}
+
+//Header returns no metadata
+func (s *Stream) Header() (metadata.MD, error) {
+	return metadata.MD{}, nil
+}
+
+//Trailer returns no metadata
+func (s *Stream) Trailer() metadata.MD {
+	return metadata.MD{}
+}
+
+//Context returns the context of the stream, done after Cancel
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+//SendMsg sends m, which must be an *ehpb.Event
+func (s *Stream) SendMsg(m interface{}) error {
+	msg, ok := m.(*ehpb.Event)
+	if !ok {
+		return fmt.Errorf("unexpected message %T", m)
+	}
+	return s.Send(msg)
+}
+
+//RecvMsg receives the next event into m, which must be an *ehpb.Event
+func (s *Stream) RecvMsg(m interface{}) error {
+	msg, ok := m.(*ehpb.Event)
+	if !ok {
+		return fmt.Errorf("unexpected message %T", m)
+	}
+	in, err := s.Recv()
+	if err != nil {
+		return err
+	}
+	proto.Merge(msg, in)
+	return nil
+}
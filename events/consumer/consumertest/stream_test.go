@@ -0,0 +1,195 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumertest_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/events/consumer"
+	"github.com/hyperledger/fabric/events/consumer/consumertest"
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func blockEvent() *ehpb.Event {
+	return &ehpb.Event{Event: &ehpb.Event_Block{Block: &ehpb.Block{}}}
+}
+
+func rejectionEvent(msg string) *ehpb.Event {
+	return &ehpb.Event{Event: &ehpb.Event_Rejection{Rejection: &ehpb.Rejection{ErrorMsg: msg}}}
+}
+
+//recordingAdapter records the events it receives
+type recordingAdapter struct {
+	events chan *ehpb.Event
+}
+
+func newRecordingAdapter() *recordingAdapter {
+	return &recordingAdapter{events: make(chan *ehpb.Event, 10)}
+}
+
+func (a *recordingAdapter) GetInterestedEvents() ([]*ehpb.Interest, error) {
+	return []*ehpb.Interest{{EventType: ehpb.EventType_BLOCK}, {EventType: ehpb.EventType_REJECTION}}, nil
+}
+
+func (a *recordingAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	a.events <- msg
+	return true, nil
+}
+
+func (a *recordingAdapter) Disconnected(err error) {}
+
+func (a *recordingAdapter) waitEvent(t *testing.T) *ehpb.Event {
+	select {
+	case e := <-a.events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for an event")
+		return nil
+	}
+}
+
+func TestMidStreamError(t *testing.T) {
+	stream := consumertest.NewStream()
+	stream.QueueAck()
+	stream.Queue(blockEvent())
+	stream.QueueError(errors.New("transport is closing"))
+
+	adapter := newRecordingAdapter()
+	client := consumer.NewFromStream(stream, adapter)
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	if e := adapter.waitEvent(t); e.GetBlock() == nil {
+		t.Fatalf("expected the block, got %v", e)
+	}
+	if err := client.Wait(); err == nil || !strings.Contains(err.Error(), "transport is closing") {
+		t.Fatalf("expected the transport error, got %v", err)
+	}
+	sent := stream.Sent()
+	if len(sent) != 1 || sent[0].GetRegister() == nil {
+		t.Fatalf("expected a single Register, got %v", sent)
+	}
+	if n := len(sent[0].GetRegister().Events); n != 2 {
+		t.Fatalf("expected 2 interested events, got %d", n)
+	}
+}
+
+func TestRegistrationFailure(t *testing.T) {
+	// a block in place of the ack
+	stream := consumertest.NewStream()
+	stream.Queue(blockEvent())
+	client := consumer.NewFromStream(stream, newRecordingAdapter())
+	if err := client.Start(); err == nil || !strings.Contains(err.Error(), "invalid registration object") {
+		t.Fatalf("expected an invalid ack, got %v", err)
+	}
+
+	// the Register cannot be sent
+	stream = consumertest.NewStream()
+	stream.FailSend(1, errors.New("stream reset"))
+	client = consumer.NewFromStream(stream, newRecordingAdapter())
+	if err := client.Start(); err == nil || !strings.Contains(err.Error(), "stream reset") {
+		t.Fatalf("expected the send error, got %v", err)
+	}
+	if n := len(stream.Sent()); n != 0 {
+		t.Fatalf("expected the failed send not to be recorded, got %d messages", n)
+	}
+
+	// the stream ends before the ack
+	stream = consumertest.NewStream()
+	stream.End()
+	client = consumer.NewFromStream(stream, newRecordingAdapter())
+	if err := client.Start(); err == nil {
+		t.Fatalf("expected the registration to fail")
+	}
+}
+
+func TestLateAck(t *testing.T) {
+	// the block before the ack makes the client resend its Register, and the
+	// first ack then comes late
+	stream := consumertest.NewStream()
+	stream.Queue(blockEvent())
+	stream.QueueAck()
+	stream.QueueAck()
+	stream.Queue(rejectionEvent("late"))
+
+	adapter := newRecordingAdapter()
+	client := consumer.NewFromStream(stream, adapter, consumer.WithRegisterRetries(1))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	if e := adapter.waitEvent(t); e.GetRejection() == nil {
+		t.Fatalf("expected the late ack to be discarded, got %v", e)
+	}
+	if n := len(stream.Sent()); n != 2 {
+		t.Fatalf("expected the Register to be sent twice, got %d messages", n)
+	}
+}
+
+func TestStop(t *testing.T) {
+	stream := consumertest.NewStream()
+	stream.QueueAck()
+	client := consumer.NewFromStream(stream, newRecordingAdapter())
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	client.Stop()
+	if err := client.Wait(); err != nil {
+		t.Fatalf("expected a clean stop, got %s", err)
+	}
+	if !stream.Closed() {
+		t.Fatalf("expected Stop to close the send side")
+	}
+
+	// cancelling the stream ends the client
+	stream = consumertest.NewStream()
+	stream.QueueAck()
+	client = consumer.NewFromStream(stream, newRecordingAdapter())
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	stream.Cancel()
+	if err := client.Wait(); err == nil {
+		t.Fatalf("expected the cancellation to end the client")
+	}
+}
+
+func ExampleStream() {
+	stream := consumertest.NewStream()
+	stream.QueueAck()
+	stream.Queue(rejectionEvent("endorsement failed"))
+	stream.QueueError(errors.New("connection reset"))
+
+	adapter := newRecordingAdapter()
+	client := consumer.NewFromStream(stream, adapter)
+	if err := client.Start(); err != nil {
+		fmt.Println("start:", err)
+		return
+	}
+	e := <-adapter.events
+	fmt.Println("received:", e.GetRejection().ErrorMsg)
+	fmt.Println("terminated:", client.Wait())
+	fmt.Println("sent:", len(stream.Sent()), "message")
+	// Output:
+	// received: endorsement failed
+	// terminated: connection reset
+	// sent: 1 message
+}
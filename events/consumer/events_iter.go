@@ -0,0 +1,54 @@
+//go:build go1.23
+// +build go1.23
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"iter"
+
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//Events returns an iterator over the events received by the client:
+//
+//	for msg, err := range client.Events(ctx) {
+//
+//Iterating starts the client if needed and switches its delivery to the
+//iterator, keeping the interested events of its adapter, which no longer
+//receives anything. The iteration ends when the stream ends, yielding the
+//terminal error if any, or when ctx is done, yielding ctx.Err(). Breaking
+//out of the loop or the end of the iteration stops the client
+func (ec *EventsClient) Events(ctx context.Context) iter.Seq2[*ehpb.Event, error] {
+	return func(yield func(*ehpb.Event, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		events, errs := ec.Subscribe(ctx)
+		defer ec.Stop()
+		for msg := range events {
+			if !yield(msg, nil) {
+				return
+			}
+		}
+		if err := <-errs; err != nil {
+			yield(nil, err)
+		}
+	}
+}
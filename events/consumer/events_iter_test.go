@@ -0,0 +1,105 @@
+//go:build go1.23
+// +build go1.23
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestEventsIteration(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for i := 0; i < 3; i++ {
+			if err := stream.Send(blockEvent()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter())
+	received := 0
+	var last error
+	for msg, err := range client.Events(context.Background()) {
+		if err != nil {
+			last = err
+			continue
+		}
+		if msg.GetBlock() == nil {
+			t.Fatalf("unexpected event %v", msg)
+		}
+		received++
+	}
+	if received != 3 || last != ErrServerClosed {
+		t.Fatalf("expected 3 blocks then ErrServerClosed, got %d and %v", received, last)
+	}
+}
+
+func TestEventsEarlyBreak(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(5))
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter())
+	for _, err := range client.Events(context.Background()) {
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		break
+	}
+	done := make(chan error)
+	go func() { done <- client.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected a clean stop, got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the client was not stopped by the break")
+	}
+}
+
+func TestEventsContextCancelled(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := NewEventsClient(addr, newRecordingAdapter())
+	var errs []error
+	for msg, err := range client.Events(ctx) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if msg != nil {
+			cancel()
+		}
+	}
+	if len(errs) != 1 || errs[0] != context.Canceled {
+		t.Fatalf("expected a single context.Canceled, got %v", errs)
+	}
+}
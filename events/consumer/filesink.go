@@ -0,0 +1,373 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//FileSinkConfig configures a FileSink
+type FileSinkConfig struct {
+	//Path is the file the events are appended to
+	Path string
+	//MaxSize, if positive, is the size in bytes above which the file is
+	//rotated: it is renamed Path.N, N counting up from 1, and a new file is
+	//started at Path. An event larger than MaxSize still gets its own file
+	MaxSize int64
+	//SyncInterval, if positive, is the period at which the written events are
+	//flushed and synced to disk. When zero every event is flushed to the
+	//file as it is received, and the file is only synced on rotation and by
+	//Done
+	SyncInterval time.Duration
+	//Compress writes the files as gzip streams, each rotated file a complete
+	//one. A flush ends the compressed blocks written so far, so that they can
+	//be read back before the file is closed; when the sink reopens an
+	//existing file it appends a new gzip member to it. MaxSize then counts
+	//the uncompressed bytes written, besides the size of a reopened file
+	Compress bool
+}
+
+//FileSink is an EventAdapter that appends every received event to a file,
+//each event in protobuf binary encoding prefixed by its length as a varint,
+//the delimited format of the protobuf libraries, the whole file compressed
+//with gzip with FileSinkConfig.Compress. EventFileReader reads the files
+//back
+type FileSink struct {
+	mutex     sync.Mutex
+	config    FileSinkConfig
+	interests []*ehpb.Interest
+	file      *os.File
+	gz        *gzip.Writer
+	w         *bufio.Writer
+	size      int64
+	next      int
+	closed    bool
+	stop      chan struct{}
+
+	//OnError, if set, is called when an event cannot be written, synced or
+	//rotated, with the event concerned, nil for a periodic sync. When unset
+	//the errors are logged
+	OnError func(msg *ehpb.Event, err error)
+}
+
+//FileSinkAdapter returns a FileSink registering the given interests and
+//appending the events it receives to config.Path, created if needed. The
+//client calls Done to flush and close the file when it terminates
+func FileSinkAdapter(config FileSinkConfig, interests []*ehpb.Interest) (*FileSink, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("file sink path must not be empty")
+	}
+	rotated, err := rotatedFiles(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileSink{config: config, interests: interests, next: 1, stop: make(chan struct{})}
+	if len(rotated) > 0 {
+		s.next = rotatedIndex(config.Path, rotated[len(rotated)-1]) + 1
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	if config.SyncInterval > 0 {
+		go s.syncPeriodically()
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.config.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening event file: %s", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error opening event file: %s", err)
+	}
+	s.file, s.size = file, info.Size()
+	if s.config.Compress {
+		s.gz = gzip.NewWriter(file)
+		s.w = bufio.NewWriter(s.gz)
+	} else {
+		s.gz, s.w = nil, bufio.NewWriter(file)
+	}
+	return nil
+}
+
+//flush writes the buffered events to the file, with the sink locked
+func (s *FileSink) flush() error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if s.gz != nil {
+		return s.gz.Flush()
+	}
+	return nil
+}
+
+//GetInterestedEvents implements EventAdapter
+func (s *FileSink) GetInterestedEvents() ([]*ehpb.Interest, error) {
+	return s.interests, nil
+}
+
+//Recv implements EventAdapter. Errors never stop the client; they are
+//reported through OnError instead
+func (s *FileSink) Recv(msg *ehpb.Event) (bool, error) {
+	if err := s.write(msg); err != nil {
+		s.report(msg, err)
+	}
+	return true, nil
+}
+
+func (s *FileSink) write(msg *ehpb.Event) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error marshaling event: %s", err)
+	}
+	frame := append(proto.EncodeVarint(uint64(len(data))), data...)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return fmt.Errorf("file sink is closed")
+	}
+	if s.config.MaxSize > 0 && s.size > 0 && s.size+int64(len(frame)) > s.config.MaxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.w.Write(frame)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("error writing event: %s", err)
+	}
+	if s.config.SyncInterval <= 0 {
+		if err := s.flush(); err != nil {
+			return fmt.Errorf("error writing event: %s", err)
+		}
+	}
+	return nil
+}
+
+//rotate moves the current file aside and starts a new one, with the sink
+//locked
+func (s *FileSink) rotate() error {
+	if err := s.closeFile(); err != nil {
+		return err
+	}
+	rotated := s.config.Path + "." + strconv.Itoa(s.next)
+	if err := os.Rename(s.config.Path, rotated); err != nil {
+		return fmt.Errorf("error rotating event file: %s", err)
+	}
+	s.next++
+	return s.open()
+}
+
+//closeFile flushes, syncs and closes the current file, with the sink locked.
+//A compressed file is terminated as a complete gzip stream
+func (s *FileSink) closeFile() error {
+	err := s.w.Flush()
+	if s.gz != nil {
+		if gerr := s.gz.Close(); err == nil {
+			err = gerr
+		}
+	}
+	if serr := s.file.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("error closing event file: %s", err)
+	}
+	return nil
+}
+
+func (s *FileSink) sync() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	if err := s.flush(); err != nil {
+		return fmt.Errorf("error writing event: %s", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("error syncing event file: %s", err)
+	}
+	return nil
+}
+
+func (s *FileSink) syncPeriodically() {
+	ticker := time.NewTicker(s.config.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.sync(); err != nil {
+				s.report(nil, err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *FileSink) report(msg *ehpb.Event, err error) {
+	if s.OnError != nil {
+		s.OnError(msg, err)
+		return
+	}
+	consumerLogger.Error(err.Error())
+}
+
+//Flush implements FlushingAdapter: it flushes the written events and syncs
+//the file to disk
+func (s *FileSink) Flush() error {
+	return s.sync()
+}
+
+//Disconnected implements EventAdapter. The file stays open until Done
+func (s *FileSink) Disconnected(err error) {
+}
+
+//Done implements ClosingAdapter: it flushes, syncs and closes the current
+//file. The events received afterwards are reported as errors
+func (s *FileSink) Done() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	return s.closeFile()
+}
+
+//EventFileReader reads the events written by a FileSink
+type EventFileReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+//NewEventFileReader returns a reader of the delimited events of r, which is
+//decompressed if it is a gzip stream
+func NewEventFileReader(r io.Reader) *EventFileReader {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return &EventFileReader{err: fmt.Errorf("invalid compressed event file: %s", err)}
+		}
+		return &EventFileReader{r: bufio.NewReader(gz)}
+	}
+	return &EventFileReader{r: br}
+}
+
+//Next returns the next event, or io.EOF at the end of the input
+func (e *EventFileReader) Next() (*ehpb.Event, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	size, err := binary.ReadUvarint(e.r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("truncated event file: %s", err)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(e.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("truncated event file: %s", err)
+	}
+	msg := &ehpb.Event{}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("invalid event in event file: %s", err)
+	}
+	return msg, nil
+}
+
+//SinkFiles returns the files written by a FileSink at path, oldest first:
+//the rotated files, then path itself if it exists
+func SinkFiles(path string) ([]string, error) {
+	files, err := rotatedFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return files, nil
+}
+
+//rotatedFiles returns the files rotated from path, in rotation order
+func rotatedFiles(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var rotated []string
+	for _, m := range matches {
+		if rotatedIndex(path, m) > 0 {
+			rotated = append(rotated, m)
+		}
+	}
+	sort.Sort(byRotation{path: path, files: rotated})
+	return rotated, nil
+}
+
+//byRotation sorts the files rotated from path in rotation order
+type byRotation struct {
+	path  string
+	files []string
+}
+
+func (r byRotation) Len() int { return len(r.files) }
+func (r byRotation) Less(i, j int) bool {
+	return rotatedIndex(r.path, r.files[i]) < rotatedIndex(r.path, r.files[j])
+}
+func (r byRotation) Swap(i, j int) { r.files[i], r.files[j] = r.files[j], r.files[i] }
+
+//rotatedIndex returns N for the file path.N, or 0 if name is not one
+func rotatedIndex(path, name string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(name, path+"."))
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n
+}
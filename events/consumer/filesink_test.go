@@ -0,0 +1,276 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func readSinkFiles(t *testing.T, path string) []*ehpb.Event {
+	files, err := SinkFiles(path)
+	if err != nil {
+		t.Fatalf("could not list the event files: %s", err)
+	}
+	var events []*ehpb.Event
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatalf("could not open %s: %s", name, err)
+		}
+		r := NewEventFileReader(f)
+		for {
+			msg, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("could not read %s: %s", name, err)
+			}
+			events = append(events, msg)
+		}
+		f.Close()
+	}
+	return events
+}
+
+func TestFileSinkRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events")
+
+	events := []*ehpb.Event{blockEvent(), chaincodeEvent("mycc", "a"), rejectionEvent(), chaincodeEvent("mycc", "b"), blockEvent()}
+	// room for about two events per file
+	size := 2*proto.Size(chaincodeEvent("mycc", "a")) + 4
+	sink, err := FileSinkAdapter(FileSinkConfig{Path: path, MaxSize: int64(size)}, nil)
+	if err != nil {
+		t.Fatalf("could not create the sink: %s", err)
+	}
+	sink.OnError = func(msg *ehpb.Event, err error) {
+		t.Errorf("unexpected error %s", err)
+	}
+	for _, e := range events[:3] {
+		sink.Recv(e)
+	}
+	if err := sink.Done(); err != nil {
+		t.Fatalf("could not close the sink: %s", err)
+	}
+	// a new sink appends and keeps rotating after the existing files
+	sink, err = FileSinkAdapter(FileSinkConfig{Path: path, MaxSize: int64(size)}, nil)
+	if err != nil {
+		t.Fatalf("could not reopen the sink: %s", err)
+	}
+	for _, e := range events[3:] {
+		sink.Recv(e)
+	}
+	sink.Done()
+
+	if files, _ := SinkFiles(path); len(files) < 3 {
+		t.Fatalf("expected the file to be rotated, got %v", files)
+	}
+	got := readSinkFiles(t, path)
+	if len(got) != len(events) {
+		t.Fatalf("expected %d events, got %d", len(events), len(got))
+	}
+	for i := range events {
+		if !proto.Equal(got[i], events[i]) {
+			t.Fatalf("event %d: expected %v, got %v", i, events[i], got[i])
+		}
+	}
+
+	var reported error
+	sink.OnError = func(msg *ehpb.Event, err error) {
+		reported = err
+	}
+	sink.Recv(blockEvent())
+	if reported == nil {
+		t.Fatalf("expected an error for an event received after Done")
+	}
+}
+
+func TestFileSinkCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.gz")
+
+	events := []*ehpb.Event{blockEvent(), chaincodeEvent("mycc", "a"), rejectionEvent(), chaincodeEvent("mycc", "b"), blockEvent()}
+	config := FileSinkConfig{Path: path, MaxSize: int64(2*proto.Size(chaincodeEvent("mycc", "a")) + 4), Compress: true}
+	sink, err := FileSinkAdapter(config, nil)
+	if err != nil {
+		t.Fatalf("could not create the sink: %s", err)
+	}
+	for _, e := range events[:3] {
+		sink.Recv(e)
+	}
+	// the flushed events can be read before the file is closed
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("could not open the live file: %s", err)
+	}
+	if msg, err := NewEventFileReader(f).Next(); err != nil || !proto.Equal(msg, events[2]) {
+		t.Fatalf("expected the last event written, got %v and %v", msg, err)
+	}
+	f.Close()
+	if err := sink.Done(); err != nil {
+		t.Fatalf("could not close the sink: %s", err)
+	}
+	// reopening appends a gzip member to the file
+	if sink, err = FileSinkAdapter(config, nil); err != nil {
+		t.Fatalf("could not reopen the sink: %s", err)
+	}
+	for _, e := range events[3:] {
+		sink.Recv(e)
+	}
+	sink.Done()
+
+	files, _ := SinkFiles(path)
+	if len(files) < 2 {
+		t.Fatalf("expected the file to be rotated, got %v", files)
+	}
+	for _, name := range files {
+		data, err := ioutil.ReadFile(name)
+		if err != nil || len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+			t.Fatalf("expected %s to be compressed", name)
+		}
+	}
+	got := readSinkFiles(t, path)
+	if len(got) != len(events) {
+		t.Fatalf("expected %d events, got %d", len(events), len(got))
+	}
+	for i := range events {
+		if !proto.Equal(got[i], events[i]) {
+			t.Fatalf("event %d: expected %v, got %v", i, events[i], got[i])
+		}
+	}
+}
+
+func TestFileSinkSyncInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events")
+
+	sink, err := FileSinkAdapter(FileSinkConfig{Path: path, SyncInterval: 10 * time.Millisecond}, nil)
+	if err != nil {
+		t.Fatalf("could not create the sink: %s", err)
+	}
+	defer sink.Done()
+	sink.Recv(blockEvent())
+	waitFor(t, "the event to be synced", func() bool {
+		info, err := os.Stat(path)
+		return err == nil && info.Size() > 0
+	})
+}
+
+//closingBatchingAdapter is a batchingAdapter logging when it is closed
+type closingBatchingAdapter struct {
+	*batchingAdapter
+}
+
+func (a closingBatchingAdapter) Done() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.log = append(a.log, "done")
+	return nil
+}
+
+//loggedSink is a FileSink logging its flushes and closing to log
+type loggedSink struct {
+	*FileSink
+	log *batchingAdapter
+}
+
+func (s loggedSink) Flush() error {
+	s.log.mutex.Lock()
+	s.log.log = append(s.log.log, "sink flush")
+	s.log.mutex.Unlock()
+	return s.FileSink.Flush()
+}
+
+func (s loggedSink) Done() error {
+	s.log.mutex.Lock()
+	s.log.log = append(s.log.log, "sink done")
+	s.log.mutex.Unlock()
+	return s.FileSink.Done()
+}
+
+func TestShutdownFlushesSinks(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(3))
+	defer stop()
+	dir, err := ioutil.TempDir("", "filesink")
+	if err != nil {
+		t.Fatalf("could not create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events")
+	// a sync interval keeps the events buffered until flushed
+	sink, err := FileSinkAdapter(FileSinkConfig{Path: path, SyncInterval: time.Hour}, nil)
+	if err != nil {
+		t.Fatalf("could not create the file sink: %s", err)
+	}
+	var raw bytes.Buffer
+	recorded := bufio.NewWriterSize(&raw, 1<<16)
+
+	adapter := closingBatchingAdapter{&batchingAdapter{gate: make(chan struct{})}}
+	client := NewEventsClient(addr, adapter, WithBufferSize(10), WithRawRecorder(recorded, nil))
+	client.SetTap(loggedSink{sink, adapter.batchingAdapter})
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	waitFor(t, "the events to be buffered", func() bool { return client.Stats().QueueDepth == 2 })
+	client.Stop()
+	close(adapter.gate)
+	client.Wait()
+
+	expected := []string{"flush 3", "disconnected", "done", "sink flush", "sink done"}
+	if log := adapter.entries(); !reflect.DeepEqual(log, expected) {
+		t.Fatalf("expected the shutdown sequence %v, got %v", expected, log)
+	}
+	if events := readSinkFiles(t, path); len(events) != 3 {
+		t.Fatalf("expected the 3 events in the file sink, got %d", len(events))
+	}
+	frames := 0
+	for {
+		if _, err := ReadRawEvent(&raw); err != nil {
+			break
+		}
+		frames++
+	}
+	// the registration ack and the 3 blocks
+	if frames != 4 {
+		t.Fatalf("expected the 4 received messages to be recorded, got %d", frames)
+	}
+}
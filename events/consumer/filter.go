@@ -0,0 +1,143 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"regexp"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//Filter reports whether an event received from the event hub should be
+//delivered to the adapter
+type Filter func(*ehpb.Event) bool
+
+//FilterChain combines filters, matching an event when all of them (AllOf)
+//or any of them (AnyOf) match. A chain can itself be used as a Filter through
+//its Match method, so chains can be nested
+type FilterChain struct {
+	filters []Filter
+	any     bool
+}
+
+//AllOf returns a chain matching events accepted by every filter. An empty
+//chain matches every event
+func AllOf(filters ...Filter) *FilterChain {
+	return &FilterChain{filters: filters}
+}
+
+//AnyOf returns a chain matching events accepted by at least one filter. An
+//empty chain matches no event
+func AnyOf(filters ...Filter) *FilterChain {
+	return &FilterChain{filters: filters, any: true}
+}
+
+//Match reports whether e satisfies the chain
+func (fc *FilterChain) Match(e *ehpb.Event) bool {
+	for _, f := range fc.filters {
+		if f(e) == fc.any {
+			return fc.any
+		}
+	}
+	return !fc.any
+}
+
+//EventTypeFilter matches events of the given types
+func EventTypeFilter(types ...ehpb.EventType) Filter {
+	return func(e *ehpb.Event) bool {
+		et, ok := getEventType(e)
+		if !ok {
+			return false
+		}
+		for _, t := range types {
+			if t == et {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+//ChaincodeIDFilter matches chaincode events emitted by one of the given
+//chaincodes
+func ChaincodeIDFilter(ids ...string) Filter {
+	return func(e *ehpb.Event) bool {
+		cc := e.GetChaincodeEvent()
+		if cc == nil {
+			return false
+		}
+		for _, id := range ids {
+			if id == cc.ChaincodeID {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+//EventNameFilter matches chaincode events whose name matches re
+func EventNameFilter(re *regexp.Regexp) Filter {
+	return func(e *ehpb.Event) bool {
+		cc := e.GetChaincodeEvent()
+		return cc != nil && re.MatchString(cc.EventName)
+	}
+}
+
+//TransactionTypeFilter matches the rejection events of a transaction of one
+//of the given types, and the blocks holding at least one such transaction
+func TransactionTypeFilter(types ...ehpb.Transaction_Type) Filter {
+	matches := func(tx *ehpb.Transaction) bool {
+		if tx == nil {
+			return false
+		}
+		for _, t := range types {
+			if t == tx.Type {
+				return true
+			}
+		}
+		return false
+	}
+	return func(e *ehpb.Event) bool {
+		if rejection := e.GetRejection(); rejection != nil {
+			return matches(rejection.Tx)
+		}
+		if block := e.GetBlock(); block != nil {
+			for _, tx := range block.Transactions {
+				if matches(tx) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+//getEventType returns the EventType corresponding to the payload of e
+func getEventType(e *ehpb.Event) (ehpb.EventType, bool) {
+	switch e.Event.(type) {
+	case *ehpb.Event_Register:
+		return ehpb.EventType_REGISTER, true
+	case *ehpb.Event_Block:
+		return ehpb.EventType_BLOCK, true
+	case *ehpb.Event_ChaincodeEvent:
+		return ehpb.EventType_CHAINCODE, true
+	case *ehpb.Event_Rejection:
+		return ehpb.EventType_REJECTION, true
+	default:
+		return -1, false
+	}
+}
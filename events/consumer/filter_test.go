@@ -0,0 +1,244 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func chaincodeEvent(id, name string) *ehpb.Event {
+	return &ehpb.Event{Event: &ehpb.Event_ChaincodeEvent{ChaincodeEvent: &ehpb.ChaincodeEvent{ChaincodeID: id, EventName: name}}}
+}
+
+func rejectionEvent() *ehpb.Event {
+	return &ehpb.Event{Event: &ehpb.Event_Rejection{Rejection: &ehpb.Rejection{ErrorMsg: "rejected"}}}
+}
+
+func TestFilterChain(t *testing.T) {
+	orders := AllOf(
+		EventTypeFilter(ehpb.EventType_CHAINCODE),
+		ChaincodeIDFilter("mycc"),
+		EventNameFilter(regexp.MustCompile("^order")),
+	)
+	chain := AnyOf(orders.Match, EventTypeFilter(ehpb.EventType_REJECTION))
+
+	tests := []struct {
+		event *ehpb.Event
+		match bool
+	}{
+		{chaincodeEvent("mycc", "orderPlaced"), true},
+		{chaincodeEvent("mycc", "invoice"), false},
+		{chaincodeEvent("othercc", "orderPlaced"), false},
+		{rejectionEvent(), true},
+		{blockEvent(), false},
+		{&ehpb.Event{}, false},
+	}
+	for i, test := range tests {
+		if got := chain.Match(test.event); got != test.match {
+			t.Errorf("test %d: expected %t, got %t", i, test.match, got)
+		}
+	}
+
+	if !AllOf().Match(blockEvent()) || AnyOf().Match(blockEvent()) {
+		t.Errorf("unexpected result for empty chains")
+	}
+}
+
+func TestClientFilter(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for _, e := range []*ehpb.Event{chaincodeEvent("othercc", "evt"), blockEvent(), chaincodeEvent("mycc", "evt")} {
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithFilter(AnyOf(ChaincodeIDFilter("mycc"), EventTypeFilter(ehpb.EventType_BLOCK)).Match))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+
+	if e := adapter.waitEvent(t); e.GetBlock() == nil {
+		t.Fatalf("expected the block event first, got %v", e)
+	}
+	if e := adapter.waitEvent(t); e.GetChaincodeEvent() == nil || e.GetChaincodeEvent().ChaincodeID != "mycc" {
+		t.Fatalf("expected the mycc event, got %v", e)
+	}
+}
+
+func TestCoalesceDuplicates(t *testing.T) {
+	sent := []*ehpb.Event{chaincodeEvent("mycc", "a"), chaincodeEvent("mycc", "a"), chaincodeEvent("mycc", "b"), chaincodeEvent("mycc", "a"), chaincodeEvent("mycc", "a")}
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for _, e := range sent {
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	metrics := newFakeMetrics()
+	client := NewEventsClient(addr, adapter, WithCoalesceDuplicates(), WithMetrics(metrics))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+
+	for _, name := range []string{"a", "b", "a"} {
+		if e := adapter.waitEvent(t); e.GetChaincodeEvent().EventName != name {
+			t.Fatalf("expected event %s, got %v", name, e)
+		}
+	}
+	waitFor(t, "the duplicates to be counted", func() bool { return client.Stats().CoalescedEvents == 2 })
+	if n := metrics.counter(MetricCoalescedEvents); n != 2 {
+		t.Fatalf("expected 2 coalesced events in the metrics, got %v", n)
+	}
+	select {
+	case e := <-adapter.events:
+		t.Fatalf("unexpected event %v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestEmptyEvents(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for _, e := range []*ehpb.Event{{}, blockEvent()} {
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	metrics := newFakeMetrics()
+	client := NewEventsClient(addr, adapter, WithMetrics(metrics))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	if e := adapter.waitEvent(t); e.GetBlock() == nil {
+		t.Fatalf("expected the empty event to be dropped, got %v", e)
+	}
+	if n := client.Stats().EmptyEvents; n != 1 {
+		t.Fatalf("expected 1 empty event in the stats, got %d", n)
+	}
+	if n := metrics.counter(MetricEmptyEvents); n != 1 {
+		t.Fatalf("expected 1 empty event in the metrics, got %v", n)
+	}
+	client.Stop()
+
+	adapter = newRecordingAdapter()
+	client = NewEventsClient(addr, adapter, WithPassEmptyEvents())
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	if e := adapter.waitEvent(t); e.Event != nil {
+		t.Fatalf("expected the empty event to be delivered, got %v", e)
+	}
+	if n := client.Stats().EmptyEvents; n != 0 {
+		t.Fatalf("expected no empty event dropped, got %d", n)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		secret := chaincodeEvent("mycc", "payment")
+		secret.GetChaincodeEvent().Payload = []byte("card number")
+		for _, e := range []*ehpb.Event{rejectionEvent(), secret, blockEvent()} {
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+	redact := func(msg *ehpb.Event) (*ehpb.Event, error) {
+		if msg.GetRejection() != nil {
+			return nil, fmt.Errorf("rejections are not expected")
+		}
+		if cc := msg.GetChaincodeEvent(); cc != nil {
+			cc.Payload = nil
+		}
+		if msg.GetBlock() != nil {
+			return nil, nil
+		}
+		return msg, nil
+	}
+
+	adapter := newRecordingAdapter()
+	metrics := newFakeMetrics()
+	client := NewEventsClient(addr, adapter, WithTransform(redact, TransformDropEvent), WithMetrics(metrics))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	cc := adapter.waitEvent(t).GetChaincodeEvent()
+	if cc == nil || cc.EventName != "payment" || cc.Payload != nil {
+		t.Fatalf("expected the redacted chaincode event first, got %v", cc)
+	}
+	waitFor(t, "the block to be received", func() bool { return client.ReceivedEvents()[ehpb.EventType_BLOCK] == 1 })
+	select {
+	case e := <-adapter.events:
+		t.Fatalf("expected the block to be skipped, got %v", e)
+	default:
+	}
+	if n := client.Stats().TransformErrors; n != 1 {
+		t.Fatalf("expected 1 transform error in the stats, got %d", n)
+	}
+	if n := metrics.counter(MetricTransformErrors); n != 1 {
+		t.Fatalf("expected 1 transform error in the metrics, got %v", n)
+	}
+	client.Stop()
+
+	adapter = newRecordingAdapter()
+	client = NewEventsClient(addr, adapter, WithTransform(redact, TransformStopClient))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	if err := adapter.waitDisconnected(t); err == nil {
+		t.Fatalf("expected the transform error to terminate the client")
+	}
+	if n := len(adapter.events); n != 0 {
+		t.Fatalf("expected no event delivered, got %d", n)
+	}
+}
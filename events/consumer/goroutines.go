@@ -0,0 +1,60 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+//goroutineGroup tracks the goroutines a client spawns
+type goroutineGroup struct {
+	wg     sync.WaitGroup
+	active int64
+}
+
+//spawn runs f in a tracked goroutine
+func (g *goroutineGroup) spawn(f func()) {
+	atomic.AddInt64(&g.active, 1)
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer atomic.AddInt64(&g.active, -1)
+		f()
+	}()
+}
+
+func (g *goroutineGroup) count() int {
+	return int(atomic.LoadInt64(&g.active))
+}
+
+//ActiveGoroutines returns the number of goroutines the client is running:
+//its receive loop, its dispatcher, the watchers, the sends and the
+//registrations in progress, the tap and the forwarding of subscriptions.
+//The goroutines of the adapters, like the workers of an AsyncEventAdapter,
+//and those of grpc are not counted. It drops to zero once the client has
+//terminated and its subscriptions are consumed, see WaitGoroutines
+func (ec *EventsClient) ActiveGoroutines() int {
+	return ec.routines.count()
+}
+
+//WaitGoroutines blocks until every goroutine of the client has exited, once
+//it is stopped, e.g. to check for a clean teardown. The events channel of a
+//subscription must be read until it is closed for its goroutine to exit
+func (ec *EventsClient) WaitGoroutines() {
+	ec.routines.wg.Wait()
+}
@@ -0,0 +1,106 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestGoroutinesExit(t *testing.T) {
+	// the client is stopped with StopAndDrain or Stop, or terminates on
+	// its own when the event hub closes its stream
+	tests := []struct {
+		name string
+		// the event hub closes the stream once end is closed
+		endsStream bool
+		stop       func(t *testing.T, client *EventsClient, end chan struct{})
+	}{
+		{"StopAndDrain", false, func(t *testing.T, client *EventsClient, end chan struct{}) {
+			if _, err := client.StopAndDrain(); err != nil {
+				t.Fatalf("StopAndDrain failed: %s", err)
+			}
+		}},
+		{"Stop", false, func(t *testing.T, client *EventsClient, end chan struct{}) {
+			if err := client.Stop(); err != nil {
+				t.Fatalf("Stop failed: %s", err)
+			}
+			if err := client.Wait(); err != nil {
+				t.Fatalf("expected no terminal error after Stop, got %s", err)
+			}
+		}},
+		{"Wait", true, func(t *testing.T, client *EventsClient, end chan struct{}) {
+			client.SetReconnectEnabled(false)
+			close(end)
+			if err := client.Wait(); err != ErrServerClosed {
+				t.Fatalf("expected the client to terminate with %s, got %v", ErrServerClosed, err)
+			}
+		}},
+	}
+	for _, test := range tests {
+		baseline := runtime.NumGoroutine()
+		end := make(chan struct{})
+		endsStream := test.endsStream
+		addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+			if _, err := ackRegister(stream); err != nil {
+				return err
+			}
+			if err := stream.Send(blockEvent()); err != nil {
+				return err
+			}
+			if n == 1 {
+				// the client reconnects
+				return nil
+			}
+			if endsStream {
+				<-end
+				return nil
+			}
+			return waitForEOF(stream)
+		})
+
+		adapter := newRecordingAdapter()
+		client := NewEventsClient(addr, adapter, WithBufferSize(10), WithReconnectOnServerClose())
+		client.SetTap(newRecordingAdapter())
+		if err := client.Start(); err != nil {
+			t.Fatalf("%s: could not start client: %s", test.name, err)
+		}
+		adapter.waitEvent(t)
+		adapter.waitEvent(t)
+		// the receive loop, the dispatcher and the tap
+		if n := client.ActiveGoroutines(); n < 3 {
+			t.Fatalf("%s: expected at least 3 goroutines, got %d", test.name, n)
+		}
+		test.stop(t, client, end)
+		client.WaitGoroutines()
+		if n := client.ActiveGoroutines(); n != 0 {
+			t.Fatalf("%s: expected no goroutine left, got %d", test.name, n)
+		}
+		stop()
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := runtime.NumGoroutine(); n > baseline {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%s: expected %d goroutines once stopped, got %d:\n%s", test.name, baseline, n, buf[:runtime.Stack(buf, true)])
+		}
+	}
+}
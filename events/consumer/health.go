@@ -0,0 +1,107 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+
+	"github.com/op/go-logging"
+	"google.golang.org/grpc"
+)
+
+//Healthy returns nil when the client is connected to the event hub with its
+//events registered and, with WithStalenessThreshold, has received an event
+//recently enough, and an error describing the first condition not met
+//otherwise. It is meant for the readiness or liveness probes of an
+//orchestrator
+func (ec *EventsClient) Healthy() error {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if ec.stopped {
+		return fmt.Errorf("events client %s is stopped", ec.name)
+	}
+	if !ec.started {
+		return fmt.Errorf("events client %s is not started", ec.name)
+	}
+	select {
+	case <-ec.done:
+		if ec.err != nil {
+			return fmt.Errorf("events client %s terminated: %s", ec.name, ec.err)
+		}
+		return fmt.Errorf("events client %s terminated", ec.name)
+	default:
+	}
+	if ec.ownStream == nil {
+		if ec.conn == nil {
+			return fmt.Errorf("events client %s is not connected", ec.name)
+		}
+		if state := ec.conn.State(); state != grpc.Ready {
+			if ec.stats.lastErr != nil {
+				return fmt.Errorf("connection of events client %s to %s is %s, last error: %s", ec.name, ec.peerAddress, state, ec.stats.lastErr)
+			}
+			return fmt.Errorf("connection of events client %s to %s is %s", ec.name, ec.peerAddress, state)
+		}
+	}
+	if ec.staleness > 0 {
+		since := ec.stats.lastEvent
+		if since.IsZero() {
+			since = ec.stats.registeredAt
+		}
+		stale := ec.clock.Now().Sub(since)
+		if !since.IsZero() && stale > ec.staleness {
+			if ec.stats.lastEvent.IsZero() {
+				return fmt.Errorf("events client %s has received no event since it registered %s ago", ec.name, stale)
+			}
+			return fmt.Errorf("events client %s has received no event for %s", ec.name, stale)
+		}
+	}
+	return nil
+}
+
+//waitDownstream blocks while the check set with WithDownstreamHealthCheck
+//reports the downstream unhealthy. It returns false if the client was
+//stopped meanwhile
+func (ec *EventsClient) waitDownstream() bool {
+	if ec.downstreamCheck == nil || ec.downstreamCheck() {
+		return true
+	}
+	ec.setPausedForHealth(true)
+	defer ec.setPausedForHealth(false)
+	ec.logf(logging.WARNING, "downstream is unhealthy, pausing the delivery of events")
+	for {
+		select {
+		case <-ec.clock.After(ec.downstreamInterval):
+		case <-ec.stopChan:
+			return false
+		case <-ec.ctx.Done():
+			return false
+		}
+		if ec.downstreamCheck() {
+			ec.logf(logging.INFO, "downstream is healthy again, resuming the delivery of events")
+			return true
+		}
+	}
+}
+
+func (ec *EventsClient) setPausedForHealth(paused bool) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.stats.pausedForHealth = paused
+	if paused {
+		ec.stats.healthPauses++
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func expectUnhealthy(t *testing.T, client *EventsClient, reason string) {
+	waitFor(t, "client to report "+reason, func() bool {
+		err := client.Healthy()
+		return err != nil && strings.Contains(err.Error(), reason)
+	})
+}
+
+func TestHealthy(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithStalenessThreshold(50*time.Millisecond))
+	expectUnhealthy(t, client, "not started")
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	adapter.waitEvent(t)
+	if err := client.Healthy(); err != nil {
+		t.Fatalf("expected a healthy client, got %s", err)
+	}
+	expectUnhealthy(t, client, "received no event for")
+	client.Stop()
+	expectUnhealthy(t, client, "stopped")
+}
+
+func TestHealthyTerminated(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		_, err := ackRegister(stream)
+		return err
+	})
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter())
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	client.Wait()
+	expectUnhealthy(t, client, "terminated: "+ErrServerClosed.Error())
+}
+
+func TestHealthyDisconnected(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(0))
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter())
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	if err := client.Healthy(); err != nil {
+		t.Fatalf("expected a healthy client, got %s", err)
+	}
+	// a connection that cannot be established replaces the current one, as
+	// while reconnecting to an unavailable peer
+	unreachable, err := grpc.Dial("127.0.0.1:1", grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not dial: %s", err)
+	}
+	defer unreachable.Close()
+	client.mutex.Lock()
+	conn := client.conn
+	client.conn = unreachable
+	client.mutex.Unlock()
+	expectUnhealthy(t, client, "connection of events client")
+	client.mutex.Lock()
+	client.conn = conn
+	client.mutex.Unlock()
+}
+
+func TestHealthyNoEventSinceRegistration(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(0))
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter(), WithStalenessThreshold(20*time.Millisecond))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	expectUnhealthy(t, client, "no event since it registered")
+}
+
+func TestDownstreamHealthCheck(t *testing.T) {
+	for _, buffered := range []bool{false, true} {
+		addr, _, stop := startFakeServer(t, sendBlocks(3))
+		var healthy int32
+		opts := []Option{WithDownstreamHealthCheck(func() bool { return atomic.LoadInt32(&healthy) == 1 }, 10*time.Millisecond)}
+		if buffered {
+			opts = append(opts, WithBufferSize(10))
+		}
+		adapter := newRecordingAdapter()
+		client := NewEventsClient(addr, adapter, opts...)
+		if err := client.Start(); err != nil {
+			t.Fatalf("could not start client: %s", err)
+		}
+		waitFor(t, "delivery to pause", func() bool { return client.Stats().PausedForHealth })
+		if buffered {
+			waitFor(t, "events to be buffered", func() bool { return client.QueueDepth() == 3 })
+		}
+		time.Sleep(50 * time.Millisecond)
+		if n := len(adapter.events); n != 0 {
+			t.Fatalf("expected no event while the downstream is unhealthy, got %d (buffered %t)", n, buffered)
+		}
+		atomic.StoreInt32(&healthy, 1)
+		for i := 0; i < 3; i++ {
+			adapter.waitEvent(t)
+		}
+		stats := client.Stats()
+		if stats.PausedForHealth || stats.HealthPauses != 1 {
+			t.Fatalf("expected one pause over, got paused %t and %d pauses (buffered %t)", stats.PausedForHealth, stats.HealthPauses, buffered)
+		}
+		client.Stop()
+		adapter.waitDisconnected(t)
+		stop()
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"sort"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//BlockInterest returns the interest in the blocks committed by the peer
+func BlockInterest() *ehpb.Interest {
+	return &ehpb.Interest{EventType: ehpb.EventType_BLOCK}
+}
+
+//RejectionInterest returns the interest in the transactions rejected by the
+//peer
+func RejectionInterest() *ehpb.Interest {
+	return &ehpb.Interest{EventType: ehpb.EventType_REJECTION}
+}
+
+//ChaincodeInterest returns the interest in the events named eventName set by
+//the chaincode chaincodeID, or in all its events if eventName is empty. The
+//event hub has no wildcard for the chaincode ID, which must not be empty
+func ChaincodeInterest(chaincodeID, eventName string) *ehpb.Interest {
+	return &ehpb.Interest{
+		EventType: ehpb.EventType_CHAINCODE,
+		RegInfo: &ehpb.Interest_ChaincodeRegInfo{
+			ChaincodeRegInfo: &ehpb.ChaincodeReg{ChaincodeID: chaincodeID, EventName: eventName},
+		},
+	}
+}
+
+//AllEventsInterest returns the interests in blocks, rejections and all the
+//events of each of the chaincodes chaincodeIDs, ready to be returned by
+//EventAdapter.GetInterestedEvents. Events of chaincodes that are not listed
+//are not covered
+func AllEventsInterest(chaincodeIDs ...string) []*ehpb.Interest {
+	interests := []*ehpb.Interest{BlockInterest(), RejectionInterest()}
+	for _, id := range chaincodeIDs {
+		interests = append(interests, ChaincodeInterest(id, ""))
+	}
+	return interests
+}
+
+//TransactionEventInterest returns what subscribing to the transactions of
+//type txType takes. The interests of the event hub cannot be narrowed by
+//transaction type, so it returns the interests in the events carrying
+//transactions, blocks and rejections, and a TransactionTypeFilter to set
+//with WithFilter. Unlike a server-side filter, the peer still sends every
+//block and rejection, which mostly matters for bandwidth on busy networks
+func TransactionEventInterest(txType ehpb.Transaction_Type) ([]*ehpb.Interest, Filter) {
+	return []*ehpb.Interest{BlockInterest(), RejectionInterest()}, TransactionTypeFilter(txType)
+}
+
+//InterestProvider returns the interested events to register, see
+//WithInterestProvider
+type InterestProvider func() ([]*ehpb.Interest, error)
+
+//interestedEvents returns the interested events to register: those of the
+//interest provider when one is set, else those of the adapter
+func (ec *EventsClient) interestedEvents() ([]*ehpb.Interest, error) {
+	if ec.interestProvider != nil {
+		return ec.interestProvider()
+	}
+	return ec.getAdapter().GetInterestedEvents()
+}
+
+//SubscribedTypes returns the distinct event types of the interests the event
+//hub last acknowledged, in ascending order. It follows the registrations:
+//the interests of the adapter are registered again on every new stream, so
+//that the types added or removed by the adapter show once it re-registers.
+//It is empty before the first registration
+func (ec *EventsClient) SubscribedTypes() []ehpb.EventType {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	types := make([]ehpb.EventType, 0, len(ec.subscribed))
+	for et := range ec.subscribed {
+		types = append(types, et)
+	}
+	sort.Sort(eventTypes(types))
+	return types
+}
+
+//setRegistered records the interests ies as the registered ones
+func (ec *EventsClient) setRegistered(ies []*ehpb.Interest) {
+	subscribed := make(map[ehpb.EventType]bool, len(ies))
+	for _, ie := range ies {
+		subscribed[ie.EventType] = true
+	}
+	ec.mutex.Lock()
+	ec.subscribed = subscribed
+	ec.mutex.Unlock()
+}
+
+//interestKey holds the fields identifying an interest for the event hub
+type interestKey struct {
+	eventType   ehpb.EventType
+	chaincodeID string
+	eventName   string
+}
+
+func keyOf(ie *ehpb.Interest) interestKey {
+	key := interestKey{eventType: ie.EventType}
+	if reg := ie.GetChaincodeRegInfo(); reg != nil {
+		key.chaincodeID, key.eventName = reg.ChaincodeID, reg.EventName
+	}
+	return key
+}
+
+//DefaultInterestOrder orders interests by event type, then by chaincode ID
+//and event name for the chaincode events. It is the order of
+//WithInterestOrder with a nil comparator
+func DefaultInterestOrder(a, b *ehpb.Interest) bool {
+	ka, kb := keyOf(a), keyOf(b)
+	if ka.eventType != kb.eventType {
+		return ka.eventType < kb.eventType
+	}
+	if ka.chaincodeID != kb.chaincodeID {
+		return ka.chaincodeID < kb.chaincodeID
+	}
+	return ka.eventName < kb.eventName
+}
+
+//sortInterests returns a copy of ies sorted by less, keeping the order of
+//the interests less does not tell apart
+func sortInterests(ies []*ehpb.Interest, less func(a, b *ehpb.Interest) bool) []*ehpb.Interest {
+	sorted := append([]*ehpb.Interest(nil), ies...)
+	sort.Stable(interestsBy{ies: sorted, less: less})
+	return sorted
+}
+
+//interestsBy sorts interests by less
+type interestsBy struct {
+	ies  []*ehpb.Interest
+	less func(a, b *ehpb.Interest) bool
+}
+
+func (s interestsBy) Len() int           { return len(s.ies) }
+func (s interestsBy) Less(i, j int) bool { return s.less(s.ies[i], s.ies[j]) }
+func (s interestsBy) Swap(i, j int)      { s.ies[i], s.ies[j] = s.ies[j], s.ies[i] }
+
+//dedupInterests returns ies without the interests equal to a previous one,
+//and the number of interests removed
+func dedupInterests(ies []*ehpb.Interest) ([]*ehpb.Interest, int) {
+	seen := make(map[interestKey]bool, len(ies))
+	unique := make([]*ehpb.Interest, 0, len(ies))
+	for _, ie := range ies {
+		key := keyOf(ie)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, ie)
+	}
+	return unique, len(ies) - len(unique)
+}
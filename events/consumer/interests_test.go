@@ -0,0 +1,249 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestAllEventsInterest(t *testing.T) {
+	expected := []*ehpb.Interest{
+		{EventType: ehpb.EventType_BLOCK},
+		{EventType: ehpb.EventType_REJECTION},
+		{EventType: ehpb.EventType_CHAINCODE, RegInfo: &ehpb.Interest_ChaincodeRegInfo{ChaincodeRegInfo: &ehpb.ChaincodeReg{ChaincodeID: "mycc"}}},
+		{EventType: ehpb.EventType_CHAINCODE, RegInfo: &ehpb.Interest_ChaincodeRegInfo{ChaincodeRegInfo: &ehpb.ChaincodeReg{ChaincodeID: "othercc"}}},
+	}
+	if interests := AllEventsInterest("mycc", "othercc"); !reflect.DeepEqual(interests, expected) {
+		t.Fatalf("unexpected interests %v", interests)
+	}
+	if interests := AllEventsInterest(); !reflect.DeepEqual(interests, expected[:2]) {
+		t.Fatalf("unexpected interests without chaincodes %v", interests)
+	}
+}
+
+func TestChaincodeInterest(t *testing.T) {
+	reg := ChaincodeInterest("mycc", "transfer").GetChaincodeRegInfo()
+	if reg.ChaincodeID != "mycc" || reg.EventName != "transfer" {
+		t.Fatalf("unexpected chaincode registration %v", reg)
+	}
+}
+
+//interestsAdapter registers a fixed list of interests
+type interestsAdapter struct {
+	*recordingAdapter
+	interests []*ehpb.Interest
+}
+
+func (a *interestsAdapter) GetInterestedEvents() ([]*ehpb.Interest, error) {
+	return a.interests, nil
+}
+
+func TestDuplicateInterests(t *testing.T) {
+	registered := make(chan *ehpb.Register, 1)
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		reg, err := ackRegister(stream)
+		if err != nil {
+			return err
+		}
+		registered <- reg
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	interests := []*ehpb.Interest{
+		BlockInterest(), ChaincodeInterest("mycc", "transfer"), BlockInterest(),
+		ChaincodeInterest("mycc", ""), ChaincodeInterest("mycc", "transfer"),
+	}
+	adapter := &interestsAdapter{newRecordingAdapter(), interests}
+
+	client := NewEventsClient(addr, adapter, WithRejectDuplicateInterests())
+	if err := client.Start(); err == nil {
+		client.Stop()
+		t.Fatalf("expected the duplicate interests to be rejected")
+	}
+
+	client = NewEventsClient(addr, adapter)
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	reg := <-registered
+	expected := []*ehpb.Interest{interests[0], interests[1], interests[3]}
+	if !reflect.DeepEqual(reg.Events, expected) {
+		t.Fatalf("expected the duplicates to be removed, got %v", reg.Events)
+	}
+}
+
+func TestTransactionEventInterest(t *testing.T) {
+	interests, filter := TransactionEventInterest(ehpb.Transaction_CHAINCODE_INVOKE)
+	if len(interests) != 2 || !proto.Equal(interests[0], BlockInterest()) || !proto.Equal(interests[1], RejectionInterest()) {
+		t.Fatalf("expected the block and rejection interests, got %v", interests)
+	}
+	invoke := &ehpb.Transaction{Type: ehpb.Transaction_CHAINCODE_INVOKE}
+	deploy := &ehpb.Transaction{Type: ehpb.Transaction_CHAINCODE_DEPLOY}
+	block := func(txs ...*ehpb.Transaction) *ehpb.Event {
+		return &ehpb.Event{Event: &ehpb.Event_Block{Block: &ehpb.Block{Transactions: txs}}}
+	}
+	rejection := func(tx *ehpb.Transaction) *ehpb.Event {
+		return &ehpb.Event{Event: &ehpb.Event_Rejection{Rejection: &ehpb.Rejection{Tx: tx}}}
+	}
+	tests := []struct {
+		event *ehpb.Event
+		match bool
+	}{
+		{block(deploy, invoke), true},
+		{block(deploy), false},
+		{block(), false},
+		{rejection(invoke), true},
+		{rejection(deploy), false},
+		{rejection(nil), false},
+		{chaincodeEvent("mycc", "evt"), false},
+	}
+	for i, test := range tests {
+		if filter(test.event) != test.match {
+			t.Errorf("event %d: expected match %t", i, test.match)
+		}
+	}
+}
+
+func TestInterestOrder(t *testing.T) {
+	interests := []*ehpb.Interest{ChaincodeInterest("mycc", "b"), RejectionInterest(), ChaincodeInterest("mycc", "a"), BlockInterest(), ChaincodeInterest("a", "z")}
+	byType := func(a, b *ehpb.Interest) bool { return a.EventType > b.EventType }
+	tests := []struct {
+		less     func(a, b *ehpb.Interest) bool
+		expected []*ehpb.Interest
+	}{
+		{nil, []*ehpb.Interest{BlockInterest(), ChaincodeInterest("a", "z"), ChaincodeInterest("mycc", "a"), ChaincodeInterest("mycc", "b"), RejectionInterest()}},
+		// a stable sort keeps the order of the chaincode interests
+		{byType, []*ehpb.Interest{RejectionInterest(), ChaincodeInterest("mycc", "b"), ChaincodeInterest("mycc", "a"), ChaincodeInterest("a", "z"), BlockInterest()}},
+	}
+	for i, test := range tests {
+		registered := make(chan *ehpb.Register, 1)
+		addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+			reg, err := ackRegister(stream)
+			if err != nil {
+				return err
+			}
+			registered <- reg
+			return waitForEOF(stream)
+		})
+		adapter := &interestsAdapter{newRecordingAdapter(), interests}
+		client := NewEventsClient(addr, adapter, WithInterestOrder(test.less))
+		if err := client.Start(); err != nil {
+			t.Fatalf("test %d: could not start client: %s", i, err)
+		}
+		if reg := <-registered; !reflect.DeepEqual(reg.Events, test.expected) {
+			t.Errorf("test %d: expected the interests %v, got %v", i, test.expected, reg.Events)
+		}
+		client.Stop()
+		stop()
+	}
+	if interests[0].EventType != ehpb.EventType_CHAINCODE {
+		t.Fatalf("the interests of the adapter were reordered")
+	}
+}
+
+//switchingInterestsAdapter registers blocks and chaincode events first,
+//then rejections only
+type switchingInterestsAdapter struct {
+	*recordingAdapter
+	calls int32
+}
+
+func (a *switchingInterestsAdapter) GetInterestedEvents() ([]*ehpb.Interest, error) {
+	if atomic.AddInt32(&a.calls, 1) == 1 {
+		return []*ehpb.Interest{BlockInterest(), ChaincodeInterest("mycc", ""), ChaincodeInterest("othercc", "")}, nil
+	}
+	return []*ehpb.Interest{RejectionInterest()}, nil
+}
+
+func TestSubscribedTypes(t *testing.T) {
+	reconnect := make(chan struct{})
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		if err := stream.Send(blockEvent()); err != nil {
+			return err
+		}
+		if n == 1 {
+			<-reconnect
+			return nil
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	adapter := &switchingInterestsAdapter{recordingAdapter: newRecordingAdapter()}
+	client := NewEventsClient(addr, adapter, WithReconnectOnServerClose())
+	if types := client.SubscribedTypes(); len(types) != 0 {
+		t.Fatalf("expected no type before the registration, got %v", types)
+	}
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	if types := client.SubscribedTypes(); !reflect.DeepEqual(types, []ehpb.EventType{ehpb.EventType_BLOCK, ehpb.EventType_CHAINCODE}) {
+		t.Fatalf("expected the block and chaincode types, got %v", types)
+	}
+	close(reconnect)
+	adapter.waitEvent(t)
+	if types := client.SubscribedTypes(); !reflect.DeepEqual(types, []ehpb.EventType{ehpb.EventType_REJECTION}) {
+		t.Fatalf("expected the rejection type after re-registering, got %v", types)
+	}
+}
+
+func TestInterestProvider(t *testing.T) {
+	adapterInterests := []*ehpb.Interest{BlockInterest()}
+	providerInterests := []*ehpb.Interest{RejectionInterest(), ChaincodeInterest("mycc", "")}
+	provider := func() ([]*ehpb.Interest, error) { return providerInterests, nil }
+	tests := []struct {
+		opts     []Option
+		expected []*ehpb.Interest
+	}{
+		{nil, adapterInterests},
+		{[]Option{WithInterestProvider(provider)}, providerInterests},
+	}
+	for i, test := range tests {
+		registered := make(chan *ehpb.Register, 1)
+		addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+			reg, err := ackRegister(stream)
+			if err != nil {
+				return err
+			}
+			registered <- reg
+			return waitForEOF(stream)
+		})
+		adapter := &interestsAdapter{newRecordingAdapter(), adapterInterests}
+		client := NewEventsClient(addr, adapter, test.opts...)
+		if err := client.Start(); err != nil {
+			t.Fatalf("test %d: could not start client: %s", i, err)
+		}
+		if reg := <-registered; !reflect.DeepEqual(reg.Events, test.expected) {
+			t.Errorf("test %d: expected the interests %v, got %v", i, test.expected, reg.Events)
+		}
+		client.Stop()
+		stop()
+	}
+}
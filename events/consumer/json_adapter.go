@@ -0,0 +1,92 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/golang/protobuf/jsonpb"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//JSONWriter is an EventAdapter that writes every received event to an
+//io.Writer as newline-delimited JSON, using the protobuf field names. The
+//scalar fields are written even when they have their zero value, and the
+//unset messages are left out
+type JSONWriter struct {
+	mutex     sync.Mutex
+	w         io.Writer
+	interests []*ehpb.Interest
+
+	//EnumsAsInts writes the enum values, like the event types of the
+	//interests, as their numbers instead of their names
+	EnumsAsInts bool
+
+	//OnError, if set, is called when an event cannot be marshaled or written.
+	//When unset, marshal failures are written to the output as an error
+	//record ({"error": "..."}) and write failures are logged
+	OnError func(msg *ehpb.Event, err error)
+}
+
+//JSONWriterAdapter returns a JSONWriter registering the given interests and
+//writing the events it receives to w
+func JSONWriterAdapter(w io.Writer, interests []*ehpb.Interest) *JSONWriter {
+	return &JSONWriter{w: w, interests: interests}
+}
+
+//GetInterestedEvents implements EventAdapter
+func (j *JSONWriter) GetInterestedEvents() ([]*ehpb.Interest, error) {
+	return j.interests, nil
+}
+
+//Recv implements EventAdapter. Errors never stop the client; they are
+//reported through OnError or an error record instead
+func (j *JSONWriter) Recv(msg *ehpb.Event) (bool, error) {
+	var buf bytes.Buffer
+	marshaler := jsonpb.Marshaler{EnumsAsInts: j.EnumsAsInts}
+	if err := marshaler.Marshal(&buf, msg); err != nil {
+		if j.OnError != nil {
+			j.OnError(msg, err)
+			return true, nil
+		}
+		buf.Reset()
+		buf.WriteString(`{"error":` + strconv.Quote(err.Error()) + `}`)
+	}
+	buf.WriteByte('\n')
+
+	j.mutex.Lock()
+	_, err := j.w.Write(buf.Bytes())
+	j.mutex.Unlock()
+	if err != nil {
+		err = fmt.Errorf("error writing event: %s", err)
+		if j.OnError != nil {
+			j.OnError(msg, err)
+		} else {
+			consumerLogger.Error(err.Error())
+		}
+	}
+	return true, nil
+}
+
+//Disconnected implements EventAdapter
+func (j *JSONWriter) Disconnected(err error) {
+}
@@ -0,0 +1,96 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestJSONWriterAdapter(t *testing.T) {
+	var buf bytes.Buffer
+	adapter := JSONWriterAdapter(&buf, []*ehpb.Interest{{EventType: ehpb.EventType_BLOCK}})
+
+	events := []*ehpb.Event{
+		blockEvent(),
+		{Event: &ehpb.Event_ChaincodeEvent{ChaincodeEvent: &ehpb.ChaincodeEvent{ChaincodeID: "mycc", EventName: "evt"}}},
+	}
+	for _, e := range events {
+		if cont, err := adapter.Recv(e); !cont || err != nil {
+			t.Fatalf("unexpected Recv result %t, %v", cont, err)
+		}
+	}
+
+	scanner := bufio.NewScanner(&buf)
+	var lines int
+	for scanner.Scan() {
+		var got ehpb.Event
+		if err := jsonpb.UnmarshalString(scanner.Text(), &got); err != nil {
+			t.Fatalf("line %d is not a valid event: %s", lines, err)
+		}
+		lines++
+	}
+	if lines != len(events) {
+		t.Fatalf("expected %d lines, got %d", len(events), lines)
+	}
+}
+
+func TestJSONWriterAdapterWriteError(t *testing.T) {
+	adapter := JSONWriterAdapter(failingWriter{}, nil)
+	var reported error
+	adapter.OnError = func(msg *ehpb.Event, err error) {
+		reported = err
+	}
+	if cont, _ := adapter.Recv(blockEvent()); !cont {
+		t.Fatalf("write errors should not stop the client")
+	}
+	if reported == nil {
+		t.Fatalf("expected write error to be reported")
+	}
+}
+
+func TestJSONWriterEnumsAsInts(t *testing.T) {
+	register := &ehpb.Event{Event: &ehpb.Event_Register{Register: &ehpb.Register{Events: []*ehpb.Interest{{EventType: ehpb.EventType_REJECTION}}}}}
+	for _, test := range []struct {
+		enumsAsInts bool
+		expected    string
+	}{
+		{false, `"eventType":"REJECTION"`},
+		{true, `"eventType":3`},
+	} {
+		var buf bytes.Buffer
+		adapter := JSONWriterAdapter(&buf, nil)
+		adapter.EnumsAsInts = test.enumsAsInts
+		adapter.Recv(register)
+		if !strings.Contains(buf.String(), test.expected) {
+			t.Fatalf("expected %s with EnumsAsInts %t, got %s", test.expected, test.enumsAsInts, buf.String())
+		}
+	}
+}
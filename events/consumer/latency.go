@@ -0,0 +1,114 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"sort"
+	"time"
+)
+
+//defaultLatencyWindow is the number of registrations the latency summary
+//covers by default
+const defaultLatencyWindow = 100
+
+//LatencySummary describes the distribution of the registration round-trip
+//times, from sending a Register message to receiving its ack, over the last
+//registrations of the window set with WithRegistrationLatencyWindow. The
+//percentiles are those of the nearest rank; all are zero without samples
+type LatencySummary struct {
+	//Count is the number of registrations in the window
+	Count         int
+	P50, P95, P99 time.Duration
+	Max           time.Duration
+}
+
+//WithRegistrationLatencyWindow sets the number of registrations, the initial
+//one, those of the reconnections and of Reregister, summarized by
+//ClientStats.RegistrationLatency and MetricRegistrationLatencySeconds. A
+//degrading peer shows in the percentiles before it fails the registrations.
+//The window holds the last 100 registrations by default; StatsAndReset
+//empties it, and a window of 0 disables the summary
+func WithRegistrationLatencyWindow(n int) Option {
+	return func(ec *EventsClient) {
+		ec.latencyWindow = n
+	}
+}
+
+//latencyWindow holds the last samples, up to the window size, in a ring
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+//add records d in a window of size samples
+func (w *latencyWindow) add(d time.Duration, size int) {
+	if size <= 0 {
+		return
+	}
+	if len(w.samples) < size {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next%len(w.samples)] = d
+	w.next = (w.next + 1) % len(w.samples)
+}
+
+func (w *latencyWindow) summary() LatencySummary {
+	n := len(w.samples)
+	if n == 0 {
+		return LatencySummary{}
+	}
+	sorted := append([]time.Duration(nil), w.samples...)
+	sort.Sort(durations(sorted))
+	rank := func(p int) time.Duration {
+		// the smallest sample greater than or equal to p percent of them
+		return sorted[(p*n+99)/100-1]
+	}
+	return LatencySummary{Count: n, P50: rank(50), P95: rank(95), P99: rank(99), Max: sorted[n-1]}
+}
+
+//durations sorts durations in ascending order
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+//latencyObserved records the round-trip time of a registration in the
+//summary and reports its percentiles
+func (ec *EventsClient) latencyObserved(elapsed time.Duration) {
+	if ec.latencyWindow <= 0 {
+		return
+	}
+	ec.mutex.Lock()
+	ec.stats.latencies.add(elapsed, ec.latencyWindow)
+	summary := ec.stats.latencies.summary()
+	ec.mutex.Unlock()
+	if ec.metrics == nil {
+		return
+	}
+	for _, q := range []struct {
+		quantile string
+		value    time.Duration
+	}{{"0.5", summary.P50}, {"0.95", summary.P95}, {"0.99", summary.P99}} {
+		labels := map[string]string{MetricLabelQuantile: q.quantile}
+		for k, v := range ec.labels {
+			labels[k] = v
+		}
+		ec.metrics.SetGauge(MetricRegistrationLatencySeconds, labels, q.value.Seconds())
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestLatencySummary(t *testing.T) {
+	var w latencyWindow
+	if s := w.summary(); s != (LatencySummary{}) {
+		t.Fatalf("expected an empty summary, got %+v", s)
+	}
+	for i := 100; i > 0; i-- {
+		w.add(time.Duration(i)*time.Millisecond, 100)
+	}
+	expected := LatencySummary{Count: 100, P50: 50 * time.Millisecond, P95: 95 * time.Millisecond, P99: 99 * time.Millisecond, Max: 100 * time.Millisecond}
+	if s := w.summary(); s != expected {
+		t.Fatalf("expected %+v, got %+v", expected, s)
+	}
+
+	// the window keeps the last samples
+	w = latencyWindow{}
+	for i := 1; i <= 100; i++ {
+		w.add(time.Duration(i)*time.Millisecond, 10)
+	}
+	expected = LatencySummary{Count: 10, P50: 95 * time.Millisecond, P95: 100 * time.Millisecond, P99: 100 * time.Millisecond, Max: 100 * time.Millisecond}
+	if s := w.summary(); s != expected {
+		t.Fatalf("expected %+v, got %+v", expected, s)
+	}
+}
+
+func TestRegistrationLatency(t *testing.T) {
+	// the fifth registration is acknowledged slowly, the streams before it
+	// end to have the client reconnect
+	const slow = 200 * time.Millisecond
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		in, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if n == 5 {
+			time.Sleep(slow)
+		}
+		if err := stream.Send(in); err != nil {
+			return err
+		}
+		if n < 5 {
+			return nil
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	metrics := newFakeMetrics()
+	client := NewEventsClient(addr, newRecordingAdapter(), WithReconnectOnServerClose(), WithMetrics(metrics))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	waitFor(t, "the five registrations", func() bool { return client.Stats().RegistrationLatency.Count == 5 })
+	latency := client.StatsAndReset().RegistrationLatency
+	if latency.P50 >= slow/2 {
+		t.Fatalf("expected a fast median, got %s", latency.P50)
+	}
+	if latency.P95 < slow || latency.P99 < slow || latency.Max < slow {
+		t.Fatalf("expected the slow registration in the tail, got %+v", latency)
+	}
+	gauges := metrics.gauge(MetricRegistrationLatencySeconds)
+	if n := len(gauges); n != 15 {
+		t.Fatalf("expected 3 percentiles for each of 5 registrations, got %d values", n)
+	}
+	if p99 := gauges[14]; p99 < slow.Seconds() {
+		t.Fatalf("expected the last p99 to include the slow registration, got %v", p99)
+	}
+	if n := client.Stats().RegistrationLatency.Count; n != 0 {
+		t.Fatalf("expected StatsAndReset to empty the window, got %d samples", n)
+	}
+}
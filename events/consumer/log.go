@@ -0,0 +1,104 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//dumpEvent logs the text representation of the received event in at debug
+//level, if enabled with WithEventDump
+func (ec *EventsClient) dumpEvent(in *ehpb.Event) {
+	if !ec.dumpEvents || ec.logLevel < logging.DEBUG || !ec.logEnabled(logging.DEBUG) {
+		return
+	}
+	ec.logEventf(logging.DEBUG, in, "received message: %s", proto.CompactTextString(in))
+}
+
+//structuredLogger receives the messages of the client with their fields
+//instead of its go-logging logger, see WithSlogLogger
+type structuredLogger interface {
+	enabled(level logging.Level) bool
+	log(level logging.Level, msg string, fields logFields)
+}
+
+//logFields are the attributes of a message of the client: its name, the
+//peer and the ID of the current connection, and the type of the event the
+//message is about, all empty when unknown
+type logFields struct {
+	client       string
+	peer         string
+	connectionID string
+	eventType    string
+}
+
+//logEnabled tells whether the logger of the client logs messages at level
+func (ec *EventsClient) logEnabled(level logging.Level) bool {
+	if ec.structured != nil {
+		return ec.structured.enabled(level)
+	}
+	return ec.logger.IsEnabledFor(level)
+}
+
+//logf logs a message of the client at level, prefixed with the client name,
+//unless the level is more verbose than the one set with WithLogLevel.
+//Errors and critical messages are always logged
+func (ec *EventsClient) logf(level logging.Level, format string, args ...interface{}) {
+	ec.logEventf(level, nil, format, args...)
+}
+
+//logEventf is logf for a message about the event in
+func (ec *EventsClient) logEventf(level logging.Level, in *ehpb.Event, format string, args ...interface{}) {
+	if level > ec.logLevel && level > logging.ERROR {
+		return
+	}
+	if ec.structured != nil {
+		fields := logFields{client: ec.name, connectionID: ec.CurrentConnectionID()}
+		fields.peer, _ = ec.dialedPeer.Load().(string)
+		if in != nil {
+			if et, ok := getEventType(in); ok {
+				fields.eventType = et.String()
+			}
+		}
+		ec.structured.log(level, fmt.Sprintf(format, args...), fields)
+		return
+	}
+	prefix := ec.name
+	if id := ec.CurrentConnectionID(); id != "" {
+		prefix += " " + id
+	}
+	msg := fmt.Sprintf("[%s] ", prefix) + fmt.Sprintf(format, args...)
+	switch level {
+	case logging.CRITICAL:
+		ec.logger.Critical(msg)
+	case logging.ERROR:
+		ec.logger.Error(msg)
+	case logging.WARNING:
+		ec.logger.Warning(msg)
+	case logging.NOTICE:
+		ec.logger.Notice(msg)
+	case logging.INFO:
+		ec.logger.Info(msg)
+	default:
+		ec.logger.Debug(msg)
+	}
+}
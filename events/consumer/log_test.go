@@ -0,0 +1,104 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/op/go-logging"
+)
+
+//logged returns the messages recorded by backend containing s
+func logged(backend *logging.MemoryBackend, s string) []string {
+	var found []string
+	for n := backend.Head(); n != nil; n = n.Next() {
+		if msg := n.Record.Message(); strings.Contains(msg, s) {
+			found = append(found, msg)
+		}
+	}
+	return found
+}
+
+func TestLogLevel(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	for _, debug := range []bool{false, true} {
+		backend := logging.InitForTesting(logging.DEBUG)
+		adapter := newRecordingAdapter()
+		var opts []Option
+		if debug {
+			opts = append(opts, WithLogLevel(logging.DEBUG))
+		}
+		client := NewEventsClient(addr, adapter, opts...)
+		if err := client.Start(); err != nil {
+			t.Fatalf("could not start client: %s", err)
+		}
+		adapter.waitEvent(t)
+		client.Stop()
+		client.Wait()
+		found := logged(backend, "received BLOCK event")
+		if debug && len(found) != 1 {
+			t.Fatalf("expected the received event logged at debug level, got %v", found)
+		}
+		if !debug && len(found) != 0 {
+			t.Fatalf("expected the debug messages suppressed by default, got %v", found)
+		}
+	}
+
+	backend := logging.InitForTesting(logging.DEBUG)
+	client := NewEventsClient(addr, newRecordingAdapter(), WithName("quiet"), WithLogLevel(logging.WARNING))
+	client.logf(logging.INFO, "some info")
+	client.logf(logging.ERROR, "some error")
+	if found := logged(backend, "[quiet] some"); len(found) != 1 || found[0] != "[quiet] some error" {
+		t.Fatalf("expected only the error logged, got %v", found)
+	}
+}
+
+func TestEventDump(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(2))
+	defer stop()
+
+	for _, tc := range []struct {
+		dump  bool
+		level logging.Level
+		dumps int
+	}{
+		{false, logging.DEBUG, 0},
+		{true, logging.DEBUG, 2},
+		{true, logging.INFO, 0},
+	} {
+		backend := logging.InitForTesting(logging.DEBUG)
+		adapter := newRecordingAdapter()
+		opts := []Option{WithLogLevel(tc.level)}
+		if tc.dump {
+			opts = append(opts, WithEventDump())
+		}
+		client := NewEventsClient(addr, adapter, opts...)
+		if err := client.Start(); err != nil {
+			t.Fatalf("could not start client: %s", err)
+		}
+		adapter.waitEvent(t)
+		adapter.waitEvent(t)
+		client.Stop()
+		client.Wait()
+		if found := logged(backend, "received message: block:"); len(found) != tc.dumps {
+			t.Fatalf("expected %d dumps with dump %v at level %s, got %v", tc.dumps, tc.dump, tc.level, found)
+		}
+	}
+}
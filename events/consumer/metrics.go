@@ -0,0 +1,101 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+//Names of the metrics reported by EventsClient
+const (
+	MetricQueueDepth     = "eventhub_consumer_queue_depth"
+	MetricDroppedEvents  = "eventhub_consumer_dropped_events"
+	MetricReceivedEvents = "eventhub_consumer_received_events"
+	//MetricRegistrationSeconds is the time between sending the last Register
+	//message and receiving its ack
+	MetricRegistrationSeconds = "eventhub_consumer_registration_seconds"
+	//MetricRegistrationLatencySeconds is the summary of the registration
+	//round-trip times over the latency window, a gauge per percentile told
+	//by MetricLabelQuantile, see WithRegistrationLatencyWindow
+	MetricRegistrationLatencySeconds = "eventhub_consumer_registration_latency_seconds"
+	//MetricEventsPerSecond is the rate of received events over the rate
+	//window
+	MetricEventsPerSecond = "eventhub_consumer_events_per_second"
+	//MetricThrottles counts the streams ended by an overloaded event hub
+	MetricThrottles = "eventhub_consumer_throttles"
+	//MetricAdapterRecvSeconds is the histogram of the time spent delivering
+	//each event to the adapter, reported to HistogramMetrics only
+	MetricAdapterRecvSeconds = "eventhub_consumer_adapter_recv_seconds"
+	//MetricCoalescedEvents counts the events dropped as duplicates of the
+	//previous one, see WithCoalesceDuplicates
+	MetricCoalescedEvents = "eventhub_consumer_coalesced_events"
+	//MetricEmptyEvents counts the events received without payload and
+	//dropped, see WithPassEmptyEvents
+	MetricEmptyEvents = "eventhub_consumer_empty_events"
+	//MetricTapDroppedEvents counts the events the tap adapter lagged too far
+	//behind to receive, see SetTap
+	MetricTapDroppedEvents = "eventhub_consumer_tap_dropped_events"
+	//MetricTransformErrors counts the events dropped because the transform
+	//failed on them, see WithTransform
+	MetricTransformErrors = "eventhub_consumer_transform_errors"
+	//MetricRecvTimeouts counts the adapter calls abandoned at the timeout,
+	//see WithRecvTimeout
+	MetricRecvTimeouts = "eventhub_consumer_recv_timeouts"
+	//MetricBytesSent and MetricBytesReceived count the serialized size of the
+	//messages sent to and received from the event hub
+	MetricBytesSent     = "eventhub_consumer_bytes_sent"
+	MetricBytesReceived = "eventhub_consumer_bytes_received"
+	//MetricRegistrations counts the completed registrations by peer and
+	//result
+	MetricRegistrations = "eventhub_consumer_registrations"
+	//MetricSampledInEvents and MetricSampledOutEvents count the events kept
+	//and those dropped by the sampling, see WithSampleRate
+	MetricSampledInEvents  = "eventhub_consumer_sampled_in_events"
+	MetricSampledOutEvents = "eventhub_consumer_sampled_out_events"
+)
+
+//Labels attached to the metrics. MetricLabelClient carries the client's name
+//on every metric; MetricLabelEventType carries the EventType name of the
+//event counted by MetricReceivedEvents, or MetricLabelUnknownEventType for an
+//event without a payload. MetricLabelPeer and MetricLabelResult carry the
+//peer address and the result, MetricResultSuccess or MetricResultFailure, of
+//the registrations counted by MetricRegistrations. MetricLabelQuantile
+//carries the percentile, 0.5, 0.95 or 0.99, of MetricRegistrationLatencySeconds
+const (
+	MetricLabelClient           = "client"
+	MetricLabelEventType        = "event_type"
+	MetricLabelUnknownEventType = "UNKNOWN"
+	MetricLabelPeer             = "peer"
+	MetricLabelResult           = "result"
+	MetricLabelQuantile         = "quantile"
+	MetricResultSuccess         = "success"
+	MetricResultFailure         = "failure"
+)
+
+//Metrics receives the instrumentation of an EventsClient, typically by
+//forwarding it to a monitoring system. Implementations must be safe for
+//concurrent use and must not modify the labels they are given
+type Metrics interface {
+	//SetGauge sets the current value of the named gauge
+	SetGauge(name string, labels map[string]string, value float64)
+	//AddCounter increments the named counter by delta
+	AddCounter(name string, labels map[string]string, delta float64)
+}
+
+//HistogramMetrics can be implemented by a Metrics to also receive the
+//distributions measured by the client, like MetricAdapterRecvSeconds
+type HistogramMetrics interface {
+	Metrics
+	//Observe adds value to the named histogram
+	Observe(name string, labels map[string]string, value float64)
+}
@@ -0,0 +1,137 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+
+	"github.com/hyperledger/fabric/core/util"
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//MigrateTo moves a started client to the event hub at newAddress, make
+//before break: it connects to newAddress and registers the interested
+//events there, switches the receive loop over to the new stream and only
+//then ends the old one. The events the old peer sent before ending its
+//stream are still delivered, so none is lost, while an event both peers
+//sent around the switch can be delivered twice. When newAddress cannot be
+//reached or the registration fails, the client keeps its stream and the
+//error is returned. ctx bounds the connection, the registration and the
+//wait for the old peer to end its stream, which is cancelled afterwards.
+//The reconnections then go to newAddress, unless a peer selector picks the
+//peers. The client is in StateMigrating meanwhile: MigrateTo fails unless it
+//is in StateConnected, e.g. while it reconnects, drains or another
+//migration is in progress. A client created with NewFromStream cannot
+//migrate
+func (ec *EventsClient) MigrateTo(ctx context.Context, newAddress string) error {
+	if ec.ownStream != nil {
+		return fmt.Errorf("a client created from a stream cannot migrate")
+	}
+	addr, err := normalizePeerAddress(newAddress)
+	if err != nil {
+		return err
+	}
+	ec.mutex.Lock()
+	state, old, parent := ec.state, ec.stream, ec.ctx
+	if state != StateConnected {
+		ec.mutex.Unlock()
+		return fmt.Errorf("cannot migrate events client %s while it is %s", ec.name, state)
+	}
+	ec.transitionLocked(StateMigrating)
+	ec.mutex.Unlock()
+	defer ec.transitionFrom(StateMigrating, StateConnected)
+	ies, err := ec.interestedEvents()
+	if err != nil {
+		return fmt.Errorf("error getting interested events:%s", err)
+	}
+	if len(ies) == 0 {
+		return fmt.Errorf("must supply interested events")
+	}
+
+	conn, err := ec.dialContext(ctx, addr)
+	if err != nil {
+		return err
+	}
+	id := util.GenerateUUID()
+	streamCtx, cancel := context.WithCancel(withConnectionID(parent, id))
+	chat, err := ehpb.NewEventsClient(conn).Chat(streamCtx)
+	if err != nil {
+		cancel()
+		conn.Close()
+		return fmt.Errorf("Could not open event stream to %s: %s", addr, err)
+	}
+	stream := closeSendOnce(chat, id)
+	ec.registrationAttempted(addr)
+	err = ec.register(ctx, stream, ies)
+	ec.registrationEnded(addr, err)
+	if err != nil {
+		cancel()
+		conn.Close()
+		return err
+	}
+
+	left := make(chan struct{})
+	ec.mutex.Lock()
+	if ec.stopped || ec.stream != old {
+		stopped := ec.stopped
+		ec.mutex.Unlock()
+		cancel()
+		conn.Close()
+		if stopped {
+			return errClientStopped
+		}
+		return fmt.Errorf("the stream was replaced during the migration to %s", addr)
+	}
+	oldConn, oldCancel := ec.conn, ec.cancelStream
+	ec.conn, ec.stream, ec.cancelStream, ec.peerAddress = conn, stream, cancel, addr
+	ec.leftStream = left
+	ec.mutex.Unlock()
+	ec.connectionID.Store(id)
+	ec.logf(logging.INFO, "migrated to %s, ending the stream of the previous peer", addr)
+
+	old.CloseSend()
+	select {
+	case <-left:
+	case <-ctx.Done():
+		ec.logf(logging.WARNING, "the previous peer did not end its stream in time, cancelling it")
+	case <-ec.Context().Done():
+	}
+	if oldCancel != nil {
+		oldCancel()
+	}
+	if oldConn != nil {
+		oldConn.Close()
+	}
+	return nil
+}
+
+//leftReplacedStream tells whether stream, which the receive loop got an
+//error from, was replaced by MigrateTo, and lets MigrateTo know that the
+//loop has left it
+func (ec *EventsClient) leftReplacedStream(stream ehpb.Events_ChatClient) bool {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if ec.stream == stream || ec.leftStream == nil {
+		return false
+	}
+	close(ec.leftStream)
+	ec.leftStream = nil
+	return true
+}
@@ -0,0 +1,122 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+	"golang.org/x/net/context"
+)
+
+func TestMigrateTo(t *testing.T) {
+	oldEnded := make(chan struct{})
+	oldAddr, _, stopOld := startFakeServer(t, func(_ int, stream ehpb.Events_ChatServer) error {
+		defer close(oldEnded)
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for i := 0; i < 2; i++ {
+			if err := stream.Send(blockEvent()); err != nil {
+				return err
+			}
+		}
+		waitForEOF(stream)
+		// an event sent while the client migrates is still delivered
+		return stream.Send(blockEvent())
+	})
+	defer stopOld()
+	newAddr, newSrv, stopNew := startFakeServer(t, sendBlocks(2))
+	defer stopNew()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(oldAddr, adapter)
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	adapter.waitEvent(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.MigrateTo(ctx, newAddr); err != nil {
+		t.Fatalf("could not migrate: %s", err)
+	}
+	select {
+	case <-oldEnded:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the stream of the previous peer was not ended")
+	}
+	for i := 0; i < 3; i++ {
+		adapter.waitEvent(t)
+	}
+	if client.PeerAddress() != newAddr {
+		t.Fatalf("expected the client to be on %s, got %s", newAddr, client.PeerAddress())
+	}
+	if newSrv.chatCount() != 1 {
+		t.Fatalf("expected a single stream to the new peer, got %d", newSrv.chatCount())
+	}
+	select {
+	case err := <-adapter.disconnected:
+		t.Fatalf("the client disconnected during the migration: %v", err)
+	case e := <-adapter.events:
+		t.Fatalf("unexpected event %v", e)
+	default:
+	}
+}
+
+func TestMigrateToUnreachablePeer(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(_ int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		waitForEOF(stream)
+		return nil
+	})
+	defer stop()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start listener: %s", err)
+	}
+	unreachable := lis.Addr().String()
+	lis.Close()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter)
+	if err := client.MigrateTo(context.Background(), unreachable); err == nil {
+		t.Fatalf("expected an error migrating a client not started")
+	}
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.MigrateTo(ctx, unreachable); err == nil {
+		t.Fatalf("expected an error migrating to an unreachable peer")
+	}
+	if client.PeerAddress() != addr {
+		t.Fatalf("expected the client to stay on %s, got %s", addr, client.PeerAddress())
+	}
+	if err := client.Healthy(); err != nil {
+		t.Fatalf("expected the client to keep its stream, got %s", err)
+	}
+}
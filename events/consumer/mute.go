@@ -0,0 +1,72 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"sort"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//Mute stops the delivery of the received events of type eventType to the
+//adapter until Unmute is called, without registering with the event hub
+//again: the events are still received and counted by the statistics, and
+//seen by WaitForEvent, but discarded before the adapter
+func (ec *EventsClient) Mute(eventType ehpb.EventType) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if ec.muted == nil {
+		ec.muted = make(map[ehpb.EventType]bool)
+	}
+	ec.muted[eventType] = true
+}
+
+//Unmute resumes the delivery of the events of type eventType muted with Mute
+func (ec *EventsClient) Unmute(eventType ehpb.EventType) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	delete(ec.muted, eventType)
+}
+
+//Muted returns the muted event types, in ascending order
+func (ec *EventsClient) Muted() []ehpb.EventType {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	types := make([]ehpb.EventType, 0, len(ec.muted))
+	for et := range ec.muted {
+		types = append(types, et)
+	}
+	sort.Sort(eventTypes(types))
+	return types
+}
+
+//eventTypes sorts event types in ascending order
+type eventTypes []ehpb.EventType
+
+func (t eventTypes) Len() int           { return len(t) }
+func (t eventTypes) Less(i, j int) bool { return t[i] < t[j] }
+func (t eventTypes) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+func (ec *EventsClient) isMuted(e *ehpb.Event) bool {
+	et, ok := getEventType(e)
+	if !ok {
+		return false
+	}
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.muted[et]
+}
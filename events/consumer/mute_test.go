@@ -0,0 +1,72 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"testing"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestMute(t *testing.T) {
+	release := make(chan struct{})
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for i, e := range []*ehpb.Event{blockEvent(), chaincodeEvent("mycc", "first"), blockEvent(), chaincodeEvent("mycc", "second")} {
+			if i == 2 {
+				<-release
+			}
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter)
+	client.Mute(ehpb.EventType_CHAINCODE)
+	client.Mute(ehpb.EventType_REJECTION)
+	if muted := client.Muted(); len(muted) != 2 || muted[0] != ehpb.EventType_CHAINCODE || muted[1] != ehpb.EventType_REJECTION {
+		t.Fatalf("unexpected mute set %v", muted)
+	}
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+
+	if adapter.waitEvent(t).GetBlock() == nil {
+		t.Fatalf("expected a block event")
+	}
+	waitFor(t, "muted chaincode event", func() bool {
+		return client.Stats().ReceivedEvents[ehpb.EventType_CHAINCODE] == 1
+	})
+	client.Unmute(ehpb.EventType_CHAINCODE)
+	if muted := client.Muted(); len(muted) != 1 || muted[0] != ehpb.EventType_REJECTION {
+		t.Fatalf("unexpected mute set after Unmute %v", muted)
+	}
+	close(release)
+	if adapter.waitEvent(t).GetBlock() == nil {
+		t.Fatalf("expected the muted chaincode event to be dropped")
+	}
+	if cc := adapter.waitEvent(t).GetChaincodeEvent(); cc == nil || cc.EventName != "second" {
+		t.Fatalf("expected the chaincode events to be delivered again, got %v", cc)
+	}
+}
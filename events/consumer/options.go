@@ -0,0 +1,768 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//Option configures optional behavior of an EventsClient
+type Option func(*EventsClient)
+
+//WithReconnectOnServerClose makes the client reconnect and re-register its
+//interested events when the event hub closes the stream cleanly, instead of
+//reporting ErrServerClosed to the adapter
+func WithReconnectOnServerClose() Option {
+	return func(ec *EventsClient) {
+		ec.reconnectOnServerClose = true
+	}
+}
+
+//WithReconnectOnStreamError makes the client reconnect and re-register its
+//interested events when the stream fails, e.g. the peer restarted or the
+//network dropped the connection, instead of terminating with the error. The
+//reconnection follows WithBackoff and the circuit breaker like the one after
+//a clean close, and the adapter is only disconnected, with the last error,
+//once the attempts are exhausted. A stream ended by Stop or by the context
+//of StartContext, and a peer changing of identity with WithTrustOnFirstUse,
+//still terminate the client
+func WithReconnectOnStreamError() Option {
+	return func(ec *EventsClient) {
+		ec.reconnectOnStreamError = true
+	}
+}
+
+//WithReconnectPolicy keeps a long-running client alive across the restarts
+//of the peer and the network failures: it reconnects when the stream is
+//closed or fails, as with WithReconnectOnServerClose and
+//WithReconnectOnStreamError, up to maxRetries attempts in a row, unlimited
+//when not positive, waiting from baseBackoff doubling up to maxBackoff in
+//between, with the jitter of DefaultBackoff. Stop interrupts the wait at once
+func WithReconnectPolicy(maxRetries int, baseBackoff, maxBackoff time.Duration) Option {
+	return func(ec *EventsClient) {
+		ec.reconnectOnServerClose = true
+		ec.reconnectOnStreamError = true
+		backoff := DefaultBackoff()
+		backoff.Initial, backoff.Max, backoff.MaxAttempts = baseBackoff, maxBackoff, maxRetries
+		ec.backoff = backoff
+	}
+}
+
+//WithBufferSize decouples receiving from the adapter by buffering up to size
+//events, so a slow adapter does not hold up the stream. When the buffer is
+//full the receive loop waits for the adapter unless WithDropWhenFull is set
+func WithBufferSize(size int) Option {
+	return func(ec *EventsClient) {
+		ec.bufferSize = size
+	}
+}
+
+//WithBufferBytes also limits the buffer set with WithBufferSize to limit
+//bytes, the serialized size of the buffered events: the buffer is full when
+//either limit is reached, and the overflow policy applies the same. An event
+//larger than limit is only buffered once the buffer is empty
+func WithBufferBytes(limit int) Option {
+	return func(ec *EventsClient) {
+		ec.bufferBytes = limit
+	}
+}
+
+//WithMaxEvents has the client stop on its own once it has delivered n
+//events to its adapter, e.g. to take a sample: the adapter receives exactly
+//n events and is then disconnected with nil, CloseMaxEvents being the
+//reason given to a CloseNotifier. Zero, the default, means no limit
+func WithMaxEvents(n uint64) Option {
+	return func(ec *EventsClient) {
+		ec.maxEvents = n
+	}
+}
+
+//WithCoalesceDuplicates drops a received event when it is byte-identical to
+//the previous one passed on for delivery, e.g. for a source repeating events
+//while it retries. Only adjacent events are compared: a duplicate of an older
+//event is delivered. The check happens after the filter and the muted types;
+//the dropped events are counted by ClientStats.CoalescedEvents and the
+//MetricCoalescedEvents counter
+func WithCoalesceDuplicates() Option {
+	return func(ec *EventsClient) {
+		ec.coalesce = true
+	}
+}
+
+//WithPassEmptyEvents delivers the events received without payload, i.e. with
+//none of the Register, Block, ChaincodeEvent or Rejection fields set. By
+//default they are dropped before the filter, so that an adapter can count on
+//the payload of the events it receives, and counted by
+//ClientStats.EmptyEvents and the MetricEmptyEvents counter
+func WithPassEmptyEvents() Option {
+	return func(ec *EventsClient) {
+		ec.passEmpty = true
+	}
+}
+
+//WithDropWhenFull makes a buffered client discard received events while its
+//buffer is full instead of waiting for the adapter to catch up
+func WithDropWhenFull() Option {
+	return func(ec *EventsClient) {
+		ec.dropWhenFull = true
+	}
+}
+
+//WithMetrics reports the client's instrumentation to m
+func WithMetrics(m Metrics) Option {
+	return func(ec *EventsClient) {
+		ec.metrics = m
+	}
+}
+
+//WithRegisterHook lets hook modify the Register message before it is sent to
+//the event hub, both on Start and whenever the client re-registers
+func WithRegisterHook(hook func(*ehpb.Register)) Option {
+	return func(ec *EventsClient) {
+		ec.registerHook = hook
+	}
+}
+
+//WithSendHook calls hook with every message the client is about to send to
+//the event hub, to observe the client to server direction of the stream.
+//The event hub protocol has no unregister or keepalive message, so these are
+//the Register messages, resends included. hook must not modify msg
+func WithSendHook(hook func(msg *ehpb.Event)) Option {
+	return func(ec *EventsClient) {
+		ec.sendHook = hook
+	}
+}
+
+//WithName sets the name identifying the client in log messages and as the
+//MetricLabelClient metric label. It defaults to the peer address
+func WithName(name string) Option {
+	return func(ec *EventsClient) {
+		ec.name = name
+	}
+}
+
+//WithTransform applies transform to every received event before it is
+//delivered, so that the adapter, the tap, the replay buffer and the event
+//dumps see the transformed event. It runs after the events without payload
+//are dropped and before the filter, the muted types and the coalescing. When
+//transform fails, policy tells whether the event is dropped or the client
+//terminated
+func WithTransform(transform Transform, policy TransformErrorPolicy) Option {
+	return func(ec *EventsClient) {
+		ec.transform = transform
+		ec.transformPolicy = policy
+	}
+}
+
+//WithRecvTimeout bounds the time the adapter is given for each event. A
+//call still running at the timeout is abandoned: the client stops waiting
+//for it and, as policy tells, skips the event, handing it to deadLetter when
+//not nil, or terminates with an error. The abandoned call keeps running
+//concurrently with the next ones; a ContextAdapter is told to give up, the
+//context passed to RecvContext expiring at the timeout. With pooled events
+//the adapter and deadLetter are given a copy of each event, which deadLetter
+//can keep. There is no timeout by default
+func WithRecvTimeout(timeout time.Duration, policy RecvTimeoutPolicy, deadLetter func(msg *ehpb.Event)) Option {
+	return func(ec *EventsClient) {
+		ec.recvTimeout = timeout
+		ec.recvTimeoutPolicy = policy
+		ec.deadLetter = deadLetter
+	}
+}
+
+//WithFilter only delivers the received events accepted by filter to the
+//adapter. Use a FilterChain to combine several filters
+func WithFilter(filter Filter) Option {
+	return func(ec *EventsClient) {
+		ec.filter = filter
+	}
+}
+
+//WithContextDialer makes the client open its network connections to the
+//event hub with dialer, e.g. to go through a tunnel or an in-memory listener.
+//dialer receives the peer address and a context bounded by the dial timeout.
+//It only provides the raw connection: when TLS is enabled the handshake is
+//still performed over the returned net.Conn
+func WithContextDialer(dialer func(ctx context.Context, addr string) (net.Conn, error)) Option {
+	return func(ec *EventsClient) {
+		ec.contextDialer = dialer
+	}
+}
+
+//WithLocalAddr makes the client open its TCP connections to the event hub
+//from addr, e.g. to leave a multi-homed host through a given interface.
+//addr.Port is usually 0 to let the system pick the port. The TLS handshake,
+//when enabled, is performed over the bound connection as over any other. It
+//does not apply to unix sockets and cannot be combined with
+//WithContextDialer, whose dialer can bind the connections itself
+func WithLocalAddr(addr *net.TCPAddr) Option {
+	return func(ec *EventsClient) {
+		ec.localAddr = addr
+	}
+}
+
+//WithCircuitBreaker keeps a client reconnecting until it succeeds, but
+//suspends the attempts for config.Cooldown once config.Failures of them have
+//failed within config.Window. A single trial attempt follows the cooldown:
+//the breaker closes again if it succeeds and reopens otherwise
+func WithCircuitBreaker(config CircuitBreakerConfig) Option {
+	return func(ec *EventsClient) {
+		ec.breaker = newCircuitBreaker(config)
+	}
+}
+
+//WithBackoff paces the retries with b, or with DefaultBackoff when b is nil.
+//A lost stream is then re-established with attempts separated by the delays
+//of b until one succeeds, the client is stopped or b gives up, on top of the
+//circuit breaker if any; the Register messages resent with
+//WithRegisterRetries wait for the delays of b as well. Without it a single
+//reconnect attempt is made, unless a circuit breaker is set, and Register
+//messages are resent at once
+func WithBackoff(b Backoff) Option {
+	return func(ec *EventsClient) {
+		if b == nil {
+			b = DefaultBackoff()
+		}
+		ec.backoff = b
+	}
+}
+
+//WithRegisterRetries resends the Register message up to retries times over
+//the same stream when the event hub answers it with something else than a
+//registration ack, e.g. a nil event while it is briefly overloaded. Timeouts
+//and stream errors are not retried this way: they fail the connection
+//attempt, which is then subject to the reconnection settings
+func WithRegisterRetries(retries int) Option {
+	return func(ec *EventsClient) {
+		ec.registerRetries = retries
+	}
+}
+
+//WithCertificatePins only accepts a TLS connection to the event hub if one of
+//the certificates it presents has a SubjectPublicKeyInfo whose SHA-256 hash
+//is in pins (see CertificatePin). With verifyCA the certificate chain must
+//also verify against the configured CAs, otherwise the pins are the sole
+//check. TLS must be enabled
+func WithCertificatePins(pins [][]byte, verifyCA bool) Option {
+	return func(ec *EventsClient) {
+		ec.pins = &certificatePins{pins: pins, verifyCA: verifyCA}
+	}
+}
+
+//WithTrustOnFirstUse records the certificate each peer presents when the
+//client first connects to it, by the pin of its public key, and refuses the
+//certificates of a different key on the following connections, locking the
+//client to the first seen identity of its peers rather than to pins known
+//in advance. A peer that changes of identity terminates the client, its
+//adapter receiving a PeerIdentityError in Disconnected and a CloseNotifier
+//the CloseIdentityChanged reason; the reconnection is not retried. It
+//requires TLS and combines with WithCertificatePins. The pins are only kept
+//for the life of the client
+func WithTrustOnFirstUse() Option {
+	return func(ec *EventsClient) {
+		ec.tofu = newFirstUsePins()
+	}
+}
+
+//WithReconnectHook calls hook after every attempt to re-establish a lost
+//stream, with the attempt number, its cause, the delay applied before it
+//and its outcome. It is called from the goroutine receiving events and
+//should not block
+func WithReconnectHook(hook func(ReconnectInfo)) Option {
+	return func(ec *EventsClient) {
+		ec.reconnectHook = hook
+	}
+}
+
+//WithCodec makes the client marshal and unmarshal the messages of the chat
+//stream with codec instead of the standard protobuf codec, e.g. for forks
+//using gogoproto or a custom registry
+func WithCodec(codec grpc.Codec) Option {
+	return func(ec *EventsClient) {
+		ec.codec = codec
+	}
+}
+
+//WithRejectDuplicateInterests makes the registration fail when the
+//interested events contain duplicates, i.e. interests for the same event
+//type, chaincode ID and event name. By default duplicates are removed with a
+//warning
+func WithRejectDuplicateInterests() Option {
+	return func(ec *EventsClient) {
+		ec.rejectDuplicates = true
+	}
+}
+
+//WithInterestProvider registers the interested events returned by provider
+//instead of those of the adapter, on every registration and with
+//Reregister, so that the subscription can be driven by configuration while
+//the adapter only handles the events. The adapter's GetInterestedEvents is
+//then not called
+func WithInterestProvider(provider InterestProvider) Option {
+	return func(ec *EventsClient) {
+		ec.interestProvider = provider
+	}
+}
+
+//WithInterestOrder sorts the interested events of the adapter with less
+//before registering them, DefaultInterestOrder when less is nil, so that the
+//Register message is the same whatever the order the adapter returns them
+//in, e.g. for peers giving overlapping interests a precedence by order. The
+//sort is stable and happens before the register hook is called
+func WithInterestOrder(less func(a, b *ehpb.Interest) bool) Option {
+	if less == nil {
+		less = DefaultInterestOrder
+	}
+	return func(ec *EventsClient) {
+		ec.interestOrder = less
+	}
+}
+
+//WithLenientRegistration has the client ignore the messages other than the
+//Register ack it receives while registering, e.g. those of a newer event hub
+//sending message types unknown to the client, and keep waiting for the ack
+//until the registration timeout. By default such a message fails the
+//attempt, and the Register is resent if WithRegisterRetries allows it
+func WithLenientRegistration() Option {
+	return func(ec *EventsClient) {
+		ec.lenientRegister = true
+	}
+}
+
+//WithRegistrationDelay makes the client wait for delay between opening a
+//stream and sending the Register message on it, for peers that time out
+//the registrations sent too soon after the stream is established. It
+//applies to the streams of the reconnections, and to the first one too
+//with initial. It does not count in the registration timeout
+func WithRegistrationDelay(delay time.Duration, initial bool) Option {
+	return func(ec *EventsClient) {
+		ec.registerDelay = delay
+		ec.registerDelayInitial = initial
+	}
+}
+
+//WithRegistrationTimeout bounds the time allowed to send the Register
+//message and receive its ack, resends included, 5 seconds by default
+func WithRegistrationTimeout(timeout time.Duration) Option {
+	return func(ec *EventsClient) {
+		ec.registrationTimeout = timeout
+	}
+}
+
+//WithReplayBuffer keeps the last size events delivered to the adapter so
+//that they can be delivered again with ReplayTo. A size of 0 keeps none
+func WithReplayBuffer(size int) Option {
+	return func(ec *EventsClient) {
+		ec.replaySize = size
+		ec.replay = nil
+		if size > 0 {
+			ec.replay = newReplayRing(size)
+		}
+	}
+}
+
+//WithConnectionConfig makes the client connect to the event hub with config
+//instead of the peer configuration read by ConnectionConfigFromViper
+func WithConnectionConfig(config ConnectionConfig) Option {
+	return func(ec *EventsClient) {
+		ec.connConfig = &config
+	}
+}
+
+//WithDialOptions makes the client dial the event hub with opts as given,
+//instead of deriving the transport security and the timeout from a
+//ConnectionConfig or the peer configuration, e.g. to embed clients with
+//their own credentials in a process without populating viper. opts must
+//therefore include grpc.WithTransportCredentials or grpc.WithInsecure, and
+//grpc.WithBlock and grpc.WithTimeout for a blocking dial: neither the dial
+//timeout of a ConnectionConfig nor WithBlockingDial apply. They only follow
+//the dialer and codec set up by the other options. Without opts the client
+//dials as usual. It excludes WithConnectionConfig, whose DialOptions would
+//compete with opts, WithPeerConnectionConfig, WithCertificatePins,
+//WithTrustOnFirstUse and WithTLSFileWatch, which need the client to set up
+//TLS itself: Start rejects their combination
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(ec *EventsClient) {
+		ec.dialOpts = opts
+	}
+}
+
+//WithPeerConnectionConfig makes the client connect to the peer at address
+//with config, e.g. when the peers of a WithPeerSelector client belong to
+//different trust domains and need their own CA or server name. It can be
+//given once per peer; the other peers use the configuration set with
+//WithConnectionConfig or read by ConnectionConfigFromViper
+func WithPeerConnectionConfig(address string, config ConnectionConfig) Option {
+	return func(ec *EventsClient) {
+		if ec.peerConfigs == nil {
+			ec.peerConfigs = make(map[string]ConnectionConfig)
+		}
+		ec.peerConfigs[address] = config
+	}
+}
+
+//WithPeerSelector makes the client ask selector for the peer of each
+//connection, the first one and those re-established after a loss, instead
+//of always connecting to the peer address it was created with. The
+//interested events are registered again with every newly selected peer;
+//the events published while the client switches peers may be missed, or
+//received twice when the peers are not in sync
+func WithPeerSelector(selector PeerSelector) Option {
+	return func(ec *EventsClient) {
+		ec.selector = selector
+	}
+}
+
+//WithRateWindow sets the sliding window over which EventsPerSecond and the
+//MetricEventsPerSecond gauge average the received events, 10 seconds by
+//default
+func WithRateWindow(window time.Duration) Option {
+	return func(ec *EventsClient) {
+		ec.rate = newRateMeter(window)
+	}
+}
+
+//WithThrottleBackoff makes the client handle a stream ended by the event
+//hub with the ResourceExhausted code, which means it is overloaded, by
+//waiting before re-establishing the stream instead of failing at once. The
+//wait starts at min and doubles with every consecutive throttle up to max; it
+//is reset once an event is received. The reconnection itself follows the
+//reconnect settings, like the circuit breaker. Throttles are counted by
+//ClientStats.Throttles and the MetricThrottles counter. As the grpc stream
+//is over once the error is received, it cannot be resumed: the registration
+//is sent again on the new stream
+func WithThrottleBackoff(min, max time.Duration) Option {
+	return func(ec *EventsClient) {
+		ec.throttle = &throttleBackoff{min: min, max: max}
+	}
+}
+
+//WithReconnectOnAdapterError re-establishes the stream, instead of stopping
+//the client, when the adapter returns from Recv an error for which classify
+//returns true, e.g. one reporting that a downstream store is temporarily
+//unavailable. The event that failed is not delivered again; the reconnection
+//follows the reconnect settings, like the backoff and the circuit breaker,
+//and reports the adapter error as the cause. ErrStopConsuming always stops
+//the client
+func WithReconnectOnAdapterError(classify func(error) bool) Option {
+	return func(ec *EventsClient) {
+		ec.reconnectOn = classify
+	}
+}
+
+//WithRawRecorder writes to w the exact bytes of every message received from
+//the event hub, registration acks included, before it is decoded and
+//delivered, e.g. to keep an audit record of what the peer sent. Each message
+//is framed by its length as a 4-byte big-endian prefix; ReadRawEvent reads
+//the frames back. A failed write is passed to onError, or logged when
+//onError is nil, and does not stop the client; the writes are serialized.
+//When the client terminates, w is flushed if it has a Flush method, like a
+//bufio.Writer, and synced if it has a Sync method, like an os.File; it is
+//not closed. To compress the record w can be a gzip.Writer, closed by the
+//caller once the client has terminated, and read back through a gzip.Reader
+func WithRawRecorder(w io.Writer, onError func(error)) Option {
+	return func(ec *EventsClient) {
+		ec.recorder = &rawRecorder{w: w, onError: onError}
+	}
+}
+
+//WithBlockingDial sets whether connecting waits for the connection to the
+//event hub to be established, which is the default. Without blocking, the
+//dial returns at once and an unreachable event hub is only reported when
+//the chat stream is opened, so Start fails on the stream or the registration
+//rather than on the dial, and the dial timeout no longer applies
+func WithBlockingDial(block bool) Option {
+	return func(ec *EventsClient) {
+		ec.blockingDial = block
+	}
+}
+
+//WithLogger has the client log its messages to logger instead of the
+//eventhub_consumer module logger, e.g. to tell apart the clients of an
+//application in its logging configuration. WithLogLevel still applies
+func WithLogger(logger *logging.Logger) Option {
+	return func(ec *EventsClient) {
+		ec.logger = logger
+	}
+}
+
+//WithEventDump has the client log the full text representation of every
+//message it receives, for troubleshooting the protocol with the event hub.
+//The messages are logged at debug level, so the level set with WithLogLevel
+//and the one of the logger must both include logging.DEBUG; the messages
+//are not even formatted otherwise. It is costly: it is meant for short
+//debugging sessions
+func WithEventDump() Option {
+	return func(ec *EventsClient) {
+		ec.dumpEvents = true
+	}
+}
+
+//WithLogLevel sets the most verbose level of the messages the client logs,
+//logging.INFO by default: logging.DEBUG adds a message per received event
+//and per registration step, logging.WARNING keeps only the problems. Errors
+//are logged at any level. The level configured for the eventhub_consumer
+//logging module still applies on top of it
+func WithLogLevel(level logging.Level) Option {
+	return func(ec *EventsClient) {
+		ec.logLevel = level
+	}
+}
+
+//WithBaseContext makes the values of ctx, e.g. a tenant or a correlation ID,
+//available to a ContextAdapter through the context passed to RecvContext.
+//Only its values are used: the context passed to RecvContext is cancelled
+//with the client, not with ctx
+func WithBaseContext(ctx context.Context) Option {
+	return func(ec *EventsClient) {
+		ec.baseCtx = ctx
+	}
+}
+
+//WithReconnectErrorHistory sets how many errors of failed reconnect attempts
+//the ReconnectError terminating the client keeps, the last 10 by default
+func WithReconnectErrorHistory(n int) Option {
+	return func(ec *EventsClient) {
+		ec.errorHistory = n
+	}
+}
+
+//WithShutdownTimeout bounds the shutdown sequence of the client, which
+//delivers the buffered events, flushes and closes the adapter, the raw
+//recorder and the tap when the client terminates; it is the deadline of the
+//context passed to DisconnectedContext of a ShutdownAdapter. It is 5 seconds
+//by default
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(ec *EventsClient) {
+		ec.shutdownTimeout = timeout
+	}
+}
+
+//WithFlushOnReconnect makes the client flush a FlushingAdapter when it loses
+//its stream, before re-establishing it, so that a batch accumulated from
+//the lost stream is emitted rather than completed with the events of the
+//next one. With a buffer, the buffered events of the lost stream are
+//delivered first. By default the partial batch is carried over: the client
+//does not flush the adapter on a reconnection, and discards nothing it
+//holds either
+func WithFlushOnReconnect() Option {
+	return func(ec *EventsClient) {
+		ec.flushOnReconnect = true
+	}
+}
+
+//WithStalenessThreshold makes Healthy report the client unhealthy when no
+//event was received for longer than threshold, counted from the last
+//registration while none was, e.g. for a peer expected to commit blocks
+//regularly
+func WithStalenessThreshold(threshold time.Duration) Option {
+	return func(ec *EventsClient) {
+		ec.staleness = threshold
+	}
+}
+
+//WithDownstreamHealthCheck makes the client call healthy before delivering
+//each event and pause the delivery while it returns false, instead of having
+//the adapter fail the events one by one while its downstream is unavailable.
+//healthy is called again every interval until it returns true. The events
+//received meanwhile are buffered with WithBufferSize, within its limits;
+//without a buffer no event is read from the stream, so that the event hub
+//is held back by the flow control of the connection. The pause does not
+//survive Stop. ClientStats.PausedForHealth reports whether the delivery is
+//paused and ClientStats.HealthPauses counts the pauses
+func WithDownstreamHealthCheck(healthy func() bool, interval time.Duration) Option {
+	return func(ec *EventsClient) {
+		ec.downstreamCheck = healthy
+		ec.downstreamInterval = interval
+	}
+}
+
+//validate checks the consistency of the options the client was created
+//with. It returns a single error listing every problem found, or nil
+func (ec *EventsClient) validate() error {
+	var problems []string
+	if ec.adapter == nil {
+		problems = append(problems, "no adapter")
+	}
+	if ec.bufferSize < 0 {
+		problems = append(problems, fmt.Sprintf("negative buffer size %d", ec.bufferSize))
+	}
+	if ec.dropWhenFull && ec.bufferSize <= 0 {
+		problems = append(problems, "dropping when full requires a buffer")
+	}
+	if ec.bufferBytes < 0 {
+		problems = append(problems, fmt.Sprintf("negative buffer byte limit %d", ec.bufferBytes))
+	} else if ec.bufferBytes > 0 && ec.bufferSize <= 0 {
+		problems = append(problems, "a buffer byte limit requires a buffer")
+	}
+	if ec.highWatermark != 0 || ec.lowWatermark != 0 {
+		if ec.bufferSize <= 0 {
+			problems = append(problems, "buffer watermarks require a buffer")
+		} else if ec.highWatermark > ec.bufferSize {
+			problems = append(problems, fmt.Sprintf("high watermark %d is over the buffer size %d", ec.highWatermark, ec.bufferSize))
+		}
+		if ec.lowWatermark < 0 || ec.lowWatermark >= ec.highWatermark {
+			problems = append(problems, fmt.Sprintf("low watermark %d is not between 0 and the high watermark %d", ec.lowWatermark, ec.highWatermark))
+		}
+	}
+	if ec.registerRetries < 0 {
+		problems = append(problems, fmt.Sprintf("negative register retries %d", ec.registerRetries))
+	}
+	if ec.reconnectAllowed != nil && ec.schedulePoll <= 0 {
+		problems = append(problems, fmt.Sprintf("reconnect schedule poll interval %s is not positive", ec.schedulePoll))
+	}
+	if ec.eventWindow < 0 {
+		problems = append(problems, fmt.Sprintf("negative required event window %s", ec.eventWindow))
+	}
+	if ec.sampleRateSet && (ec.sampleRate < 0 || ec.sampleRate > 1) {
+		problems = append(problems, fmt.Sprintf("sample rate %v is not between 0 and 1", ec.sampleRate))
+	}
+	if ec.sampleEvery < 0 {
+		problems = append(problems, fmt.Sprintf("negative sampling interval %d", ec.sampleEvery))
+	}
+	if ec.sampleRateSet && ec.sampleEvery > 0 {
+		problems = append(problems, "a sample rate and a sampling interval are exclusive")
+	}
+	if ec.latencyWindow < 0 {
+		problems = append(problems, fmt.Sprintf("negative registration latency window %d", ec.latencyWindow))
+	}
+	if ec.tickInterval < 0 {
+		problems = append(problems, fmt.Sprintf("negative tick interval %s", ec.tickInterval))
+	}
+	if ec.recvTimeout < 0 {
+		problems = append(problems, fmt.Sprintf("negative recv timeout %s", ec.recvTimeout))
+	}
+	if ec.registrationTimeout <= 0 {
+		problems = append(problems, fmt.Sprintf("registration timeout %s is not positive", ec.registrationTimeout))
+	}
+	if ec.registerBatchWait < 0 {
+		problems = append(problems, fmt.Sprintf("negative register batch wait %s", ec.registerBatchWait))
+	}
+	if ec.registerDelay < 0 {
+		problems = append(problems, fmt.Sprintf("negative registration delay %s", ec.registerDelay))
+	}
+	if ec.replaySize < 0 {
+		problems = append(problems, fmt.Sprintf("negative replay buffer size %d", ec.replaySize))
+	}
+	if ec.rate.window < rateBuckets {
+		problems = append(problems, fmt.Sprintf("rate window %s is too short", ec.rate.window))
+	}
+	if ec.breaker != nil {
+		config := ec.breaker.config
+		if config.Failures <= 0 {
+			problems = append(problems, fmt.Sprintf("circuit breaker failures %d is not positive", config.Failures))
+		}
+		if config.Window <= 0 {
+			problems = append(problems, fmt.Sprintf("circuit breaker window %s is not positive", config.Window))
+		}
+		if config.Cooldown < 0 {
+			problems = append(problems, fmt.Sprintf("negative circuit breaker cooldown %s", config.Cooldown))
+		}
+	}
+	if config := ec.connConfig; config != nil {
+		if config.DialTimeout < 0 {
+			problems = append(problems, fmt.Sprintf("negative dial timeout %s", config.DialTimeout))
+		}
+		if !config.TLSEnabled {
+			if len(config.CACert) > 0 || config.CACertFile != "" {
+				problems = append(problems, "CA certificates are set but TLS is disabled")
+			}
+			if ec.pins != nil {
+				problems = append(problems, "certificate pinning requires TLS to be enabled")
+			}
+			if ec.tofu != nil {
+				problems = append(problems, "trust on first use requires TLS to be enabled")
+			}
+		}
+	}
+	var peers []string
+	for addr := range ec.peerConfigs {
+		peers = append(peers, addr)
+	}
+	sort.Strings(peers)
+	for _, addr := range peers {
+		config := ec.peerConfigs[addr]
+		if _, err := normalizePeerAddress(addr); err != nil {
+			problems = append(problems, fmt.Sprintf("connection config for invalid peer address %q", addr))
+		}
+		if config.DialTimeout < 0 {
+			problems = append(problems, fmt.Sprintf("negative dial timeout %s for peer %s", config.DialTimeout, addr))
+		}
+	}
+	if ec.downstreamCheck != nil && ec.downstreamInterval <= 0 {
+		problems = append(problems, fmt.Sprintf("downstream health check interval %s is not positive", ec.downstreamInterval))
+	}
+	if ec.throttle != nil && (ec.throttle.min <= 0 || ec.throttle.max < ec.throttle.min) {
+		problems = append(problems, fmt.Sprintf("invalid throttle backoff from %s to %s", ec.throttle.min, ec.throttle.max))
+	}
+	if ec.ownStream != nil {
+		if ec.reconnectOnServerClose || ec.reconnectOnStreamError || ec.breaker != nil || ec.throttle != nil || ec.backoff != nil || ec.reconnectOn != nil || ec.watchInterval > 0 {
+			problems = append(problems, "a client created from a stream cannot reconnect")
+		}
+		if ec.selector != nil {
+			problems = append(problems, "a client created from a stream cannot select peers")
+		}
+		if ec.recorder != nil {
+			problems = append(problems, "a client created from a stream cannot record the raw messages")
+		}
+	}
+	if len(ec.dialOpts) > 0 && (ec.connConfig != nil || ec.peerConfigs != nil || ec.pins != nil || ec.tofu != nil || ec.watchInterval > 0) {
+		problems = append(problems, "dial options exclude the connection configurations, certificate pinning, trust on first use and the TLS file watch")
+	}
+	if ec.errorHistory <= 0 {
+		problems = append(problems, fmt.Sprintf("reconnect error history %d is not positive", ec.errorHistory))
+	}
+	if ec.watchInterval < 0 {
+		problems = append(problems, fmt.Sprintf("negative TLS file watch interval %s", ec.watchInterval))
+	}
+	if ec.shutdownTimeout <= 0 {
+		problems = append(problems, fmt.Sprintf("shutdown timeout %s is not positive", ec.shutdownTimeout))
+	}
+	if ec.staleness < 0 {
+		problems = append(problems, fmt.Sprintf("negative staleness threshold %s", ec.staleness))
+	}
+	if ec.logLevel < logging.CRITICAL || ec.logLevel > logging.DEBUG {
+		problems = append(problems, fmt.Sprintf("unknown log level %d", ec.logLevel))
+	}
+	if ec.pool != nil && ec.replaySize > 0 {
+		problems = append(problems, "a message pool cannot be combined with a replay buffer")
+	}
+	if ec.localAddr != nil && ec.contextDialer != nil {
+		problems = append(problems, "a local address cannot be combined with a context dialer")
+	}
+	if ec.logger == nil {
+		problems = append(problems, "nil logger")
+	}
+	if ec.pins != nil && len(ec.pins.pins) == 0 {
+		problems = append(problems, "certificate pinning without any pin")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid events client configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
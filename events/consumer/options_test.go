@@ -0,0 +1,93 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		opts     []Option
+		problems []string
+	}{
+		{nil, nil},
+		{[]Option{WithBufferSize(10), WithDropWhenFull(), WithReplayBuffer(5)}, nil},
+		{[]Option{WithBufferSize(-1), WithDropWhenFull()}, []string{"negative buffer size -1", "dropping when full requires a buffer"}},
+		{[]Option{WithBufferWatermarks(5, 5)}, []string{"buffer watermarks require a buffer", "low watermark 5 is not between 0 and the high watermark 5"}},
+		{[]Option{WithBufferSize(10), WithBufferWatermarks(20, 2)}, []string{"high watermark 20 is over the buffer size 10"}},
+		{[]Option{WithRegisterRetries(-2), WithRegistrationTimeout(0), WithReplayBuffer(-3)}, []string{"negative register retries -2", "registration timeout 0s is not positive", "negative replay buffer size -3"}},
+		{[]Option{WithRateWindow(0)}, []string{"rate window 0s is too short"}},
+		{[]Option{WithRegistrationDelay(-time.Second, true)}, []string{"negative registration delay -1s"}},
+		{[]Option{WithReconnectSchedule(func(time.Time) bool { return true }, 0)}, []string{"reconnect schedule poll interval 0s is not positive"}},
+		{[]Option{WithRecvTimeout(-time.Second, RecvTimeoutSkipEvent, nil), WithRequireEventWithin(-time.Second, false)}, []string{"negative required event window -1s", "negative recv timeout -1s"}},
+		{[]Option{WithDownstreamHealthCheck(func() bool { return true }, 0)}, []string{"downstream health check interval 0s is not positive"}},
+		{[]Option{WithStalenessThreshold(-time.Second), WithReconnectErrorHistory(0)}, []string{"reconnect error history 0 is not positive", "negative staleness threshold -1s"}},
+		{[]Option{WithCircuitBreaker(CircuitBreakerConfig{Cooldown: -time.Second})}, []string{"circuit breaker failures 0 is not positive", "circuit breaker window 0s is not positive", "negative circuit breaker cooldown -1s"}},
+		{[]Option{WithConnectionConfig(ConnectionConfig{CACertFile: "ca.pem", DialTimeout: -time.Second}), WithCertificatePins([][]byte{{1}}, false)}, []string{"negative dial timeout -1s", "CA certificates are set but TLS is disabled", "certificate pinning requires TLS to be enabled"}},
+		{[]Option{WithConnectionConfig(ConnectionConfig{TLSEnabled: true}), WithCertificatePins(nil, true)}, []string{"certificate pinning without any pin"}},
+		{[]Option{WithConnectionConfig(ConnectionConfig{}), WithTrustOnFirstUse()}, []string{"trust on first use requires TLS to be enabled"}},
+	}
+	for i, test := range tests {
+		err := NewEventsClient("127.0.0.1:7053", newRecordingAdapter(), test.opts...).validate()
+		if len(test.problems) == 0 {
+			if err != nil {
+				t.Errorf("test %d: unexpected error %s", i, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("test %d: expected %v", i, test.problems)
+			continue
+		}
+		if got := strings.Split(strings.TrimPrefix(err.Error(), "invalid events client configuration: "), "; "); strings.Join(got, "|") != strings.Join(test.problems, "|") {
+			t.Errorf("test %d: expected %v, got %v", i, test.problems, got)
+		}
+	}
+}
+
+func TestValidateFromStream(t *testing.T) {
+	stream := struct{ ehpb.Events_ChatClient }{}
+	for _, opt := range []Option{WithReconnectOnServerClose(), WithReconnectOnStreamError(), WithReconnectPolicy(3, time.Millisecond, time.Second)} {
+		err := NewFromStream(stream, newRecordingAdapter(), opt).validate()
+		if err == nil || !strings.Contains(err.Error(), "a client created from a stream cannot reconnect") {
+			t.Errorf("expected reconnecting to be rejected, got %v", err)
+		}
+	}
+}
+
+func TestStartValidates(t *testing.T) {
+	addr, srv, stop := startFakeServer(t, sendBlocks(0))
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter(), WithBufferSize(-1))
+	if err := client.Start(); err == nil || !strings.Contains(err.Error(), "negative buffer size") {
+		t.Fatalf("expected the invalid buffer size to fail Start, got %v", err)
+	}
+	if n := srv.chatCount(); n != 0 {
+		t.Fatalf("expected no connection to the event hub, got %d", n)
+	}
+
+	client = NewEventsClient(addr, nil)
+	if err := client.Start(); err == nil || !strings.Contains(err.Error(), "no adapter") {
+		t.Fatalf("expected the missing adapter to fail Start, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+	"sync"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//outboundMessage is a message waiting in the outbound queue
+type outboundMessage struct {
+	stream ehpb.Events_ChatClient
+	msg    *ehpb.Event
+	hook   func(*ehpb.Event)
+	sent   chan error
+}
+
+//outboundQueue hands the messages sent to the event hub to a single goroutine,
+//as a grpc stream does not support concurrent Send calls. The goroutine runs
+//while messages are queued
+type outboundQueue struct {
+	mutex   sync.Mutex
+	pending []outboundMessage
+	sending bool
+	// routines tracks the sending goroutine
+	routines *goroutineGroup
+}
+
+//send queues msg for stream and waits until it is sent, calling hook before
+func (q *outboundQueue) send(stream ehpb.Events_ChatClient, msg *ehpb.Event, hook func(*ehpb.Event)) error {
+	out := outboundMessage{stream: stream, msg: msg, hook: hook, sent: make(chan error, 1)}
+	q.mutex.Lock()
+	q.pending = append(q.pending, out)
+	if !q.sending {
+		q.sending = true
+		q.routines.spawn(q.run)
+	}
+	q.mutex.Unlock()
+	return <-out.sent
+}
+
+func (q *outboundQueue) run() {
+	for {
+		q.mutex.Lock()
+		if len(q.pending) == 0 {
+			q.sending = false
+			q.mutex.Unlock()
+			return
+		}
+		out := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mutex.Unlock()
+		if out.hook != nil {
+			out.hook(out.msg)
+		}
+		out.sent <- out.stream.Send(out.msg)
+	}
+}
+
+//Send sends msg to the event hub over the current stream and returns once it
+//is sent. The messages of concurrent callers, and the registrations of the
+//client, are sent one at a time in the order they are queued. The event hub
+//only handles registrations: other messages are for event hubs extending the
+//protocol
+func (ec *EventsClient) Send(msg *ehpb.Event) error {
+	if msg == nil {
+		return fmt.Errorf("message must not be nil")
+	}
+	ec.mutex.Lock()
+	stopped, stream := ec.stopped, ec.stream
+	ec.mutex.Unlock()
+	if stopped {
+		return errClientStopped
+	}
+	if stream == nil {
+		return fmt.Errorf("events client not started")
+	}
+	return ec.send(stream, msg)
+}
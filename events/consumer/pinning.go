@@ -0,0 +1,96 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+//CertificatePin returns the SHA-256 hash of the SubjectPublicKeyInfo of cert,
+//the form of pin expected by WithCertificatePins
+func CertificatePin(cert *x509.Certificate) []byte {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return sum[:]
+}
+
+//certificatePins checks that the event hub presents a pinned certificate
+type certificatePins struct {
+	pins     [][]byte
+	verifyCA bool
+}
+
+//verify accepts the handshake if any of the presented certificates matches
+//a pin
+func (p *certificatePins) verify(rawCerts [][]byte) error {
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("could not parse peer certificate: %s", err)
+		}
+		pin := CertificatePin(cert)
+		for _, expected := range p.pins {
+			if bytes.Equal(pin, expected) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no peer certificate matches the configured pins")
+}
+
+//apply prepares config for the pin check
+func (p *certificatePins) apply(config *tls.Config) {
+	// the pins are the only check, the chain is not verified against the CAs
+	config.InsecureSkipVerify = !p.verifyCA
+}
+
+//verifyingCreds runs the verification of a tlsVerifier on the certificates
+//the event hub presented once the TLS handshake is done. It stands for
+//tls.Config.VerifyPeerCertificate, which needs Go 1.8
+type verifyingCreds struct {
+	credentials.TransportAuthenticator
+	verifier tlsVerifier
+}
+
+//ClientHandshake implements credentials.TransportAuthenticator
+func (c verifyingCreds) ClientHandshake(addr string, rawConn net.Conn, timeout time.Duration) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := c.TransportAuthenticator.ClientHandshake(addr, rawConn, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		conn.Close()
+		return nil, nil, fmt.Errorf("the connection to %s is not a TLS connection", addr)
+	}
+	var rawCerts [][]byte
+	for _, cert := range tlsConn.ConnectionState().PeerCertificates {
+		rawCerts = append(rawCerts, cert.Raw)
+	}
+	if err := c.verifier.verify(rawCerts); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, info, nil
+}
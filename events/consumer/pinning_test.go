@@ -0,0 +1,169 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//selfSignedCert returns a certificate valid for 127.0.0.1
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "eventhub"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("could not create certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("could not parse certificate: %s", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert, certPEM
+}
+
+func startTLSFakeServer(t *testing.T, cert tls.Certificate, handle func(n int, stream ehpb.Events_ChatServer) error) (string, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start listener: %s", err)
+	}
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewServerTLSFromCert(&cert)))
+	ehpb.RegisterEventsServer(grpcServer, &fakeEventsServer{handle: handle})
+	go grpcServer.Serve(lis)
+	return lis.Addr().String(), grpcServer.Stop
+}
+
+func TestCertificatePins(t *testing.T) {
+	tlsCert, cert, certPEM := selfSignedCert(t)
+	_, other, _ := selfSignedCert(t)
+	addr, stop := startTLSFakeServer(t, tlsCert, sendBlocks(1))
+	defer stop()
+
+	tests := []struct {
+		name     string
+		caPEM    []byte
+		pin      []byte
+		verifyCA bool
+		ok       bool
+	}{
+		{"pin only", nil, CertificatePin(cert), false, true},
+		{"pin and CA", certPEM, CertificatePin(cert), true, true},
+		{"pin and unknown CA", nil, CertificatePin(cert), true, false},
+		{"wrong pin", certPEM, CertificatePin(other), true, false},
+	}
+	for _, test := range tests {
+		adapter := newRecordingAdapter()
+		config := ConnectionConfig{TLSEnabled: true, CACert: test.caPEM}
+		client := NewEventsClient(addr, adapter, WithConnectionConfig(config), WithCertificatePins([][]byte{test.pin}, test.verifyCA))
+		err := client.Start()
+		if test.ok {
+			if err != nil {
+				t.Errorf("%s: could not start client: %s", test.name, err)
+			} else {
+				adapter.waitEvent(t)
+				client.Stop()
+			}
+		} else if err == nil {
+			t.Errorf("%s: expected the handshake to be rejected", test.name)
+			client.Stop()
+		}
+	}
+}
+
+func TestCertificatePinsRequireTLS(t *testing.T) {
+	client := NewEventsClient("127.0.0.1:7053", newRecordingAdapter(), WithCertificatePins([][]byte{{0}}, false))
+	if err := client.Start(); err == nil {
+		t.Fatalf("expected pinning without TLS to fail")
+	}
+}
+
+func TestTrustOnFirstUse(t *testing.T) {
+	first, _, firstPEM := selfSignedCert(t)
+	second, _, secondPEM := selfSignedCert(t)
+	var switched int32
+	serverConfig := &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if atomic.LoadInt32(&switched) == 1 {
+			return &second, nil
+		}
+		return &first, nil
+	}}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start listener: %s", err)
+	}
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverConfig)))
+	ehpb.RegisterEventsServer(grpcServer, &fakeEventsServer{handle: func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		if err := stream.Send(blockEvent()); err != nil {
+			return err
+		}
+		// the next connection gets another certificate, trusted as well
+		atomic.StoreInt32(&switched, 1)
+		return nil
+	}})
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	adapter := &closeAdapter{newRecordingAdapter(), make(chan CloseReason, 1)}
+	config := ConnectionConfig{TLSEnabled: true, CACert: append(firstPEM, secondPEM...), DialTimeout: 500 * time.Millisecond}
+	client := NewEventsClient(lis.Addr().String(), adapter, WithConnectionConfig(config), WithTrustOnFirstUse(), WithReconnectOnServerClose(), WithBackoff(DefaultBackoff()))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	adapter.waitEvent(t)
+	err = adapter.waitDisconnected(t)
+	if _, ok := err.(*PeerIdentityError); !ok {
+		t.Fatalf("expected a PeerIdentityError, got %v", err)
+	}
+	if reason := <-adapter.reasons; reason != CloseIdentityChanged {
+		t.Fatalf("expected the %s reason, got %s", CloseIdentityChanged, reason)
+	}
+	if err := client.Wait(); err == nil {
+		t.Fatalf("expected the client to terminate with an error")
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"sync"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//WithMessagePool has the client reuse the events it receives instead of
+//allocating one per message: once an event has been delivered, or filtered
+//out or dropped, it is reset and decoded into again by a later receive.
+//Decoding still allocates the content of the event, the block or the
+//chaincode event, so only the Event wrapper itself is saved. The
+//adapter must therefore not keep an event, nor anything it points to, after
+//its Recv returns, which rules out adapters handing the events over to
+//another goroutine like an AsyncEventAdapter. The events returned by
+//WaitForEvent and sent on the channels of Subscribe, SubscribeByType and
+//SubscribeChaincodeEvents are copies. It cannot be combined with a replay
+//buffer, which keeps the delivered events
+func WithMessagePool() Option {
+	return func(ec *EventsClient) {
+		ec.pool = &sync.Pool{New: func() interface{} { return new(ehpb.Event) }}
+	}
+}
+
+//recvEvent receives the next event of stream, into a pooled one when there
+//is a pool
+func (ec *EventsClient) recvEvent(stream ehpb.Events_ChatClient) (*ehpb.Event, error) {
+	if ec.pool == nil {
+		return stream.Recv()
+	}
+	in := ec.pool.Get().(*ehpb.Event)
+	if err := stream.RecvMsg(in); err != nil {
+		ec.releaseEvent(in)
+		return nil, err
+	}
+	return in, nil
+}
+
+//releaseEvent returns in to the pool once the client is done with it
+func (ec *EventsClient) releaseEvent(in *ehpb.Event) {
+	if ec.pool == nil || in == nil {
+		return
+	}
+	in.Reset()
+	ec.pool.Put(in)
+}
@@ -0,0 +1,129 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//encodedStream is a chat stream receiving the same encoded event forever
+type encodedStream struct {
+	ehpb.Events_ChatClient
+	data []byte
+}
+
+func (s *encodedStream) Recv() (*ehpb.Event, error) {
+	m := new(ehpb.Event)
+	if err := s.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (s *encodedStream) RecvMsg(m interface{}) error {
+	return proto.Unmarshal(s.data, m.(proto.Message))
+}
+
+func TestMessagePool(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for _, e := range []*ehpb.Event{blockEvent(), rejectionEvent(), blockEvent()} {
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	// the events are copied since the client reuses them after Recv
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, &copyingAdapter{adapter}, WithMessagePool())
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	for _, rejection := range []bool{false, true, false} {
+		e := adapter.waitEvent(t)
+		if (e.GetRejection() != nil) != rejection || (e.GetBlock() != nil) == rejection {
+			t.Fatalf("unexpected event %v", e)
+		}
+	}
+
+	// the events of Subscribe stay valid while the consumer holds them
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	subscriber := NewEventsClient(addr, newRecordingAdapter(), WithMessagePool())
+	events, _ := subscriber.Subscribe(ctx)
+	var held []*ehpb.Event
+	for len(held) < 3 {
+		held = append(held, <-events)
+	}
+	for i, rejection := range []bool{false, true, false} {
+		if e := held[i]; (e.GetRejection() != nil) != rejection || (e.GetBlock() != nil) == rejection {
+			t.Fatalf("unexpected event %d %v", i, e)
+		}
+	}
+	cancel()
+	for range events {
+	}
+
+	if err := NewEventsClient(addr, adapter, WithMessagePool(), WithReplayBuffer(10)).Start(); err == nil {
+		t.Fatalf("expected a message pool with a replay buffer to be rejected")
+	}
+}
+
+//copyingAdapter delivers a copy of each event to its adapter
+type copyingAdapter struct {
+	EventAdapter
+}
+
+func (a *copyingAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	return a.EventAdapter.Recv(proto.Clone(msg).(*ehpb.Event))
+}
+
+func BenchmarkRecvEventUnpooled(b *testing.B) {
+	benchmarkRecvEvent(b)
+}
+
+func BenchmarkRecvEventPooled(b *testing.B) {
+	benchmarkRecvEvent(b, WithMessagePool())
+}
+
+func benchmarkRecvEvent(b *testing.B, opts ...Option) {
+	data, err := proto.Marshal(blockEvent())
+	if err != nil {
+		b.Fatalf("could not encode the event: %s", err)
+	}
+	stream := &encodedStream{data: data}
+	client := NewEventsClient("127.0.0.1:7053", newRecordingAdapter(), opts...)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		in, err := client.recvEvent(stream)
+		if err != nil {
+			b.Fatalf("could not receive: %s", err)
+		}
+		client.releaseEvent(in)
+	}
+}
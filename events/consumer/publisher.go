@@ -0,0 +1,117 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//Publisher publishes payloads to the topics of a message broker, e.g. Kafka
+//or NATS. It is the seam between a PublisherAdapter and the client library
+//of the broker, which this package does not depend on
+type Publisher interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+}
+
+//PublisherAdapter is an EventAdapter that serializes every received event
+//and publishes it with a Publisher, to the topic Topic returns for it
+type PublisherAdapter struct {
+	publisher Publisher
+	interests []*ehpb.Interest
+
+	//Topic returns the topic an event is published to, DefaultTopic when
+	//unset
+	Topic func(msg *ehpb.Event) string
+
+	//Marshal serializes an event into the published payload, proto.Marshal
+	//when unset
+	Marshal func(msg *ehpb.Event) ([]byte, error)
+
+	//OnError, if set, is called when an event cannot be serialized or
+	//published, and the client goes on with the next event. When unset the
+	//error is returned from Recv, which terminates the client unless
+	//WithReconnectOnAdapterError says otherwise
+	OnError func(msg *ehpb.Event, err error)
+}
+
+//NewPublisherAdapter returns a PublisherAdapter registering the given
+//interests and publishing the events it receives with publisher
+func NewPublisherAdapter(publisher Publisher, interests []*ehpb.Interest) *PublisherAdapter {
+	return &PublisherAdapter{publisher: publisher, interests: interests}
+}
+
+//DefaultTopic returns the lower case name of the event type of msg, e.g.
+//"block", followed for a chaincode event by a dot and its chaincode ID, e.g.
+//"chaincode.mycc". It returns "unknown" for an event without payload
+func DefaultTopic(msg *ehpb.Event) string {
+	et, ok := getEventType(msg)
+	if !ok {
+		return "unknown"
+	}
+	topic := strings.ToLower(et.String())
+	if cc := msg.GetChaincodeEvent(); cc != nil {
+		topic += "." + cc.ChaincodeID
+	}
+	return topic
+}
+
+//GetInterestedEvents implements EventAdapter
+func (p *PublisherAdapter) GetInterestedEvents() ([]*ehpb.Interest, error) {
+	return p.interests, nil
+}
+
+//Recv implements EventAdapter
+func (p *PublisherAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	return p.RecvContext(context.Background(), msg)
+}
+
+//RecvContext implements ContextAdapter: the event is published with ctx, so
+//that a publication in progress is abandoned when the client terminates
+func (p *PublisherAdapter) RecvContext(ctx context.Context, msg *ehpb.Event) (bool, error) {
+	marshal := p.Marshal
+	if marshal == nil {
+		marshal = func(msg *ehpb.Event) ([]byte, error) { return proto.Marshal(msg) }
+	}
+	topic := p.Topic
+	if topic == nil {
+		topic = DefaultTopic
+	}
+	payload, err := marshal(msg)
+	if err != nil {
+		err = fmt.Errorf("error serializing event: %s", err)
+	} else if err = p.publisher.Publish(ctx, topic(msg), payload); err != nil {
+		err = fmt.Errorf("error publishing event: %s", err)
+	}
+	if err == nil {
+		return true, nil
+	}
+	if p.OnError != nil {
+		p.OnError(msg, err)
+		return true, nil
+	}
+	return false, err
+}
+
+//Disconnected implements EventAdapter
+func (p *PublisherAdapter) Disconnected(err error) {
+}
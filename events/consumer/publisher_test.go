@@ -0,0 +1,97 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//memPublisher records the payloads published to each topic, failing with
+//err when set
+type memPublisher struct {
+	mutex    sync.Mutex
+	topics   []string
+	payloads [][]byte
+	err      error
+}
+
+func (p *memPublisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.topics = append(p.topics, topic)
+	p.payloads = append(p.payloads, payload)
+	return nil
+}
+
+func TestPublisherAdapter(t *testing.T) {
+	publisher := &memPublisher{}
+	adapter := NewPublisherAdapter(publisher, []*ehpb.Interest{{EventType: ehpb.EventType_BLOCK}})
+	events := []*ehpb.Event{blockEvent(), chaincodeEvent("mycc", "transfer"), rejectionEvent()}
+	for _, e := range events {
+		if cont, err := adapter.Recv(e); !cont || err != nil {
+			t.Fatalf("expected the event to be published, got %v, %v", cont, err)
+		}
+	}
+	expected := []string{"block", "chaincode.mycc", "rejection"}
+	if len(publisher.topics) != len(expected) {
+		t.Fatalf("expected %d publications, got %v", len(expected), publisher.topics)
+	}
+	for i, topic := range expected {
+		if publisher.topics[i] != topic {
+			t.Fatalf("expected topic %s, got %s", topic, publisher.topics[i])
+		}
+		var e ehpb.Event
+		if err := proto.Unmarshal(publisher.payloads[i], &e); err != nil {
+			t.Fatalf("could not unmarshal payload: %s", err)
+		}
+		if !proto.Equal(&e, events[i]) {
+			t.Fatalf("expected %v to be published, got %v", events[i], &e)
+		}
+	}
+
+	adapter.Topic = func(msg *ehpb.Event) string { return "fabric." + DefaultTopic(msg) }
+	adapter.Recv(blockEvent())
+	if topic := publisher.topics[len(publisher.topics)-1]; topic != "fabric.block" {
+		t.Fatalf("expected the custom topic, got %s", topic)
+	}
+}
+
+func TestPublisherAdapterError(t *testing.T) {
+	publisher := &memPublisher{err: errors.New("broker unavailable")}
+	adapter := NewPublisherAdapter(publisher, nil)
+	if cont, err := adapter.Recv(blockEvent()); cont || err == nil {
+		t.Fatalf("expected the publication failure to stop the client, got %v, %v", cont, err)
+	}
+	var failed *ehpb.Event
+	adapter.OnError = func(msg *ehpb.Event, err error) { failed = msg }
+	if cont, err := adapter.Recv(blockEvent()); !cont || err != nil {
+		t.Fatalf("expected OnError to handle the failure, got %v, %v", cont, err)
+	}
+	if failed == nil {
+		t.Fatal("expected OnError to be called")
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultRateWindow = 10 * time.Second
+	rateBuckets       = 10
+)
+
+//rateMeter computes the events per second over a sliding window, split in
+//rateBuckets buckets so that the window slides by a tenth at a time
+type rateMeter struct {
+	mutex  sync.Mutex
+	window time.Duration
+	counts [rateBuckets]uint64
+	// slots holds the absolute index of the period counted by each bucket
+	slots [rateBuckets]int64
+	now   func() time.Time
+}
+
+func newRateMeter(window time.Duration) *rateMeter {
+	return &rateMeter{window: window, now: time.Now}
+}
+
+func (m *rateMeter) slot() int64 {
+	return m.now().UnixNano() / int64(m.window/rateBuckets)
+}
+
+//add counts an event and returns the updated rate
+func (m *rateMeter) add() float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	slot := m.slot()
+	i := slot % rateBuckets
+	if m.slots[i] != slot {
+		m.slots[i] = slot
+		m.counts[i] = 0
+	}
+	m.counts[i]++
+	return m.rateAt(slot)
+}
+
+func (m *rateMeter) rate() float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.rateAt(m.slot())
+}
+
+func (m *rateMeter) rateAt(slot int64) float64 {
+	var total uint64
+	for i, s := range m.slots {
+		if s > slot-rateBuckets && s <= slot {
+			total += m.counts[i]
+		}
+	}
+	return float64(total) / m.window.Seconds()
+}
+
+//EventsPerSecond returns the average number of events received per second
+//over the rate window (see WithRateWindow)
+func (ec *EventsClient) EventsPerSecond() float64 {
+	return ec.rate.rate()
+}
@@ -0,0 +1,69 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateMeter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	meter := newRateMeter(10 * time.Second)
+	meter.now = func() time.Time { return now }
+
+	// 5 events per second for 20 seconds
+	for i := 0; i < 100; i++ {
+		meter.add()
+		now = now.Add(200 * time.Millisecond)
+	}
+	if rate := meter.rate(); rate < 4.5 || rate > 5.5 {
+		t.Fatalf("expected about 5 events per second, got %f", rate)
+	}
+
+	// the rate decays once the events stop
+	now = now.Add(5 * time.Second)
+	if rate := meter.rate(); rate < 2 || rate > 3 {
+		t.Fatalf("expected about 2.5 events per second, got %f", rate)
+	}
+	now = now.Add(10 * time.Second)
+	if rate := meter.rate(); rate != 0 {
+		t.Fatalf("expected no events in the window, got %f", rate)
+	}
+}
+
+func TestEventsPerSecond(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(4))
+	defer stop()
+
+	metrics := newFakeMetrics()
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithRateWindow(time.Minute), WithMetrics(metrics))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	for i := 0; i < 4; i++ {
+		adapter.waitEvent(t)
+	}
+	if rate := client.EventsPerSecond(); rate != 4.0/60 {
+		t.Fatalf("expected 4 events per minute, got %f per second", rate)
+	}
+	if gauge := metrics.gauge(MetricEventsPerSecond); len(gauge) != 4 || gauge[3] != 4.0/60 {
+		t.Fatalf("unexpected rate gauge %v", gauge)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+//rawRecorder writes the framed raw messages set up with WithRawRecorder
+type rawRecorder struct {
+	mutex   sync.Mutex
+	w       io.Writer
+	onError func(error)
+}
+
+//flush flushes the buffered writes of the writer, and syncs it to disk, when
+//it supports it, like a bufio.Writer or an os.File
+func (r *rawRecorder) flush() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if f, ok := r.w.(interface {
+		Flush() error
+	}); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	if s, ok := r.w.(interface {
+		Sync() error
+	}); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (r *rawRecorder) record(data []byte) {
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	r.mutex.Lock()
+	_, err := r.w.Write(frame)
+	r.mutex.Unlock()
+	if err == nil {
+		return
+	}
+	if r.onError != nil {
+		r.onError(err)
+		return
+	}
+	consumerLogger.Warningf("could not record a received message: %s", err)
+}
+
+//recordingCodec records the received messages before unmarshaling them
+//with next, or with protobuf when next is nil
+type recordingCodec struct {
+	next     grpc.Codec
+	recorder *rawRecorder
+}
+
+func (c *recordingCodec) Marshal(v interface{}) ([]byte, error) {
+	if c.next != nil {
+		return c.next.Marshal(v)
+	}
+	return proto.Marshal(v.(proto.Message))
+}
+
+func (c *recordingCodec) Unmarshal(data []byte, v interface{}) error {
+	c.recorder.record(data)
+	if c.next != nil {
+		return c.next.Unmarshal(data, v)
+	}
+	return proto.Unmarshal(data, v.(proto.Message))
+}
+
+func (c *recordingCodec) String() string {
+	if c.next != nil {
+		return c.next.String()
+	}
+	return "proto"
+}
+
+//ReadRawEvent reads from r the next message recorded with WithRawRecorder
+//and returns its bytes, which proto.Unmarshal decodes into an ehpb.Event. It
+//returns io.EOF at the end of the record
+func ReadRawEvent(r io.Reader) ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(prefix[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("truncated raw message: %s", err)
+	}
+	return data, nil
+}
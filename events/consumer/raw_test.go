@@ -0,0 +1,88 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestRawRecorder(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(2))
+	defer stop()
+
+	var record bytes.Buffer
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithRawRecorder(&record, nil))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	adapter.waitEvent(t)
+	adapter.waitEvent(t)
+	client.Stop()
+	client.Wait()
+
+	// the registration ack, then the two blocks
+	var events []*ehpb.Event
+	for {
+		data, err := ReadRawEvent(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("could not read the record: %s", err)
+		}
+		e := &ehpb.Event{}
+		if err = proto.Unmarshal(data, e); err != nil {
+			t.Fatalf("could not parse a recorded message: %s", err)
+		}
+		events = append(events, e)
+	}
+	if len(events) != 3 || events[0].GetRegister() == nil || !proto.Equal(events[1], blockEvent()) || !proto.Equal(events[2], blockEvent()) {
+		t.Fatalf("unexpected recorded messages %v", events)
+	}
+}
+
+func TestRawRecorderErrors(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(2))
+	defer stop()
+
+	errs := make(chan error, 10)
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithRawRecorder(failingWriter{}, func(err error) { errs <- err }))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	// the events are still delivered
+	adapter.waitEvent(t)
+	adapter.waitEvent(t)
+	if n := len(errs); n != 3 {
+		t.Fatalf("expected a write error per message, got %d", n)
+	}
+}
+
+func TestReadRawEventTruncated(t *testing.T) {
+	if _, err := ReadRawEvent(bytes.NewReader([]byte{0, 0, 0, 5, 1, 2})); err == nil {
+		t.Fatalf("expected a truncated frame to fail")
+	}
+}
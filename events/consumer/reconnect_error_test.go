@@ -0,0 +1,37 @@
+//go:build go1.20
+// +build go1.20
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReconnectErrorUnwrap(t *testing.T) {
+	cause := invalidAckError("invalid ack")
+	err := error(&ReconnectError{Errors: []error{errors.New("overloaded"), cause}, Attempts: 2})
+	var invalidAck invalidAckError
+	if !errors.As(err, &invalidAck) || invalidAck != cause {
+		t.Fatalf("expected errors.As to find the invalid ack in %s", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Fatalf("expected errors.Is to find the invalid ack in %s", err)
+	}
+}
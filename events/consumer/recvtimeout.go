@@ -0,0 +1,103 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//RecvTimeoutPolicy tells what the client does with an event the adapter did
+//not handle within the timeout set with WithRecvTimeout
+type RecvTimeoutPolicy int
+
+const (
+	//RecvTimeoutSkipEvent abandons the adapter call and goes on with the next
+	//event, counted by ClientStats.RecvTimeouts and the MetricRecvTimeouts
+	//counter and handed to the dead letter hook
+	RecvTimeoutSkipEvent RecvTimeoutPolicy = iota
+	//RecvTimeoutStopClient terminates the client with an error
+	RecvTimeoutStopClient
+)
+
+//recvResult is what an adapter call returned
+type recvResult struct {
+	cont bool
+	err  error
+}
+
+//callAdapter passes in to adapter through the richest interface it
+//implements, RecvContext with ctx first. md is the metadata of in, taken
+//under deliverMutex since an abandoned call outlives the delivery
+func (ec *EventsClient) callAdapter(ctx context.Context, adapter EventAdapter, in *ehpb.Event, md EventMetadata) (bool, error) {
+	if ca, ok := adapter.(ContextAdapter); ok {
+		return ca.RecvContext(ctx, in)
+	}
+	if ma, ok := adapter.(MetadataAdapter); ok {
+		return ma.RecvWithMetadata(in, md)
+	}
+	return adapter.Recv(in)
+}
+
+//recvWithTimeout calls the adapter in its own goroutine and stops waiting for
+//it after the timeout of the client, then applies the timeout policy. The
+//context given to a ContextAdapter expires at the timeout, so that it can
+//give up too. When the client is stopped meanwhile the call is waited for,
+//as without a timeout
+func (ec *EventsClient) recvWithTimeout(adapter EventAdapter, in *ehpb.Event, md EventMetadata) (bool, error) {
+	ctx, cancel := withTimeout(ec.clock, ec.recvContext(), ec.recvTimeout)
+	defer cancel()
+	msg := in
+	if ec.pool != nil {
+		// an abandoned call may still use its event once in is recycled
+		msg = proto.Clone(in).(*ehpb.Event)
+	}
+	results := make(chan recvResult, 1)
+	ec.routines.spawn(func() {
+		cont, err := ec.callAdapter(ctx, adapter, msg, md)
+		results <- recvResult{cont: cont, err: err}
+	})
+	select {
+	case r := <-results:
+		return r.cont, r.err
+	case <-ctx.Done():
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		r := <-results
+		return r.cont, r.err
+	}
+	ec.mutex.Lock()
+	ec.stats.recvTimeouts++
+	ec.mutex.Unlock()
+	if ec.metrics != nil {
+		ec.metrics.AddCounter(MetricRecvTimeouts, ec.labels, 1)
+	}
+	if ec.recvTimeoutPolicy == RecvTimeoutStopClient {
+		return false, fmt.Errorf("adapter did not handle the event within %s", ec.recvTimeout)
+	}
+	ec.logf(logging.WARNING, "adapter did not handle the event within %s, skipping it", ec.recvTimeout)
+	if ec.deadLetter != nil {
+		ec.deadLetter(msg)
+	}
+	return true, nil
+}
+
@@ -0,0 +1,158 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//hangingAdapter hangs on the first event until hang is closed, whatever its
+//context says
+type hangingAdapter struct {
+	*recordingAdapter
+	hang  chan struct{}
+	calls int32
+}
+
+func (a *hangingAdapter) RecvContext(ctx context.Context, msg *ehpb.Event) (bool, error) {
+	if atomic.AddInt32(&a.calls, 1) == 1 {
+		<-a.hang
+		return true, nil
+	}
+	return a.recordingAdapter.Recv(msg)
+}
+
+//hangingMetadataAdapter is a hangingAdapter receiving the events with their
+//metadata, recording the sequence numbers it was given
+type hangingMetadataAdapter struct {
+	*recordingAdapter
+	hang      chan struct{}
+	calls     int32
+	sequences chan uint64
+}
+
+func (a *hangingMetadataAdapter) RecvWithMetadata(msg *ehpb.Event, md EventMetadata) (bool, error) {
+	a.sequences <- md.Sequence
+	if atomic.AddInt32(&a.calls, 1) == 1 {
+		<-a.hang
+		return true, nil
+	}
+	return a.recordingAdapter.Recv(msg)
+}
+
+func TestRecvTimeout(t *testing.T) {
+	for _, policy := range []RecvTimeoutPolicy{RecvTimeoutSkipEvent, RecvTimeoutStopClient} {
+		addr, _, stop := startFakeServer(t, sendBlocks(2))
+		adapter := &hangingAdapter{recordingAdapter: newRecordingAdapter(), hang: make(chan struct{})}
+		deadLetters := make(chan *ehpb.Event, 2)
+		metrics := newFakeMetrics()
+		client := NewEventsClient(addr, adapter, WithMetrics(metrics), WithRecvTimeout(50*time.Millisecond, policy, func(msg *ehpb.Event) {
+			deadLetters <- msg
+		}))
+		if err := client.Start(); err != nil {
+			t.Fatalf("could not start client: %s", err)
+		}
+		if policy == RecvTimeoutSkipEvent {
+			if e := adapter.waitEvent(t); e.GetBlock() == nil {
+				t.Fatalf("expected the second block, got %v", e)
+			}
+			select {
+			case e := <-deadLetters:
+				if e.GetBlock() == nil {
+					t.Fatalf("expected the abandoned block as dead letter, got %v", e)
+				}
+			default:
+				t.Fatal("expected the abandoned event to be dead lettered")
+			}
+			if n := client.Stats().RecvTimeouts; n != 1 {
+				t.Fatalf("expected 1 recv timeout, got %d", n)
+			}
+			if n := metrics.counter(MetricRecvTimeouts); n != 1 {
+				t.Fatalf("expected the recv timeout metric to be 1, got %v", n)
+			}
+			close(adapter.hang)
+			client.Stop()
+		} else {
+			err := client.Wait()
+			if err == nil || !strings.Contains(err.Error(), "did not handle the event within 50ms") {
+				t.Fatalf("expected the client to stop on the timeout, got %v", err)
+			}
+			if len(deadLetters) != 0 {
+				t.Fatal("expected no dead letter when stopping the client")
+			}
+			close(adapter.hang)
+		}
+		stop()
+	}
+}
+
+func TestRecvTimeoutMetadata(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(2))
+	defer stop()
+	adapter := &hangingMetadataAdapter{recordingAdapter: newRecordingAdapter(), hang: make(chan struct{}), sequences: make(chan uint64, 2)}
+	client := NewEventsClient(addr, adapter, WithRecvTimeout(50*time.Millisecond, RecvTimeoutSkipEvent, nil))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	defer close(adapter.hang)
+	adapter.waitEvent(t)
+	// the abandoned call got the metadata of its own event
+	for want := uint64(1); want <= 2; want++ {
+		if seq := <-adapter.sequences; seq != want {
+			t.Fatalf("expected sequence %d, got %d", want, seq)
+		}
+	}
+}
+
+func TestRecvTimeoutPooledDeadLetter(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for _, e := range []*ehpb.Event{blockEvent(), rejectionEvent(), rejectionEvent()} {
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+	adapter := &hangingAdapter{recordingAdapter: newRecordingAdapter(), hang: make(chan struct{})}
+	deadLetters := make(chan *ehpb.Event, 1)
+	client := NewEventsClient(addr, adapter, WithMessagePool(), WithRecvTimeout(50*time.Millisecond, RecvTimeoutSkipEvent, func(msg *ehpb.Event) {
+		deadLetters <- msg
+	}))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	defer close(adapter.hang)
+	adapter.waitEvent(t)
+	adapter.waitEvent(t)
+	// the kept dead letter is not recycled for the events that followed
+	if e := <-deadLetters; e.GetBlock() == nil {
+		t.Fatalf("expected the dead letter to still be the abandoned block, got %v", e)
+	}
+}
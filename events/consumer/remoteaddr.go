@@ -0,0 +1,72 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"net"
+	"time"
+)
+
+//remoteConn is a network connection dialed by the client, which stops being
+//the current one once closed
+type remoteConn struct {
+	net.Conn
+	ec *EventsClient
+}
+
+func (c *remoteConn) Close() error {
+	c.ec.remoteMutex.Lock()
+	if c.ec.remote == c {
+		c.ec.remote = nil
+	}
+	c.ec.remoteMutex.Unlock()
+	return c.Conn.Close()
+}
+
+//trackRemoteAddr wraps dial to make every connection it opens the current
+//one of the client
+func (ec *EventsClient) trackRemoteAddr(dial func(string, time.Duration) (net.Conn, error)) func(string, time.Duration) (net.Conn, error) {
+	return func(addr string, timeout time.Duration) (net.Conn, error) {
+		conn, err := dial(addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		rc := &remoteConn{Conn: conn, ec: ec}
+		ec.remoteMutex.Lock()
+		ec.remote = rc
+		ec.remoteMutex.Unlock()
+		return rc, nil
+	}
+}
+
+//RemoteAddr returns the remote address of the network connection the client
+//currently uses, i.e. the peer instance a DNS name or a load balancer
+//resolved PeerAddress to, or the empty string when the client is not
+//connected or has terminated. It is updated on every connection and
+//reconnection, including those gRPC makes on its own when the transport
+//breaks
+func (ec *EventsClient) RemoteAddr() string {
+	if ec.Context().Err() != nil {
+		return ""
+	}
+	ec.remoteMutex.Lock()
+	defer ec.remoteMutex.Unlock()
+	if ec.remote == nil {
+		return ""
+	}
+	return ec.remote.RemoteAddr().String()
+}
@@ -0,0 +1,219 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//ReorderingAdapter holds the received events for a window and delivers them
+//to the wrapped adapter in the order of their timestamps, e.g. when
+//reconnections or several clients feeding one adapter interleave them. See
+//ReorderAdapter
+type ReorderingAdapter struct {
+	next   EventAdapter
+	window time.Duration
+
+	mutex    sync.Mutex
+	held     []reorderedEvent
+	released time.Time
+	latest   time.Time
+	stopped  bool
+	stopErr  error
+	closed   bool
+	wake     chan struct{}
+	done     chan struct{}
+	exited   chan struct{}
+	once     sync.Once
+
+	// deliverMutex serializes the calls to next
+	deliverMutex sync.Mutex
+	late         uint64
+
+	//OnLate, if set, is called with each event arriving after an event with
+	//a later timestamp was delivered, i.e. too late for the window to
+	//reorder it. The event is then delivered right away, out of order
+	OnLate func(msg *ehpb.Event)
+
+	//OnError, if set, is called with the errors of the wrapped adapter's
+	//Recv. Like OnLate it must be set before the first event is received
+	OnError func(msg *ehpb.Event, err error)
+}
+
+//reorderedEvent is an event held by a ReorderingAdapter
+type reorderedEvent struct {
+	msg     *ehpb.Event
+	at      time.Time
+	arrived time.Time
+}
+
+//ReorderAdapter wraps next so that every event is held for up to window and
+//delivered in the order of the timestamps of the blocks and the rejected
+//transactions. An event without timestamp, like a chaincode event, keeps its
+//place after the events received before it. An event is delivered once the
+//event held for the longest has been held for window, so delivering in order
+//costs up to window of latency per event; an event arriving after a later
+//one was delivered is delivered at once, counted by LateEvents and passed to
+//OnLate. As Recv returns before next has seen the event, errors are surfaced
+//through OnError; once next asks to stop, the following Recv stops the
+//client. Disconnected delivers the held events before calling
+//next.Disconnected
+func ReorderAdapter(next EventAdapter, window time.Duration) *ReorderingAdapter {
+	a := &ReorderingAdapter{next: next, window: window, wake: make(chan struct{}, 1), done: make(chan struct{}), exited: make(chan struct{})}
+	go a.run()
+	return a
+}
+
+//LateEvents returns the number of events which arrived too late to be
+//reordered
+func (a *ReorderingAdapter) LateEvents() uint64 {
+	return atomic.LoadUint64(&a.late)
+}
+
+//GetInterestedEvents implements EventAdapter
+func (a *ReorderingAdapter) GetInterestedEvents() ([]*ehpb.Interest, error) {
+	return a.next.GetInterestedEvents()
+}
+
+//Recv implements EventAdapter by holding msg until its turn comes
+func (a *ReorderingAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	now := time.Now()
+	a.mutex.Lock()
+	if a.stopped {
+		defer a.mutex.Unlock()
+		return false, a.stopErr
+	}
+	if a.closed {
+		a.mutex.Unlock()
+		return false, nil
+	}
+	at, ok := eventTimestamp(msg)
+	if !ok {
+		at = a.latest
+	}
+	if !a.released.IsZero() && at.Before(a.released) {
+		a.mutex.Unlock()
+		atomic.AddUint64(&a.late, 1)
+		if a.OnLate != nil {
+			a.OnLate(msg)
+		}
+		a.deliver(msg)
+		return true, nil
+	}
+	if at.After(a.latest) {
+		a.latest = at
+	}
+	e := reorderedEvent{msg: msg, at: at, arrived: now}
+	i := sort.Search(len(a.held), func(i int) bool { return a.held[i].at.After(at) })
+	a.held = append(a.held, reorderedEvent{})
+	copy(a.held[i+1:], a.held[i:])
+	a.held[i] = e
+	a.mutex.Unlock()
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+	return true, nil
+}
+
+//run delivers the held events as their window expires, until Disconnected
+func (a *ReorderingAdapter) run() {
+	defer close(a.exited)
+	for {
+		due, wait := a.takeDue(time.Now())
+		for _, e := range due {
+			a.deliver(e.msg)
+		}
+		var timer <-chan time.Time
+		if wait > 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-a.wake:
+		case <-timer:
+		case <-a.done:
+			return
+		}
+	}
+}
+
+//takeDue removes the events to deliver at now, in order: as long as the
+//event held for the longest has been held for the window, the event with
+//the earliest timestamp is due. It returns how long until the next events
+//are due, zero if none is held
+func (a *ReorderingAdapter) takeDue(now time.Time) ([]reorderedEvent, time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	var due []reorderedEvent
+	for len(a.held) > 0 {
+		oldest := a.held[0].arrived
+		for _, e := range a.held[1:] {
+			if e.arrived.Before(oldest) {
+				oldest = e.arrived
+			}
+		}
+		if wait := oldest.Add(a.window).Sub(now); wait > 0 {
+			return due, wait
+		}
+		due = append(due, a.held[0])
+		a.released = a.held[0].at
+		a.held = a.held[1:]
+	}
+	return due, 0
+}
+
+//deliver passes msg to next, recording its request to stop
+func (a *ReorderingAdapter) deliver(msg *ehpb.Event) {
+	a.deliverMutex.Lock()
+	cont, err := a.next.Recv(msg)
+	a.deliverMutex.Unlock()
+	if err != nil && a.OnError != nil {
+		a.OnError(msg, err)
+	}
+	if !cont {
+		a.mutex.Lock()
+		if !a.stopped {
+			a.stopped, a.stopErr = true, err
+		}
+		a.mutex.Unlock()
+	}
+}
+
+//Disconnected implements EventAdapter. It delivers the held events in order
+//before passing err on to the wrapped adapter
+func (a *ReorderingAdapter) Disconnected(err error) {
+	a.once.Do(func() {
+		a.mutex.Lock()
+		a.closed = true
+		a.mutex.Unlock()
+		close(a.done)
+		<-a.exited
+		a.mutex.Lock()
+		held := a.held
+		a.held = nil
+		a.mutex.Unlock()
+		for _, e := range held {
+			a.deliver(e.msg)
+		}
+		a.next.Disconnected(err)
+	})
+}
@@ -0,0 +1,68 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestReorderAdapter(t *testing.T) {
+	next := newRecordingAdapter()
+	adapter := ReorderAdapter(next, 100*time.Millisecond)
+	var late []*ehpb.Event
+	adapter.OnLate = func(msg *ehpb.Event) { late = append(late, msg) }
+	expectOrder := func(offsets ...time.Duration) {
+		for _, offset := range offsets {
+			e := next.waitEvent(t)
+			if at, _ := eventTimestamp(e); !at.Equal(time.Unix(1000000, 0).Add(offset)) {
+				t.Fatalf("expected the block at +%s, got one at %s", offset, at)
+			}
+		}
+	}
+
+	// the arrivals within the window are delivered in order once it expires
+	begin := time.Now()
+	for _, offset := range []time.Duration{2 * time.Hour, 0, time.Hour} {
+		if cont, err := adapter.Recv(timedBlock(offset)); !cont || err != nil {
+			t.Fatalf("expected the event to be held, got %v, %v", cont, err)
+		}
+	}
+	expectOrder(0, time.Hour, 2*time.Hour)
+	if elapsed := time.Since(begin); elapsed < 100*time.Millisecond {
+		t.Fatalf("expected the events to be held for the window, delivered after %s", elapsed)
+	}
+
+	// an event older than those delivered is delivered at once and flagged
+	adapter.Recv(timedBlock(30 * time.Minute))
+	expectOrder(30 * time.Minute)
+	if len(late) != 1 || adapter.LateEvents() != 1 {
+		t.Fatalf("expected 1 late event, got %d reported and %d counted", len(late), adapter.LateEvents())
+	}
+
+	// the held events are delivered before the disconnection
+	adapter.Recv(timedBlock(4 * time.Hour))
+	adapter.Recv(timedBlock(3 * time.Hour))
+	adapter.Disconnected(nil)
+	expectOrder(3*time.Hour, 4*time.Hour)
+	next.waitDisconnected(t)
+	if cont, _ := adapter.Recv(timedBlock(5 * time.Hour)); cont {
+		t.Fatal("expected the adapter to stop the client once disconnected")
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//replayRing keeps the last events delivered to the adapter
+type replayRing struct {
+	events []*ehpb.Event
+	next   int
+	full   bool
+}
+
+func newReplayRing(size int) *replayRing {
+	return &replayRing{events: make([]*ehpb.Event, size)}
+}
+
+func (r *replayRing) add(e *ehpb.Event) {
+	r.events[r.next] = e
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+//snapshot returns the kept events, oldest first
+func (r *replayRing) snapshot() []*ehpb.Event {
+	if !r.full {
+		return append([]*ehpb.Event(nil), r.events[:r.next]...)
+	}
+	return append(append([]*ehpb.Event(nil), r.events[r.next:]...), r.events[:r.next]...)
+}
+
+//ReplayTo delivers to adapter, oldest first, the events kept by the replay
+//buffer (see WithReplayBuffer), e.g. to bring a newly attached adapter up to
+//date. Live delivery is suspended during the replay, so that the events
+//delivered next follow the replayed ones. The replay ends early if adapter
+//asks to stop, with the error it returned
+func (ec *EventsClient) ReplayTo(adapter EventAdapter) error {
+	if ec.replay == nil {
+		return nil
+	}
+	ec.deliverMutex.Lock()
+	defer ec.deliverMutex.Unlock()
+	for _, e := range ec.replay.snapshot() {
+		if cont, err := adapter.Recv(e); !cont || err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestReplayTo(t *testing.T) {
+	events := []*ehpb.Event{blockEvent(), chaincodeEvent("mycc", "a"), rejectionEvent(), chaincodeEvent("mycc", "b")}
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for _, e := range events {
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithReplayBuffer(3))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	for range events {
+		adapter.waitEvent(t)
+	}
+
+	late := newRecordingAdapter()
+	if err := client.ReplayTo(late); err != nil {
+		t.Fatalf("replay failed: %s", err)
+	}
+	if n := len(late.events); n != 3 {
+		t.Fatalf("expected the last 3 events to be replayed, got %d", n)
+	}
+	for _, expected := range events[1:] {
+		if e := <-late.events; !proto.Equal(e, expected) {
+			t.Fatalf("expected %v to be replayed, got %v", expected, e)
+		}
+	}
+}
+
+func TestReplayRingPartial(t *testing.T) {
+	ring := newReplayRing(3)
+	e := blockEvent()
+	ring.add(e)
+	if events := ring.snapshot(); len(events) != 1 || events[0] != e {
+		t.Fatalf("unexpected snapshot %v", events)
+	}
+}
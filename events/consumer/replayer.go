@@ -0,0 +1,141 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//Replayer feeds the events recorded by a FileSink to an adapter the way the
+//client delivers the events of the event hub, e.g. to test an adapter against
+//captured traffic. Only the events matching the interested events of the
+//adapter are delivered, in the recorded order
+type Replayer struct {
+	events  *EventFileReader
+	adapter EventAdapter
+
+	//Speed, if positive, paces the delivery by the timestamps recorded in the
+	//events, the blocks' and the rejected transactions', the time between two
+	//events divided by Speed: 1 replays at the recorded pace, 2 twice as fast.
+	//The events without a timestamp are delivered at once. When zero the
+	//events are delivered as fast as possible
+	Speed float64
+	//MaxRate, if positive, is the maximum number of events delivered per
+	//second, whatever the recorded pace
+	MaxRate float64
+}
+
+//NewReplayer returns a Replayer delivering to adapter the events read from r
+func NewReplayer(r io.Reader, adapter EventAdapter) *Replayer {
+	return &Replayer{events: NewEventFileReader(r), adapter: adapter}
+}
+
+//Run delivers the events until the end of the input, the adapter stops
+//consuming or ctx is done. As with the client, the adapter's Disconnected is
+//called with nil at the end of the input or when the adapter returns
+//ErrStopConsuming, and with the error when the input cannot be read, and the
+//replay stops without calling it when Recv returns false otherwise. The
+//returned error is the one of the input, of the adapter or ctx.Err()
+func (r *Replayer) Run(ctx context.Context) error {
+	interests, err := r.adapter.GetInterestedEvents()
+	if err != nil {
+		return err
+	}
+	var last time.Time
+	var delivered time.Time
+	for {
+		msg, err := r.events.Next()
+		if err == io.EOF {
+			r.adapter.Disconnected(nil)
+			return nil
+		}
+		if err != nil {
+			r.adapter.Disconnected(err)
+			return err
+		}
+		if !interested(interests, msg) {
+			continue
+		}
+		var delay time.Duration
+		if recorded, ok := eventTimestamp(msg); ok && r.Speed > 0 {
+			if !last.IsZero() && recorded.After(last) {
+				delay = time.Duration(float64(recorded.Sub(last)) / r.Speed)
+			}
+			last = recorded
+		}
+		if r.MaxRate > 0 && !delivered.IsZero() {
+			min := time.Duration(float64(time.Second) / r.MaxRate)
+			if wait := min - time.Since(delivered); wait > delay {
+				delay = wait
+			}
+		}
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		delivered = time.Now()
+		cont, err := r.adapter.Recv(msg)
+		if err == ErrStopConsuming {
+			r.adapter.Disconnected(nil)
+			return nil
+		}
+		if !cont {
+			return err
+		}
+	}
+}
+
+//interested reports whether the event hub sends msg to a consumer
+//registered with interests
+func interested(interests []*ehpb.Interest, msg *ehpb.Event) bool {
+	for _, interest := range interests {
+		switch {
+		case interest.EventType == ehpb.EventType_BLOCK && msg.GetBlock() != nil:
+			return true
+		case interest.EventType == ehpb.EventType_REJECTION && msg.GetRejection() != nil:
+			return true
+		case interest.EventType == ehpb.EventType_CHAINCODE && msg.GetChaincodeEvent() != nil:
+			reg, event := interest.GetChaincodeRegInfo(), msg.GetChaincodeEvent()
+			if reg != nil && reg.ChaincodeID == event.ChaincodeID && (reg.EventName == "" || reg.EventName == event.EventName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//eventTimestamp returns the time recorded in msg, if any
+func eventTimestamp(msg *ehpb.Event) (time.Time, bool) {
+	ts := msg.GetBlock().GetTimestamp()
+	if ts == nil {
+		ts = msg.GetRejection().GetTx().GetTimestamp()
+	}
+	if ts == nil {
+		return time.Time{}, false
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)), true
+}
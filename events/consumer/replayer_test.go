@@ -0,0 +1,105 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	google_protobuf "google/protobuf"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//recording returns events in the format of a FileSink
+func recording(t *testing.T, events ...*ehpb.Event) *bytes.Buffer {
+	var buf bytes.Buffer
+	for _, e := range events {
+		data, err := proto.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(proto.EncodeVarint(uint64(len(data))))
+		buf.Write(data)
+	}
+	return &buf
+}
+
+func timedBlock(offset time.Duration) *ehpb.Event {
+	at := time.Unix(1000000, 0).Add(offset)
+	block := &ehpb.Block{Timestamp: &google_protobuf.Timestamp{Seconds: at.Unix(), Nanos: int32(at.Nanosecond())}}
+	return &ehpb.Event{Event: &ehpb.Event_Block{Block: block}}
+}
+
+func TestReplayerOrder(t *testing.T) {
+	events := []*ehpb.Event{timedBlock(0), chaincodeEvent("mycc", "a"), timedBlock(time.Hour), rejectionEvent(), timedBlock(2 * time.Hour)}
+	adapter := newRecordingAdapter()
+	if err := NewReplayer(recording(t, events...), adapter).Run(context.Background()); err != nil {
+		t.Fatalf("replay failed: %s", err)
+	}
+	// the recording adapter is only interested in blocks, delivered as fast
+	// as possible
+	for _, i := range []int{0, 2, 4} {
+		if e := adapter.waitEvent(t); !proto.Equal(e, events[i]) {
+			t.Fatalf("expected %v, got %v", events[i], e)
+		}
+	}
+	if err := adapter.waitDisconnected(t); err != nil {
+		t.Fatalf("expected a clean end of replay, got %s", err)
+	}
+	if len(adapter.events) != 0 {
+		t.Fatalf("unexpected events %d", len(adapter.events))
+	}
+}
+
+func TestReplayerPacing(t *testing.T) {
+	events := recording(t, timedBlock(0), timedBlock(time.Second), timedBlock(2*time.Second))
+	replayer := NewReplayer(events, newRecordingAdapter())
+	replayer.Speed = 20
+	start := time.Now()
+	if err := replayer.Run(context.Background()); err != nil {
+		t.Fatalf("replay failed: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("expected the replay to last 2s/20, took %s", elapsed)
+	}
+
+	events = recording(t, timedBlock(0), timedBlock(0), timedBlock(0), timedBlock(0), timedBlock(0))
+	replayer = NewReplayer(events, newRecordingAdapter())
+	replayer.MaxRate = 100
+	start = time.Now()
+	if err := replayer.Run(context.Background()); err != nil {
+		t.Fatalf("replay failed: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("expected 5 events at 100 per second to take 40ms, took %s", elapsed)
+	}
+}
+
+func TestReplayerContextCancelled(t *testing.T) {
+	events := recording(t, timedBlock(0), timedBlock(time.Hour))
+	replayer := NewReplayer(events, newRecordingAdapter())
+	replayer.Speed = 1
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := replayer.Run(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the replay to stop with ctx, got %v", err)
+	}
+}
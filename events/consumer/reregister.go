@@ -0,0 +1,120 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+
+	"github.com/op/go-logging"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//Reregister sends the Register message for the interested events, those of
+//the adapter or of the interest provider, again over the current stream,
+//e.g. after the event hub lost its subscription state, and waits for the
+//ack within the registration timeout. It is cheaper than replacing the
+//stream: the connection is kept and no event is lost. The message goes through the outbound queue like Send, and
+//the ack is taken by the receive loop, which goes on delivering the events
+//meanwhile. One re-registration can be in progress at a time. When
+//WithRegisterBatches splits the interests, the messages are sent and
+//acknowledged one after the other; a failure leaves the interests of the
+//messages acknowledged before registered on the stream
+func (ec *EventsClient) Reregister() error {
+	ec.mutex.Lock()
+	stopped, started, stream := ec.stopped, ec.started, ec.stream
+	ec.mutex.Unlock()
+	if stopped {
+		return errClientStopped
+	}
+	if !started || stream == nil {
+		return fmt.Errorf("events client not started")
+	}
+	ies, err := ec.interestedEvents()
+	if err != nil {
+		return fmt.Errorf("error getting interested events:%s", err)
+	}
+	if len(ies) == 0 {
+		return fmt.Errorf("must supply interested events")
+	}
+	emsg, err := ec.registerMessage(ies)
+	if err != nil {
+		return err
+	}
+
+	ack := make(chan *ehpb.Register, 1)
+	ec.mutex.Lock()
+	if ec.reregisterAck != nil {
+		ec.mutex.Unlock()
+		return fmt.Errorf("a re-registration is already in progress")
+	}
+	ec.reregisterAck = ack
+	ec.mutex.Unlock()
+	defer func() {
+		ec.mutex.Lock()
+		ec.reregisterAck = nil
+		ec.mutex.Unlock()
+	}()
+
+	peerAddress := ec.PeerAddress()
+	ec.registrationAttempted(peerAddress)
+	err = ec.awaitReregister(ack, stream, emsg.GetRegister())
+	ec.registrationEnded(peerAddress, err)
+	return err
+}
+
+//awaitReregister sends the Register messages of reg over stream and waits
+//for each to be acknowledged on ack
+func (ec *EventsClient) awaitReregister(ack chan *ehpb.Register, stream ehpb.Events_ChatClient, reg *ehpb.Register) error {
+	ctx, cancel := withTimeout(ec.clock, ec.Context(), ec.registrationTimeout)
+	defer cancel()
+	ec.logf(logging.DEBUG, "re-registering %d interested events", len(reg.Events))
+	for _, batch := range ec.registerBatches(reg) {
+		begin := ec.clock.Now()
+		if err := ec.send(stream, batch); err != nil {
+			ec.logf(logging.ERROR, "error on Register send %s", err)
+			return err
+		}
+		select {
+		case acked := <-ack:
+			if err := ec.checkAccepted(batch.GetRegister(), acked); err != nil {
+				return err
+			}
+			ec.registered(ec.clock.Now().Sub(begin))
+		case <-ctx.Done():
+			return registrationAborted(ctx, "waiting for")
+		}
+	}
+	ec.setRegistered(reg.Events)
+	return nil
+}
+
+//reregisterAcked hands ack, a registration ack received by the receive loop,
+//to Reregister, and tells whether one was waiting for it
+func (ec *EventsClient) reregisterAcked(ack *ehpb.Register) bool {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if ec.reregisterAck == nil {
+		return false
+	}
+	select {
+	case ec.reregisterAck <- ack:
+		return true
+	default:
+		return false
+	}
+}
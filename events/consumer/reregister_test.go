@@ -0,0 +1,95 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestReregister(t *testing.T) {
+	registers := make(chan *ehpb.Register, 1)
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		if err := stream.Send(blockEvent()); err != nil {
+			return err
+		}
+		in, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		registers <- in.GetRegister()
+		if n == 1 {
+			// only the re-registration of the first client is acknowledged
+			if err := stream.Send(in); err != nil {
+				return err
+			}
+		}
+		if err := stream.Send(blockEvent()); err != nil {
+			return err
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	interests := []*ehpb.Interest{
+		{EventType: ehpb.EventType_BLOCK},
+		{EventType: ehpb.EventType_REJECTION},
+	}
+	adapter := &interestsAdapter{newRecordingAdapter(), interests}
+	client := NewEventsClient(addr, adapter)
+	if err := client.Reregister(); err == nil {
+		t.Fatal("expected Reregister to fail before Start")
+	}
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	adapter.waitEvent(t)
+	if err := client.Reregister(); err != nil {
+		t.Fatalf("could not re-register: %s", err)
+	}
+	if reg := <-registers; len(reg.Events) != len(interests) {
+		t.Fatalf("expected the %d interested events to be re-registered, got %v", len(interests), reg.Events)
+	}
+	if e := adapter.waitEvent(t); e.GetBlock() == nil {
+		t.Fatalf("expected the ack not to be delivered, got %v", e)
+	}
+	if n := client.Stats().RegistrationAttempts; n != 2 {
+		t.Fatalf("expected 2 registration attempts, got %d", n)
+	}
+	client.Stop()
+
+	adapter = &interestsAdapter{newRecordingAdapter(), interests}
+	client = NewEventsClient(addr, adapter, WithRegistrationTimeout(100*time.Millisecond))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	if err := client.Reregister(); err == nil || !strings.Contains(err.Error(), "timeout waiting for registration") {
+		t.Fatalf("expected the re-registration to time out, got %v", err)
+	}
+	<-registers
+	if e := adapter.waitEvent(t); e.GetBlock() == nil {
+		t.Fatalf("expected the stream to go on after the timeout, got %v", e)
+	}
+}
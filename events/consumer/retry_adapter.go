@@ -0,0 +1,91 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//RetryPolicy controls how RetryAdapter retries a failing Recv
+type RetryPolicy struct {
+	//MaxAttempts is the total number of Recv calls made for an event,
+	//including the first one. Values below 1 are treated as 1
+	MaxAttempts int
+	//Backoff is the delay before the first retry. It doubles on every
+	//further retry, up to MaxBackoff when MaxBackoff is set
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	//Retryable, if set, limits retries to the errors it accepts; other
+	//errors are returned immediately
+	Retryable func(error) bool
+}
+
+type retryAdapter struct {
+	EventAdapter
+	policy RetryPolicy
+}
+
+//RetryAdapter wraps next so that a failing Recv is retried according to
+//policy before its error is returned to the client. ErrStopConsuming is
+//never retried. The backoff waits are measured by the clock of the client
+//and end with the context of RecvContext, so Stop does not wait for them
+func RetryAdapter(next EventAdapter, policy RetryPolicy) EventAdapter {
+	return &retryAdapter{EventAdapter: next, policy: policy}
+}
+
+//Recv implements EventAdapter
+func (r *retryAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	return r.RecvContext(context.Background(), msg)
+}
+
+//RecvContext implements ContextAdapter: a backoff wait is abandoned once ctx
+//is done, returning the last error, and ctx is passed on to next when it is
+//a ContextAdapter
+func (r *retryAdapter) RecvContext(ctx context.Context, msg *ehpb.Event) (bool, error) {
+	clock := clockFrom(ctx)
+	delay := r.policy.Backoff
+	for attempt := 1; ; attempt++ {
+		cont, err := r.recv(ctx, msg)
+		if err == nil || err == ErrStopConsuming || attempt >= r.policy.MaxAttempts {
+			return cont, err
+		}
+		if r.policy.Retryable != nil && !r.policy.Retryable(err) {
+			return cont, err
+		}
+		consumerLogger.Debugf("retrying event delivery after error (attempt %d): %s", attempt, err)
+		select {
+		case <-clock.After(delay):
+		case <-ctx.Done():
+			return cont, err
+		}
+		delay *= 2
+		if r.policy.MaxBackoff > 0 && delay > r.policy.MaxBackoff {
+			delay = r.policy.MaxBackoff
+		}
+	}
+}
+
+func (r *retryAdapter) recv(ctx context.Context, msg *ehpb.Event) (bool, error) {
+	if ca, ok := r.EventAdapter.(ContextAdapter); ok {
+		return ca.RecvContext(ctx, msg)
+	}
+	return r.EventAdapter.Recv(msg)
+}
@@ -0,0 +1,130 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+var errTransient = errors.New("transient")
+
+//flakyAdapter fails the first failures calls to Recv
+type flakyAdapter struct {
+	*recordingAdapter
+	failures int
+	calls    int
+	err      error
+}
+
+func (a *flakyAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	a.calls++
+	if a.calls <= a.failures {
+		return false, a.err
+	}
+	return true, nil
+}
+
+func TestRetryAdapterTransientFailure(t *testing.T) {
+	next := &flakyAdapter{recordingAdapter: newRecordingAdapter(), failures: 2, err: errTransient}
+	adapter := RetryAdapter(next, RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	if cont, err := adapter.Recv(blockEvent()); !cont || err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %t, %v", cont, err)
+	}
+	if next.calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", next.calls)
+	}
+}
+
+func TestRetryAdapterExhausted(t *testing.T) {
+	next := &flakyAdapter{recordingAdapter: newRecordingAdapter(), failures: 10, err: errTransient}
+	adapter := RetryAdapter(next, RetryPolicy{MaxAttempts: 4, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond})
+	if cont, err := adapter.Recv(blockEvent()); cont || err != errTransient {
+		t.Fatalf("expected the error after exhausting retries, got %t, %v", cont, err)
+	}
+	if next.calls != 4 {
+		t.Fatalf("expected 4 calls, got %d", next.calls)
+	}
+}
+
+func TestRetryAdapterPermanentFailure(t *testing.T) {
+	permanent := errors.New("permanent")
+	next := &flakyAdapter{recordingAdapter: newRecordingAdapter(), failures: 10, err: permanent}
+	policy := RetryPolicy{MaxAttempts: 5, Retryable: func(err error) bool { return err == errTransient }}
+	if _, err := RetryAdapter(next, policy).Recv(blockEvent()); err != permanent {
+		t.Fatalf("expected the permanent error, got %v", err)
+	}
+	if next.calls != 1 {
+		t.Fatalf("permanent errors should not be retried, got %d calls", next.calls)
+	}
+}
+
+func TestRetryAdapterStopConsuming(t *testing.T) {
+	next := &flakyAdapter{recordingAdapter: newRecordingAdapter(), failures: 10, err: ErrStopConsuming}
+	adapter := RetryAdapter(next, RetryPolicy{MaxAttempts: 5, Backoff: time.Hour})
+	if _, err := adapter.Recv(blockEvent()); err != ErrStopConsuming {
+		t.Fatalf("expected ErrStopConsuming, got %v", err)
+	}
+	if next.calls != 1 {
+		t.Fatalf("ErrStopConsuming should not be retried, got %d calls", next.calls)
+	}
+}
+
+//alwaysFailingAdapter fails every Recv, counting the calls
+type alwaysFailingAdapter struct {
+	*recordingAdapter
+	calls int32
+}
+
+func (a *alwaysFailingAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	atomic.AddInt32(&a.calls, 1)
+	return false, errTransient
+}
+
+func TestRetryAdapterStopInterruptsBackoff(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	clock := newFakeClock()
+	next := &alwaysFailingAdapter{recordingAdapter: newRecordingAdapter()}
+	client := NewEventsClient(addr, RetryAdapter(next, RetryPolicy{MaxAttempts: 5, Backoff: time.Hour}), withClock(clock))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	// the backoff waits on the clock of the client
+	waitFor(t, "the first backoff", func() bool { return clock.pending() > 0 && atomic.LoadInt32(&next.calls) == 1 })
+	clock.advance(time.Hour)
+	waitFor(t, "the second attempt", func() bool { return atomic.LoadInt32(&next.calls) == 2 })
+
+	stopped := make(chan struct{})
+	go func() {
+		client.StopAndDrain()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Stop to interrupt the backoff wait")
+	}
+	if n := atomic.LoadInt32(&next.calls); n != 2 {
+		t.Fatalf("expected no attempt after Stop, got %d calls", n)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"math/rand"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//WithSampleRate delivers a random fraction rate, between 0 and 1, of the
+//events, for the statistical uses which do not need every event, e.g. high
+//volume monitoring. Each event is kept with probability rate, independently
+//of the others. Sampling is client-side: the event hub still sends every
+//event the client registered for, so it lightens the load downstream of the
+//client, not that of the stream; register narrower interests to reduce the
+//traffic. The events sampled out are counted by ClientStats.SampledOutEvents
+//and MetricSampledOutEvents, those kept by SampledInEvents and
+//MetricSampledInEvents. Sampling applies after the filter, the mutes and
+//the coalescing of duplicates. It is disabled by default
+func WithSampleRate(rate float64) Option {
+	return func(ec *EventsClient) {
+		ec.sampleRate = rate
+		ec.sampleRateSet = true
+	}
+}
+
+//WithSampleEvery is like WithSampleRate, but deterministic: the client
+//delivers the first event of every n, counting the events which would have
+//been delivered without sampling. n of 1 delivers every event
+func WithSampleEvery(n int) Option {
+	return func(ec *EventsClient) {
+		ec.sampleEvery = n
+	}
+}
+
+func (ec *EventsClient) sampling() bool {
+	return ec.sampleRateSet || ec.sampleEvery > 0
+}
+
+//sampledIn tells whether in is delivered with the sampling configured, and
+//counts it either way. It is called by the receive loop only
+func (ec *EventsClient) sampledIn(in *ehpb.Event) bool {
+	var keep bool
+	if ec.sampleRateSet {
+		if ec.sampleRand == nil {
+			ec.sampleRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		keep = ec.sampleRand.Float64() < ec.sampleRate
+	} else {
+		keep = ec.sampleCount%uint64(ec.sampleEvery) == 0
+		ec.sampleCount++
+	}
+	metric := MetricSampledOutEvents
+	ec.mutex.Lock()
+	if keep {
+		ec.stats.sampledIn++
+		metric = MetricSampledInEvents
+	} else {
+		ec.stats.sampledOut++
+	}
+	ec.mutex.Unlock()
+	if ec.metrics != nil {
+		ec.metrics.AddCounter(metric, ec.labels, 1)
+	}
+	return keep
+}
@@ -0,0 +1,102 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"math"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//tallyAdapter counts the events delivered
+type tallyAdapter struct {
+	*recordingAdapter
+	delivered int64
+}
+
+func (a *tallyAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	atomic.AddInt64(&a.delivered, 1)
+	return true, nil
+}
+
+//sample delivers n blocks to a client with opts and returns the number
+//delivered and the client's stats
+func sample(t *testing.T, n int, opts ...Option) (int, ClientStats, *fakeMetrics) {
+	addr, _, stop := startFakeServer(t, sendBlocks(n))
+	defer stop()
+
+	metrics := newFakeMetrics()
+	adapter := &tallyAdapter{recordingAdapter: newRecordingAdapter()}
+	client := NewEventsClient(addr, adapter, append(opts, WithMetrics(metrics))...)
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	waitFor(t, "the events to be sampled", func() bool {
+		stats := client.Stats()
+		return stats.SampledInEvents+stats.SampledOutEvents == uint64(n)
+	})
+	return int(atomic.LoadInt64(&adapter.delivered)), client.Stats(), metrics
+}
+
+func TestSampleRate(t *testing.T) {
+	const n = 2000
+	delivered, stats, metrics := sample(t, n, WithSampleRate(0.25))
+	if fraction := float64(delivered) / n; math.Abs(fraction-0.25) > 0.05 {
+		t.Fatalf("expected about a quarter of the events delivered, got %d of %d", delivered, n)
+	}
+	if stats.SampledInEvents != uint64(delivered) || stats.SampledOutEvents != uint64(n-delivered) {
+		t.Fatalf("expected %d events sampled in and %d out, got %d and %d", delivered, n-delivered, stats.SampledInEvents, stats.SampledOutEvents)
+	}
+	if in, out := metrics.counter(MetricSampledInEvents), metrics.counter(MetricSampledOutEvents); in != float64(delivered) || out != float64(n-delivered) {
+		t.Fatalf("expected the metrics to count %d events in and %d out, got %v and %v", delivered, n-delivered, in, out)
+	}
+
+	if delivered, _, _ := sample(t, 100, WithSampleRate(0)); delivered != 0 {
+		t.Fatalf("expected no event delivered at rate 0, got %d", delivered)
+	}
+	if delivered, _, _ := sample(t, 100, WithSampleRate(1)); delivered != 100 {
+		t.Fatalf("expected every event delivered at rate 1, got %d", delivered)
+	}
+}
+
+func TestSampleEvery(t *testing.T) {
+	delivered, stats, _ := sample(t, 1000, WithSampleEvery(10))
+	if delivered != 100 || stats.SampledOutEvents != 900 {
+		t.Fatalf("expected 100 events delivered and 900 dropped, got %d and %d", delivered, stats.SampledOutEvents)
+	}
+}
+
+func TestSampleOptions(t *testing.T) {
+	for _, test := range []struct {
+		opts    []Option
+		problem string
+	}{
+		{[]Option{WithSampleRate(1.5)}, "sample rate 1.5 is not between 0 and 1"},
+		{[]Option{WithSampleRate(-0.1)}, "sample rate -0.1 is not between 0 and 1"},
+		{[]Option{WithSampleEvery(-1)}, "negative sampling interval -1"},
+		{[]Option{WithSampleRate(0.5), WithSampleEvery(2)}, "are exclusive"},
+	} {
+		client := NewEventsClient("localhost:7053", newRecordingAdapter(), test.opts...)
+		if err := client.Start(); err == nil || !strings.Contains(err.Error(), test.problem) {
+			t.Fatalf("expected %q, got %v", test.problem, err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+//WithReconnectSchedule only lets the client attempt reconnections when
+//allowed returns true for the current time, e.g. during business hours for
+//a peer taken offline overnight. Outside the allowed times the reconnect
+//loop pauses, asking allowed again every poll, and then goes on with the
+//backoff and the circuit breaker as usual; ClientStats.PausedBySchedule
+//tells whether it is paused. The initial connection of Start is not
+//subject to the schedule
+func WithReconnectSchedule(allowed func(now time.Time) bool, poll time.Duration) Option {
+	return func(ec *EventsClient) {
+		ec.reconnectAllowed = allowed
+		ec.schedulePoll = poll
+	}
+}
+
+//waitSchedule waits until the schedule set with WithReconnectSchedule
+//allows a reconnection attempt. It returns the time waited, and false if
+//the client was stopped meanwhile
+func (ec *EventsClient) waitSchedule() (time.Duration, bool) {
+	if ec.reconnectAllowed == nil || ec.reconnectAllowed(ec.clock.Now()) {
+		return 0, true
+	}
+	ec.logf(logging.INFO, "reconnection paused by the schedule")
+	ec.setPausedBySchedule(true)
+	defer ec.setPausedBySchedule(false)
+	var waited time.Duration
+	for !ec.reconnectAllowed(ec.clock.Now()) {
+		select {
+		case <-ec.clock.After(ec.schedulePoll):
+		case <-ec.stopChan:
+			return waited, false
+		case <-ec.ctx.Done():
+			return waited, false
+		}
+		waited += ec.schedulePoll
+	}
+	ec.logf(logging.INFO, "reconnection allowed by the schedule after %s", waited)
+	return waited, true
+}
+
+func (ec *EventsClient) setPausedBySchedule(paused bool) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.stats.pausedBySchedule = paused
+}
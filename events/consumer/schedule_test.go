@@ -0,0 +1,66 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestReconnectSchedule(t *testing.T) {
+	addr, srv, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		if err := stream.Send(blockEvent()); err != nil {
+			return err
+		}
+		if n == 1 {
+			return nil
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	// the peer is offline until allowed is set
+	var allowed int32
+	schedule := func(time.Time) bool { return atomic.LoadInt32(&allowed) == 1 }
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithReconnectOnServerClose(), WithReconnectSchedule(schedule, 10*time.Millisecond))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	waitFor(t, "the scheduled pause", func() bool { return client.Stats().PausedBySchedule })
+	time.Sleep(50 * time.Millisecond)
+	if n := srv.chatCount(); n != 1 {
+		t.Fatalf("expected no reconnection outside the schedule, got %d chat streams", n)
+	}
+
+	atomic.StoreInt32(&allowed, 1)
+	adapter.waitEvent(t)
+	if client.Stats().PausedBySchedule {
+		t.Fatal("expected the scheduled pause to be over")
+	}
+	if n := srv.chatCount(); n != 2 {
+		t.Fatalf("expected one reconnection once allowed, got %d chat streams", n)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"sync"
+)
+
+//PeerSelector chooses the peer of each connection of a client configured
+//with WithPeerSelector. Implementations must be safe for concurrent use
+type PeerSelector interface {
+	//Next returns the address of the peer to connect to next
+	Next() string
+}
+
+type roundRobin struct {
+	mutex sync.Mutex
+	peers []string
+	next  int
+}
+
+//RoundRobin returns a PeerSelector cycling through peers in order
+func RoundRobin(peers ...string) PeerSelector {
+	return &roundRobin{peers: peers}
+}
+
+func (r *roundRobin) Next() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	peer := r.peers[r.next]
+	r.next = (r.next + 1) % len(r.peers)
+	return peer
+}
+
+//WeightedPeer is a peer address with the relative share of the connections
+//it should receive from WeightedRoundRobin
+type WeightedPeer struct {
+	Address string
+	Weight  int
+}
+
+type weightedRoundRobin struct {
+	mutex   sync.Mutex
+	peers   []WeightedPeer
+	current []int
+	total   int
+}
+
+//WeightedRoundRobin returns a PeerSelector choosing each peer in proportion
+//to its weight, spreading the choices of a peer evenly over the cycle rather
+//than in bursts. Peers with a weight below 1 are never chosen
+func WeightedRoundRobin(peers ...WeightedPeer) PeerSelector {
+	w := &weightedRoundRobin{}
+	for _, p := range peers {
+		if p.Weight > 0 {
+			w.peers = append(w.peers, p)
+			w.total += p.Weight
+		}
+	}
+	w.current = make([]int, len(w.peers))
+	return w
+}
+
+//Next implements the smooth weighted round-robin: every peer gains its
+//weight, and the one with the most accumulated weight is chosen and pays
+//back the total
+func (w *weightedRoundRobin) Next() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	best := 0
+	for i, p := range w.peers {
+		w.current[i] += p.Weight
+		if w.current[i] > w.current[best] {
+			best = i
+		}
+	}
+	w.current[best] -= w.total
+	return w.peers[best].Address
+}
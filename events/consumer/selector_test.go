@@ -0,0 +1,181 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestRoundRobin(t *testing.T) {
+	selector := RoundRobin("a:1", "b:1", "c:1")
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, selector.Next())
+	}
+	if expected := []string{"a:1", "b:1", "c:1", "a:1"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	selector := WeightedRoundRobin(WeightedPeer{"a:1", 5}, WeightedPeer{"b:1", 3}, WeightedPeer{"c:1", 2}, WeightedPeer{"d:1", 0})
+	counts := make(map[string]int)
+	longestRun, run, last := 0, 0, ""
+	for i := 0; i < 1000; i++ {
+		peer := selector.Next()
+		counts[peer]++
+		if peer == last {
+			run++
+		} else {
+			run, last = 1, peer
+		}
+		if run > longestRun {
+			longestRun = run
+		}
+	}
+	if expected := map[string]int{"a:1": 500, "b:1": 300, "c:1": 200}; !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("expected %v, got %v", expected, counts)
+	}
+	if longestRun > 2 {
+		t.Fatalf("expected the choices to be interleaved, got a run of %d", longestRun)
+	}
+}
+
+func TestPeerSelectorOnReconnect(t *testing.T) {
+	closeFirst := func(n int, stream ehpb.Events_ChatServer) error {
+		_, err := ackRegister(stream)
+		return err
+	}
+	addr1, srv1, stop1 := startFakeServer(t, closeFirst)
+	defer stop1()
+	addr2, srv2, stop2 := startFakeServer(t, sendBlocks(1))
+	defer stop2()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient("", adapter, WithPeerSelector(RoundRobin(addr1, addr2)), WithReconnectOnServerClose())
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	if srv1.chatCount() != 1 || srv2.chatCount() != 1 {
+		t.Fatalf("expected one stream per peer, got %d and %d", srv1.chatCount(), srv2.chatCount())
+	}
+	if addr := client.PeerAddress(); addr != addr2 {
+		t.Fatalf("expected the client to be connected to %s, got %s", addr2, addr)
+	}
+}
+
+func TestPeerConnectionConfig(t *testing.T) {
+	closeFirst := func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		return stream.Send(blockEvent())
+	}
+	cert1, _, ca1 := selfSignedCert(t)
+	cert2, _, ca2 := selfSignedCert(t)
+	addr1, stop1 := startTLSFakeServer(t, cert1, closeFirst)
+	defer stop1()
+	addr2, stop2 := startTLSFakeServer(t, cert2, sendBlocks(1))
+	defer stop2()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient("", adapter, WithPeerSelector(RoundRobin(addr1, addr2)), WithReconnectOnServerClose(),
+		WithPeerConnectionConfig(addr1, ConnectionConfig{TLSEnabled: true, CACert: ca1}),
+		WithPeerConnectionConfig(addr2, ConnectionConfig{TLSEnabled: true, CACert: ca2}))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	adapter.waitEvent(t)
+	if addr := client.PeerAddress(); addr != addr2 {
+		t.Fatalf("expected the client to be connected to %s, got %s", addr2, addr)
+	}
+
+	// with the CA of the first peer only, the second cannot be verified
+	adapter = newRecordingAdapter()
+	client = NewEventsClient("", adapter, WithPeerSelector(RoundRobin(addr1, addr2)), WithReconnectOnServerClose(),
+		WithConnectionConfig(ConnectionConfig{TLSEnabled: true, CACert: ca1}))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	adapter.waitEvent(t)
+	if err := adapter.waitDisconnected(t); err == nil {
+		t.Fatalf("expected the connection to the second peer to fail")
+	}
+}
+
+func TestPeerRegistrationStats(t *testing.T) {
+	rejecting := func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := stream.Recv(); err != nil {
+			return err
+		}
+		stream.Send(&ehpb.Event{})
+		return waitForEOF(stream)
+	}
+	closeFirst := func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		if n == 1 {
+			return nil
+		}
+		if err := stream.Send(blockEvent()); err != nil {
+			return err
+		}
+		return waitForEOF(stream)
+	}
+	good, _, stopGood := startFakeServer(t, closeFirst)
+	defer stopGood()
+	bad, _, stopBad := startFakeServer(t, rejecting)
+	defer stopBad()
+
+	adapter := newRecordingAdapter()
+	metrics := newFakeMetrics()
+	client := NewEventsClient("", adapter, WithPeerSelector(RoundRobin(good, bad)), WithReconnectOnServerClose(),
+		WithBackoff(&ExponentialBackoff{Initial: time.Millisecond, Max: time.Millisecond}), WithMetrics(metrics))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+
+	expected := map[string]PeerRegistrationStats{
+		good: {Attempts: 2, Successes: 2},
+		bad:  {Attempts: 1, Failures: 1},
+	}
+	if got := client.Stats().PeerRegistrations; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	results := make(map[string]int)
+	metrics.Lock()
+	for _, labels := range metrics.labels {
+		if result, ok := labels[MetricLabelResult]; ok {
+			results[labels[MetricLabelPeer]+" "+result]++
+		}
+	}
+	metrics.Unlock()
+	if expected := map[string]int{good + " success": 2, bad + " failure": 1}; !reflect.DeepEqual(results, expected) {
+		t.Fatalf("expected the registration metrics %v, got %v", expected, results)
+	}
+}
@@ -0,0 +1,85 @@
+//go:build go1.21
+// +build go1.21
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/op/go-logging"
+)
+
+//WithSlogLogger has the client log its messages to logger instead of its
+//go-logging logger, with their fields as attributes rather than a prefix:
+//"client" is the name of the client, "peer" and "connection_id" the peer
+//and the ID of the current connection, and "event_type" the type of the
+//event a message is about, each left out when unknown. The go-logging
+//levels map to the slog ones, NOTICE between slog.LevelInfo and
+//slog.LevelWarn and CRITICAL above slog.LevelError; WithLogLevel still
+//applies. For example,
+//
+//	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+//	client := NewEventsClient(address, adapter, WithSlogLogger(logger))
+func WithSlogLogger(logger *slog.Logger) Option {
+	return func(ec *EventsClient) {
+		ec.structured = slogLogger{logger: logger}
+	}
+}
+
+//slogLevel returns the slog level of the go-logging level
+func slogLevel(level logging.Level) slog.Level {
+	switch level {
+	case logging.CRITICAL:
+		return slog.LevelError + 4
+	case logging.ERROR:
+		return slog.LevelError
+	case logging.WARNING:
+		return slog.LevelWarn
+	case logging.NOTICE:
+		return slog.LevelInfo + 2
+	case logging.INFO:
+		return slog.LevelInfo
+	default:
+		return slog.LevelDebug
+	}
+}
+
+//slogLogger is the structuredLogger of WithSlogLogger
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogLogger) enabled(level logging.Level) bool {
+	return l.logger.Enabled(context.Background(), slogLevel(level))
+}
+
+func (l slogLogger) log(level logging.Level, msg string, fields logFields) {
+	attrs := []slog.Attr{slog.String("client", fields.client)}
+	if fields.peer != "" {
+		attrs = append(attrs, slog.String("peer", fields.peer))
+	}
+	if fields.connectionID != "" {
+		attrs = append(attrs, slog.String("connection_id", fields.connectionID))
+	}
+	if fields.eventType != "" {
+		attrs = append(attrs, slog.String("event_type", fields.eventType))
+	}
+	l.logger.LogAttrs(context.Background(), slogLevel(level), msg, attrs...)
+}
@@ -0,0 +1,107 @@
+//go:build go1.21
+// +build go1.21
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/op/go-logging"
+)
+
+//capturingHandler is a slog handler recording the attributes of the records
+//by message
+type capturingHandler struct {
+	mutex   sync.Mutex
+	records map[string]map[string]string
+	levels  map[string]slog.Level
+}
+
+func (h *capturingHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *capturingHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := make(map[string]string)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value.String()
+		return true
+	})
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.records[r.Message] = attrs
+	h.levels[r.Message] = r.Level
+	return nil
+}
+
+func (h *capturingHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *capturingHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+//find returns the attributes and level of the record whose message starts
+//with prefix
+func (h *capturingHandler) find(prefix string) (map[string]string, slog.Level, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for msg, attrs := range h.records {
+		if strings.HasPrefix(msg, prefix) {
+			return attrs, h.levels[msg], true
+		}
+	}
+	return nil, 0, false
+}
+
+func TestSlogLogger(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	handler := &capturingHandler{records: make(map[string]map[string]string), levels: make(map[string]slog.Level)}
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithName("billing"), WithSlogLogger(slog.New(handler)), WithEventDump(), WithLogLevel(logging.DEBUG))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+
+	attrs, level, ok := handler.find("received message: ")
+	if !ok {
+		t.Fatal("expected the event dump to be logged")
+	}
+	expected := map[string]string{"client": "billing", "peer": addr, "connection_id": client.CurrentConnectionID(), "event_type": "BLOCK"}
+	for key, value := range expected {
+		if attrs[key] != value {
+			t.Errorf("expected the attribute %s=%s, got %q", key, value, attrs[key])
+		}
+	}
+	if level != slog.LevelDebug {
+		t.Errorf("expected the event dump at debug level, got %s", level)
+	}
+	if _, _, ok := handler.find("[billing"); ok {
+		t.Error("expected the messages not to be prefixed")
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//Package sse bridges the events received by an events client to HTTP clients
+//as Server-Sent Events, for services that cannot use the gRPC event hub
+package sse
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/op/go-logging"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+var logger = logging.MustGetLogger("eventhub_sse")
+
+//Handler is both the consumer.EventAdapter of an events client and the
+//http.Handler streaming the events it receives to every connected HTTP
+//client, one SSE message holding the JSON encoded event per event. An HTTP
+//client that does not keep up loses the events arriving while its buffer is
+//full. The streams end when the request context is done or when the events
+//client terminates
+type Handler struct {
+	mutex       sync.Mutex
+	interests   []*ehpb.Interest
+	subscribers map[chan []byte]struct{}
+	closed      bool
+	bufferSize  int
+
+	//EnumsAsInts encodes the enum values as their numbers instead of their
+	//names, like consumer.JSONWriter.EnumsAsInts
+	EnumsAsInts bool
+}
+
+//NewHandler returns a Handler registering interests, buffering up to
+//bufferSize events for each HTTP client
+func NewHandler(interests []*ehpb.Interest, bufferSize int) *Handler {
+	return &Handler{interests: interests, subscribers: make(map[chan []byte]struct{}), bufferSize: bufferSize}
+}
+
+//GetInterestedEvents implements consumer.EventAdapter
+func (h *Handler) GetInterestedEvents() ([]*ehpb.Interest, error) {
+	return h.interests, nil
+}
+
+//Recv implements consumer.EventAdapter
+func (h *Handler) Recv(msg *ehpb.Event) (bool, error) {
+	var buf bytes.Buffer
+	marshaler := jsonpb.Marshaler{EnumsAsInts: h.EnumsAsInts}
+	if err := marshaler.Marshal(&buf, msg); err != nil {
+		logger.Errorf("could not marshal event: %s", err)
+		return true, nil
+	}
+	data := buf.Bytes()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for sub := range h.subscribers {
+		select {
+		case sub <- data:
+		default:
+			logger.Warning("HTTP client too slow, dropping event")
+		}
+	}
+	return true, nil
+}
+
+//Disconnected implements consumer.EventAdapter by ending all the streams
+func (h *Handler) Disconnected(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.closed = true
+	for sub := range h.subscribers {
+		close(sub)
+		delete(h.subscribers, sub)
+	}
+}
+
+func (h *Handler) subscribe() (chan []byte, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		return nil, false
+	}
+	sub := make(chan []byte, h.bufferSize)
+	h.subscribers[sub] = struct{}{}
+	return sub, true
+}
+
+func (h *Handler) unsubscribe(sub chan []byte) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.subscribers[sub]; ok {
+		close(sub)
+		delete(h.subscribers, sub)
+	}
+}
+
+//ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	sub, ok := h.subscribe()
+	if !ok {
+		http.Error(w, "event stream closed", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.unsubscribe(sub)
+	var closed <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closed = notifier.CloseNotify()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case data, ok := <-sub:
+			if !ok {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sse
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func waitSubscribers(t *testing.T, h *Handler, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		h.mutex.Lock()
+		count := len(h.subscribers)
+		h.mutex.Unlock()
+		if count == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d subscribers, got %d", n, count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandlerStreamsEvents(t *testing.T) {
+	h := NewHandler([]*ehpb.Interest{{EventType: ehpb.EventType_BLOCK}}, 10)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("could not connect: %s", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+	waitSubscribers(t, h, 1)
+
+	event := &ehpb.Event{Event: &ehpb.Event_ChaincodeEvent{ChaincodeEvent: &ehpb.ChaincodeEvent{ChaincodeID: "mycc", EventName: "transfer"}}}
+	if cont, err := h.Recv(event); !cont || err != nil {
+		t.Fatalf("unexpected Recv result %t, %v", cont, err)
+	}
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("could not read event: %s", err)
+	}
+	if !strings.HasPrefix(line, "data: {") || !strings.Contains(line, `"chaincodeID":"mycc"`) {
+		t.Fatalf("unexpected event line %q", line)
+	}
+
+	// the end of the events client ends the stream
+	h.Disconnected(nil)
+	for {
+		if _, err = reader.ReadString('\n'); err != nil {
+			break
+		}
+	}
+	waitSubscribers(t, h, 0)
+	if resp, err = http.Get(server.URL); err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected new streams to be refused, got %v %v", resp, err)
+	}
+	resp.Body.Close()
+}
+
+func TestHandlerRequestCancelled(t *testing.T) {
+	h := NewHandler(nil, 1)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("could not connect: %s", err)
+	}
+	waitSubscribers(t, h, 1)
+	resp.Body.Close()
+	waitSubscribers(t, h, 0)
+}
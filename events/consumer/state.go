@@ -0,0 +1,124 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+
+	"github.com/op/go-logging"
+)
+
+//ClientState is a stage of the lifecycle of an events client, see State
+type ClientState int
+
+//A client is idle until Start, connecting while Start opens and registers
+//its first stream, and connected once it receives events. It reconnects
+//after losing its stream, with WithReconnectOnServerClose, and migrates
+//during MigrateTo. It drains once stopped, or terminating on its own, while
+//it ends its stream and runs its shutdown sequence, and is closed when that
+//is over. The legal transitions are:
+//  StateIdle         -> StateConnecting, StateClosed
+//  StateConnecting   -> StateConnected, StateIdle, StateDraining
+//  StateConnected    -> StateReconnecting, StateMigrating, StateDraining
+//  StateReconnecting -> StateConnected, StateDraining
+//  StateMigrating    -> StateConnected, StateReconnecting, StateDraining
+//  StateDraining     -> StateClosed
+//A failed Start goes back to StateIdle, so that it can be retried. Nothing
+//leaves StateDraining but StateClosed, the final state: a reconnection or a
+//migration completing after Stop does not take the client back to
+//StateConnected. The pauses of the delivery, set with
+//WithDownstreamHealthCheck, and of the reconnections, set with
+//WithReconnectSchedule, are not states of their own; see
+//ClientStats.PausedForHealth and ClientStats.PausedBySchedule
+const (
+	StateIdle ClientState = iota
+	StateConnecting
+	StateConnected
+	StateReconnecting
+	StateMigrating
+	StateDraining
+	StateClosed
+)
+
+func (s ClientState) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateMigrating:
+		return "migrating"
+	case StateDraining:
+		return "draining"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+//legalTransitions holds the states each state can move to
+var legalTransitions = map[ClientState][]ClientState{
+	StateIdle:         {StateConnecting, StateClosed},
+	StateConnecting:   {StateConnected, StateIdle, StateDraining},
+	StateConnected:    {StateReconnecting, StateMigrating, StateDraining},
+	StateReconnecting: {StateConnected, StateDraining},
+	StateMigrating:    {StateConnected, StateReconnecting, StateDraining},
+	StateDraining:     {StateClosed},
+}
+
+//State returns the current state of the client
+func (ec *EventsClient) State() ClientState {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.state
+}
+
+//transition moves the client to the state to, see transitionLocked
+func (ec *EventsClient) transition(to ClientState) error {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.transitionLocked(to)
+}
+
+//transitionLocked moves the client to the state to, or returns an error
+//leaving its state unchanged when the transition is not legal. It must be
+//called with the mutex held
+func (ec *EventsClient) transitionLocked(to ClientState) error {
+	for _, legal := range legalTransitions[ec.state] {
+		if legal == to {
+			ec.logf(logging.DEBUG, "events client %s -> %s", ec.state, to)
+			ec.state = to
+			return nil
+		}
+	}
+	return fmt.Errorf("events client %s cannot go from %s to %s", ec.name, ec.state, to)
+}
+
+//transitionFrom moves the client from the state from to the state to, and
+//does nothing when it is no longer in from
+func (ec *EventsClient) transitionFrom(from, to ClientState) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if ec.state == from {
+		ec.transitionLocked(to)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+	"golang.org/x/net/context"
+)
+
+//rejectsMigration checks that MigrateTo fails naming the state of client
+func rejectsMigration(t *testing.T, client *EventsClient, addr string, state ClientState) {
+	err := client.MigrateTo(context.Background(), addr)
+	if err == nil || !strings.Contains(err.Error(), "while it is "+state.String()) {
+		t.Fatalf("expected the migration to be rejected while %s, got %v", state, err)
+	}
+}
+
+func TestClientState(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		if n == 1 {
+			// ends the first stream
+			return nil
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	backoff := &ExponentialBackoff{Initial: time.Hour, Max: time.Hour}
+	client := NewEventsClient(addr, newRecordingAdapter(), WithReconnectOnServerClose(), WithBackoff(backoff))
+	if state := client.State(); state != StateIdle {
+		t.Fatalf("expected a new client to be idle, got %s", state)
+	}
+	rejectsMigration(t, client, addr, StateIdle)
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	waitFor(t, "reconnecting", func() bool { return client.State() == StateReconnecting })
+	rejectsMigration(t, client, addr, StateReconnecting)
+
+	client.Stop()
+	waitFor(t, "closed", func() bool { return client.State() == StateClosed })
+	rejectsMigration(t, client, addr, StateClosed)
+	if err := client.Start(); err != ErrAlreadyStarted {
+		t.Fatalf("expected a closed client not to start again, got %v", err)
+	}
+}
+
+func TestClientStateFailedStart(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start listener: %s", err)
+	}
+	unreachable := lis.Addr().String()
+	lis.Close()
+
+	client := NewEventsClient(unreachable, newRecordingAdapter(), WithConnectionConfig(ConnectionConfig{DialTimeout: 100 * time.Millisecond}))
+	if err := client.Start(); err == nil {
+		t.Fatalf("expected the start to fail")
+	}
+	// a failed start can be retried
+	if state := client.State(); state != StateIdle {
+		t.Fatalf("expected the client to be idle again, got %s", state)
+	}
+	client.Stop()
+	if state := client.State(); state != StateClosed {
+		t.Fatalf("expected a client stopped before starting to be closed, got %s", state)
+	}
+	if err := client.Start(); err != ErrAlreadyStarted {
+		t.Fatalf("expected a stopped client not to start, got %v", err)
+	}
+}
+
+func TestClientStateMigrating(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(2))
+	defer stop()
+	release := make(chan struct{})
+	newAddr, _, stopNew := startFakeServer(t, func(_ int, stream ehpb.Events_ChatServer) error {
+		<-release
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		return waitForEOF(stream)
+	})
+	defer stopNew()
+
+	adapter := &gatedAdapter{newRecordingAdapter(), make(chan struct{})}
+	client := NewEventsClient(addr, adapter, WithBufferSize(10))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	if state := client.State(); state != StateConnected {
+		t.Fatalf("expected a started client to be connected, got %s", state)
+	}
+
+	migrated := make(chan error, 1)
+	go func() { migrated <- client.MigrateTo(context.Background(), newAddr) }()
+	waitFor(t, "migrating", func() bool { return client.State() == StateMigrating })
+	rejectsMigration(t, client, newAddr, StateMigrating)
+
+	// the shutdown waits for the buffered events held by the adapter
+	client.Stop()
+	if state := client.State(); state != StateDraining {
+		t.Fatalf("expected a stopped client to drain, got %s", state)
+	}
+	rejectsMigration(t, client, newAddr, StateDraining)
+	close(release)
+	if err := <-migrated; err != errClientStopped {
+		t.Fatalf("expected the migration to be abandoned on Stop, got %v", err)
+	}
+	if state := client.State(); state != StateDraining {
+		t.Fatalf("expected the abandoned migration to leave the client draining, got %s", state)
+	}
+	close(adapter.gate)
+	waitFor(t, "closed", func() bool { return client.State() == StateClosed })
+}
+
+func TestIllegalTransition(t *testing.T) {
+	client := NewEventsClient("127.0.0.1:7053", newRecordingAdapter())
+	if err := client.transition(StateConnected); err == nil {
+		t.Fatalf("expected an idle client not to become connected without connecting")
+	}
+	for _, state := range []ClientState{StateConnecting, StateConnected, StateDraining, StateClosed} {
+		if err := client.transition(state); err != nil {
+			t.Fatalf("unexpected error going to %s: %s", state, err)
+		}
+	}
+	if err := client.transition(StateConnected); err == nil || client.State() != StateClosed {
+		t.Fatalf("expected a closed client to stay closed, got %v", err)
+	}
+}
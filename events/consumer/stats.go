@@ -0,0 +1,304 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//ClientStats is a consistent snapshot of the activity of an EventsClient
+type ClientStats struct {
+	//ReceivedEvents counts the events received from the event hub by type,
+	//including those later filtered out or dropped
+	ReceivedEvents map[ehpb.EventType]uint64
+	//Reconnects counts the streams successfully re-established after the
+	//initial one
+	Reconnects uint64
+	//RegistrationAttempts and RegistrationFailures count the Register
+	//messages sent to the event hub and those that were not acknowledged
+	RegistrationAttempts uint64
+	RegistrationFailures uint64
+	//PeerRegistrations breaks RegistrationAttempts down by peer address,
+	//the empty address for a client created with NewFromStream
+	PeerRegistrations map[string]PeerRegistrationStats
+	//RegistrationTime is the time between sending the Register message and
+	//receiving its ack, for the last acknowledged registration
+	RegistrationTime time.Duration
+	//RegistrationLatency summarizes the registration times over the
+	//latency window, see WithRegistrationLatencyWindow
+	RegistrationLatency LatencySummary
+	//UnconfirmedBatches numbers from 1 the Register messages whose ack the
+	//last registration did not wait for, with WithRegisterBatchWait
+	UnconfirmedBatches []int
+	//DroppedEvents counts the events discarded because the buffer was full
+	DroppedEvents uint64
+	//CoalescedEvents counts the events dropped as duplicates of the previous
+	//one, see WithCoalesceDuplicates
+	CoalescedEvents uint64
+	//EmptyEvents counts the events received without payload and dropped,
+	//see WithPassEmptyEvents
+	EmptyEvents uint64
+	//TransformErrors counts the events dropped because the transform failed
+	//on them, see WithTransform
+	TransformErrors uint64
+	//RecvTimeouts counts the adapter calls abandoned at the timeout, see
+	//WithRecvTimeout
+	RecvTimeouts uint64
+	//SampledInEvents and SampledOutEvents count the events kept and those
+	//dropped by the sampling, see WithSampleRate
+	SampledInEvents  uint64
+	SampledOutEvents uint64
+	//TapDroppedEvents counts the events not handed to the tap adapter
+	//because it lagged behind, see SetTap
+	TapDroppedEvents uint64
+	//Throttles counts the streams ended by the event hub with
+	//ResourceExhausted and re-established after a backoff, see
+	//WithThrottleBackoff
+	Throttles uint64
+	//PausedForHealth tells whether the delivery is paused because the
+	//downstream is unhealthy and HealthPauses counts these pauses, see
+	//WithDownstreamHealthCheck
+	PausedForHealth bool
+	HealthPauses    uint64
+	//AboveBufferWatermark tells whether the buffer is above its high
+	//watermark, see AboveBufferWatermark
+	AboveBufferWatermark bool
+	//PausedBySchedule tells whether the reconnections are paused by the
+	//schedule set with WithReconnectSchedule
+	PausedBySchedule bool
+	//BytesSent and BytesReceived count the serialized size of the messages
+	//sent to and received from the event hub, registrations and their acks
+	//included
+	BytesSent     uint64
+	BytesReceived uint64
+	//AdapterCalls counts the events delivered to the adapter and
+	//AdapterTime is the total time spent delivering them, i.e. blocked in
+	//its Recv
+	AdapterCalls uint64
+	AdapterTime  time.Duration
+	//QueueDepth is the number of buffered events not yet delivered, and
+	//BufferedBytes their serialized size
+	QueueDepth    int
+	BufferedBytes int
+	//ConnectionState is the state of the current connection to the event
+	//hub, grpc.Idle when there is none
+	ConnectionState grpc.ConnectivityState
+	//LastEventTime is when the last event was received, zero if none was
+	LastEventTime time.Time
+	//LastError is the last connection, registration or stream error
+	LastError error
+}
+
+//PeerRegistrationStats counts the registrations sent to a peer: each attempt
+//ends in a success or a failure, except the one in progress
+type PeerRegistrationStats struct {
+	Attempts  uint64
+	Successes uint64
+	Failures  uint64
+}
+
+//clientCounters holds the cumulative statistics of a client. It is
+//protected by the client's mutex
+type clientCounters struct {
+	received             map[ehpb.EventType]uint64
+	reconnects           uint64
+	registrations        uint64
+	registrationFailures uint64
+	peerRegistrations    map[string]PeerRegistrationStats
+	lastRegistration     time.Duration
+	unconfirmedBatches   []int
+	latencies            latencyWindow
+	dropped              uint64
+	coalesced            uint64
+	empty                uint64
+	transformErrors      uint64
+	recvTimeouts         uint64
+	tapDropped           uint64
+	sampledIn            uint64
+	sampledOut           uint64
+	throttles            uint64
+	healthPauses         uint64
+	pausedForHealth      bool
+	aboveWatermark       bool
+	pausedBySchedule     bool
+	bytesSent            uint64
+	bytesReceived        uint64
+	adapterCalls         uint64
+	adapterTime          time.Duration
+	lastEvent            time.Time
+	registeredAt         time.Time
+	lastErr              error
+}
+
+func newClientCounters() clientCounters {
+	return clientCounters{received: make(map[ehpb.EventType]uint64), peerRegistrations: make(map[string]PeerRegistrationStats)}
+}
+
+func (c *clientCounters) receivedCopy() map[ehpb.EventType]uint64 {
+	counts := make(map[ehpb.EventType]uint64, len(c.received))
+	for et, n := range c.received {
+		counts[et] = n
+	}
+	return counts
+}
+
+func (c *clientCounters) peerRegistrationsCopy() map[string]PeerRegistrationStats {
+	counts := make(map[string]PeerRegistrationStats, len(c.peerRegistrations))
+	for peer, s := range c.peerRegistrations {
+		counts[peer] = s
+	}
+	return counts
+}
+
+//countTraffic adds n bytes to the traffic counted by metric, MetricBytesSent
+//or MetricBytesReceived
+func (ec *EventsClient) countTraffic(metric string, n int) {
+	ec.mutex.Lock()
+	if metric == MetricBytesSent {
+		ec.stats.bytesSent += uint64(n)
+	} else {
+		ec.stats.bytesReceived += uint64(n)
+	}
+	ec.mutex.Unlock()
+	if ec.metrics != nil {
+		ec.metrics.AddCounter(metric, ec.labels, float64(n))
+	}
+}
+
+//registrationAttempted counts a Register about to be sent to peerAddress
+func (ec *EventsClient) registrationAttempted(peerAddress string) {
+	ec.mutex.Lock()
+	ec.stats.registrations++
+	s := ec.stats.peerRegistrations[peerAddress]
+	s.Attempts++
+	ec.stats.peerRegistrations[peerAddress] = s
+	ec.mutex.Unlock()
+}
+
+//registrationEnded counts the outcome of the registration with peerAddress,
+//failed with err if not nil
+func (ec *EventsClient) registrationEnded(peerAddress string, err error) {
+	result := MetricResultSuccess
+	ec.mutex.Lock()
+	s := ec.stats.peerRegistrations[peerAddress]
+	if err != nil {
+		ec.stats.registrationFailures++
+		s.Failures++
+		result = MetricResultFailure
+	} else {
+		s.Successes++
+	}
+	ec.stats.peerRegistrations[peerAddress] = s
+	ec.mutex.Unlock()
+	if ec.metrics != nil {
+		ec.metrics.AddCounter(MetricRegistrations, map[string]string{MetricLabelClient: ec.name, MetricLabelPeer: peerAddress, MetricLabelResult: result}, 1)
+	}
+}
+
+func (ec *EventsClient) recordError(err error) {
+	ec.mutex.Lock()
+	ec.stats.lastErr = err
+	ec.mutex.Unlock()
+}
+
+//Stats returns a snapshot of the client's statistics
+func (ec *EventsClient) Stats() ClientStats {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.snapshot()
+}
+
+//StatsAndReset returns a snapshot of the client's statistics like Stats and
+//resets the counters at the same time, so that successive calls report the
+//activity of each interval without missing any: the received, dropped,
+//coalesced, empty and failed to transform events, the events sampled in
+//and out, the events dropped for the tap, the reconnects, the registrations,
+//their failures and latency summary, the throttles, the health pauses, the
+//bytes sent and received, the adapter calls and time and the calls
+//abandoned at the recv timeout. The state of the client is kept: the queue
+//depth, the connection state, the health and schedule pauses, the last
+//registration time, event and error. ReceivedEvents and DroppedEvents count
+//from the last reset too, while the Metrics counters are not reset
+func (ec *EventsClient) StatsAndReset() ClientStats {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	stats := ec.snapshot()
+	ec.stats.reset()
+	return stats
+}
+
+//reset zeroes the cumulative counters, keeping the state of the client
+func (c *clientCounters) reset() {
+	*c = clientCounters{
+		received:           make(map[ehpb.EventType]uint64),
+		peerRegistrations:  make(map[string]PeerRegistrationStats),
+		lastRegistration:   c.lastRegistration,
+		unconfirmedBatches: c.unconfirmedBatches,
+		lastEvent:          c.lastEvent,
+		registeredAt:       c.registeredAt,
+		lastErr:            c.lastErr,
+		pausedForHealth:    c.pausedForHealth,
+		aboveWatermark:     c.aboveWatermark,
+		pausedBySchedule:   c.pausedBySchedule,
+	}
+}
+
+//snapshot returns the statistics of the client, which must be locked
+func (ec *EventsClient) snapshot() ClientStats {
+	stats := ClientStats{
+		ReceivedEvents:       ec.stats.receivedCopy(),
+		Reconnects:           ec.stats.reconnects,
+		RegistrationAttempts: ec.stats.registrations,
+		RegistrationFailures: ec.stats.registrationFailures,
+		PeerRegistrations:    ec.stats.peerRegistrationsCopy(),
+		RegistrationTime:     ec.stats.lastRegistration,
+		RegistrationLatency:  ec.stats.latencies.summary(),
+		UnconfirmedBatches:   append([]int(nil), ec.stats.unconfirmedBatches...),
+		DroppedEvents:        ec.stats.dropped,
+		CoalescedEvents:      ec.stats.coalesced,
+		EmptyEvents:          ec.stats.empty,
+		TransformErrors:      ec.stats.transformErrors,
+		RecvTimeouts:         ec.stats.recvTimeouts,
+		TapDroppedEvents:     ec.stats.tapDropped,
+		SampledInEvents:      ec.stats.sampledIn,
+		SampledOutEvents:     ec.stats.sampledOut,
+		Throttles:            ec.stats.throttles,
+		PausedForHealth:      ec.stats.pausedForHealth,
+		AboveBufferWatermark: ec.stats.aboveWatermark,
+		HealthPauses:         ec.stats.healthPauses,
+		PausedBySchedule:     ec.stats.pausedBySchedule,
+		BytesSent:            ec.stats.bytesSent,
+		BytesReceived:        ec.stats.bytesReceived,
+		AdapterCalls:         ec.stats.adapterCalls,
+		AdapterTime:          ec.stats.adapterTime,
+		ConnectionState:      grpc.Idle,
+		LastEventTime:        ec.stats.lastEvent,
+		LastError:            ec.stats.lastErr,
+	}
+	if ec.queue != nil {
+		stats.QueueDepth = ec.queue.len()
+		stats.BufferedBytes = ec.queue.byteSize()
+	}
+	if ec.conn != nil {
+		stats.ConnectionState = ec.conn.State()
+	}
+	return stats
+}
@@ -0,0 +1,264 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestStats(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		switch n {
+		case 1:
+			if err := sendBlocks(2)(n, stream); err != nil {
+				return err
+			}
+			return nil
+		case 2:
+			stream.Recv()
+			return errors.New("overloaded")
+		}
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		if err := stream.Send(rejectionEvent()); err != nil {
+			return err
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	config := CircuitBreakerConfig{Failures: 5, Window: time.Minute, Cooldown: time.Second}
+	client := NewEventsClient(addr, adapter, WithReconnectOnServerClose(), WithCircuitBreaker(config))
+	before := time.Now()
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	adapter.waitEvent(t)
+	// the first stream ends after the client closes its side
+	client.getStream().CloseSend()
+	adapter.waitEvent(t)
+
+	stats := client.Stats()
+	if stats.ReceivedEvents[ehpb.EventType_BLOCK] != 2 || stats.ReceivedEvents[ehpb.EventType_REJECTION] != 1 {
+		t.Errorf("unexpected received counts %v", stats.ReceivedEvents)
+	}
+	if stats.Reconnects != 1 {
+		t.Errorf("expected 1 reconnect, got %d", stats.Reconnects)
+	}
+	if stats.RegistrationAttempts != 3 || stats.RegistrationFailures != 1 {
+		t.Errorf("expected 3 registrations with 1 failure, got %d and %d", stats.RegistrationAttempts, stats.RegistrationFailures)
+	}
+	if stats.LastError == nil {
+		t.Errorf("expected the registration failure to be recorded")
+	}
+	if stats.LastEventTime.Before(before) {
+		t.Errorf("unexpected last event time %s", stats.LastEventTime)
+	}
+	if stats.ConnectionState != grpc.Ready {
+		t.Errorf("expected a ready connection, got %s", stats.ConnectionState)
+	}
+}
+
+func TestRegistrationTime(t *testing.T) {
+	const delay = 200 * time.Millisecond
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		in, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		time.Sleep(delay)
+		if err = stream.Send(in); err != nil {
+			return err
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	metrics := newFakeMetrics()
+	client := NewEventsClient(addr, newRecordingAdapter(), WithMetrics(metrics))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+
+	elapsed := client.Stats().RegistrationTime
+	if elapsed < delay || elapsed > delay+time.Second {
+		t.Fatalf("implausible registration time %s for a %s delay", elapsed, delay)
+	}
+	if gauge := metrics.gauge(MetricRegistrationSeconds); len(gauge) != 1 || gauge[0] != elapsed.Seconds() {
+		t.Fatalf("unexpected registration gauge %v", gauge)
+	}
+}
+
+//histogramMetrics also records the histogram observations
+type histogramMetrics struct {
+	*fakeMetrics
+	observed map[string][]float64
+}
+
+func (m *histogramMetrics) Observe(name string, labels map[string]string, value float64) {
+	m.Lock()
+	m.observed[name] = append(m.observed[name], value)
+	m.Unlock()
+}
+
+func TestAdapterTime(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(3))
+	defer stop()
+
+	metrics := &histogramMetrics{newFakeMetrics(), make(map[string][]float64)}
+	adapter := &slowAdapter{recordingAdapter: newRecordingAdapter(), delay: 20 * time.Millisecond}
+	client := NewEventsClient(addr, adapter, WithMetrics(metrics))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	for i := 0; i < 3; i++ {
+		adapter.waitEvent(t)
+	}
+	waitFor(t, "adapter calls", func() bool { return client.Stats().AdapterCalls == 3 })
+	if total := client.Stats().AdapterTime; total < 60*time.Millisecond {
+		t.Fatalf("expected at least 60ms spent in the adapter, got %s", total)
+	}
+	metrics.Lock()
+	observed := metrics.observed[MetricAdapterRecvSeconds]
+	metrics.Unlock()
+	if len(observed) != 3 {
+		t.Fatalf("expected an observation per event, got %v", observed)
+	}
+	for _, seconds := range observed {
+		if seconds < 0.02 {
+			t.Fatalf("expected each observation to be 20ms at least, got %v", observed)
+		}
+	}
+}
+
+func TestStatsAndReset(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		switch n {
+		case 1:
+			return sendBlocks(2)(n, stream)
+		default:
+			if _, err := ackRegister(stream); err != nil {
+				return err
+			}
+			if err := stream.Send(rejectionEvent()); err != nil {
+				return err
+			}
+			return waitForEOF(stream)
+		}
+	})
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithReconnectOnServerClose())
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	adapter.waitEvent(t)
+
+	stats := client.StatsAndReset()
+	if n := stats.ReceivedEvents[ehpb.EventType_BLOCK]; n != 2 {
+		t.Fatalf("expected 2 blocks before the reset, got %d", n)
+	}
+	if stats.RegistrationAttempts != 1 || stats.AdapterCalls != 2 || stats.Reconnects != 0 {
+		t.Fatalf("unexpected stats before the reset: %+v", stats)
+	}
+	if stats = client.Stats(); len(stats.ReceivedEvents) != 0 || stats.RegistrationAttempts != 0 || stats.AdapterCalls != 0 || len(stats.PeerRegistrations) != 0 {
+		t.Fatalf("expected the counters to be reset, got %+v", stats)
+	}
+	if stats.LastEventTime.IsZero() || stats.RegistrationTime == 0 {
+		t.Fatalf("expected the state of the client to be kept, got %+v", stats)
+	}
+
+	if err := client.CancelStream(); err != nil {
+		t.Fatalf("could not cancel the stream: %s", err)
+	}
+	adapter.waitEvent(t)
+	waitFor(t, "the reconnect to be counted", func() bool { return client.Stats().Reconnects == 1 })
+	stats = client.StatsAndReset()
+	expected := map[ehpb.EventType]uint64{ehpb.EventType_REJECTION: 1}
+	if !reflect.DeepEqual(stats.ReceivedEvents, expected) {
+		t.Fatalf("expected %v received after the reset, got %v", expected, stats.ReceivedEvents)
+	}
+	if stats.RegistrationAttempts != 1 || stats.AdapterCalls != 1 {
+		t.Fatalf("expected only the activity after the reset, got %+v", stats)
+	}
+}
+
+func TestTrafficStats(t *testing.T) {
+	sent := make(chan int, 1)
+	events := []*ehpb.Event{blockEvent(), chaincodeEvent("mycc", "transfer"), rejectionEvent()}
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		in, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		sent <- proto.Size(in)
+		if err := stream.Send(in); err != nil {
+			return err
+		}
+		for _, e := range events {
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	metrics := newFakeMetrics()
+	client := NewEventsClient(addr, adapter, WithMetrics(metrics))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	for range events {
+		adapter.waitEvent(t)
+	}
+	registerSize := <-sent
+	// the ack echoes the Register message
+	received := registerSize
+	for _, e := range events {
+		received += proto.Size(e)
+	}
+	stats := client.Stats()
+	if stats.BytesSent != uint64(registerSize) || stats.BytesReceived != uint64(received) {
+		t.Fatalf("expected %d bytes sent and %d received, got %d and %d", registerSize, received, stats.BytesSent, stats.BytesReceived)
+	}
+	if n := metrics.counter(MetricBytesSent); n != float64(registerSize) {
+		t.Fatalf("expected the sent bytes metric to be %d, got %v", registerSize, n)
+	}
+	if n := metrics.counter(MetricBytesReceived); n != float64(received) {
+		t.Fatalf("expected the received bytes metric to be %d, got %v", received, n)
+	}
+}
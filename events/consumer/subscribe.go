@@ -0,0 +1,316 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//chanAdapter hands the received events over to the goroutine of Subscribe,
+//copies of them when the client pools its messages
+type chanAdapter struct {
+	interests func() ([]*ehpb.Interest, error)
+	events    chan *ehpb.Event
+	closed    chan error
+	done      chan struct{}
+	clone     bool
+}
+
+func (a *chanAdapter) GetInterestedEvents() ([]*ehpb.Interest, error) {
+	return a.interests()
+}
+
+func (a *chanAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	if a.clone {
+		// the pooled event is reused once Recv returns
+		msg = proto.Clone(msg).(*ehpb.Event)
+	}
+	select {
+	case a.events <- msg:
+		return true, nil
+	case <-a.done:
+		return false, nil
+	}
+}
+
+func (a *chanAdapter) Disconnected(err error) {
+	a.closed <- err
+}
+
+//Subscribe returns the channel of the events received by the client and the
+//channel of its terminal error. Subscribing starts the client with ctx if
+//needed and switches its delivery to the channel, keeping the interested
+//events of its adapter, which no longer receives anything.
+//
+//The events channel is unbuffered: until the consumer takes an event, the
+//client does not deliver the next one. Without a buffer this blocks the
+//receive loop, and so the stream; with WithBufferSize the events wait in the
+//buffer, and once it is full the overflow policy applies: the receive loop
+//blocks, or the events are dropped with WithDropWhenFull.
+//
+//When the stream ends and the buffered events are consumed, or when ctx is
+//done, the terminal error, if any, or ctx.Err() is sent on the error channel
+//and both channels are closed, the events channel first. Ending through ctx
+//stops the client. The consumer must read the events channel until it is
+//closed, even after Stop, or cancel ctx to give up early
+func (ec *EventsClient) Subscribe(ctx context.Context) (<-chan *ehpb.Event, <-chan error) {
+	current := ec.getAdapter()
+	if current == nil {
+		return ec.subscribe(ctx, nil, fmt.Errorf("events client has no adapter providing the interested events"))
+	}
+	return ec.subscribe(ctx, current.GetInterestedEvents, nil)
+}
+
+//subscribe implements Subscribe with the interested events returned by
+//interests, or fails with err if it is not nil
+func (ec *EventsClient) subscribe(ctx context.Context, interests func() ([]*ehpb.Interest, error), err error) (<-chan *ehpb.Event, <-chan error) {
+	events := make(chan *ehpb.Event)
+	errs := make(chan error, 1)
+	fail := func(err error) (<-chan *ehpb.Event, <-chan error) {
+		errs <- err
+		close(events)
+		close(errs)
+		return events, errs
+	}
+	if err != nil {
+		return fail(err)
+	}
+	adapter := &chanAdapter{interests: interests, events: make(chan *ehpb.Event), closed: make(chan error, 1), done: make(chan struct{}), clone: ec.pool != nil}
+	if err := ec.SetAdapter(adapter); err != nil {
+		return fail(err)
+	}
+	ec.mutex.Lock()
+	started := ec.started || ec.starting
+	ec.mutex.Unlock()
+	if !started {
+		if err := ec.StartContext(ctx); err != nil {
+			close(adapter.done)
+			return fail(err)
+		}
+	}
+	ec.routines.spawn(func() { ec.forward(ctx, adapter, events, errs) })
+	return events, errs
+}
+
+//forward moves the events of adapter to the channels of Subscribe until the
+//client terminates and its buffered events are delivered, or ctx is done
+func (ec *EventsClient) forward(ctx context.Context, adapter *chanAdapter, events chan<- *ehpb.Event, errs chan<- error) {
+	var err error
+	defer func() {
+		close(adapter.done)
+		if err != nil {
+			errs <- err
+		}
+		close(events)
+		close(errs)
+	}()
+	var dispatched chan struct{}
+	for {
+		select {
+		case msg := <-adapter.events:
+			select {
+			case events <- msg:
+				continue
+			case <-ctx.Done():
+			}
+		case err = <-adapter.closed:
+			// the buffered events are delivered after the disconnection
+			dispatched = make(chan struct{})
+			ec.routines.spawn(func() {
+				ec.waitDispatched(context.Background())
+				close(dispatched)
+			})
+			continue
+		case <-dispatched:
+		case <-ctx.Done():
+		}
+		if err == nil && ctx.Err() != nil {
+			// the client stopped because of ctx, or stops now
+			err = ctx.Err()
+			ec.Stop()
+		}
+		return
+	}
+}
+
+//SubscribeChaincodeEvents subscribes like Subscribe to all the events of the
+//chaincode chaincodeID, decoded. The client registers the interest in these
+//events in place of the interested events of its adapter, so that it can be
+//created without adapter, and it must not be started yet. The messages that
+//are not chaincode events of chaincodeID are skipped. The channels follow
+//the rules of Subscribe: the consumer must read the events channel until it
+//is closed, or cancel ctx
+func (ec *EventsClient) SubscribeChaincodeEvents(ctx context.Context, chaincodeID string) (<-chan *ChaincodeEvent, <-chan error) {
+	out := make(chan *ChaincodeEvent)
+	outErrs := make(chan error, 1)
+	var err error
+	if chaincodeID == "" {
+		err = fmt.Errorf("chaincode ID must not be empty")
+	} else {
+		ec.mutex.Lock()
+		if ec.started || ec.starting {
+			err = ErrAlreadyStarted
+		}
+		ec.mutex.Unlock()
+	}
+	interests := func() ([]*ehpb.Interest, error) {
+		return []*ehpb.Interest{ChaincodeInterest(chaincodeID, "")}, nil
+	}
+	events, errs := ec.subscribe(ctx, interests, err)
+	ec.routines.spawn(func() {
+		defer close(outErrs)
+		defer close(out)
+		for msg := range events {
+			cc, err := DecodeChaincodeEvent(msg)
+			if err != nil || cc.ChaincodeID != chaincodeID || ctx.Err() != nil {
+				continue
+			}
+			select {
+			case out <- cc:
+			case <-ctx.Done():
+			}
+		}
+		if err, ok := <-errs; ok {
+			outErrs <- err
+		}
+	})
+	return out, outErrs
+}
+
+//TypedChannel configures a channel of SubscribeByType
+type TypedChannel struct {
+	//Size is the buffer of the channel, 0 for an unbuffered channel
+	Size int
+	//DropWhenFull drops the events arriving while the channel is full
+	//instead of waiting for the consumer to take one. They are counted by
+	//ClientStats.DroppedEvents and MetricDroppedEvents
+	DropWhenFull bool
+}
+
+//TypedChannels configures the channels of SubscribeByType, one per category
+//of events
+type TypedChannels struct {
+	Blocks          TypedChannel
+	ChaincodeEvents TypedChannel
+	Rejections      TypedChannel
+}
+
+//SubscribeByType subscribes like Subscribe, but demultiplexes the events
+//into a channel per category, the blocks, the chaincode events and the
+//rejections, configured by channels; the other messages are skipped. The
+//events are routed one at a time in the order of the stream, so a full
+//channel which does not drop holds back the other categories too: size each
+//buffer for the bursts of its category, e.g. after the blocks a
+//transaction produces its chaincode events, or let the less important
+//categories drop. The channels follow the rules of Subscribe: when the
+//subscription ends, the terminal error, if any, is sent on the error channel
+//and the category channels are closed, then the error channel. The consumer
+//must read every category channel until it is closed, or cancel ctx
+func (ec *EventsClient) SubscribeByType(ctx context.Context, channels TypedChannels) (<-chan *ehpb.Block, <-chan *ehpb.ChaincodeEvent, <-chan *ehpb.Rejection, <-chan error) {
+	outErrs := make(chan error, 1)
+	for _, c := range []TypedChannel{channels.Blocks, channels.ChaincodeEvents, channels.Rejections} {
+		if c.Size < 0 {
+			outErrs <- fmt.Errorf("negative channel size %d", c.Size)
+			close(outErrs)
+			blocks, ccEvents, rejections := make(chan *ehpb.Block), make(chan *ehpb.ChaincodeEvent), make(chan *ehpb.Rejection)
+			close(blocks)
+			close(ccEvents)
+			close(rejections)
+			return blocks, ccEvents, rejections, outErrs
+		}
+	}
+	blocks := make(chan *ehpb.Block, channels.Blocks.Size)
+	ccEvents := make(chan *ehpb.ChaincodeEvent, channels.ChaincodeEvents.Size)
+	rejections := make(chan *ehpb.Rejection, channels.Rejections.Size)
+	events, errs := ec.Subscribe(ctx)
+	ec.routines.spawn(func() {
+		defer close(outErrs)
+		defer close(rejections)
+		defer close(ccEvents)
+		defer close(blocks)
+		for msg := range events {
+			// a full channel allowed to drop is not waited for
+			dropped := false
+			switch e := msg.Event.(type) {
+			case *ehpb.Event_Block:
+				if channels.Blocks.DropWhenFull {
+					select {
+					case blocks <- e.Block:
+					default:
+						dropped = true
+					}
+				} else {
+					select {
+					case blocks <- e.Block:
+					case <-ctx.Done():
+					}
+				}
+			case *ehpb.Event_ChaincodeEvent:
+				if channels.ChaincodeEvents.DropWhenFull {
+					select {
+					case ccEvents <- e.ChaincodeEvent:
+					default:
+						dropped = true
+					}
+				} else {
+					select {
+					case ccEvents <- e.ChaincodeEvent:
+					case <-ctx.Done():
+					}
+				}
+			case *ehpb.Event_Rejection:
+				if channels.Rejections.DropWhenFull {
+					select {
+					case rejections <- e.Rejection:
+					default:
+						dropped = true
+					}
+				} else {
+					select {
+					case rejections <- e.Rejection:
+					case <-ctx.Done():
+					}
+				}
+			}
+			if dropped {
+				ec.eventDropped()
+			}
+		}
+		if err, ok := <-errs; ok {
+			outErrs <- err
+		}
+	})
+	return blocks, ccEvents, rejections, outErrs
+}
+
+//waitDispatched blocks until the dispatcher has delivered the buffered
+//events, once the receive loop has terminated, or ctx is done. It does not
+//wait for a queue emptied by StopAndDrain
+func (ec *EventsClient) waitDispatched(ctx context.Context) error {
+	ec.mutex.Lock()
+	queue := ec.queue
+	ec.mutex.Unlock()
+	if queue != nil && !queue.isDrained() {
+		return queue.waitIdle(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//drain reads events until it is closed and returns their count and the
+//terminal error of errs
+func drain(t *testing.T, events <-chan *ehpb.Event, errs <-chan error) (int, error) {
+	n := 0
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return n, <-errs
+			}
+			n++
+		case <-timeout:
+			t.Fatalf("timed out waiting for the events channel to be closed")
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for i := 0; i < 3; i++ {
+			if err := stream.Send(blockEvent()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithBufferSize(1))
+	events, errs := client.Subscribe(context.Background())
+	n, err := drain(t, events, errs)
+	if n != 3 || err != ErrServerClosed {
+		t.Fatalf("expected 3 events then ErrServerClosed, got %d and %v", n, err)
+	}
+	if _, ok := <-errs; ok {
+		t.Fatalf("expected the error channel to be closed")
+	}
+	select {
+	case e := <-adapter.events:
+		t.Fatalf("the adapter should not receive events, got %v", e)
+	default:
+	}
+}
+
+func TestSubscribeStop(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter())
+	events, errs := client.Subscribe(context.Background())
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+	client.Stop()
+	if n, err := drain(t, events, errs); n != 0 || err != nil {
+		t.Fatalf("expected a clean close after Stop, got %d events and %v", n, err)
+	}
+}
+
+func TestSubscribeContextCancelled(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(5))
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := NewEventsClient(addr, newRecordingAdapter(), WithBufferSize(10))
+	events, errs := client.Subscribe(ctx)
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+	// stop reading, the remaining events stay in the buffer
+	cancel()
+	if _, err := drain(t, events, errs); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if err := client.Wait(); err != nil {
+		t.Fatalf("expected the client to be stopped cleanly, got %s", err)
+	}
+}
+
+func TestSubscribeStartFailure(t *testing.T) {
+	client := NewEventsClient("localhost:7053", newRecordingAdapter(), WithBufferSize(-1))
+	events, errs := client.Subscribe(context.Background())
+	if n, err := drain(t, events, errs); n != 0 || err == nil {
+		t.Fatalf("expected the start error, got %d events and %v", n, err)
+	}
+}
+
+func TestSubscribeChaincodeEvents(t *testing.T) {
+	registered := make(chan *ehpb.Register, 1)
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		reg, err := ackRegister(stream)
+		if err != nil {
+			return err
+		}
+		registered <- reg
+		for _, msg := range []*ehpb.Event{chaincodeEvent("mycc", "first"), chaincodeEvent("other", "skipped"), rejectionEvent(), chaincodeEvent("mycc", "second")} {
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	defer stop()
+
+	client := NewEventsClient(addr, nil)
+	events, errs := client.SubscribeChaincodeEvents(context.Background(), "mycc")
+	var names []string
+	for cc := range events {
+		if cc.ChaincodeID != "mycc" {
+			t.Fatalf("unexpected event of chaincode %s", cc.ChaincodeID)
+		}
+		names = append(names, cc.EventName)
+	}
+	if err := <-errs; err != ErrServerClosed {
+		t.Fatalf("expected ErrServerClosed, got %v", err)
+	}
+	if len(names) != 2 || names[0] != "first" || names[1] != "second" {
+		t.Fatalf("expected the events first and second, got %v", names)
+	}
+	reg := <-registered
+	if len(reg.Events) != 1 || reg.Events[0].GetChaincodeRegInfo().ChaincodeID != "mycc" {
+		t.Fatalf("expected the interest in the events of mycc, got %v", reg.Events)
+	}
+
+	_, errs = NewEventsClient(addr, nil).SubscribeChaincodeEvents(context.Background(), "")
+	if err := <-errs; err == nil {
+		t.Fatalf("expected an empty chaincode ID to be refused")
+	}
+}
+
+//drainByType reads the channels of SubscribeByType until they are closed and
+//returns what they carried and the terminal error
+func drainByType(t *testing.T, blocks <-chan *ehpb.Block, ccEvents <-chan *ehpb.ChaincodeEvent, rejections <-chan *ehpb.Rejection, errs <-chan error) (int, []*ehpb.ChaincodeEvent, []*ehpb.Rejection, error) {
+	var n int
+	var ccs []*ehpb.ChaincodeEvent
+	var rejected []*ehpb.Rejection
+	timeout := time.After(5 * time.Second)
+	for blocks != nil || ccEvents != nil || rejections != nil {
+		select {
+		case _, ok := <-blocks:
+			if !ok {
+				blocks = nil
+				continue
+			}
+			n++
+		case cc, ok := <-ccEvents:
+			if !ok {
+				ccEvents = nil
+				continue
+			}
+			ccs = append(ccs, cc)
+		case rejection, ok := <-rejections:
+			if !ok {
+				rejections = nil
+				continue
+			}
+			rejected = append(rejected, rejection)
+		case <-timeout:
+			t.Fatalf("timed out waiting for the channels to be closed")
+		}
+	}
+	err := <-errs
+	if _, ok := <-errs; ok {
+		t.Fatalf("expected the error channel to be closed")
+	}
+	return n, ccs, rejected, err
+}
+
+func TestSubscribeByType(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for _, e := range []*ehpb.Event{blockEvent(), chaincodeEvent("mycc", "transfer"), rejectionEvent(), blockEvent(), {}} {
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter(), WithPassEmptyEvents())
+	size := TypedChannel{Size: 10}
+	blocks, ccEvents, rejections, errs := client.SubscribeByType(context.Background(), TypedChannels{Blocks: size, ChaincodeEvents: size, Rejections: size})
+	n, ccs, rejected, err := drainByType(t, blocks, ccEvents, rejections, errs)
+	if n != 2 || len(ccs) != 1 || len(rejected) != 1 {
+		t.Fatalf("expected 2 blocks, a chaincode event and a rejection, got %d, %d and %d", n, len(ccs), len(rejected))
+	}
+	if ccs[0].ChaincodeID != "mycc" || rejected[0].ErrorMsg != "rejected" {
+		t.Fatalf("unexpected events %v and %v", ccs[0], rejected[0])
+	}
+	if err != ErrServerClosed {
+		t.Fatalf("expected ErrServerClosed, got %v", err)
+	}
+}
+
+func TestSubscribeByTypeDropWhenFull(t *testing.T) {
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		for _, e := range []*ehpb.Event{rejectionEvent(), rejectionEvent(), rejectionEvent(), blockEvent()} {
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter())
+	ctx, cancel := context.WithCancel(context.Background())
+	blocks, ccEvents, rejections, errs := client.SubscribeByType(ctx, TypedChannels{Rejections: TypedChannel{Size: 1, DropWhenFull: true}})
+	// the rejections overflowing their channel do not hold back the block
+	select {
+	case <-blocks:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the block")
+	}
+	if dropped := client.Stats().DroppedEvents; dropped != 2 {
+		t.Fatalf("expected 2 rejections dropped, got %d", dropped)
+	}
+	cancel()
+	n, ccs, rejected, err := drainByType(t, blocks, ccEvents, rejections, errs)
+	if n != 0 || len(ccs) != 0 || len(rejected) != 1 {
+		t.Fatalf("expected the buffered rejection only, got %d, %d and %d", n, len(ccs), len(rejected))
+	}
+	if err != context.Canceled {
+		t.Fatalf("expected the cancellation, got %v", err)
+	}
+
+	_, _, _, errs = client.SubscribeByType(context.Background(), TypedChannels{Blocks: TypedChannel{Size: -1}})
+	if err := <-errs; err == nil {
+		t.Fatalf("expected a negative size to be refused")
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//tapBufferSize is the number of events a tap can lag behind the adapter
+//before its events are dropped
+const tapBufferSize = 100
+
+//tapAdapter delivers the events to a tap adapter from its own
+//goroutine
+type tapAdapter struct {
+	adapter EventAdapter
+	events  chan *ehpb.Event
+	// final tells whether the client has terminated, gracefully or not, and
+	// err is its terminal error, set before events is closed
+	final    bool
+	graceful bool
+	err      error
+	// done is closed once the adapter is disconnected
+	done chan struct{}
+}
+
+//run delivers the events until the tap is closed, then disconnects the
+//adapter. An adapter that fails or asks to stop is not delivered any more
+//events
+func (t *tapAdapter) run(ec *EventsClient) {
+	defer close(t.done)
+	failed := false
+	for in := range t.events {
+		if failed {
+			continue
+		}
+		cont, err := t.adapter.Recv(in)
+		if !cont {
+			if err != nil && err != ErrStopConsuming {
+				ec.logf(logging.WARNING, "tap adapter failed, no longer tapping: %s", err)
+			}
+			failed = true
+		}
+	}
+	if !t.final {
+		t.adapter.Disconnected(nil)
+		return
+	}
+	if fa, ok := t.adapter.(FlushingAdapter); ok && t.graceful {
+		if err := fa.Flush(); err != nil {
+			ec.logf(logging.WARNING, "tap adapter failed to flush: %s", err)
+		}
+	}
+	t.adapter.Disconnected(t.err)
+	ec.closeAdapter("tap adapter", t.adapter)
+}
+
+//SetTap attaches adapter as a tap, an observer receiving the events
+//delivered to the adapter of the client, e.g. for metrics or sampling,
+//without ever holding the delivery up: the tap receives the events from its
+//own goroutine, and when it lags too far behind its events are dropped,
+//counted by ClientStats.TapDroppedEvents and the MetricTapDroppedEvents
+//counter. An error or a false return from its Recv only stops the tap. The
+//tap shares the events with the adapter, so neither may modify them; they
+//are copies when the client pools its messages. The interested events of
+//the tap are not used. Its Disconnected is called with nil when it is
+//replaced or removed with a nil adapter, and with the terminal error of the
+//client when the client terminates; the tap is then flushed and closed like
+//the adapter of the client, see FlushingAdapter and ClosingAdapter
+func (ec *EventsClient) SetTap(adapter EventAdapter) {
+	var tap *tapAdapter
+	if adapter != nil {
+		tap = &tapAdapter{adapter: adapter, events: make(chan *ehpb.Event, tapBufferSize), done: make(chan struct{})}
+	}
+	ec.mutex.Lock()
+	if ec.tapClosed {
+		ec.mutex.Unlock()
+		if adapter != nil {
+			// the client has terminated, there is nothing to tap
+			adapter.Disconnected(nil)
+		}
+		return
+	}
+	previous := ec.tap
+	ec.tap = tap
+	ec.mutex.Unlock()
+	if previous != nil {
+		close(previous.events)
+	}
+	if tap != nil {
+		ec.routines.spawn(func() { tap.run(ec) })
+	}
+}
+
+//offerTap hands in to the tap if there is one and it keeps up
+func (ec *EventsClient) offerTap(in *ehpb.Event) {
+	ec.mutex.Lock()
+	tap := ec.tap
+	if tap == nil {
+		ec.mutex.Unlock()
+		return
+	}
+	if ec.pool != nil {
+		in = proto.Clone(in).(*ehpb.Event)
+	}
+	dropped := false
+	select {
+	case tap.events <- in:
+	default:
+		ec.stats.tapDropped++
+		dropped = true
+	}
+	ec.mutex.Unlock()
+	if dropped && ec.metrics != nil {
+		ec.metrics.AddCounter(MetricTapDroppedEvents, ec.labels, 1)
+	}
+}
+
+//closeTap ends the tap of a terminated client with err and waits for it
+//to be disconnected, until ctx is done
+func (ec *EventsClient) closeTap(ctx context.Context, graceful bool, err error) {
+	ec.mutex.Lock()
+	tap := ec.tap
+	ec.tap = nil
+	ec.tapClosed = true
+	ec.mutex.Unlock()
+	if tap == nil {
+		return
+	}
+	tap.final, tap.graceful, tap.err = true, graceful, err
+	close(tap.events)
+	select {
+	case <-tap.done:
+	case <-ctx.Done():
+		ec.logf(logging.WARNING, "shutdown deadline exceeded, tap adapter not disconnected")
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//slowTap blocks in Recv until released
+type slowTap struct {
+	*recordingAdapter
+	release chan struct{}
+}
+
+func (a *slowTap) Recv(msg *ehpb.Event) (bool, error) {
+	<-a.release
+	return a.recordingAdapter.Recv(msg)
+}
+
+func TestTap(t *testing.T) {
+	const sent = tapBufferSize + 20
+	addr, _, stop := startFakeServer(t, sendBlocks(sent))
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	adapter.events = make(chan *ehpb.Event, sent)
+	metrics := newFakeMetrics()
+	client := NewEventsClient(addr, adapter, WithMetrics(metrics))
+	tap := &slowTap{newRecordingAdapter(), make(chan struct{})}
+	tap.events = make(chan *ehpb.Event, sent)
+	client.SetTap(tap)
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+
+	// the tap holds an event and buffers the next ones: the adapter receives
+	// them all regardless
+	for i := 0; i < sent; i++ {
+		adapter.waitEvent(t)
+	}
+	dropped := client.Stats().TapDroppedEvents
+	if dropped < sent-tapBufferSize-1 || dropped > sent-tapBufferSize {
+		t.Fatalf("expected about %d events dropped for the tap, got %d", sent-tapBufferSize, dropped)
+	}
+	if n := metrics.counter(MetricTapDroppedEvents); n != float64(dropped) {
+		t.Fatalf("expected %d events dropped for the tap in the metrics, got %v", dropped, n)
+	}
+
+	close(tap.release)
+	for i := uint64(0); i < sent-dropped; i++ {
+		tap.waitEvent(t)
+	}
+	client.Stop()
+	if err := tap.waitDisconnected(t); err != nil {
+		t.Fatalf("expected the tap to be disconnected with nil, got %v", err)
+	}
+}
+
+func TestReplaceTap(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(1))
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter)
+	first := newRecordingAdapter()
+	client.SetTap(first)
+	second := newRecordingAdapter()
+	client.SetTap(second)
+	if err := first.waitDisconnected(t); err != nil {
+		t.Fatalf("expected the replaced tap to be disconnected with nil, got %v", err)
+	}
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	second.waitEvent(t)
+	select {
+	case e := <-first.events:
+		t.Fatalf("unexpected event %v for the replaced tap", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"time"
+
+	"github.com/op/go-logging"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//throttleBackoff is the wait applied before re-establishing a stream ended
+//by an overloaded event hub. It is only used by the receive loop
+type throttleBackoff struct {
+	min  time.Duration
+	max  time.Duration
+	next time.Duration
+}
+
+//delay returns the wait before the next attempt and doubles the following
+func (b *throttleBackoff) delay() time.Duration {
+	if b.next == 0 {
+		b.next = b.min
+	}
+	d := b.next
+	if b.next *= 2; b.next > b.max {
+		b.next = b.max
+	}
+	return d
+}
+
+func (b *throttleBackoff) reset() {
+	b.next = 0
+}
+
+//throttled waits for the throttle backoff and replaces stream, ended by the
+//event hub with err because it is overloaded
+func (ec *EventsClient) throttled(stream ehpb.Events_ChatClient, err error) error {
+	delay := ec.throttle.delay()
+	ec.mutex.Lock()
+	ec.stats.throttles++
+	ec.mutex.Unlock()
+	if ec.metrics != nil {
+		ec.metrics.AddCounter(MetricThrottles, ec.labels, 1)
+	}
+	ec.logf(logging.WARNING, "event hub %s is overloaded (%s), reconnecting in %s", ec.PeerAddress(), err, delay)
+	stream.CloseSend()
+	select {
+	case <-ec.clock.After(delay):
+	case <-ec.stopChan:
+		return errClientStopped
+	case <-ec.ctx.Done():
+		return errClientStopped
+	}
+	return ec.reconnect(err)
+}
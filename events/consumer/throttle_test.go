@@ -0,0 +1,97 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestThrottleBackoffDelay(t *testing.T) {
+	b := &throttleBackoff{min: 100 * time.Millisecond, max: 300 * time.Millisecond}
+	for i, expected := range []time.Duration{100, 200, 300, 300} {
+		if d := b.delay(); d != expected*time.Millisecond {
+			t.Fatalf("delay %d: expected %dms, got %s", i, expected, d)
+		}
+	}
+	b.reset()
+	if d := b.delay(); d != 100*time.Millisecond {
+		t.Fatalf("expected the reset delay to be the minimum, got %s", d)
+	}
+}
+
+func TestThrottleBackoff(t *testing.T) {
+	var mutex sync.Mutex
+	var throttledAt, reconnectedAt time.Time
+	overloaded := func(n int, stream ehpb.Events_ChatServer) error {
+		if n == 1 {
+			if _, err := ackRegister(stream); err != nil {
+				return err
+			}
+			mutex.Lock()
+			throttledAt = time.Now()
+			mutex.Unlock()
+			return grpc.Errorf(codes.ResourceExhausted, "slow down")
+		}
+		mutex.Lock()
+		reconnectedAt = time.Now()
+		mutex.Unlock()
+		return sendBlocks(1)(n, stream)
+	}
+	addr, _, stop := startFakeServer(t, overloaded)
+	defer stop()
+
+	metrics := newFakeMetrics()
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter, WithThrottleBackoff(200*time.Millisecond, time.Second), WithMetrics(metrics))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	mutex.Lock()
+	waited := reconnectedAt.Sub(throttledAt)
+	mutex.Unlock()
+	if waited < 200*time.Millisecond {
+		t.Fatalf("expected the client to back off 200ms before reconnecting, waited %s", waited)
+	}
+	stats := client.Stats()
+	if stats.Throttles != 1 || stats.Reconnects != 1 {
+		t.Fatalf("expected one throttle and one reconnect, got %d and %d", stats.Throttles, stats.Reconnects)
+	}
+	if n := metrics.counter(MetricThrottles); n != 1 {
+		t.Fatalf("expected the throttle counter to be 1, got %v", n)
+	}
+
+	// without the option ResourceExhausted is fatal
+	adapter = newRecordingAdapter()
+	addr2, _, stop2 := startFakeServer(t, overloaded)
+	defer stop2()
+	client = NewEventsClient(addr2, adapter)
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	if err := adapter.waitDisconnected(t); grpc.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected the client to fail with ResourceExhausted, got %v", err)
+	}
+}
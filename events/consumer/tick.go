@@ -0,0 +1,70 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+//WithTickInterval makes the client call OnTick every interval on an adapter
+//implementing TickAdapter, whether or not events arrive, so that the adapter
+//does its periodic work without a timer of its own. It is disabled by
+//default
+func WithTickInterval(interval time.Duration) Option {
+	return func(ec *EventsClient) {
+		ec.tickInterval = interval
+	}
+}
+
+//tickAdapter calls OnTick on the adapter every tick interval until the
+//client terminates. The ticks are serialized with the deliveries, and
+//skipped while the client is not connected or its delivery is paused
+func (ec *EventsClient) tickAdapter() {
+	if ec.tickInterval <= 0 {
+		return
+	}
+	ctx := ec.Context()
+	ec.routines.spawn(func() {
+		for {
+			select {
+			case <-ec.clock.After(ec.tickInterval):
+			case <-ctx.Done():
+				return
+			}
+			if !ec.tickable() {
+				continue
+			}
+			ec.deliverMutex.Lock()
+			if ticker, ok := ec.getAdapter().(TickAdapter); ok && ctx.Err() == nil {
+				ticker.OnTick(ec.clock.Now())
+			}
+			ec.deliverMutex.Unlock()
+		}
+	})
+}
+
+//tickable tells whether the client is connected with its delivery running
+func (ec *EventsClient) tickable() bool {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	if ec.stopped || ec.stats.pausedForHealth {
+		return false
+	}
+	return ec.ownStream != nil || ec.conn != nil && ec.conn.State() == grpc.Ready
+}
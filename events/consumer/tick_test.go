@@ -0,0 +1,70 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"testing"
+	"time"
+)
+
+//tickingAdapter records the ticks it is given
+type tickingAdapter struct {
+	*recordingAdapter
+	ticks chan time.Time
+}
+
+func (a *tickingAdapter) OnTick(now time.Time) {
+	a.ticks <- now
+}
+
+func TestTickAdapter(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(0))
+	defer stop()
+
+	clock := newFakeClock()
+	start := clock.Now()
+	adapter := &tickingAdapter{newRecordingAdapter(), make(chan time.Time, 10)}
+	client := NewEventsClient(addr, adapter, WithTickInterval(time.Minute), WithRegistrationTimeout(time.Hour), withClock(clock))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	// the registration timeout is still pending besides the tick
+	for i := 1; i <= 3; i++ {
+		waitFor(t, "the next tick to be set", func() bool { return clock.pending() == 2 })
+		clock.advance(time.Minute)
+		select {
+		case now := <-adapter.ticks:
+			if expected := start.Add(time.Duration(i) * time.Minute); !now.Equal(expected) {
+				t.Fatalf("expected tick %d at %s, got %s", i, expected, now)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("tick %d not delivered", i)
+		}
+	}
+
+	waitFor(t, "the next tick to be set", func() bool { return clock.pending() == 2 })
+	client.Stop()
+	clock.advance(time.Minute)
+	select {
+	case now := <-adapter.ticks:
+		t.Fatalf("unexpected tick at %s after stop", now)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := len(adapter.events); n != 0 {
+		t.Fatalf("expected ticks without events, got %d events", n)
+	}
+}
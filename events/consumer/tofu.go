@@ -0,0 +1,142 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync"
+)
+
+//PeerIdentityError terminates a client created with WithTrustOnFirstUse when
+//a peer presents a certificate whose public key differs from the one it
+//presented first, which may reveal a man in the middle or a misrouted
+//connection. Pins are the hashes returned by CertificatePin
+type PeerIdentityError struct {
+	PeerAddress string
+	Expected    []byte
+	Presented   []byte
+}
+
+func (e *PeerIdentityError) Error() string {
+	return fmt.Sprintf("peer %s presented a certificate with pin %x, it first presented %x", e.PeerAddress, e.Presented, e.Expected)
+}
+
+//tlsVerifier verifies the certificate of the event hub: apply prepares the
+//TLS configuration and verify checks the certificates presented in the
+//handshake, see verifyingCreds
+type tlsVerifier interface {
+	apply(config *tls.Config)
+	verify(rawCerts [][]byte) error
+}
+
+//firstUsePins holds the pin of the certificate each peer presented first,
+//and the identity changes detected since, see WithTrustOnFirstUse
+type firstUsePins struct {
+	mutex   sync.Mutex
+	pins    map[string][]byte
+	changed map[string]*PeerIdentityError
+}
+
+func newFirstUsePins() *firstUsePins {
+	return &firstUsePins{pins: make(map[string][]byte), changed: make(map[string]*PeerIdentityError)}
+}
+
+//check records the pin of the leaf certificate in rawCerts as the one of
+//peerAddress if it has none yet, and fails if it has another one
+func (f *firstUsePins) check(peerAddress string, rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("peer %s presented no certificate", peerAddress)
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return fmt.Errorf("could not parse peer certificate: %s", err)
+	}
+	pin := CertificatePin(cert)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	expected, ok := f.pins[peerAddress]
+	if !ok {
+		f.pins[peerAddress] = pin
+		return nil
+	}
+	if bytes.Equal(pin, expected) {
+		return nil
+	}
+	err = &PeerIdentityError{PeerAddress: peerAddress, Expected: expected, Presented: pin}
+	f.changed[peerAddress] = err.(*PeerIdentityError)
+	return err
+}
+
+//takeChange returns and clears the identity change detected for
+//peerAddress, nil if there is none
+func (f *firstUsePins) takeChange(peerAddress string) *PeerIdentityError {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	err := f.changed[peerAddress]
+	delete(f.changed, peerAddress)
+	return err
+}
+
+//firstUseVerifier checks the certificate of peerAddress against the pin it
+//presented first, after the check of the configured pins if any
+type firstUseVerifier struct {
+	tofu        *firstUsePins
+	peerAddress string
+	pins        *certificatePins
+}
+
+func (v *firstUseVerifier) apply(config *tls.Config) {
+	if v.pins != nil {
+		v.pins.apply(config)
+	}
+}
+
+func (v *firstUseVerifier) verify(rawCerts [][]byte) error {
+	if v.pins != nil {
+		if err := v.pins.verify(rawCerts); err != nil {
+			return err
+		}
+	}
+	return v.tofu.check(v.peerAddress, rawCerts)
+}
+
+//verifier returns the verification of the certificate of peerAddress, nil
+//if the client has no other than the chain of the TLS configuration
+func (ec *EventsClient) verifier(peerAddress string) tlsVerifier {
+	if ec.tofu != nil {
+		return &firstUseVerifier{tofu: ec.tofu, peerAddress: peerAddress, pins: ec.pins}
+	}
+	if ec.pins != nil {
+		return ec.pins
+	}
+	return nil
+}
+
+//identityChanged returns the identity change of peerAddress that made its
+//connection fail, nil if there is none
+func (ec *EventsClient) identityChanged(peerAddress string) error {
+	if ec.tofu == nil {
+		return nil
+	}
+	if err := ec.tofu.takeChange(peerAddress); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+
+	"github.com/op/go-logging"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//Transform rewrites a received event before it is delivered, e.g. to enrich
+//it or to redact sensitive fields. It returns the event to deliver, which
+//may be msg modified in place, or nil to skip the event like a filter
+type Transform func(msg *ehpb.Event) (*ehpb.Event, error)
+
+//TransformErrorPolicy tells what the client does with an event whose
+//Transform failed
+type TransformErrorPolicy int
+
+const (
+	//TransformDropEvent drops the event, counted by
+	//ClientStats.TransformErrors and the MetricTransformErrors counter
+	TransformDropEvent TransformErrorPolicy = iota
+	//TransformStopClient terminates the client with the error
+	TransformStopClient
+)
+
+//transformEvent applies the transform of the client to in. It returns the
+//event to pass on, nil to skip it, or the error terminating the client
+func (ec *EventsClient) transformEvent(in *ehpb.Event) (*ehpb.Event, error) {
+	out, err := ec.transform(in)
+	if err == nil {
+		return out, nil
+	}
+	if ec.transformPolicy == TransformStopClient {
+		return nil, fmt.Errorf("event transform failed: %s", err)
+	}
+	ec.logEventf(logging.WARNING, in, "dropping an event the transform failed on: %s", err)
+	ec.mutex.Lock()
+	ec.stats.transformErrors++
+	ec.mutex.Unlock()
+	if ec.metrics != nil {
+		ec.metrics.AddCounter(MetricTransformErrors, ec.labels, 1)
+	}
+	return nil, nil
+}
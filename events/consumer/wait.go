@@ -0,0 +1,100 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+//eventTap is a WaitForEvent call waiting for its first matching event
+type eventTap struct {
+	match func(*ehpb.Event) bool
+	found chan *ehpb.Event
+}
+
+//WaitForEvent blocks until the client receives an event accepted by match
+//and returns it, e.g. to wait for a transaction to be committed in a test or
+//for the peer to be ready. It taps the received events without consuming
+//them: the adapter receives the matching event too, and match sees the
+//events ahead of the filter set with WithFilter. Only the events received
+//after the call are considered. It can be called before Start, and returns
+//ctx.Err() when ctx is done first, or an error if the client terminates.
+//match is called from the goroutine receiving events and should not block
+func (ec *EventsClient) WaitForEvent(ctx context.Context, match func(*ehpb.Event) bool) (*ehpb.Event, error) {
+	tap := &eventTap{match: match, found: make(chan *ehpb.Event, 1)}
+	ec.mutex.Lock()
+	ec.taps = append(ec.taps, tap)
+	life := ec.lifeCtx
+	ec.mutex.Unlock()
+	defer ec.removeTap(tap)
+
+	var ended <-chan struct{}
+	if life != nil {
+		ended = life.Done()
+	}
+	select {
+	case e := <-tap.found:
+		return e, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-ended:
+		// a matching event may have come with the end of the client
+		select {
+		case e := <-tap.found:
+			return e, nil
+		default:
+		}
+		return nil, fmt.Errorf("events client terminated before receiving a matching event")
+	}
+}
+
+//tapEvent hands in to the pending WaitForEvent calls it matches
+func (ec *EventsClient) tapEvent(in *ehpb.Event) {
+	ec.mutex.Lock()
+	taps := ec.taps
+	ec.mutex.Unlock()
+	for _, tap := range taps {
+		if tap.match(in) {
+			found := in
+			if ec.pool != nil {
+				// in goes back to the pool once delivered
+				found = proto.Clone(in).(*ehpb.Event)
+			}
+			select {
+			case tap.found <- found:
+				ec.removeTap(tap)
+			default:
+			}
+		}
+	}
+}
+
+func (ec *EventsClient) removeTap(tap *eventTap) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	for i, t := range ec.taps {
+		if t == tap {
+			ec.taps = append(ec.taps[:i:i], ec.taps[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestWaitForEvent(t *testing.T) {
+	release := make(chan struct{})
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		if _, err := ackRegister(stream); err != nil {
+			return err
+		}
+		<-release
+		for _, e := range []*ehpb.Event{blockEvent(), chaincodeEvent("mycc", "other"), chaincodeEvent("mycc", "ready"), chaincodeEvent("mycc", "ready")} {
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+		return waitForEOF(stream)
+	})
+	defer stop()
+
+	adapter := newRecordingAdapter()
+	client := NewEventsClient(addr, adapter)
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+
+	found := make(chan *ehpb.Event)
+	go func() {
+		e, err := client.WaitForEvent(context.Background(), func(e *ehpb.Event) bool {
+			cc := e.GetChaincodeEvent()
+			return cc != nil && cc.EventName == "ready"
+		})
+		if err != nil {
+			t.Errorf("WaitForEvent failed: %s", err)
+		}
+		found <- e
+	}()
+	waitFor(t, "pending WaitForEvent", func() bool {
+		client.mutex.Lock()
+		defer client.mutex.Unlock()
+		return len(client.taps) == 1
+	})
+	close(release)
+	select {
+	case e := <-found:
+		if cc := e.GetChaincodeEvent(); cc == nil || cc.EventName != "ready" {
+			t.Fatalf("unexpected event %v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("WaitForEvent did not return")
+	}
+
+	// the adapter still receives every event
+	for i := 0; i < 4; i++ {
+		adapter.waitEvent(t)
+	}
+	waitFor(t, "removed tap", func() bool {
+		client.mutex.Lock()
+		defer client.mutex.Unlock()
+		return len(client.taps) == 0
+	})
+}
+
+func TestWaitForEventEnds(t *testing.T) {
+	addr, _, stop := startFakeServer(t, sendBlocks(0))
+	defer stop()
+
+	client := NewEventsClient(addr, newRecordingAdapter())
+	never := func(*ehpb.Event) bool { return false }
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.WaitForEvent(ctx, never); err != context.DeadlineExceeded {
+		t.Fatalf("expected the deadline to expire, got %v", err)
+	}
+
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	go client.Stop()
+	if _, err := client.WaitForEvent(context.Background(), never); err == nil {
+		t.Fatalf("expected an error once the client terminates")
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"github.com/op/go-logging"
+)
+
+//WithBufferWatermarks sets the marks of the buffer set with WithBufferSize
+//at which a WatermarkAdapter is notified: the buffer goes above its high
+//watermark when it holds high events, and back below once it holds no more
+//than low. Between the two marks its state does not change, so that a depth
+//oscillating around one mark does not make the notifications flap
+func WithBufferWatermarks(high, low int) Option {
+	return func(ec *EventsClient) {
+		ec.highWatermark = high
+		ec.lowWatermark = low
+	}
+}
+
+//AboveBufferWatermark tells whether the buffer went above its high
+//watermark and did not fall back to its low watermark since, see
+//WithBufferWatermarks. It is always false without watermarks
+func (ec *EventsClient) AboveBufferWatermark() bool {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.stats.aboveWatermark
+}
+
+//checkWatermarks notifies the adapter when the buffer depth crossed a
+//watermark, after an event was queued or dequeued. watermarkMutex keeps the
+//transitions and their notifications in order
+func (ec *EventsClient) checkWatermarks() {
+	if ec.highWatermark <= 0 {
+		return
+	}
+	ec.watermarkMutex.Lock()
+	defer ec.watermarkMutex.Unlock()
+	depth := ec.queue.len()
+	ec.mutex.Lock()
+	above := ec.stats.aboveWatermark
+	switch {
+	case !above && depth >= ec.highWatermark:
+		above = true
+	case above && depth <= ec.lowWatermark:
+		above = false
+	default:
+		ec.mutex.Unlock()
+		return
+	}
+	ec.stats.aboveWatermark = above
+	ec.mutex.Unlock()
+	wa, ok := ec.getAdapter().(WatermarkAdapter)
+	if above {
+		ec.logf(logging.DEBUG, "buffer reached its high watermark with %d events", depth)
+		if ok {
+			wa.OnBufferHighWatermark()
+		}
+	} else {
+		ec.logf(logging.DEBUG, "buffer fell to its low watermark with %d events", depth)
+		if ok {
+			wa.OnBufferLowWatermark()
+		}
+	}
+}
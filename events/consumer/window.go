@@ -0,0 +1,73 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"errors"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+//ErrNoEvents is the error of a client which received no event within the
+//window set with WithRequireEventWithin after registering
+var ErrNoEvents = errors.New("no event received after registering")
+
+//WithRequireEventWithin makes receiving no event within window of a
+//registration a failure, for the consumers expecting a steady flow of
+//events, e.g. as a sanity check of their interests. The client then
+//replaces its stream when reconnect is true, which opens a new window, and
+//else terminates with ErrNoEvents and the CloseNoEvents reason, reported by
+//Disconnected and Wait. Every registration, the initial one and those of
+//the reconnections, has its window. It is disabled by default
+func WithRequireEventWithin(window time.Duration, reconnect bool) Option {
+	return func(ec *EventsClient) {
+		ec.eventWindow = window
+		ec.eventWindowReconnect = reconnect
+	}
+}
+
+//watchEventWindow fails the registration made at registeredAt if no event
+//arrives within the window, unless the client has registered again or
+//terminated meanwhile
+func (ec *EventsClient) watchEventWindow(registeredAt time.Time) {
+	if ec.eventWindow <= 0 {
+		return
+	}
+	ctx := ec.Context()
+	ec.routines.spawn(func() {
+		select {
+		case <-ec.clock.After(ec.eventWindow):
+		case <-ctx.Done():
+			return
+		}
+		ec.mutex.Lock()
+		missed := ec.stats.registeredAt.Equal(registeredAt) && ec.stats.lastEvent.Before(registeredAt)
+		ec.mutex.Unlock()
+		if !missed {
+			return
+		}
+		ec.logf(logging.WARNING, "no event received within %s of registering", ec.eventWindow)
+		ec.requestReconnect(ErrNoEvents)
+	})
+}
+
+//noEventsFatal tells whether cause, the reason a stream was cancelled,
+//terminates the client
+func (ec *EventsClient) noEventsFatal(cause error) bool {
+	return cause == ErrNoEvents && !ec.eventWindowReconnect
+}
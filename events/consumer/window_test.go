@@ -0,0 +1,65 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+func TestRequireEventWithin(t *testing.T) {
+	// the first stream of each client stays silent, the third delivers a
+	// block
+	var streams int32
+	addr, _, stop := startFakeServer(t, func(n int, stream ehpb.Events_ChatServer) error {
+		atomic.StoreInt32(&streams, int32(n))
+		if n <= 2 {
+			if _, err := ackRegister(stream); err != nil {
+				return err
+			}
+			return waitForEOF(stream)
+		}
+		return sendBlocks(1)(n, stream)
+	})
+	defer stop()
+
+	adapter := &closeAdapter{newRecordingAdapter(), make(chan CloseReason, 1)}
+	client := NewEventsClient(addr, adapter, WithRequireEventWithin(100*time.Millisecond, false))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	if err := client.Wait(); err != ErrNoEvents {
+		t.Fatalf("expected the client to terminate with ErrNoEvents, got %v", err)
+	}
+	if reason := <-adapter.reasons; reason != CloseNoEvents {
+		t.Fatalf("expected the %s close reason, got %s", CloseNoEvents, reason)
+	}
+
+	adapter = &closeAdapter{newRecordingAdapter(), make(chan CloseReason, 1)}
+	client = NewEventsClient(addr, adapter, WithRequireEventWithin(100*time.Millisecond, true))
+	if err := client.Start(); err != nil {
+		t.Fatalf("could not start client: %s", err)
+	}
+	defer client.Stop()
+	adapter.waitEvent(t)
+	if n := atomic.LoadInt32(&streams); n != 3 {
+		t.Fatalf("expected the event from the third stream, got it from stream %d", n)
+	}
+}
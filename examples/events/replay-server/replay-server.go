@@ -0,0 +1,65 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/hyperledger/fabric/events/consumer/consumertest"
+)
+
+func main() {
+	var recording string
+	var listenAddress string
+	var closeAtEnd bool
+
+	flag.StringVar(&recording, "recording", "", "file of the events recorded by a FileSink")
+	flag.StringVar(&listenAddress, "listen-address", "0.0.0.0:7053", "address to serve the recording on")
+	flag.BoolVar(&closeAtEnd, "close-at-end", false, "end each stream once the recording is sent")
+	flag.Parse()
+
+	if recording == "" {
+		fmt.Fprintf(os.Stderr, "a recording must be given with -recording\n")
+		os.Exit(2)
+	}
+	f, err := os.Open(recording)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening the recording: %s\n", err)
+		os.Exit(1)
+	}
+	server, err := consumertest.NewReplayServer(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading the recording: %s\n", err)
+		os.Exit(1)
+	}
+	server.CloseAtEnd = closeAtEnd
+
+	lis, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listening on %s: %s\n", listenAddress, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Serving %d recorded events on %s\n", server.Len(), lis.Addr())
+	if err := server.Serve(lis); err != nil {
+		fmt.Fprintf(os.Stderr, "Error serving the recording: %s\n", err)
+		os.Exit(1)
+	}
+}